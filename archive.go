@@ -0,0 +1,289 @@
+package main
+
+import (
+	"archive/zip"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// Étendues possibles d'une archive
+const (
+	archiveSelection = "selection" // Fichiers cochés dans la liste
+	archiveFolder    = "folder"    // Un dossier avec ses sous-dossiers
+	archiveVault     = "vault"     // Tout le coffre de l'utilisateur
+)
+
+// Nom de l'entrée qui liste les contenus absents d'une archive
+const archiveErrorsName = "LISEZMOI-contenus-manquants.txt"
+
+// Définir une structure pour représenter une entrée d'archive : un dossier, un fichier ou une note
+type archiveItem struct {
+	Path string // Chemin dans l'archive (terminé par "/" pour un dossier)
+	File *UploadedFile
+	Note *Note
+}
+
+// archivePlan construit la liste des entrées en gardant des chemins uniques, même pour des noms identiques
+type archivePlan struct {
+	items []archiveItem
+	taken map[string]bool // Chemins déjà utilisés, sans distinction de casse
+}
+
+// Fonction pour réserver un chemin unique dans un dossier de l'archive : "nom (2).ext" si le nom est pris
+func (p *archivePlan) name(dir, name string) string {
+	name = cleanDisplayName(strings.ReplaceAll(name, `\`, "_"))
+	ext := path.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+	candidate := path.Join(dir, name)
+	for n := 2; p.taken[strings.ToLower(candidate)]; n++ {
+		candidate = path.Join(dir, base+" ("+strconv.Itoa(n)+")"+ext)
+	}
+	p.taken[strings.ToLower(candidate)] = true
+	return candidate
+}
+
+// Fonction pour ajouter le contenu d'un dossier (notes, fichiers et sous-dossiers) sous le chemin dir
+func (s *Server) planFolder(ctx context.Context, plan *archivePlan, ownerID int, folderID sql.NullInt64, dir string) error {
+	files, err := s.files.ListByFolder(ctx, ownerID, folderID, FileListOptions{Sort: "name"})
+	if err != nil {
+		return err
+	}
+	for i := range files {
+		plan.items = append(plan.items, archiveItem{Path: plan.name(dir, files[i].FileName), File: &files[i]})
+	}
+
+	notes, err := s.notes.ListByFolder(ctx, ownerID, folderID)
+	if err != nil {
+		return err
+	}
+	for i := range notes {
+		// Le nom de la note n'est connu qu'après déchiffrement : Path désigne son dossier, voir writeArchive
+		plan.items = append(plan.items, archiveItem{Path: dir, Note: &notes[i]})
+	}
+
+	children, err := s.folders.ListChildren(ctx, ownerID, folderID)
+	if err != nil {
+		return err
+	}
+	for _, child := range children {
+		childDir := plan.name(dir, child.Name) + "/"
+		plan.items = append(plan.items, archiveItem{Path: childDir})
+		if err := s.planFolder(ctx, plan, ownerID, sql.NullInt64{Int64: int64(child.ID), Valid: true}, childDir); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Gestionnaire de route pour télécharger une archive zip de fichiers cochés, d'un dossier ou de tout le coffre.
+// L'archive est écrite directement dans la réponse, sans copie intermédiaire sur le disque.
+func (s *Server) archiveHandler(c echo.Context) error {
+	ctx := c.Request().Context()
+	userID := currentUserID(c)
+	plan := &archivePlan{taken: make(map[string]bool)}
+	archiveName := "coffre"
+
+	switch c.FormValue("scope") {
+	case archiveSelection:
+		form, err := c.FormParams()
+		if err != nil {
+			return err
+		}
+		if len(form["file"]) == 0 {
+			return c.JSON(http.StatusBadRequest, map[string]string{"message": "aucun fichier sélectionné"})
+		}
+		for _, value := range form["file"] {
+			fileID, err := parseObjectID(value)
+			if err != nil {
+				return accessErrorResponse(c, err, "Erreur lors de la préparation de l'archive")
+			}
+			file, err := s.authz.File(ctx, userID, fileID, AccessRead)
+			if err != nil {
+				return accessErrorResponse(c, err, "Erreur lors de la préparation de l'archive")
+			}
+			plan.items = append(plan.items, archiveItem{Path: plan.name("", file.FileName), File: &file})
+		}
+		archiveName = "selection"
+	case archiveFolder:
+		folderID, err := parseObjectID(c.FormValue("folder"))
+		if err != nil {
+			return accessErrorResponse(c, err, "Erreur lors de la préparation de l'archive")
+		}
+		folder, err := s.authz.Folder(ctx, userID, folderID, AccessRead)
+		if err != nil {
+			return accessErrorResponse(c, err, "Erreur lors de la préparation de l'archive")
+		}
+		dir := plan.name("", folder.Name) + "/"
+		plan.items = append(plan.items, archiveItem{Path: dir})
+		if err := s.planFolder(ctx, plan, folder.UserID, sql.NullInt64{Int64: folderID, Valid: true}, dir); err != nil {
+			log.Println("Erreur lors de la préparation de l'archive :", err)
+			return err
+		}
+		archiveName = folder.Name
+	case archiveVault:
+		if err := s.planFolder(ctx, plan, userID, sql.NullInt64{}, ""); err != nil {
+			log.Println("Erreur lors de la préparation de l'archive :", err)
+			return err
+		}
+	default:
+		return c.JSON(http.StatusBadRequest, map[string]string{"message": "étendue d'archive inconnue"})
+	}
+
+	// Les contenus chiffrés se déchiffrent avec la clé de l'utilisateur (la sienne, ou celle de ses accès reçus) :
+	// tout vérifier avant d'écrire l'archive
+	userKey, keyErr := s.keys.ForRequest(c, userID)
+	for _, item := range plan.items {
+		encrypted := (item.File != nil && item.File.WrappedKey != nil) || (item.Note != nil && item.Note.WrappedKey != nil)
+		if encrypted && keyErr != nil {
+			return keyringLockedResponse(c)
+		}
+	}
+
+	header := c.Response().Header()
+	header.Set(echo.HeaderContentType, "application/zip")
+	header.Set(echo.HeaderContentDisposition, mime.FormatMediaType("attachment", map[string]string{"filename": cleanDisplayName(archiveName) + ".zip"}))
+	header.Set("Cache-Control", "private, no-store")
+	c.Response().WriteHeader(http.StatusOK)
+
+	// La réponse est commencée : une erreur ne peut plus que tronquer l'archive
+	if err := s.writeArchive(c, c.Response(), plan, userKey, c.FormValue("password")); err != nil {
+		log.Println("Erreur lors de l'écriture de l'archive :", err)
+	}
+	return nil
+}
+
+// Fonction pour écrire l'archive : dossiers, fichiers déchiffrés à la volée et notes en Markdown.
+// Avec un mot de passe, chaque entrée est chiffrée en AES-256 (format WinZip).
+func (s *Server) writeArchive(c echo.Context, out io.Writer, plan *archivePlan, userKey []byte, password string) error {
+	zw := zip.NewWriter(out)
+	var aesMethod uint16 // Vraie méthode de l'entrée chiffrée en cours
+	zw.RegisterCompressor(zipMethodAES, func(w io.Writer) (io.WriteCloser, error) {
+		return newZipAESWriter(w, password, aesMethod)
+	})
+
+	create := func(name string, method uint16, modified time.Time) (io.Writer, error) {
+		header := &zip.FileHeader{Name: name, Method: method, Modified: modified}
+		if password != "" {
+			aesMethod = method
+			header.Method = zipMethodAES
+			header.Flags |= 0x1 // Entrée chiffrée
+			header.Extra = zipAESExtra(method)
+		}
+		return zw.CreateHeader(header)
+	}
+
+	var missing []string
+	for _, item := range plan.items {
+		switch {
+		case item.File != nil:
+			if item.File.StorageKey == "" {
+				log.Printf("Fichier %d sans clé de stockage (lancer `storage reconcile`), absent de l'archive", item.File.ID)
+				missing = append(missing, item.Path)
+				continue
+			}
+			if item.File.Metadata.Quarantine != "" {
+				missing = append(missing, item.Path+" (en quarantaine)")
+				continue
+			}
+			content, err := s.openFileContent(c, item.File)
+			if errors.Is(err, errBlobNotFound) {
+				log.Printf("Contenu du fichier %d introuvable, absent de l'archive", item.File.ID)
+				missing = append(missing, item.Path)
+				continue
+			}
+			if errors.Is(err, errGrantKeyMissing) {
+				missing = append(missing, item.Path+" (pas encore accessible)")
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			w, err := create(item.Path, archiveMethod(item.File.Metadata.MIMEType), item.File.UploadedAt)
+			if err == nil {
+				_, err = io.Copy(w, content)
+			}
+			content.Close()
+			if err != nil {
+				return err
+			}
+		case item.Note != nil:
+			note := *item.Note
+			if err := s.openNoteFor(c, userKey, &note); err != nil {
+				log.Printf("Erreur lors du déchiffrement de la note %d : %v", note.ID, err)
+				missing = append(missing, path.Join(item.Path, "note "+strconv.Itoa(note.ID)))
+				continue
+			}
+			title := note.Title
+			if strings.TrimSpace(title) == "" {
+				title = "note " + strconv.Itoa(note.ID)
+			}
+			w, err := create(plan.name(item.Path, title+".md"), zip.Deflate, time.Now())
+			if err == nil {
+				_, err = io.WriteString(w, "# "+note.Title+"\n\n"+note.Content+"\n")
+			}
+			if err != nil {
+				return err
+			}
+		default:
+			// Dossier : entrée vide, jamais chiffrée
+			if _, err := zw.CreateHeader(&zip.FileHeader{Name: item.Path, Method: zip.Store, Modified: time.Now()}); err != nil {
+				return err
+			}
+		}
+	}
+
+	if len(missing) > 0 {
+		w, err := create(plan.name("", archiveErrorsName), zip.Deflate, time.Now())
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(w, "Ces contenus n'ont pas pu être lus et manquent dans l'archive :\n\n%s\n", strings.Join(missing, "\n"))
+	}
+	return zw.Close()
+}
+
+// Fonction pour choisir la méthode d'une entrée : les contenus déjà compressés sont stockés tels quels
+func archiveMethod(mimeType string) uint16 {
+	mediaType, _, _ := mime.ParseMediaType(mimeType)
+	switch {
+	case strings.HasPrefix(mediaType, "image/") && mediaType != "image/bmp" && mediaType != "image/svg+xml",
+		strings.HasPrefix(mediaType, "audio/"), strings.HasPrefix(mediaType, "video/"),
+		mediaType == "application/zip", mediaType == "application/x-gzip", mediaType == "application/x-rar-compressed":
+		return zip.Store
+	}
+	return zip.Deflate
+}
+
+// Fonction pour construire le formulaire de téléchargement en zip (les fichiers se cochent dans la liste)
+func renderArchiveForm(folder sql.NullInt64) string {
+	folderButton := ""
+	if folder.Valid {
+		folderButton = `
+        <input type="hidden" name="folder" value="` + template.HTMLEscapeString(strconv.FormatInt(folder.Int64, 10)) + `">
+        <button type="submit" name="scope" value="` + archiveFolder + `">Ce dossier</button>`
+	}
+	return `<form id="archiveForm" action="/archive" method="post">
+        Télécharger en zip :
+        <button type="submit" name="scope" value="` + archiveSelection + `">Les fichiers cochés</button>` + folderButton + `
+        <button type="submit" name="scope" value="` + archiveVault + `">Tout le coffre</button>
+        <input type="password" name="password" placeholder="Mot de passe (facultatif)" autocomplete="new-password">
+    </form>`
+}
+
+// Fonction pour construire la case qui ajoute un fichier à la sélection à télécharger en zip
+func renderArchiveCheckbox(fileID string) string {
+	return `<input type="checkbox" form="archiveForm" name="file" value="` + fileID + `" title="Sélectionner pour l'archive zip">`
+}
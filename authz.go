@@ -0,0 +1,204 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+)
+
+// Erreur renvoyée pour tout objet que l'utilisateur ne peut pas voir (inexistant ou appartenant à un autre)
+var errNotVisible = errors.New("élément introuvable")
+
+// Clé du contexte Echo contenant l'ID de l'utilisateur connecté
+const userIDContextKey = "userID"
+
+// Access représente le niveau d'accès demandé sur un objet
+type Access int
+
+const (
+	AccessRead   Access = iota // Lire ou télécharger
+	AccessWrite                // Modifier, déplacer ou ajouter du contenu
+	AccessManage               // Supprimer ou partager
+)
+
+// Fonction pour afficher un niveau d'accès dans les journaux
+func (a Access) String() string {
+	switch a {
+	case AccessRead:
+		return "lecture"
+	case AccessWrite:
+		return "écriture"
+	default:
+		return "gestion"
+	}
+}
+
+// Types d'objets contrôlés par l'Authorizer
+const (
+	resourceNote   = "note"
+	resourceFile   = "fichier"
+	resourceFolder = "dossier"
+)
+
+// GrantChecker permet d'accorder un accès explicite à un objet dont l'utilisateur n'est pas propriétaire
+type GrantChecker interface {
+	HasGrant(ctx context.Context, userID int, resource string, id int64, access Access) (bool, error)
+}
+
+// Authorizer est le point de passage unique de toutes les routes sur les notes, fichiers et dossiers
+type Authorizer struct {
+	notes   *NoteRepo
+	files   *FileRepo
+	folders *FolderRepo
+	grants  GrantChecker // Accès accordés par les propriétaires (nil : seuls les propriétaires ont accès)
+}
+
+// Fonction pour vérifier qu'un utilisateur peut accéder à un objet ; tout refus est journalisé
+func (a *Authorizer) check(ctx context.Context, userID, ownerID int, resource string, id int64, access Access) error {
+	if userID > 0 && ownerID == userID {
+		return nil
+	}
+	if a.grants != nil && userID > 0 {
+		ok, err := a.grants.HasGrant(ctx, userID, resource, id, access)
+		if err != nil {
+			return err
+		}
+		if ok {
+			return nil
+		}
+	}
+	log.Printf("Accès refusé : utilisateur %d, %s %d (%s)", userID, resource, id, access)
+	return errNotVisible
+}
+
+// Fonction pour récupérer une note si l'utilisateur y a accès
+func (a *Authorizer) Note(ctx context.Context, userID int, noteID int64, access Access) (Note, error) {
+	note, err := a.notes.Get(ctx, noteID)
+	if errors.Is(err, errNoteNotFound) {
+		log.Printf("Accès refusé : utilisateur %d, %s %d inexistante", userID, resourceNote, noteID)
+		return note, errNotVisible
+	}
+	if err != nil {
+		return note, err
+	}
+	return note, a.check(ctx, userID, note.UserID, resourceNote, noteID, access)
+}
+
+// Fonction pour récupérer un fichier si l'utilisateur y a accès
+func (a *Authorizer) File(ctx context.Context, userID int, fileID int64, access Access) (UploadedFile, error) {
+	file, err := a.files.Get(ctx, fileID)
+	if errors.Is(err, errFileNotFound) {
+		log.Printf("Accès refusé : utilisateur %d, %s %d inexistant", userID, resourceFile, fileID)
+		return file, errNotVisible
+	}
+	if err != nil {
+		return file, err
+	}
+	return file, a.check(ctx, userID, file.UserID, resourceFile, fileID, access)
+}
+
+// Fonction pour récupérer un dossier si l'utilisateur y a accès
+func (a *Authorizer) Folder(ctx context.Context, userID int, folderID int64, access Access) (Folder, error) {
+	folder, err := a.folders.GetByID(ctx, folderID)
+	if errors.Is(err, errFolderNotFound) {
+		log.Printf("Accès refusé : utilisateur %d, %s %d inexistant", userID, resourceFolder, folderID)
+		return folder, errNotVisible
+	}
+	if err != nil {
+		return folder, err
+	}
+	return folder, a.check(ctx, userID, folder.UserID, resourceFolder, folderID, access)
+}
+
+// Fonction pour trouver le propriétaire d'un contenu que l'utilisateur crée dans un dossier (NULL = racine de son coffre) :
+// l'utilisateur lui-même, ou le propriétaire du dossier si l'utilisateur peut y écrire
+func (a *Authorizer) ContentOwner(ctx context.Context, userID int, folderID sql.NullInt64) (int, error) {
+	if !folderID.Valid {
+		return userID, nil
+	}
+	folder, err := a.Folder(ctx, userID, folderID.Int64, AccessWrite)
+	if err != nil {
+		return 0, err
+	}
+	return folder.UserID, nil
+}
+
+// Fonction pour vérifier qu'un dossier de destination (NULL = racine) peut recevoir un objet appartenant à ownerID.
+// L'utilisateur doit pouvoir écrire dans le dossier, et le dossier doit appartenir au même propriétaire que l'objet.
+// Seul le propriétaire peut ranger à la racine de son coffre : un objet partagé n'en sort pas.
+func (a *Authorizer) Destination(ctx context.Context, userID, ownerID int, folderID sql.NullInt64) error {
+	if !folderID.Valid {
+		if userID != ownerID {
+			log.Printf("Accès refusé : utilisateur %d, racine du coffre de l'utilisateur %d", userID, ownerID)
+			return errNotVisible
+		}
+		return nil
+	}
+	folder, err := a.Folder(ctx, userID, folderID.Int64, AccessWrite)
+	if err != nil {
+		return err
+	}
+	if folder.UserID != ownerID {
+		log.Printf("Accès refusé : utilisateur %d, %s %d n'appartient pas au propriétaire %d", userID, resourceFolder, folderID.Int64, ownerID)
+		return errNotVisible
+	}
+	return nil
+}
+
+// Middleware qui exige une session valide et place l'ID de l'utilisateur dans le contexte
+func (s *Server) requireUser(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		userID, err := getUserIDFromSession(c)
+		if err != nil {
+			if c.Request().Method == http.MethodGet {
+				return c.Redirect(http.StatusSeeOther, "/login")
+			}
+			return c.JSON(http.StatusUnauthorized, map[string]string{"message": "Authentification requise"})
+		}
+		c.Set(userIDContextKey, userID)
+		return next(c)
+	}
+}
+
+// Middleware qui réserve une route aux utilisateurs de rôle admin
+func (s *Server) requireAdmin(next echo.HandlerFunc) echo.HandlerFunc {
+	return s.requireUser(func(c echo.Context) error {
+		user, err := s.users.FindByID(c.Request().Context(), currentUserID(c))
+		if err != nil && !errors.Is(err, errUserNotFound) {
+			return err
+		}
+		if err != nil || user.Role != "admin" {
+			log.Printf("Accès refusé : utilisateur %d, page d'administration %s", currentUserID(c), c.Path())
+			return c.JSON(http.StatusForbidden, map[string]string{"message": "Accès réservé à l'administrateur"})
+		}
+		return next(c)
+	})
+}
+
+// Fonction pour lire l'ID de l'utilisateur placé dans le contexte par requireUser
+func currentUserID(c echo.Context) int {
+	userID, _ := c.Get(userIDContextKey).(int)
+	return userID
+}
+
+// Fonction pour lire l'identifiant numérique d'un objet dans l'URL
+func parseObjectID(value string) (int64, error) {
+	id, err := parseFolderID(value)
+	if err != nil || !id.Valid {
+		return 0, errNotVisible
+	}
+	return id.Int64, nil
+}
+
+// Fonction pour traduire une erreur d'autorisation en réponse JSON (404 pour tout objet invisible)
+func accessErrorResponse(c echo.Context, err error, message string) error {
+	if errors.Is(err, errNotVisible) {
+		return c.JSON(http.StatusNotFound, map[string]string{"message": errNotVisible.Error()})
+	}
+	log.Println(message+" :", err)
+	return c.JSON(http.StatusInternalServerError, map[string]string{"message": message})
+}
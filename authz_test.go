@@ -0,0 +1,385 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+)
+
+// fakeTables est une base en mémoire minimale : table -> lignes (colonne -> valeur).
+// Elle répond aux SELECT ... FROM <table> WHERE id = ? des dépôts ; toute lecture est enregistrée,
+// toute écriture l'est avec ses arguments.
+type fakeTables struct {
+	tables   map[string][]map[string]driver.Value
+	queries  []string
+	execs    []string
+	execArgs [][]driver.Value
+}
+
+// Fonction pour ouvrir un *sql.DB qui interroge la base en mémoire
+func (f *fakeTables) open() *sql.DB {
+	return sql.OpenDB(fakeConnector{f})
+}
+
+type fakeConnector struct{ tables *fakeTables }
+
+func (c fakeConnector) Connect(context.Context) (driver.Conn, error) { return fakeConn{c.tables}, nil }
+func (c fakeConnector) Driver() driver.Driver                        { return fakeDriver{} }
+
+type fakeDriver struct{}
+
+func (fakeDriver) Open(string) (driver.Conn, error) { return nil, errors.New("utiliser fakeConnector") }
+
+type fakeConn struct{ tables *fakeTables }
+
+func (c fakeConn) Prepare(string) (driver.Stmt, error) { return nil, errors.New("non pris en charge") }
+func (c fakeConn) Close() error                        { return nil }
+func (c fakeConn) Begin() (driver.Tx, error)           { return fakeTx{c.tables}, nil }
+
+func (c fakeConn) ExecContext(_ context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	values := make([]driver.Value, len(args))
+	for i, arg := range args {
+		values[i] = arg.Value
+	}
+	c.tables.execs = append(c.tables.execs, query)
+	c.tables.execArgs = append(c.tables.execArgs, values)
+	return fakeResult{}, nil
+}
+
+// fakeResult annonce une ligne modifiée, d'ID 1
+type fakeResult struct{}
+
+func (fakeResult) LastInsertId() (int64, error) { return 1, nil }
+func (fakeResult) RowsAffected() (int64, error) { return 1, nil }
+
+// Fonction pour retrouver les arguments de la première écriture qui commence par prefix
+func (f *fakeTables) execWith(prefix string) ([]driver.Value, bool) {
+	for i, query := range f.execs {
+		if strings.HasPrefix(query, prefix) {
+			return f.execArgs[i], true
+		}
+	}
+	return nil, false
+}
+
+func (c fakeConn) QueryContext(_ context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	c.tables.queries = append(c.tables.queries, query)
+	columns := selectColumns(query)
+	rest := query[strings.Index(query, " FROM ")+len(" FROM "):]
+	table := strings.Fields(rest)[0]
+
+	rows := &fakeRows{columns: columns}
+	for _, row := range c.tables.tables[table] {
+		// Seul le filtre sur l'ID (premier argument) est appliqué : les dépôts testés lisent un objet à la fois
+		if strings.Contains(rest, "WHERE id = ?") && (len(args) == 0 || !sameValue(row["id"], args[0].Value)) {
+			continue
+		}
+		values := make([]driver.Value, len(columns))
+		for i, column := range columns {
+			values[i] = row[column]
+		}
+		rows.values = append(rows.values, values)
+	}
+	return rows, nil
+}
+
+// Fonction pour comparer une valeur de la base à un argument (les []byte ne se comparent pas avec ==)
+func sameValue(a, b driver.Value) bool {
+	if x, ok := a.([]byte); ok {
+		y, ok := b.([]byte)
+		return ok && bytes.Equal(x, y)
+	}
+	return a == b
+}
+
+type fakeTx struct{ tables *fakeTables }
+
+func (t fakeTx) Commit() error {
+	t.tables.execs = append(t.tables.execs, "COMMIT")
+	t.tables.execArgs = append(t.tables.execArgs, nil)
+	return nil
+}
+func (t fakeTx) Rollback() error { return nil }
+
+// Fonction pour extraire les colonnes d'un SELECT (les virgules entre parenthèses ne séparent pas les colonnes)
+func selectColumns(query string) []string {
+	list := query[len("SELECT "):strings.Index(query, " FROM ")]
+	var columns []string
+	depth, start := 0, 0
+	for i, r := range list {
+		switch r {
+		case '(':
+			depth++
+		case ')':
+			depth--
+		case ',':
+			if depth == 0 {
+				columns = append(columns, strings.TrimSpace(list[start:i]))
+				start = i + 1
+			}
+		}
+	}
+	return append(columns, strings.TrimSpace(list[start:]))
+}
+
+type fakeRows struct {
+	columns []string
+	values  [][]driver.Value
+}
+
+func (r *fakeRows) Columns() []string { return r.columns }
+func (r *fakeRows) Close() error      { return nil }
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if len(r.values) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.values[0])
+	r.values = r.values[1:]
+	return nil
+}
+
+// Coffres de deux utilisateurs : l'utilisateur 1 possède la note 1, le fichier 1 et le dossier 1,
+// l'utilisateur 2 possède la note 2, le fichier 2 et le dossier 2
+func twoUserTables() *fakeTables {
+	return &fakeTables{tables: map[string][]map[string]driver.Value{
+		"notes": {
+			{"id": int64(1), "user_id": int64(1)},
+			{"id": int64(2), "user_id": int64(2)},
+		},
+		"files": {
+			{"id": int64(1), "user_id": int64(1), "filename": "secret.txt", "storage_key": "cle-1"},
+			{"id": int64(2), "user_id": int64(2), "filename": "mien.txt", "storage_key": "cle-2"},
+		},
+		"folders": {
+			{"id": int64(1), "user_id": int64(1), "folder_name": "Privé"},
+			{"id": int64(2), "user_id": int64(2), "folder_name": "Perso"},
+		},
+	}}
+}
+
+// Fonction pour construire un Authorizer sur la base en mémoire
+func newTestAuthorizer(db *sql.DB, grants GrantChecker) *Authorizer {
+	return &Authorizer{notes: &NoteRepo{db: db}, files: &FileRepo{db: db}, folders: &FolderRepo{db: db}, grants: grants}
+}
+
+// Fonction pour capturer le journal pendant un test
+func captureLog(t *testing.T) *bytes.Buffer {
+	var buf bytes.Buffer
+	previous := log.Writer()
+	log.SetOutput(&buf)
+	t.Cleanup(func() { log.SetOutput(previous) })
+	return &buf
+}
+
+// stubGrants accorde un niveau d'accès fixe sur une liste d'objets
+type stubGrants struct {
+	access  Access
+	objects map[string]int64
+	err     error
+}
+
+func (g stubGrants) HasGrant(_ context.Context, _ int, resource string, id int64, access Access) (bool, error) {
+	if g.err != nil {
+		return false, g.err
+	}
+	granted, ok := g.objects[resource]
+	return ok && granted == id && g.access >= access, nil
+}
+
+func TestAuthorizerCheck(t *testing.T) {
+	ctx := context.Background()
+	grants := stubGrants{access: AccessWrite, objects: map[string]int64{resourceFile: 7}}
+	cases := []struct {
+		name            string
+		grants          GrantChecker
+		userID, ownerID int
+		id              int64
+		access          Access
+		allowed         bool
+	}{
+		{"propriétaire", nil, 1, 1, 7, AccessManage, true},
+		{"autre utilisateur", nil, 2, 1, 7, AccessRead, false},
+		{"sans session", nil, 0, 0, 7, AccessRead, false},
+		{"accès accordé suffisant", grants, 2, 1, 7, AccessWrite, true},
+		{"accès accordé insuffisant", grants, 2, 1, 7, AccessManage, false},
+		{"accès accordé sur un autre objet", grants, 2, 1, 8, AccessRead, false},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			logs := captureLog(t)
+			authz := &Authorizer{grants: tc.grants}
+			err := authz.check(ctx, tc.userID, tc.ownerID, resourceFile, tc.id, tc.access)
+			if tc.allowed && err != nil {
+				t.Fatalf("accès refusé : %v", err)
+			}
+			if !tc.allowed {
+				if !errors.Is(err, errNotVisible) {
+					t.Fatalf("erreur = %v, attendu errNotVisible", err)
+				}
+				if !strings.Contains(logs.String(), "Accès refusé") {
+					t.Fatalf("refus non journalisé : %q", logs.String())
+				}
+			}
+		})
+	}
+
+	// Une panne du contrôle des accès accordés n'est pas un refus silencieux
+	failure := errors.New("panne")
+	err := (&Authorizer{grants: stubGrants{err: failure}}).check(ctx, 2, 1, resourceFile, 7, AccessRead)
+	if !errors.Is(err, failure) {
+		t.Fatalf("erreur = %v, attendu la panne", err)
+	}
+}
+
+func TestAuthorizerObjectsOfAnotherUser(t *testing.T) {
+	ctx := context.Background()
+	tables := twoUserTables()
+	authz := newTestAuthorizer(tables.open(), nil)
+
+	lookups := map[string]func(userID int, id int64) error{
+		resourceNote: func(userID int, id int64) error {
+			_, err := authz.Note(ctx, userID, id, AccessRead)
+			return err
+		},
+		resourceFile: func(userID int, id int64) error {
+			_, err := authz.File(ctx, userID, id, AccessRead)
+			return err
+		},
+		resourceFolder: func(userID int, id int64) error {
+			_, err := authz.Folder(ctx, userID, id, AccessRead)
+			return err
+		},
+	}
+	for resource, lookup := range lookups {
+		t.Run(resource, func(t *testing.T) {
+			if err := lookup(1, 1); err != nil {
+				t.Fatalf("le propriétaire n'a pas accès : %v", err)
+			}
+
+			logs := captureLog(t)
+			if err := lookup(2, 1); !errors.Is(err, errNotVisible) {
+				t.Fatalf("objet d'un autre utilisateur : erreur = %v, attendu errNotVisible", err)
+			}
+			if !strings.Contains(logs.String(), "Accès refusé : utilisateur 2, "+resource+" 1") {
+				t.Fatalf("refus non journalisé : %q", logs.String())
+			}
+
+			// Un objet inexistant est indiscernable d'un objet d'un autre utilisateur
+			logs.Reset()
+			if err := lookup(2, 99); !errors.Is(err, errNotVisible) {
+				t.Fatalf("objet inexistant : erreur = %v, attendu errNotVisible", err)
+			}
+			if !strings.Contains(logs.String(), "Accès refusé : utilisateur 2, "+resource+" 99") {
+				t.Fatalf("refus non journalisé : %q", logs.String())
+			}
+		})
+	}
+	if len(tables.execs) != 0 {
+		t.Fatalf("écritures inattendues : %v", tables.execs)
+	}
+}
+
+func TestAuthorizerDestination(t *testing.T) {
+	ctx := context.Background()
+	tables := twoUserTables()
+	// L'utilisateur 2 peut écrire dans le dossier 1 de l'utilisateur 1
+	authz := newTestAuthorizer(tables.open(), stubGrants{access: AccessWrite, objects: map[string]int64{resourceFolder: 1}})
+	folder := func(id int64) sql.NullInt64 { return sql.NullInt64{Int64: id, Valid: true} }
+
+	cases := []struct {
+		name            string
+		userID, ownerID int
+		folderID        sql.NullInt64
+		allowed         bool
+	}{
+		{"racine de son coffre", 1, 1, sql.NullInt64{}, true},
+		{"racine d'un autre coffre", 2, 1, sql.NullInt64{}, false},
+		{"son propre dossier", 2, 2, folder(2), true},
+		{"dossier d'un autre sans accès", 1, 1, folder(2), false},
+		{"dossier partagé pour un objet du même propriétaire", 2, 1, folder(1), true},
+		{"dossier partagé pour un objet d'un autre propriétaire", 2, 2, folder(1), false},
+		{"dossier inexistant", 1, 1, folder(99), false},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			logs := captureLog(t)
+			err := authz.Destination(ctx, tc.userID, tc.ownerID, tc.folderID)
+			if tc.allowed && err != nil {
+				t.Fatalf("destination refusée : %v", err)
+			}
+			if !tc.allowed {
+				if !errors.Is(err, errNotVisible) {
+					t.Fatalf("erreur = %v, attendu errNotVisible", err)
+				}
+				if !strings.Contains(logs.String(), "Accès refusé") {
+					t.Fatalf("refus non journalisé : %q", logs.String())
+				}
+			}
+		})
+	}
+}
+
+func TestHandlersHideObjectsOfAnotherUser(t *testing.T) {
+	e := echo.New()
+	cases := []struct {
+		name    string
+		handler func(s *Server) echo.HandlerFunc
+		id      string
+		form    url.Values
+		logged  string
+	}{
+		{"suppression d'une note", func(s *Server) echo.HandlerFunc { return s.deleteNoteHandler }, "1", nil, "note 1"},
+		{"déplacement d'une note", func(s *Server) echo.HandlerFunc { return s.moveNoteHandler }, "1", url.Values{"folder_id": {"2"}}, "note 1"},
+		{"lecture d'un fichier", func(s *Server) echo.HandlerFunc { return s.viewFileHandler }, "1", nil, "fichier 1"},
+		{"suppression d'un fichier", func(s *Server) echo.HandlerFunc { return s.deleteFileHandler }, "1", nil, "fichier 1"},
+		{"déplacement d'un fichier", func(s *Server) echo.HandlerFunc { return s.moveFileHandler }, "1", url.Values{"folder_id": {"2"}}, "fichier 1"},
+		{"fichier rangé dans le dossier d'un autre", func(s *Server) echo.HandlerFunc { return s.moveFileHandler }, "2", url.Values{"folder_id": {"1"}}, "dossier 1"},
+		{"renommage d'un dossier", func(s *Server) echo.HandlerFunc { return s.renameFolderHandler }, "1", url.Values{"name": {"Volé"}}, "dossier 1"},
+		{"déplacement d'un dossier", func(s *Server) echo.HandlerFunc { return s.moveFolderHandler }, "1", url.Values{"parent_id": {"2"}}, "dossier 1"},
+		{"suppression d'un dossier", func(s *Server) echo.HandlerFunc { return s.deleteFolderHandler }, "1", nil, "dossier 1"},
+		{"note inexistante", func(s *Server) echo.HandlerFunc { return s.deleteNoteHandler }, "99", nil, "note 99"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			tables := twoUserTables()
+			db := tables.open()
+			s := &Server{db: db, notes: &NoteRepo{db: db}, files: &FileRepo{db: db}, folders: &FolderRepo{db: db}, grants: &GrantRepo{db: db}}
+			s.trash = &TrashRepo{db: db, folders: s.folders}
+			s.authz = &Authorizer{notes: s.notes, files: s.files, folders: s.folders, grants: s.grants}
+
+			req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(tc.form.Encode()))
+			req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationForm)
+			rec := httptest.NewRecorder()
+			c := e.NewContext(req, rec)
+			c.SetParamNames("id")
+			c.SetParamValues(tc.id)
+			c.Set(userIDContextKey, 2)
+
+			logs := captureLog(t)
+			if err := tc.handler(s)(c); err != nil {
+				t.Fatalf("erreur du gestionnaire : %v", err)
+			}
+			if rec.Code != http.StatusNotFound {
+				t.Fatalf("statut = %d, attendu 404 (%s)", rec.Code, rec.Body.String())
+			}
+			if !strings.Contains(logs.String(), "Accès refusé : utilisateur 2, "+tc.logged) {
+				t.Fatalf("refus non journalisé : %q", logs.String())
+			}
+			if len(tables.execs) != 0 {
+				t.Fatalf("écritures inattendues : %v", tables.execs)
+			}
+		})
+	}
+}
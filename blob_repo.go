@@ -0,0 +1,255 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// BlobRepo regroupe les requêtes sur la table blobs : un blob est partagé par toutes les lignes
+// (fichiers et anciennes versions) qui ont le même contenu, et compte ces lignes.
+// Une référence est prise avant d'écrire le contenu, puis rendue dans la transaction qui supprime la ligne.
+type BlobRepo struct {
+	db *sql.DB
+}
+
+// Fonction pour prendre une référence sur un blob avant d'enregistrer la ligne qui le désigne.
+// Un blob inconnu est créé avec la clé de données proposée ; un blob connu garde la sienne, renvoyée à l'appelant.
+// stored vaut true si le contenu est déjà dans le stockage : l'appelant n'a alors rien à écrire.
+func (r *BlobRepo) Acquire(ctx context.Context, key string, wrappedKey []byte) (current []byte, stored bool, err error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, false, err
+	}
+	defer tx.Rollback()
+
+	// Créer la ligne si besoin : dans tous les cas elle reste verrouillée jusqu'à la fin de la transaction
+	_, err = tx.ExecContext(ctx, "INSERT INTO blobs (storage_key, wrapped_key, ref_count, stored) VALUES (?, ?, 0, FALSE) ON DUPLICATE KEY UPDATE storage_key = storage_key", key, wrappedKey)
+	if err != nil {
+		return nil, false, err
+	}
+	var refCount int
+	err = tx.QueryRowContext(ctx, "SELECT wrapped_key, ref_count, stored FROM blobs WHERE storage_key = ? FOR UPDATE", key).
+		Scan(&current, &refCount, &stored)
+	if err != nil {
+		return nil, false, err
+	}
+	if _, err := tx.ExecContext(ctx, "UPDATE blobs SET ref_count = ref_count + 1 WHERE storage_key = ?", key); err != nil {
+		return nil, false, err
+	}
+	// Un blob sans référence peut être en cours d'effacement : son contenu est réécrit
+	return current, stored && refCount > 0, tx.Commit()
+}
+
+// Fonction pour indiquer que le contenu d'un blob a été écrit dans le stockage, avec l'empreinte des octets stockés
+func (r *BlobRepo) MarkStored(ctx context.Context, key, checksum string) error {
+	_, err := r.db.ExecContext(ctx, "UPDATE blobs SET stored = TRUE, checksum = ? WHERE storage_key = ?", checksum, key)
+	return err
+}
+
+// Fonction pour enregistrer l'empreinte d'un blob écrit avant son calcul, relevée par le vérificateur d'intégrité
+func (r *BlobRepo) SetChecksum(ctx context.Context, key, checksum string) error {
+	_, err := r.db.ExecContext(ctx, "UPDATE blobs SET checksum = ? WHERE storage_key = ? AND checksum IS NULL", checksum, key)
+	return err
+}
+
+// Définir une structure pour représenter un contenu à vérifier : une clé désignée par au moins une ligne
+type storedContent struct {
+	Key      string
+	Checksum sql.NullString // Empreinte des octets stockés, inconnue pour les blobs écrits avant son calcul
+}
+
+// Fonction pour lister les clés désignées par des fichiers ou d'anciennes versions, avec l'empreinte attendue
+func (r *BlobRepo) ListReferenced(ctx context.Context) ([]storedContent, error) {
+	rows, err := r.db.QueryContext(ctx, "SELECT k.storage_key, b.checksum FROM (SELECT storage_key FROM files WHERE storage_key IS NOT NULL UNION SELECT storage_key FROM file_versions) AS k LEFT JOIN blobs b ON b.storage_key = k.storage_key ORDER BY k.storage_key")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var contents []storedContent
+	for rows.Next() {
+		var content storedContent
+		if err := rows.Scan(&content.Key, &content.Checksum); err != nil {
+			return nil, err
+		}
+		contents = append(contents, content)
+	}
+	return contents, rows.Err()
+}
+
+// Définir une structure pour représenter la vignette d'un blob
+type Thumbnail struct {
+	Kind      string // "" tant qu'elle n'est pas générée, sinon thumbnailImage, thumbnailPDF ou thumbnailNone
+	Key       string // Clé de stockage de l'aperçu (thumbnailImage)
+	PageCount int    // Nombre de pages d'un PDF (0 : inconnu)
+}
+
+// Fonction pour lire la vignette d'un blob
+func (r *BlobRepo) Thumbnail(ctx context.Context, key string) (Thumbnail, error) {
+	var kind, thumbnailKey sql.NullString
+	var pageCount sql.NullInt64
+	err := r.db.QueryRowContext(ctx, "SELECT thumbnail, thumbnail_key, page_count FROM blobs WHERE storage_key = ?", key).Scan(&kind, &thumbnailKey, &pageCount)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Thumbnail{}, nil
+	}
+	return Thumbnail{Kind: kind.String, Key: thumbnailKey.String, PageCount: int(pageCount.Int64)}, err
+}
+
+// Fonction pour enregistrer la vignette d'un blob, une fois l'aperçu écrit dans le stockage
+func (r *BlobRepo) SetThumbnail(ctx context.Context, key string, thumbnail Thumbnail) error {
+	_, err := r.db.ExecContext(ctx, "UPDATE blobs SET thumbnail = ?, thumbnail_key = ?, page_count = ? WHERE storage_key = ?",
+		thumbnail.Kind, nullString(thumbnail.Key), sql.NullInt64{Int64: int64(thumbnail.PageCount), Valid: thumbnail.PageCount > 0}, key)
+	return err
+}
+
+// Fonction pour enregistrer le verdict de l'analyse antivirus d'un blob (infection vide : contenu sain)
+func (r *BlobRepo) SetScan(ctx context.Context, key, signatures, infection string) error {
+	_, err := r.db.ExecContext(ctx, "UPDATE blobs SET scan_signatures = ?, scanned_at = ?, infection = ? WHERE storage_key = ?",
+		signatures, time.Now(), sql.NullString{String: infection, Valid: infection != ""}, key)
+	return err
+}
+
+// Fonction pour rendre des références prises pour des lignes finalement non enregistrées.
+// Renvoie les clés qui ne sont plus référencées, à effacer avec removeBlobs.
+func (r *BlobRepo) Release(ctx context.Context, keys ...string) ([]string, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	unreferenced, err := releaseBlobs(ctx, tx, keys)
+	if err != nil {
+		return nil, err
+	}
+	return unreferenced, tx.Commit()
+}
+
+// Fonction pour rendre, dans la transaction qui les supprime, les références des lignes désignant ces clés
+// (une clé par ligne supprimée). Renvoie les clés qui ne sont plus référencées, à effacer après la validation.
+func releaseBlobs(ctx context.Context, tx *sql.Tx, keys []string) ([]string, error) {
+	var unreferenced []string
+	for _, key := range keys {
+		var refCount int
+		err := tx.QueryRowContext(ctx, "SELECT ref_count FROM blobs WHERE storage_key = ? FOR UPDATE", key).Scan(&refCount)
+		if errors.Is(err, sql.ErrNoRows) {
+			continue // Blob jamais enregistré : rien à compter
+		}
+		if err != nil {
+			return nil, err
+		}
+		if refCount > 0 {
+			refCount--
+			if _, err := tx.ExecContext(ctx, "UPDATE blobs SET ref_count = ? WHERE storage_key = ?", refCount, key); err != nil {
+				return nil, err
+			}
+		}
+		if refCount == 0 {
+			unreferenced = append(unreferenced, key)
+		}
+	}
+	return unreferenced, nil
+}
+
+// Fonction pour effacer un blob qui n'est plus référencé, ligne verrouillée : un envoi du même contenu
+// attend la fin de l'effacement, puis réécrit le blob. Renvoie false si le blob est de nouveau utilisé.
+func (r *BlobRepo) Collect(ctx context.Context, store BlobStore, key string) (bool, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	var refCount int
+	var thumbnailKey sql.NullString
+	err = tx.QueryRowContext(ctx, "SELECT ref_count, thumbnail_key FROM blobs WHERE storage_key = ? FOR UPDATE", key).Scan(&refCount, &thumbnailKey)
+	if errors.Is(err, sql.ErrNoRows) || refCount > 0 {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if _, err := tx.ExecContext(ctx, "DELETE FROM blobs WHERE storage_key = ?", key); err != nil {
+		return false, err
+	}
+	// Effacer le contenu (et sa vignette) avant de valider : en cas d'échec la ligne reste, et le prochain passage réessaie
+	if thumbnailKey.Valid {
+		if err := store.Delete(ctx, thumbnailKey.String); err != nil {
+			return false, err
+		}
+	}
+	if err := store.Delete(ctx, key); err != nil {
+		return false, err
+	}
+	return true, tx.Commit()
+}
+
+// Fonction pour effacer un blob du stockage qui n'a aucune ligne dans blobs (écrit par une ancienne version
+// ou abandonné). Le verrou posé sur la clé absente bloque un envoi du même contenu jusqu'à la fin de l'effacement.
+func (r *BlobRepo) CollectUnknown(ctx context.Context, store BlobStore, key string) (bool, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	var found int
+	err = tx.QueryRowContext(ctx, "SELECT 1 FROM blobs WHERE storage_key = ? FOR UPDATE", key).Scan(&found)
+	if err == nil {
+		return false, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return false, err
+	}
+	// Une ligne de `files` ou `file_versions` peut encore désigner le blob si son enregistrement a été manqué
+	err = tx.QueryRowContext(ctx, "SELECT 1 FROM files WHERE storage_key = ? UNION ALL SELECT 1 FROM file_versions WHERE storage_key = ? LIMIT 1", key, key).Scan(&found)
+	if err == nil {
+		return false, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return false, err
+	}
+	if err := store.Delete(ctx, key); err != nil {
+		return false, err
+	}
+	return true, tx.Commit()
+}
+
+// Fonction pour lister les blobs qui ne sont plus référencés (effacement manqué ou envoi interrompu)
+func (r *BlobRepo) ListUnreferenced(ctx context.Context) ([]string, error) {
+	return r.keys(ctx, "SELECT storage_key FROM blobs WHERE ref_count = 0")
+}
+
+// Fonction pour récupérer l'ensemble des clés connues de la table blobs, vignettes comprises
+func (r *BlobRepo) Keys(ctx context.Context) (map[string]bool, error) {
+	keys, err := r.keys(ctx, "SELECT storage_key FROM blobs UNION ALL SELECT thumbnail_key FROM blobs WHERE thumbnail_key IS NOT NULL")
+	if err != nil {
+		return nil, err
+	}
+	known := make(map[string]bool, len(keys))
+	for _, key := range keys {
+		known[key] = true
+	}
+	return known, nil
+}
+
+// Fonction pour lire une liste de clés de stockage
+func (r *BlobRepo) keys(ctx context.Context, query string) ([]string, error) {
+	rows, err := r.db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var keys []string
+	for rows.Next() {
+		var key string
+		if err := rows.Scan(&key); err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
+}
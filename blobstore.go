@@ -0,0 +1,509 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Erreur renvoyée quand un blob n'existe pas dans le stockage
+var errBlobNotFound = errors.New("blob introuvable")
+
+// Noms des stockages reconnus dans la configuration
+const (
+	backendLocal = "local"
+	backendS3    = "s3"
+)
+
+// Définir une structure pour représenter les métadonnées d'un blob
+type BlobInfo struct {
+	Key     string    // Clé de stockage
+	Size    int64     // Taille en octets
+	ModTime time.Time // Date de dernière écriture
+}
+
+// BlobStore est l'interface commune aux stockages de contenu (disque local, S3...).
+// Les contenus circulent en flux : aucun driver ne doit charger un blob entier en mémoire.
+type BlobStore interface {
+	Put(ctx context.Context, key string, src io.Reader) error
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	GetRange(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error) // length < 0 : jusqu'à la fin
+	Stat(ctx context.Context, key string) (BlobInfo, error)
+	Delete(ctx context.Context, key string) error                 // Ne renvoie pas d'erreur si le blob n'existe pas
+	List(ctx context.Context, fn func(info BlobInfo) error) error // Parcourt tous les blobs du stockage
+}
+
+// Fonction pour ouvrir le stockage configuré, avec son éventuel stockage de repli
+func openBlobStore(cfg StorageConfig) (BlobStore, error) {
+	primary, err := newBlobStore(cfg, cfg.Backend)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Fallback == "" {
+		return primary, nil
+	}
+	fallback, err := newBlobStore(cfg, cfg.Fallback)
+	if err != nil {
+		return nil, err
+	}
+	return &fallbackStore{primary: primary, fallback: fallback}, nil
+}
+
+// Fonction pour ouvrir la copie de secours configurée ; renvoie nil s'il n'y en a pas
+func openReplicaStore(cfg StorageConfig) (BlobStore, error) {
+	switch cfg.Replica {
+	case "":
+		return nil, nil
+	case backendLocal:
+		return &LocalStore{dir: cfg.ReplicaDir}, nil
+	default:
+		return newBlobStore(cfg, cfg.Replica)
+	}
+}
+
+// Fonction pour créer un driver de stockage à partir de son nom
+func newBlobStore(cfg StorageConfig, backend string) (BlobStore, error) {
+	switch backend {
+	case backendLocal:
+		return &LocalStore{dir: cfg.UploadsDir}, nil
+	case backendS3:
+		return NewS3Store(cfg.S3)
+	default:
+		return nil, fmt.Errorf("stockage inconnu : %q", backend)
+	}
+}
+
+// Fonction pour écrire un contenu sous une nouvelle clé de stockage aléatoire (anciens fichiers en clair) ;
+// la référence prise sur le blob passe à la ligne qui l'enregistre
+func storeBlob(ctx context.Context, refs *BlobRepo, store BlobStore, src io.Reader) (string, error) {
+	key, err := newStorageKey()
+	if err != nil {
+		return "", err
+	}
+	if _, _, err := refs.Acquire(ctx, key, nil); err != nil {
+		return "", err
+	}
+	if err := putBlob(ctx, refs, store, key, src); err != nil {
+		releaseBlob(ctx, refs, store, key)
+		return "", err
+	}
+	return key, nil
+}
+
+// Fonction pour écrire le contenu d'un blob déjà référencé, puis le marquer comme présent
+// avec l'empreinte des octets écrits (contrôlée par le vérificateur d'intégrité)
+func putBlob(ctx context.Context, refs *BlobRepo, store BlobStore, key string, src io.Reader) error {
+	digest := sha256.New()
+	if err := store.Put(ctx, key, io.TeeReader(src, digest)); err != nil {
+		return err
+	}
+	return refs.MarkStored(ctx, key, hex.EncodeToString(digest.Sum(nil)))
+}
+
+// Fonction pour rendre la référence prise pour une ligne finalement non enregistrée, et effacer le blob s'il n'est plus utilisé
+func releaseBlob(ctx context.Context, refs *BlobRepo, store BlobStore, key string) {
+	keys, err := refs.Release(ctx, key)
+	if err != nil {
+		log.Println("Erreur lors de la libération du blob :", err)
+		return
+	}
+	removeBlobs(ctx, refs, store, keys)
+}
+
+// Fonction pour effacer du stockage les blobs qui ne sont plus référencés par aucune ligne.
+// Un blob repris entre-temps par un envoi du même contenu est conservé.
+func removeBlobs(ctx context.Context, refs *BlobRepo, store BlobStore, keys []string) {
+	for _, key := range keys {
+		if _, err := refs.Collect(ctx, store, key); err != nil {
+			log.Println("Erreur lors de la suppression du fichier du stockage :", err)
+		}
+	}
+}
+
+// LocalStore range les blobs sur le disque : <dossier>/<2 premiers caractères>/<clé>
+type LocalStore struct {
+	dir string
+}
+
+// Fonction pour écrire un blob ; le contenu passe par un fichier temporaire renommé à la fin, pour ne jamais laisser de blob partiel
+func (s *LocalStore) Put(ctx context.Context, key string, src io.Reader) error {
+	path, err := blobPath(s.dir, key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".upload-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := io.Copy(tmp, contextReader{ctx: ctx, r: src}); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// Fonction pour ouvrir un blob en lecture
+func (s *LocalStore) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	path, err := blobPath(s.dir, key)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, errBlobNotFound
+	}
+	return f, err
+}
+
+// Fonction pour ouvrir une partie d'un blob en lecture
+func (s *LocalStore) GetRange(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error) {
+	rc, err := s.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	f := rc.(*os.File)
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if length < 0 {
+		return f, nil
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{io.LimitReader(f, length), f}, nil
+}
+
+// Fonction pour lire la taille et la date d'un blob
+func (s *LocalStore) Stat(ctx context.Context, key string) (BlobInfo, error) {
+	path, err := blobPath(s.dir, key)
+	if err != nil {
+		return BlobInfo{}, err
+	}
+	info, err := os.Stat(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return BlobInfo{}, errBlobNotFound
+	}
+	if err != nil {
+		return BlobInfo{}, err
+	}
+	return BlobInfo{Key: key, Size: info.Size(), ModTime: info.ModTime()}, nil
+}
+
+// Fonction pour supprimer un blob
+func (s *LocalStore) Delete(ctx context.Context, key string) error {
+	path, err := blobPath(s.dir, key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// Fonction pour parcourir les blobs du disque (les autres fichiers du dossier sont ignorés)
+func (s *LocalStore) List(ctx context.Context, fn func(info BlobInfo) error) error {
+	err := filepath.WalkDir(s.dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		if expected, err := blobPath(s.dir, d.Name()); err != nil || expected != filepath.Clean(path) {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		return fn(BlobInfo{Key: d.Name(), Size: info.Size(), ModTime: info.ModTime()})
+	})
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// fallbackStore écrit dans le stockage principal et relit dans l'ancien stockage les blobs pas encore migrés.
+// Il permet de changer de stockage sans interruption pendant `storage migrate`.
+type fallbackStore struct {
+	primary  BlobStore
+	fallback BlobStore
+}
+
+// Fonction pour écrire un blob dans le stockage principal
+func (s *fallbackStore) Put(ctx context.Context, key string, src io.Reader) error {
+	return s.primary.Put(ctx, key, src)
+}
+
+// Fonction pour lire un blob, dans l'ancien stockage s'il n'a pas encore été migré
+func (s *fallbackStore) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	rc, err := s.primary.Get(ctx, key)
+	if errors.Is(err, errBlobNotFound) {
+		return s.fallback.Get(ctx, key)
+	}
+	return rc, err
+}
+
+// Fonction pour lire une partie d'un blob, dans l'ancien stockage s'il n'a pas encore été migré
+func (s *fallbackStore) GetRange(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error) {
+	rc, err := s.primary.GetRange(ctx, key, offset, length)
+	if errors.Is(err, errBlobNotFound) {
+		return s.fallback.GetRange(ctx, key, offset, length)
+	}
+	return rc, err
+}
+
+// Fonction pour lire les métadonnées d'un blob, dans l'ancien stockage s'il n'a pas encore été migré
+func (s *fallbackStore) Stat(ctx context.Context, key string) (BlobInfo, error) {
+	info, err := s.primary.Stat(ctx, key)
+	if errors.Is(err, errBlobNotFound) {
+		return s.fallback.Stat(ctx, key)
+	}
+	return info, err
+}
+
+// Fonction pour supprimer un blob des deux stockages
+func (s *fallbackStore) Delete(ctx context.Context, key string) error {
+	if err := s.primary.Delete(ctx, key); err != nil {
+		return err
+	}
+	return s.fallback.Delete(ctx, key)
+}
+
+// Fonction pour parcourir les blobs des deux stockages (chaque clé une seule fois)
+func (s *fallbackStore) List(ctx context.Context, fn func(info BlobInfo) error) error {
+	seen := make(map[string]bool)
+	err := s.primary.List(ctx, func(info BlobInfo) error {
+		seen[info.Key] = true
+		return fn(info)
+	})
+	if err != nil {
+		return err
+	}
+	return s.fallback.List(ctx, func(info BlobInfo) error {
+		if seen[info.Key] {
+			return nil
+		}
+		return fn(info)
+	})
+}
+
+// blobReader donne un accès aléatoire à un blob : chaque déplacement rouvre une lecture partielle,
+// les lectures successives réutilisent le même flux
+type blobReader struct {
+	ctx   context.Context
+	store BlobStore
+	key   string
+	size  int64
+	pos   int64
+	rc    io.ReadCloser
+}
+
+// Fonction pour ouvrir un blob en accès aléatoire
+func openBlobReader(ctx context.Context, store BlobStore, key string) (*blobReader, error) {
+	info, err := store.Stat(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	return &blobReader{ctx: ctx, store: store, key: key, size: info.Size}, nil
+}
+
+func (r *blobReader) Read(p []byte) (int, error) {
+	if r.pos >= r.size {
+		return 0, io.EOF
+	}
+	if r.rc == nil {
+		rc, err := r.store.GetRange(r.ctx, r.key, r.pos, -1)
+		if err != nil {
+			return 0, err
+		}
+		r.rc = rc
+	}
+	n, err := r.rc.Read(p)
+	r.pos += int64(n)
+	if err == io.EOF && r.pos < r.size {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+func (r *blobReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += r.pos
+	case io.SeekEnd:
+		offset += r.size
+	}
+	if offset < 0 {
+		return 0, errors.New("position négative")
+	}
+	if offset != r.pos {
+		r.Close()
+		r.pos = offset
+	}
+	return offset, nil
+}
+
+func (r *blobReader) Close() error {
+	if r.rc == nil {
+		return nil
+	}
+	err := r.rc.Close()
+	r.rc = nil
+	return err
+}
+
+// contextReader interrompt une copie quand la requête est annulée
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (r contextReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.r.Read(p)
+}
+
+// Définir une structure pour représenter le bilan d'une migration entre stockages
+type BlobMigrationReport struct {
+	Copied  int // Blobs copiés vers la destination
+	Skipped int // Blobs déjà présents avec la même taille
+	Deleted int // Blobs supprimés de la source (-delete-source)
+}
+
+// Fonction pour copier tous les blobs d'un stockage vers un autre.
+// L'opération est idempotente : un blob déjà présent avec la même taille n'est pas recopié,
+// on peut donc la relancer après avoir basculé le serveur (avec la source en repli) pour rattraper les derniers dépôts.
+func migrateBlobs(ctx context.Context, src, dst BlobStore, deleteSource, dryRun bool) (BlobMigrationReport, error) {
+	var report BlobMigrationReport
+	err := src.List(ctx, func(info BlobInfo) error {
+		existing, err := dst.Stat(ctx, info.Key)
+		switch {
+		case err == nil && existing.Size == info.Size:
+			report.Skipped++
+		case err != nil && !errors.Is(err, errBlobNotFound):
+			return err
+		case dryRun:
+			log.Printf("[simulation] %s (%d octets) serait copié", info.Key, info.Size)
+			report.Copied++
+			return nil
+		default:
+			if err := copyBlob(ctx, src, dst, info.Key); err != nil {
+				return fmt.Errorf("blob %s : %w", info.Key, err)
+			}
+			report.Copied++
+		}
+
+		if deleteSource && !dryRun {
+			// Ne supprimer la source qu'après avoir relu la taille de la copie
+			copied, err := dst.Stat(ctx, info.Key)
+			if err != nil || copied.Size != info.Size {
+				return fmt.Errorf("blob %s : copie non vérifiée, source conservée", info.Key)
+			}
+			if err := src.Delete(ctx, info.Key); err != nil {
+				return err
+			}
+			report.Deleted++
+		}
+		return nil
+	})
+	return report, err
+}
+
+// Fonction pour copier un blob d'un stockage à l'autre, en flux
+func copyBlob(ctx context.Context, src, dst BlobStore, key string) error {
+	rc, err := src.Get(ctx, key)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	return dst.Put(ctx, key, rc)
+}
+
+// Définir une structure pour représenter le bilan d'un ramasse-miettes du stockage
+type BlobGCReport struct {
+	Unreferenced int // Blobs dont la dernière référence a disparu sans qu'ils soient effacés
+	Unknown      int // Blobs du stockage absents de la table blobs
+}
+
+// Fonction pour effacer les blobs qui ne sont plus référencés, puis ceux que la base ne connaît pas.
+// Les envois en cours ne risquent rien : leur référence est prise avant l'écriture du contenu, et chaque effacement
+// se fait ligne verrouillée. Seuls les blobs inconnus écrits avant olderThan sont effacés, pour laisser finir
+// les dépôts d'un serveur d'une version antérieure encore en service.
+func collectGarbage(ctx context.Context, refs *BlobRepo, store BlobStore, olderThan time.Time, dryRun bool) (BlobGCReport, error) {
+	var report BlobGCReport
+	unreferenced, err := refs.ListUnreferenced(ctx)
+	if err != nil {
+		return report, err
+	}
+	for _, key := range unreferenced {
+		if dryRun {
+			log.Printf("[simulation] blob sans référence : %s serait effacé", key)
+			report.Unreferenced++
+			continue
+		}
+		collected, err := refs.Collect(ctx, store, key)
+		if err != nil {
+			return report, fmt.Errorf("blob %s : %w", key, err)
+		}
+		if collected {
+			report.Unreferenced++
+		}
+	}
+
+	known, err := refs.Keys(ctx)
+	if err != nil {
+		return report, err
+	}
+	var unknown []string
+	err = store.List(ctx, func(info BlobInfo) error {
+		if !known[info.Key] && info.ModTime.Before(olderThan) {
+			unknown = append(unknown, info.Key)
+		}
+		return nil
+	})
+	if err != nil {
+		return report, err
+	}
+	for _, key := range unknown {
+		if dryRun {
+			log.Printf("[simulation] blob inconnu : %s serait effacé", key)
+			report.Unknown++
+			continue
+		}
+		collected, err := refs.CollectUnknown(ctx, store, key)
+		if err != nil {
+			return report, fmt.Errorf("blob %s : %w", key, err)
+		}
+		if collected {
+			report.Unknown++
+		}
+	}
+	return report, nil
+}
@@ -0,0 +1,432 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Taille des parties d'un envoi multipart S3 (minimum imposé par S3 : 5 Mio, sauf pour la dernière partie)
+const s3PartSize = 8 << 20
+
+// Empreinte SHA-256 d'un corps vide, utilisée pour signer les requêtes sans contenu
+const emptyPayloadHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+// S3Store range les blobs dans un bucket compatible S3 (AWS, MinIO...), signé en AWS Signature V4
+type S3Store struct {
+	endpoint  *url.URL
+	region    string
+	bucket    string
+	prefix    string // Préfixe ajouté à toutes les clés dans le bucket
+	accessKey string
+	secretKey string
+	pathStyle bool // URL https://hôte/bucket/clé au lieu de https://bucket.hôte/clé (MinIO)
+	client    *http.Client
+}
+
+// Fonction pour créer un driver S3 à partir de la configuration
+func NewS3Store(cfg S3Config) (*S3Store, error) {
+	endpoint, err := url.Parse(cfg.Endpoint)
+	if err != nil || endpoint.Host == "" || (endpoint.Scheme != "http" && endpoint.Scheme != "https") {
+		return nil, fmt.Errorf("storage.s3.endpoint invalide : %q", cfg.Endpoint)
+	}
+	if cfg.Bucket == "" {
+		return nil, errors.New("storage.s3.bucket est vide")
+	}
+	region := cfg.Region
+	if region == "" {
+		region = "us-east-1"
+	}
+	return &S3Store{
+		endpoint:  endpoint,
+		region:    region,
+		bucket:    cfg.Bucket,
+		prefix:    strings.Trim(cfg.Prefix, "/"),
+		accessKey: cfg.AccessKeyID,
+		secretKey: cfg.SecretAccessKey,
+		pathStyle: cfg.PathStyle,
+		client:    &http.Client{Timeout: 0}, // Pas de délai global : les transferts peuvent être longs, le contexte suffit
+	}, nil
+}
+
+// Définir une structure pour représenter une erreur renvoyée par S3
+type s3Error struct {
+	Status  int
+	Code    string `xml:"Code"`
+	Message string `xml:"Message"`
+}
+
+func (e *s3Error) Error() string {
+	return fmt.Sprintf("erreur S3 %d %s : %s", e.Status, e.Code, e.Message)
+}
+
+// Fonction pour écrire un blob : envoi simple s'il tient dans une partie, envoi multipart sinon
+func (s *S3Store) Put(ctx context.Context, key string, src io.Reader) error {
+	if !storageKeyPattern.MatchString(key) {
+		return errInvalidStorageKey
+	}
+
+	first, err := readPart(src)
+	if err != nil {
+		return err
+	}
+	if len(first) < s3PartSize {
+		resp, err := s.do(ctx, http.MethodPut, key, nil, first)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		return nil
+	}
+	return s.putMultipart(ctx, key, first, src)
+}
+
+// Fonction pour lire au plus une partie depuis un flux
+func readPart(src io.Reader) ([]byte, error) {
+	buf := make([]byte, s3PartSize)
+	n, err := io.ReadFull(src, buf)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		err = nil
+	}
+	return buf[:n], err
+}
+
+// Fonction pour envoyer un blob en plusieurs parties ; l'envoi est annulé côté S3 en cas d'erreur
+func (s *S3Store) putMultipart(ctx context.Context, key string, first []byte, src io.Reader) (err error) {
+	resp, err := s.do(ctx, http.MethodPost, key, url.Values{"uploads": {""}}, nil)
+	if err != nil {
+		return err
+	}
+	var initiated struct {
+		UploadID string `xml:"UploadId"`
+	}
+	err = decodeS3Response(resp, &initiated)
+	if err != nil {
+		return err
+	}
+	uploadID := initiated.UploadID
+
+	defer func() {
+		if err != nil {
+			// Contexte neuf : l'annulation doit partir même si la requête d'origine a été interrompue
+			abortCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+			defer cancel()
+			if resp, abortErr := s.do(abortCtx, http.MethodDelete, key, url.Values{"uploadId": {uploadID}}, nil); abortErr == nil {
+				resp.Body.Close()
+			}
+		}
+	}()
+
+	type completedPart struct {
+		PartNumber int    `xml:"PartNumber"`
+		ETag       string `xml:"ETag"`
+	}
+	var parts []completedPart
+	part := first
+	for number := 1; len(part) > 0; number++ {
+		query := url.Values{"partNumber": {strconv.Itoa(number)}, "uploadId": {uploadID}}
+		resp, err := s.do(ctx, http.MethodPut, key, query, part)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		parts = append(parts, completedPart{PartNumber: number, ETag: resp.Header.Get("ETag")})
+
+		if len(part) < s3PartSize {
+			break
+		}
+		if part, err = readPart(src); err != nil {
+			return err
+		}
+	}
+
+	body, err := xml.Marshal(struct {
+		XMLName xml.Name        `xml:"CompleteMultipartUpload"`
+		Parts   []completedPart `xml:"Part"`
+	}{Parts: parts})
+	if err != nil {
+		return err
+	}
+	resp, err = s.do(ctx, http.MethodPost, key, url.Values{"uploadId": {uploadID}}, body)
+	if err != nil {
+		return err
+	}
+	// S3 peut répondre 200 avec une erreur dans le corps si l'assemblage échoue
+	var completed struct {
+		XMLName xml.Name
+		Code    string `xml:"Code"`
+		Message string `xml:"Message"`
+	}
+	if err := decodeS3Response(resp, &completed); err != nil {
+		return err
+	}
+	if completed.XMLName.Local == "Error" {
+		return &s3Error{Status: http.StatusOK, Code: completed.Code, Message: completed.Message}
+	}
+	return nil
+}
+
+// Fonction pour ouvrir un blob en lecture (le corps de la réponse est renvoyé tel quel, en flux)
+func (s *S3Store) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	if !storageKeyPattern.MatchString(key) {
+		return nil, errInvalidStorageKey
+	}
+	resp, err := s.do(ctx, http.MethodGet, key, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// Fonction pour ouvrir une partie d'un blob en lecture (en-tête Range)
+func (s *S3Store) GetRange(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error) {
+	if !storageKeyPattern.MatchString(key) {
+		return nil, errInvalidStorageKey
+	}
+	byteRange := fmt.Sprintf("bytes=%d-", offset)
+	if length >= 0 {
+		byteRange += strconv.FormatInt(offset+length-1, 10)
+	}
+	resp, err := s.send(ctx, http.MethodGet, key, nil, http.Header{"Range": {byteRange}}, nil)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// Fonction pour lire la taille et la date d'un blob
+func (s *S3Store) Stat(ctx context.Context, key string) (BlobInfo, error) {
+	if !storageKeyPattern.MatchString(key) {
+		return BlobInfo{}, errInvalidStorageKey
+	}
+	resp, err := s.do(ctx, http.MethodHead, key, nil, nil)
+	if err != nil {
+		return BlobInfo{}, err
+	}
+	resp.Body.Close()
+	modTime, _ := http.ParseTime(resp.Header.Get("Last-Modified"))
+	return BlobInfo{Key: key, Size: resp.ContentLength, ModTime: modTime}, nil
+}
+
+// Fonction pour supprimer un blob (S3 ne signale pas les clés inexistantes)
+func (s *S3Store) Delete(ctx context.Context, key string) error {
+	if !storageKeyPattern.MatchString(key) {
+		return errInvalidStorageKey
+	}
+	resp, err := s.do(ctx, http.MethodDelete, key, nil, nil)
+	if errors.Is(err, errBlobNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// Fonction pour parcourir les blobs du bucket, page par page (ListObjectsV2)
+func (s *S3Store) List(ctx context.Context, fn func(info BlobInfo) error) error {
+	prefix := ""
+	if s.prefix != "" {
+		prefix = s.prefix + "/"
+	}
+	token := ""
+	for {
+		query := url.Values{"list-type": {"2"}, "prefix": {prefix}}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+		resp, err := s.do(ctx, http.MethodGet, "", query, nil)
+		if err != nil {
+			return err
+		}
+		var page struct {
+			Contents []struct {
+				Key          string    `xml:"Key"`
+				Size         int64     `xml:"Size"`
+				LastModified time.Time `xml:"LastModified"`
+			} `xml:"Contents"`
+			IsTruncated           bool   `xml:"IsTruncated"`
+			NextContinuationToken string `xml:"NextContinuationToken"`
+		}
+		if err := decodeS3Response(resp, &page); err != nil {
+			return err
+		}
+
+		for _, object := range page.Contents {
+			key := strings.TrimPrefix(object.Key, prefix)
+			if !storageKeyPattern.MatchString(key) {
+				continue
+			}
+			if err := fn(BlobInfo{Key: key, Size: object.Size, ModTime: object.LastModified}); err != nil {
+				return err
+			}
+		}
+		if !page.IsTruncated || page.NextContinuationToken == "" {
+			return nil
+		}
+		token = page.NextContinuationToken
+	}
+}
+
+// Fonction pour décoder une réponse XML de S3 puis fermer son corps
+func decodeS3Response(resp *http.Response, v interface{}) error {
+	defer resp.Body.Close()
+	return xml.NewDecoder(resp.Body).Decode(v)
+}
+
+// Fonction pour construire, signer et envoyer une requête S3. Une clé vide désigne le bucket lui-même.
+// Les réponses hors 2xx sont converties en erreur (errBlobNotFound pour 404).
+func (s *S3Store) do(ctx context.Context, method, key string, query url.Values, body []byte) (*http.Response, error) {
+	return s.send(ctx, method, key, query, nil, body)
+}
+
+// Fonction pour envoyer une requête signée avec des en-têtes supplémentaires (non signés)
+func (s *S3Store) send(ctx context.Context, method, key string, query url.Values, header http.Header, body []byte) (*http.Response, error) {
+	host := s.endpoint.Host
+	path := strings.TrimRight(s.endpoint.Path, "/")
+	if s.pathStyle {
+		path += "/" + s.bucket
+	} else {
+		host = s.bucket + "." + host
+	}
+	if key != "" {
+		if s.prefix != "" {
+			path += "/" + s.prefix
+		}
+		path += "/" + key
+	}
+	if path == "" {
+		path = "/"
+	}
+
+	u := &url.URL{Scheme: s.endpoint.Scheme, Host: host, Path: path, RawPath: s3EscapePath(path), RawQuery: s3CanonicalQuery(query)}
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.ContentLength = int64(len(body))
+	if body == nil {
+		req.Body = http.NoBody
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	s.sign(req, body, time.Now().UTC())
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return resp, nil
+	}
+
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, errBlobNotFound
+	}
+	apiErr := &s3Error{Status: resp.StatusCode}
+	xml.NewDecoder(io.LimitReader(resp.Body, 64<<10)).Decode(apiErr)
+	return nil, apiErr
+}
+
+// Fonction pour signer une requête selon AWS Signature Version 4
+func (s *S3Store) sign(req *http.Request, body []byte, now time.Time) {
+	payloadHash := emptyPayloadHash
+	if len(body) > 0 {
+		sum := sha256.Sum256(body)
+		payloadHash = hex.EncodeToString(sum[:])
+	}
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	signedHeaders := "host;x-amz-content-sha256;x-amz-date"
+	canonicalHeaders := "host:" + req.URL.Host + "\n" +
+		"x-amz-content-sha256:" + payloadHash + "\n" +
+		"x-amz-date:" + amzDate + "\n"
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		canonicalHeaders,
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := date + "/" + s.region + "/s3/aws4_request"
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+
+	signingKey := hmacSHA256([]byte("AWS4"+s.secretKey), date)
+	signingKey = hmacSHA256(signingKey, s.region)
+	signingKey = hmacSHA256(signingKey, "s3")
+	signingKey = hmacSHA256(signingKey, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(signingKey, stringToSign))
+
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+s.accessKey+"/"+scope+
+		", SignedHeaders="+signedHeaders+", Signature="+signature)
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// Fonction pour encoder un chemin au format attendu par la signature S3 (chaque segment, '/' conservé)
+func s3EscapePath(path string) string {
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		segments[i] = s3Escape(segment)
+	}
+	return strings.Join(segments, "/")
+}
+
+// Fonction pour construire la chaîne de requête canonique : clés triées, encodage RFC 3986 strict
+func s3CanonicalQuery(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for key := range query {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var parts []string
+	for _, key := range keys {
+		values := append([]string(nil), query[key]...)
+		sort.Strings(values)
+		for _, value := range values {
+			parts = append(parts, s3Escape(key)+"="+s3Escape(value))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// Fonction pour encoder une chaîne selon RFC 3986 (seuls A-Z a-z 0-9 - _ . ~ restent en clair)
+func s3Escape(value string) string {
+	var b strings.Builder
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		if ('A' <= c && c <= 'Z') || ('a' <= c && c <= 'z') || ('0' <= c && c <= '9') || c == '-' || c == '_' || c == '.' || c == '~' {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
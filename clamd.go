@@ -0,0 +1,145 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+)
+
+// Taille des morceaux envoyés à clamd (INSTREAM) : un en-tête de 4 octets puis les données
+const clamdChunkSize = 64 << 10
+
+// Erreurs renvoyées par le client clamd
+var (
+	errClamd      = errors.New("erreur clamd")                             // clamd refuse ou ne sait pas analyser le contenu
+	errScanSource = errors.New("lecture du contenu à analyser impossible") // Le contenu n'a pas pu être lu jusqu'au bout
+)
+
+// ClamdScanner analyse les contenus avec un démon clamd, par son protocole texte (commandes préfixées par "z",
+// terminées par un octet nul). Chaque commande ouvre sa propre connexion.
+type ClamdScanner struct {
+	network string // "tcp" ou "unix"
+	address string
+	timeout time.Duration
+}
+
+// Fonction pour créer le client clamd : "hôte:port" ou "unix:/chemin/clamd.sock"
+func NewClamdScanner(address string, timeout time.Duration) *ClamdScanner {
+	if path, ok := strings.CutPrefix(address, "unix:"); ok {
+		return &ClamdScanner{network: "unix", address: path, timeout: timeout}
+	}
+	return &ClamdScanner{network: "tcp", address: address, timeout: timeout}
+}
+
+// Fonction pour analyser un contenu (commande INSTREAM)
+func (s *ClamdScanner) Scan(ctx context.Context, r io.Reader) (ScanResult, error) {
+	var reply string
+	err := s.command(ctx, "INSTREAM", func(conn net.Conn) error {
+		w := bufio.NewWriterSize(conn, clamdChunkSize+4)
+		buf := make([]byte, clamdChunkSize)
+		var size [4]byte
+		for {
+			n, err := r.Read(buf)
+			if n > 0 {
+				binary.BigEndian.PutUint32(size[:], uint32(n))
+				w.Write(size[:])
+				if _, err := w.Write(buf[:n]); err != nil {
+					return err
+				}
+			}
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return fmt.Errorf("%w : %v", errScanSource, err)
+			}
+		}
+		// Un morceau de taille nulle termine le flux
+		binary.BigEndian.PutUint32(size[:], 0)
+		w.Write(size[:])
+		return w.Flush()
+	}, &reply)
+	// clamd coupe la connexion quand le flux dépasse sa limite (StreamMaxLength) : sa réponse explique pourquoi
+	if err != nil && (reply == "" || errors.Is(err, errScanSource)) {
+		return ScanResult{}, err
+	}
+
+	reply = strings.TrimPrefix(reply, "stream: ")
+	switch {
+	case reply == "OK":
+		return ScanResult{}, nil
+	case strings.HasSuffix(reply, " FOUND"):
+		return ScanResult{Infected: true, Signature: strings.TrimSuffix(reply, " FOUND")}, nil
+	default:
+		return ScanResult{}, fmt.Errorf("%w : %s", errClamd, reply)
+	}
+}
+
+// Fonction pour lire la version des signatures (commande VERSION) : "ClamAV 1.0.1/26800/date" donne "26800"
+func (s *ClamdScanner) Version(ctx context.Context) (string, error) {
+	var reply string
+	if err := s.command(ctx, "VERSION", nil, &reply); err != nil {
+		return "", err
+	}
+	if _, rest, ok := strings.Cut(reply, "/"); ok {
+		signatures, _, _ := strings.Cut(rest, "/")
+		return signatures, nil
+	}
+	if reply == "" || strings.HasSuffix(reply, " ERROR") {
+		return "", fmt.Errorf("%w : réponse VERSION inattendue %q", errClamd, reply)
+	}
+	// Sans base de signatures chargée, clamd ne renvoie que la version du moteur
+	return reply, nil
+}
+
+// Fonction pour envoyer une commande, puis d'éventuelles données, et lire la réponse jusqu'à l'octet nul
+func (s *ClamdScanner) command(ctx context.Context, name string, send func(net.Conn) error, reply *string) error {
+	dialer := net.Dialer{Timeout: s.timeout}
+	conn, err := dialer.DialContext(ctx, s.network, s.address)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	deadline := time.Now().Add(s.timeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	conn.SetDeadline(deadline)
+	// Une requête abandonnée interrompt l'analyse en cours
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-done:
+		}
+	}()
+
+	var sendErr error
+	if _, sendErr = conn.Write([]byte("z" + name + "\x00")); sendErr == nil && send != nil {
+		sendErr = send(conn)
+	}
+	if sendErr != nil {
+		// Fermer l'envoi pour que clamd réponde (ou coupe) sans attendre la suite du flux
+		if closer, ok := conn.(interface{ CloseWrite() error }); ok {
+			closer.CloseWrite()
+		}
+	}
+	line, err := bufio.NewReader(conn).ReadString(0)
+	*reply = strings.TrimSpace(strings.TrimSuffix(line, "\x00"))
+	if sendErr != nil {
+		return sendErr
+	}
+	if err != nil && (err != io.EOF || *reply == "") {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeClamd imite clamd sur une connexion TCP locale : il vérifie le découpage du flux INSTREAM
+// et répond ce que le test a prévu
+type fakeClamd struct {
+	t         *testing.T
+	listener  net.Listener
+	reply     string // Réponse à INSTREAM, sans l'octet nul final
+	version   string // Réponse à VERSION
+	sizeLimit int    // Au-delà, la connexion est coupée avec l'erreur de clamd (0 : pas de limite)
+
+	mu       sync.Mutex
+	commands []string
+	chunks   []int  // Taille de chaque morceau reçu, terminateur compris
+	received []byte // Contenu reçu
+}
+
+// Fonction pour démarrer un faux clamd et le client qui l'interroge
+func newFakeClamd(t *testing.T) (*fakeClamd, *ClamdScanner) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fake := &fakeClamd{t: t, listener: listener, reply: "stream: OK"}
+	t.Cleanup(func() { listener.Close() })
+	go fake.serve()
+	return fake, NewClamdScanner(listener.Addr().String(), 5*time.Second)
+}
+
+func (f *fakeClamd) serve() {
+	for {
+		conn, err := f.listener.Accept()
+		if err != nil {
+			return
+		}
+		go f.handle(conn)
+	}
+}
+
+func (f *fakeClamd) handle(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	command, err := r.ReadString(0)
+	if err != nil {
+		f.t.Errorf("commande incomplète : %q (%v)", command, err)
+		return
+	}
+	f.mu.Lock()
+	f.commands = append(f.commands, command)
+	f.mu.Unlock()
+
+	switch command {
+	case "zVERSION\x00":
+		conn.Write([]byte(f.version + "\x00"))
+	case "zINSTREAM\x00":
+		total := 0
+		for {
+			var size [4]byte
+			if _, err := io.ReadFull(r, size[:]); err != nil {
+				// Le client a abandonné le flux (source illisible) : les tests vérifient eux-mêmes le terminateur
+				return
+			}
+			n := int(binary.BigEndian.Uint32(size[:]))
+			f.mu.Lock()
+			f.chunks = append(f.chunks, n)
+			f.mu.Unlock()
+			if n == 0 {
+				break
+			}
+			data := make([]byte, n)
+			if _, err := io.ReadFull(r, data); err != nil {
+				f.t.Errorf("morceau incomplet : %v", err)
+				return
+			}
+			f.mu.Lock()
+			f.received = append(f.received, data...)
+			f.mu.Unlock()
+			total += n
+			// Comme clamd (StreamMaxLength) : répondre puis couper sans lire la suite
+			if f.sizeLimit > 0 && total > f.sizeLimit {
+				conn.Write([]byte("INSTREAM size limit exceeded. ERROR\x00"))
+				return
+			}
+		}
+		conn.Write([]byte(f.reply + "\x00"))
+	default:
+		conn.Write([]byte("UNKNOWN COMMAND\x00"))
+	}
+}
+
+func TestClamdInstreamFraming(t *testing.T) {
+	fake, scanner := newFakeClamd(t)
+
+	content := bytes.Repeat([]byte("0123456789abcdef"), (2*clamdChunkSize+100)/16)
+	result, err := scanner.Scan(context.Background(), bytes.NewReader(content))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Infected {
+		t.Fatalf("verdict = %+v, attendu sain", result)
+	}
+
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	if len(fake.commands) != 1 || fake.commands[0] != "zINSTREAM\x00" {
+		t.Fatalf("commandes = %q", fake.commands)
+	}
+	if !bytes.Equal(fake.received, content) {
+		t.Fatalf("contenu reçu : %d octets, attendu %d", len(fake.received), len(content))
+	}
+	// Des morceaux d'au plus clamdChunkSize, puis un morceau de taille nulle qui termine le flux
+	if last := fake.chunks[len(fake.chunks)-1]; last != 0 {
+		t.Fatalf("dernier morceau = %d, attendu le terminateur 0", last)
+	}
+	for _, n := range fake.chunks[:len(fake.chunks)-1] {
+		if n <= 0 || n > clamdChunkSize {
+			t.Fatalf("tailles des morceaux = %v", fake.chunks)
+		}
+	}
+}
+
+func TestClamdEmptyStream(t *testing.T) {
+	fake, scanner := newFakeClamd(t)
+	if _, err := scanner.Scan(context.Background(), strings.NewReader("")); err != nil {
+		t.Fatal(err)
+	}
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	if len(fake.chunks) != 1 || fake.chunks[0] != 0 {
+		t.Fatalf("morceaux = %v, attendu le seul terminateur", fake.chunks)
+	}
+}
+
+func TestClamdReplies(t *testing.T) {
+	cases := []struct {
+		reply     string
+		infected  bool
+		signature string
+		err       bool
+	}{
+		{"stream: OK", false, "", false},
+		{"stream: Eicar-Test-Signature FOUND", true, "Eicar-Test-Signature", false},
+		{"stream: Win.Test.EICAR_HDB-1 FOUND", true, "Win.Test.EICAR_HDB-1", false},
+		{"stream: Can't allocate memory ERROR", false, "", true},
+	}
+	for _, tc := range cases {
+		t.Run(tc.reply, func(t *testing.T) {
+			fake, scanner := newFakeClamd(t)
+			fake.reply = tc.reply
+			result, err := scanner.Scan(context.Background(), strings.NewReader("contenu"))
+			if tc.err {
+				if !errors.Is(err, errClamd) {
+					t.Fatalf("erreur = %v, attendu errClamd", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result.Infected != tc.infected || result.Signature != tc.signature {
+				t.Fatalf("verdict = %+v", result)
+			}
+		})
+	}
+}
+
+func TestClamdSizeLimitExceeded(t *testing.T) {
+	fake, scanner := newFakeClamd(t)
+	fake.sizeLimit = clamdChunkSize
+
+	_, err := scanner.Scan(context.Background(), bytes.NewReader(make([]byte, 64*clamdChunkSize)))
+	if !errors.Is(err, errClamd) || !strings.Contains(err.Error(), "INSTREAM size limit exceeded") {
+		t.Fatalf("erreur = %v, attendu la limite de taille de clamd", err)
+	}
+}
+
+func TestClamdSourceError(t *testing.T) {
+	_, scanner := newFakeClamd(t)
+	src := io.MultiReader(strings.NewReader("début"), &failingReader{err: errors.New("disque illisible")})
+	if _, err := scanner.Scan(context.Background(), src); !errors.Is(err, errScanSource) {
+		t.Fatalf("erreur = %v, attendu errScanSource", err)
+	}
+}
+
+func TestClamdVersion(t *testing.T) {
+	cases := []struct {
+		reply, want string
+		err         bool
+	}{
+		{"ClamAV 1.0.1/26800/Mon Feb 13 08:20:33 2023", "26800", false},
+		{"ClamAV 1.0.1", "ClamAV 1.0.1", false},
+		{"UNKNOWN COMMAND ERROR", "", true},
+	}
+	for _, tc := range cases {
+		fake, scanner := newFakeClamd(t)
+		fake.version = tc.reply
+		got, err := scanner.Version(context.Background())
+		if tc.err {
+			if !errors.Is(err, errClamd) {
+				t.Errorf("Version(%q) : erreur = %v, attendu errClamd", tc.reply, err)
+			}
+			continue
+		}
+		if err != nil || got != tc.want {
+			t.Errorf("Version(%q) = %q, %v ; attendu %q", tc.reply, got, err, tc.want)
+		}
+	}
+}
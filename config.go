@@ -0,0 +1,551 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/go-sql-driver/mysql"
+	"gopkg.in/yaml.v3"
+)
+
+// Modes de fonctionnement reconnus
+const (
+	modeDevelopment = "development"
+	modeProduction  = "production"
+)
+
+// Valeurs par défaut historiques, refusées en production
+const (
+	defaultSessionSecret = "secret"
+	redacted             = "********"
+)
+
+// Définir une structure pour représenter la configuration effective de l'application
+type Config struct {
+	Mode       string         `yaml:"mode" toml:"mode"`               // "development" ou "production"
+	ListenAddr string         `yaml:"listen_addr" toml:"listen_addr"` // Adresse d'écoute HTTP
+	Database   DatabaseConfig `yaml:"database" toml:"database"`
+	Session    SessionConfig  `yaml:"session" toml:"session"`
+	Storage    StorageConfig  `yaml:"storage" toml:"storage"`
+	Crypto     CryptoConfig   `yaml:"crypto" toml:"crypto"`
+	Versions   VersionsConfig `yaml:"versions" toml:"versions"`
+	Trash      TrashConfig    `yaml:"trash" toml:"trash"`
+	Quotas     QuotasConfig   `yaml:"quotas" toml:"quotas"`
+	Scrub      ScrubConfig    `yaml:"scrub" toml:"scrub"`
+	Extract    ExtractConfig  `yaml:"extract" toml:"extract"`
+	Upload     UploadConfig   `yaml:"upload" toml:"upload"`
+	Scan       ScanConfig     `yaml:"scan" toml:"scan"`
+	Admin      AdminConfig    `yaml:"admin" toml:"admin"`
+}
+
+// Paramètres de connexion MySQL et du pool
+type DatabaseConfig struct {
+	DSN             string        `yaml:"dsn" toml:"dsn"`   // DSN du serveur, sans base sélectionnée
+	Name            string        `yaml:"name" toml:"name"` // Base créée et utilisée par l'application
+	MaxOpenConns    int           `yaml:"max_open_conns" toml:"max_open_conns"`
+	MaxIdleConns    int           `yaml:"max_idle_conns" toml:"max_idle_conns"`
+	ConnMaxLifetime time.Duration `yaml:"conn_max_lifetime" toml:"conn_max_lifetime"`
+	ConnMaxIdleTime time.Duration `yaml:"conn_max_idle_time" toml:"conn_max_idle_time"`
+	AutoMigrate     bool          `yaml:"auto_migrate" toml:"auto_migrate"` // Appliquer les migrations au démarrage
+}
+
+// Paramètres des sessions
+type SessionConfig struct {
+	Secret string `yaml:"secret" toml:"secret"` // Clé de signature des cookies de session
+}
+
+// Paramètres de stockage des fichiers
+type StorageConfig struct {
+	Backend    string   `yaml:"backend" toml:"backend"`         // Stockage des contenus : "local" ou "s3"
+	Fallback   string   `yaml:"fallback" toml:"fallback"`       // Ancien stockage relu pendant une migration (vide sinon)
+	UploadsDir string   `yaml:"uploads_dir" toml:"uploads_dir"` // Dossier des fichiers déposés (stockage local)
+	S3         S3Config `yaml:"s3" toml:"s3"`
+
+	Replica    string `yaml:"replica" toml:"replica"`         // Copie de secours tenue à jour à part (vide : aucune), "local" ou "s3"
+	ReplicaDir string `yaml:"replica_dir" toml:"replica_dir"` // Dossier de la copie de secours locale
+
+	StagingDir   string        `yaml:"staging_dir" toml:"staging_dir"`     // Dossier local des envois reprenables en cours (tus)
+	UploadExpiry time.Duration `yaml:"upload_expiry" toml:"upload_expiry"` // Durée de vie d'un envoi reprenable sans activité
+}
+
+// Paramètres d'un stockage compatible S3 (AWS, MinIO...)
+type S3Config struct {
+	Endpoint        string `yaml:"endpoint" toml:"endpoint"` // URL du service, par exemple http://localhost:9000
+	Region          string `yaml:"region" toml:"region"`
+	Bucket          string `yaml:"bucket" toml:"bucket"`
+	Prefix          string `yaml:"prefix" toml:"prefix"` // Préfixe des clés dans le bucket
+	AccessKeyID     string `yaml:"access_key_id" toml:"access_key_id"`
+	SecretAccessKey string `yaml:"secret_access_key" toml:"secret_access_key"`
+	PathStyle       bool   `yaml:"path_style" toml:"path_style"` // Adressage hôte/bucket/clé (requis par MinIO)
+}
+
+// Paramètres du chiffrement au repos
+type CryptoConfig struct {
+	KeyProvider   string `yaml:"key_provider" toml:"key_provider"`       // Fournisseur de la clé maîtresse : "file"
+	MasterKeyFile string `yaml:"master_key_file" toml:"master_key_file"` // Fichier de la clé maîtresse (64 caractères hexadécimaux)
+}
+
+// Politique de conservation des anciennes versions appliquée aux utilisateurs qui n'en ont pas choisi (0 : pas de limite)
+type VersionsConfig struct {
+	MaxCount int `yaml:"max_count" toml:"max_count"` // Nombre d'anciennes versions gardées par fichier
+	MaxDays  int `yaml:"max_days" toml:"max_days"`   // Durée de conservation d'une ancienne version, en jours
+}
+
+// Paramètres de la corbeille
+type TrashConfig struct {
+	Retention time.Duration `yaml:"retention" toml:"retention"` // Durée avant la suppression définitive d'un élément mis à la corbeille
+}
+
+// Quotas de stockage par rôle, en Mio (0 : illimité) ; un administrateur peut fixer un quota propre à un utilisateur
+type QuotasConfig struct {
+	DefaultMB int            `yaml:"default_mb" toml:"default_mb"` // Quota des rôles absents de RolesMB
+	RolesMB   map[string]int `yaml:"roles_mb" toml:"roles_mb"`     // Quota par rôle ("utilisateur", "admin"...)
+}
+
+// Paramètres du vérificateur d'intégrité du stockage
+type ScrubConfig struct {
+	Interval time.Duration `yaml:"interval" toml:"interval"` // Délai entre deux vérifications complètes (0 : pas de vérification planifiée)
+	Repair   bool          `yaml:"repair" toml:"repair"`     // Réparer les blobs manquants ou altérés depuis la copie de secours
+}
+
+// Limites de la décompression des archives déposées (zip, tar.gz), contre les bombes de décompression
+type ExtractConfig struct {
+	MaxFiles  int `yaml:"max_files" toml:"max_files"`     // Nombre maximal d'entrées (fichiers et dossiers) dans une archive
+	MaxSizeMB int `yaml:"max_size_mb" toml:"max_size_mb"` // Taille décompressée maximale de l'ensemble des fichiers, en Mio
+	MaxRatio  int `yaml:"max_ratio" toml:"max_ratio"`     // Taux de compression maximal accepté (taille décompressée / taille compressée)
+}
+
+// Politique d'envoi : taille maximale et types de contenu (reconnus d'après les premiers octets, jamais d'après le nom)
+type UploadConfig struct {
+	MaxSizeMB       int      `yaml:"max_size_mb" toml:"max_size_mb"`           // Taille maximale d'un fichier, en Mio (0 : seul le quota limite)
+	AllowedTypes    []string `yaml:"allowed_types" toml:"allowed_types"`       // Types acceptés ("image/*" pour tous les types d'images ; vide : tous)
+	DeniedTypes     []string `yaml:"denied_types" toml:"denied_types"`         // Types refusés, prioritaires sur AllowedTypes
+	QuarantineTypes []string `yaml:"quarantine_types" toml:"quarantine_types"` // Types acceptés mais mis en quarantaine
+	SafeServing     bool     `yaml:"safe_serving" toml:"safe_serving"`         // Servir tous les contenus en téléchargement, jamais affichés dans le navigateur
+}
+
+// Analyse antivirus des contenus envoyés par un démon clamd (protocole INSTREAM)
+type ScanConfig struct {
+	ClamdAddress   string        `yaml:"clamd_address" toml:"clamd_address"`     // "hôte:port" ou "unix:/chemin/clamd.sock" (vide : pas d'analyse)
+	Timeout        time.Duration `yaml:"timeout" toml:"timeout"`                 // Délai maximal d'une analyse
+	RescanInterval time.Duration `yaml:"rescan_interval" toml:"rescan_interval"` // Délai entre deux contrôles des signatures (0 : pas de réanalyse planifiée)
+	Required       bool          `yaml:"required" toml:"required"`               // Refuser les envois quand clamd ne répond pas, au lieu de les analyser plus tard
+}
+
+// Paramètres du compte administrateur
+type AdminConfig struct {
+	InitialPassword string `yaml:"initial_password" toml:"initial_password"` // Mot de passe donné à l'admin lors de sa création
+}
+
+// Fonction pour obtenir la configuration par défaut (comportement historique en développement)
+func defaultConfig() Config {
+	return Config{
+		Mode:       modeDevelopment,
+		ListenAddr: ":8081",
+		Database: DatabaseConfig{
+			DSN:             "root:root@tcp(localhost:3306)/",
+			Name:            "CoffreFortDb",
+			MaxOpenConns:    25,
+			MaxIdleConns:    10,
+			ConnMaxLifetime: 30 * time.Minute,
+			ConnMaxIdleTime: 5 * time.Minute,
+			AutoMigrate:     true,
+		},
+		Session: SessionConfig{Secret: defaultSessionSecret},
+		Storage: StorageConfig{
+			Backend:      backendLocal,
+			UploadsDir:   "uploads",
+			S3:           S3Config{Region: "us-east-1"},
+			StagingDir:   "uploads-staging",
+			UploadExpiry: 24 * time.Hour,
+		},
+		Crypto:   CryptoConfig{KeyProvider: keyProviderFile, MasterKeyFile: "coffre.key"},
+		Versions: VersionsConfig{MaxCount: 10},
+		Trash:    TrashConfig{Retention: 30 * 24 * time.Hour},
+		Quotas:   QuotasConfig{DefaultMB: 1024, RolesMB: map[string]int{"utilisateur": 1024, "admin": 0}},
+		Scrub:    ScrubConfig{Interval: 7 * 24 * time.Hour},
+		Extract:  ExtractConfig{MaxFiles: 1000, MaxSizeMB: 2048, MaxRatio: 100},
+		Upload: UploadConfig{
+			MaxSizeMB:       2048,
+			DeniedTypes:     []string{"application/x-msdownload", "application/x-executable", "application/x-mach-binary"},
+			QuarantineTypes: []string{"text/html", "image/svg+xml", "text/xml", "text/x-shellscript"},
+		},
+		Scan:  ScanConfig{Timeout: time.Minute, RescanInterval: time.Hour},
+		Admin: AdminConfig{InitialPassword: defaultAdminPassword},
+	}
+}
+
+// Définir une structure pour représenter un paramètre surchargeable par variable d'environnement et par option
+type setting struct {
+	key   string // Nom de l'option en ligne de commande
+	env   string // Nom de la variable d'environnement
+	usage string
+	set   func(string) error
+}
+
+// Fonction pour lister les paramètres surchargeables de la configuration
+func (cfg *Config) settings() []setting {
+	return []setting{
+		stringSetting("mode", "COFFRE_MODE", "mode de fonctionnement (development|production)", &cfg.Mode),
+		stringSetting("listen-addr", "COFFRE_LISTEN_ADDR", "adresse d'écoute HTTP", &cfg.ListenAddr),
+		stringSetting("db-dsn", "COFFRE_DB_DSN", "DSN MySQL du serveur (sans base)", &cfg.Database.DSN),
+		stringSetting("db-name", "COFFRE_DB_NAME", "nom de la base de données", &cfg.Database.Name),
+		intSetting("db-max-open-conns", "COFFRE_DB_MAX_OPEN_CONNS", "nombre maximal de connexions ouvertes", &cfg.Database.MaxOpenConns),
+		intSetting("db-max-idle-conns", "COFFRE_DB_MAX_IDLE_CONNS", "nombre maximal de connexions inactives", &cfg.Database.MaxIdleConns),
+		durationSetting("db-conn-max-lifetime", "COFFRE_DB_CONN_MAX_LIFETIME", "durée de vie maximale d'une connexion", &cfg.Database.ConnMaxLifetime),
+		durationSetting("db-conn-max-idle-time", "COFFRE_DB_CONN_MAX_IDLE_TIME", "durée d'inactivité maximale d'une connexion", &cfg.Database.ConnMaxIdleTime),
+		boolSetting("db-auto-migrate", "COFFRE_DB_AUTO_MIGRATE", "appliquer les migrations au démarrage", &cfg.Database.AutoMigrate),
+		stringSetting("session-secret", "COFFRE_SESSION_SECRET", "clé de signature des cookies de session", &cfg.Session.Secret),
+		stringSetting("storage-backend", "COFFRE_STORAGE_BACKEND", "stockage des contenus (local|s3)", &cfg.Storage.Backend),
+		stringSetting("storage-fallback", "COFFRE_STORAGE_FALLBACK", "ancien stockage relu pendant une migration", &cfg.Storage.Fallback),
+		stringSetting("uploads-dir", "COFFRE_UPLOADS_DIR", "dossier des fichiers déposés", &cfg.Storage.UploadsDir),
+		stringSetting("s3-endpoint", "COFFRE_S3_ENDPOINT", "URL du service S3", &cfg.Storage.S3.Endpoint),
+		stringSetting("s3-region", "COFFRE_S3_REGION", "région S3", &cfg.Storage.S3.Region),
+		stringSetting("s3-bucket", "COFFRE_S3_BUCKET", "bucket S3", &cfg.Storage.S3.Bucket),
+		stringSetting("s3-prefix", "COFFRE_S3_PREFIX", "préfixe des clés dans le bucket", &cfg.Storage.S3.Prefix),
+		stringSetting("s3-access-key-id", "COFFRE_S3_ACCESS_KEY_ID", "identifiant de la clé d'accès S3", &cfg.Storage.S3.AccessKeyID),
+		stringSetting("s3-secret-access-key", "COFFRE_S3_SECRET_ACCESS_KEY", "clé d'accès secrète S3", &cfg.Storage.S3.SecretAccessKey),
+		boolSetting("s3-path-style", "COFFRE_S3_PATH_STYLE", "adressage hôte/bucket/clé (MinIO)", &cfg.Storage.S3.PathStyle),
+		stringSetting("storage-replica", "COFFRE_STORAGE_REPLICA", "copie de secours des contenus (local|s3)", &cfg.Storage.Replica),
+		stringSetting("replica-dir", "COFFRE_REPLICA_DIR", "dossier de la copie de secours locale", &cfg.Storage.ReplicaDir),
+		stringSetting("staging-dir", "COFFRE_STAGING_DIR", "dossier des envois reprenables en cours", &cfg.Storage.StagingDir),
+		durationSetting("upload-expiry", "COFFRE_UPLOAD_EXPIRY", "durée de vie d'un envoi reprenable sans activité", &cfg.Storage.UploadExpiry),
+		stringSetting("key-provider", "COFFRE_KEY_PROVIDER", "fournisseur de la clé maîtresse (file)", &cfg.Crypto.KeyProvider),
+		stringSetting("master-key-file", "COFFRE_MASTER_KEY_FILE", "fichier de la clé maîtresse", &cfg.Crypto.MasterKeyFile),
+		intSetting("versions-max-count", "COFFRE_VERSIONS_MAX_COUNT", "anciennes versions gardées par fichier (0 : illimité)", &cfg.Versions.MaxCount),
+		intSetting("versions-max-days", "COFFRE_VERSIONS_MAX_DAYS", "jours de conservation des anciennes versions (0 : illimité)", &cfg.Versions.MaxDays),
+		durationSetting("trash-retention", "COFFRE_TRASH_RETENTION", "durée de conservation dans la corbeille avant suppression définitive", &cfg.Trash.Retention),
+		intSetting("quota-default-mb", "COFFRE_QUOTA_DEFAULT_MB", "quota des rôles sans quota configuré, en Mio (0 : illimité)", &cfg.Quotas.DefaultMB),
+		durationSetting("scrub-interval", "COFFRE_SCRUB_INTERVAL", "délai entre deux vérifications d'intégrité du stockage (0 : désactivé)", &cfg.Scrub.Interval),
+		boolSetting("scrub-repair", "COFFRE_SCRUB_REPAIR", "réparer les blobs manquants ou altérés depuis la copie de secours", &cfg.Scrub.Repair),
+		intSetting("extract-max-files", "COFFRE_EXTRACT_MAX_FILES", "nombre maximal d'entrées d'une archive décompressée", &cfg.Extract.MaxFiles),
+		intSetting("extract-max-size-mb", "COFFRE_EXTRACT_MAX_SIZE_MB", "taille décompressée maximale d'une archive, en Mio", &cfg.Extract.MaxSizeMB),
+		intSetting("extract-max-ratio", "COFFRE_EXTRACT_MAX_RATIO", "taux de compression maximal d'une archive décompressée", &cfg.Extract.MaxRatio),
+		intSetting("upload-max-size-mb", "COFFRE_UPLOAD_MAX_SIZE_MB", "taille maximale d'un fichier envoyé, en Mio (0 : seul le quota limite)", &cfg.Upload.MaxSizeMB),
+		listSetting("upload-allowed-types", "COFFRE_UPLOAD_ALLOWED_TYPES", "types de contenu acceptés, séparés par des virgules (vide : tous)", &cfg.Upload.AllowedTypes),
+		listSetting("upload-denied-types", "COFFRE_UPLOAD_DENIED_TYPES", "types de contenu refusés, séparés par des virgules", &cfg.Upload.DeniedTypes),
+		listSetting("upload-quarantine-types", "COFFRE_UPLOAD_QUARANTINE_TYPES", "types de contenu mis en quarantaine, séparés par des virgules", &cfg.Upload.QuarantineTypes),
+		boolSetting("upload-safe-serving", "COFFRE_UPLOAD_SAFE_SERVING", "servir tous les contenus en téléchargement", &cfg.Upload.SafeServing),
+		stringSetting("scan-clamd-address", "COFFRE_SCAN_CLAMD_ADDRESS", "adresse du démon clamd (vide : pas d'analyse antivirus)", &cfg.Scan.ClamdAddress),
+		durationSetting("scan-timeout", "COFFRE_SCAN_TIMEOUT", "délai maximal d'une analyse antivirus", &cfg.Scan.Timeout),
+		durationSetting("scan-rescan-interval", "COFFRE_SCAN_RESCAN_INTERVAL", "délai entre deux contrôles des signatures antivirus (0 : désactivé)", &cfg.Scan.RescanInterval),
+		boolSetting("scan-required", "COFFRE_SCAN_REQUIRED", "refuser les envois quand l'antivirus ne répond pas", &cfg.Scan.Required),
+		stringSetting("admin-password", "COFFRE_ADMIN_PASSWORD", "mot de passe initial du compte admin", &cfg.Admin.InitialPassword),
+	}
+}
+
+func stringSetting(key, env, usage string, target *string) setting {
+	return setting{key: key, env: env, usage: usage, set: func(v string) error {
+		*target = v
+		return nil
+	}}
+}
+
+func intSetting(key, env, usage string, target *int) setting {
+	return setting{key: key, env: env, usage: usage, set: func(v string) error {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return err
+		}
+		*target = n
+		return nil
+	}}
+}
+
+func boolSetting(key, env, usage string, target *bool) setting {
+	return setting{key: key, env: env, usage: usage, set: func(v string) error {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return err
+		}
+		*target = b
+		return nil
+	}}
+}
+
+func listSetting(key, env, usage string, target *[]string) setting {
+	return setting{key: key, env: env, usage: usage, set: func(v string) error {
+		*target = nil
+		for _, item := range strings.Split(v, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				*target = append(*target, item)
+			}
+		}
+		return nil
+	}}
+}
+
+func durationSetting(key, env, usage string, target *time.Duration) setting {
+	return setting{key: key, env: env, usage: usage, set: func(v string) error {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return err
+		}
+		*target = d
+		return nil
+	}}
+}
+
+// Fonction pour charger la configuration par couches : défauts, fichier, variables d'environnement puis options.
+// Renvoie aussi les arguments restants (sous-commande éventuelle).
+func LoadConfig(args []string) (Config, []string, error) {
+	cfg := defaultConfig()
+	settings := cfg.settings()
+
+	// Les options sont collectées pendant l'analyse puis appliquées en dernier (priorité la plus haute)
+	fs := flag.NewFlagSet("coffrefort", flag.ContinueOnError)
+	configFile := fs.String("config", os.Getenv("COFFRE_CONFIG"), "fichier de configuration YAML ou TOML (COFFRE_CONFIG)")
+	var overrides []func() error
+	for _, s := range settings {
+		s := s
+		fs.Func(s.key, s.usage+" ("+s.env+")", func(v string) error {
+			overrides = append(overrides, func() error {
+				if err := s.set(v); err != nil {
+					return fmt.Errorf("option -%s invalide : %w", s.key, err)
+				}
+				return nil
+			})
+			return nil
+		})
+	}
+	if err := fs.Parse(args); err != nil {
+		return cfg, nil, err
+	}
+
+	if *configFile != "" {
+		if err := loadConfigFile(*configFile, &cfg); err != nil {
+			return cfg, nil, err
+		}
+	}
+
+	for _, s := range settings {
+		if v, ok := os.LookupEnv(s.env); ok {
+			if err := s.set(v); err != nil {
+				return cfg, nil, fmt.Errorf("variable %s invalide : %w", s.env, err)
+			}
+		}
+	}
+
+	for _, override := range overrides {
+		if err := override(); err != nil {
+			return cfg, nil, err
+		}
+	}
+
+	return cfg, fs.Args(), nil
+}
+
+// Fonction pour lire un fichier de configuration YAML ou TOML selon son extension
+func loadConfigFile(path string, cfg *Config) error {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		decoder := yaml.NewDecoder(strings.NewReader(string(content)))
+		decoder.KnownFields(true)
+		if err := decoder.Decode(cfg); err != nil && !errors.Is(err, io.EOF) {
+			return fmt.Errorf("fichier de configuration %s : %w", path, err)
+		}
+	case ".toml":
+		meta, err := toml.Decode(string(content), cfg)
+		if err != nil {
+			return fmt.Errorf("fichier de configuration %s : %w", path, err)
+		}
+		if undecoded := meta.Undecoded(); len(undecoded) > 0 {
+			return fmt.Errorf("fichier de configuration %s : clé inconnue %s", path, undecoded[0])
+		}
+	default:
+		return fmt.Errorf("format de configuration non pris en charge : %s", path)
+	}
+	return nil
+}
+
+// Fonction pour valider la configuration avant le démarrage
+func (cfg Config) Validate() error {
+	var problems []string
+	switch cfg.Mode {
+	case modeDevelopment, modeProduction:
+	default:
+		problems = append(problems, fmt.Sprintf("mode inconnu %q", cfg.Mode))
+	}
+	if cfg.ListenAddr == "" {
+		problems = append(problems, "listen_addr est vide")
+	}
+	if _, err := mysql.ParseDSN(cfg.Database.DSN); err != nil {
+		problems = append(problems, "database.dsn invalide : "+err.Error())
+	}
+	if cfg.Database.Name == "" {
+		problems = append(problems, "database.name est vide")
+	}
+	if cfg.Database.MaxOpenConns < 1 || cfg.Database.MaxIdleConns < 0 || cfg.Database.MaxIdleConns > cfg.Database.MaxOpenConns {
+		problems = append(problems, "limites du pool de connexions incohérentes")
+	}
+	if cfg.Storage.UploadsDir == "" {
+		problems = append(problems, "storage.uploads_dir est vide")
+	}
+	for _, backend := range []string{cfg.Storage.Backend, cfg.Storage.Fallback, cfg.Storage.Replica} {
+		switch backend {
+		case backendLocal, "":
+		case backendS3:
+			if _, err := NewS3Store(cfg.Storage.S3); err != nil {
+				problems = append(problems, err.Error())
+			}
+		default:
+			problems = append(problems, fmt.Sprintf("stockage inconnu %q", backend))
+		}
+	}
+	if cfg.Storage.Backend == "" {
+		problems = append(problems, "storage.backend est vide")
+	}
+	if cfg.Storage.Fallback != "" && cfg.Storage.Fallback == cfg.Storage.Backend {
+		problems = append(problems, "storage.fallback doit différer de storage.backend")
+	}
+	switch cfg.Storage.Replica {
+	case "":
+	case backendLocal:
+		if cfg.Storage.ReplicaDir == "" || filepath.Clean(cfg.Storage.ReplicaDir) == filepath.Clean(cfg.Storage.UploadsDir) {
+			problems = append(problems, "storage.replica_dir doit être renseigné et différer de storage.uploads_dir")
+		}
+	default:
+		if cfg.Storage.Replica == cfg.Storage.Backend {
+			problems = append(problems, "storage.replica doit différer de storage.backend")
+		}
+	}
+	if cfg.Storage.StagingDir == "" {
+		problems = append(problems, "storage.staging_dir est vide")
+	}
+	if cfg.Storage.UploadExpiry <= 0 {
+		problems = append(problems, "storage.upload_expiry doit être positif")
+	}
+	if cfg.Session.Secret == "" {
+		problems = append(problems, "session.secret est vide")
+	}
+	if cfg.Versions.MaxCount < 0 || cfg.Versions.MaxDays < 0 {
+		problems = append(problems, "versions.max_count et versions.max_days ne peuvent pas être négatifs")
+	}
+	if cfg.Trash.Retention <= 0 {
+		problems = append(problems, "trash.retention doit être positif")
+	}
+	if cfg.Scrub.Interval < 0 {
+		problems = append(problems, "scrub.interval ne peut pas être négatif")
+	}
+	if cfg.Scrub.Repair && cfg.Storage.Replica == "" {
+		problems = append(problems, "scrub.repair demande une copie de secours (storage.replica)")
+	}
+	if cfg.Extract.MaxFiles <= 0 || cfg.Extract.MaxSizeMB <= 0 || cfg.Extract.MaxRatio <= 0 {
+		problems = append(problems, "extract.max_files, extract.max_size_mb et extract.max_ratio doivent être positifs")
+	}
+	if cfg.Upload.MaxSizeMB < 0 {
+		problems = append(problems, "upload.max_size_mb ne peut pas être négatif")
+	}
+	for _, list := range [][]string{cfg.Upload.AllowedTypes, cfg.Upload.DeniedTypes, cfg.Upload.QuarantineTypes} {
+		for _, pattern := range list {
+			if !validTypePattern(pattern) {
+				problems = append(problems, fmt.Sprintf("type de contenu invalide dans upload : %q", pattern))
+			}
+		}
+	}
+	if cfg.Scan.ClamdAddress != "" && cfg.Scan.Timeout <= 0 {
+		problems = append(problems, "scan.timeout doit être positif")
+	}
+	if cfg.Scan.RescanInterval < 0 {
+		problems = append(problems, "scan.rescan_interval ne peut pas être négatif")
+	}
+	if cfg.Scan.Required && cfg.Scan.ClamdAddress == "" {
+		problems = append(problems, "scan.required demande un démon clamd (scan.clamd_address)")
+	}
+	if cfg.Quotas.DefaultMB < 0 {
+		problems = append(problems, "quotas.default_mb ne peut pas être négatif")
+	}
+	for role, mb := range cfg.Quotas.RolesMB {
+		if mb < 0 {
+			problems = append(problems, fmt.Sprintf("quotas.roles_mb.%s ne peut pas être négatif", role))
+		}
+	}
+	switch cfg.Crypto.KeyProvider {
+	case keyProviderFile:
+		if cfg.Crypto.MasterKeyFile == "" {
+			problems = append(problems, "crypto.master_key_file est vide")
+		}
+	default:
+		problems = append(problems, fmt.Sprintf("fournisseur de clé maîtresse inconnu %q", cfg.Crypto.KeyProvider))
+	}
+	if cfg.Mode == modeProduction {
+		if cfg.Session.Secret == defaultSessionSecret || len(cfg.Session.Secret) < 32 {
+			problems = append(problems, "session.secret doit être changé et faire au moins 32 caractères en production")
+		}
+		if cfg.Admin.InitialPassword == defaultAdminPassword {
+			problems = append(problems, "admin.initial_password par défaut interdit en production")
+		}
+	}
+
+	if len(problems) > 0 {
+		return errors.New("configuration invalide : " + strings.Join(problems, " ; "))
+	}
+	return nil
+}
+
+// Fonction pour construire le DSN de la base de l'application à partir du DSN du serveur
+func (cfg DatabaseConfig) DatabaseDSN() (string, error) {
+	dsn, err := mysql.ParseDSN(cfg.DSN)
+	if err != nil {
+		return "", err
+	}
+	dsn.DBName = cfg.Name
+	return dsn.FormatDSN(), nil
+}
+
+// Fonction pour obtenir une copie de la configuration sans les secrets, pour l'affichage
+func (cfg Config) Redacted() Config {
+	if dsn, err := mysql.ParseDSN(cfg.Database.DSN); err == nil {
+		if dsn.Passwd != "" {
+			dsn.Passwd = redacted
+		}
+		cfg.Database.DSN = dsn.FormatDSN()
+	} else {
+		cfg.Database.DSN = redacted
+	}
+	if cfg.Session.Secret != "" {
+		cfg.Session.Secret = redacted
+	}
+	if cfg.Admin.InitialPassword != "" {
+		cfg.Admin.InitialPassword = redacted
+	}
+	if cfg.Storage.S3.SecretAccessKey != "" {
+		cfg.Storage.S3.SecretAccessKey = redacted
+	}
+	return cfg
+}
+
+// Fonction pour exécuter la commande `config dump` : afficher la configuration effective
+func runConfigCommand(cfg Config, args []string) error {
+	if len(args) == 0 || args[0] != "dump" {
+		return errors.New("usage : config dump")
+	}
+
+	encoder := yaml.NewEncoder(os.Stdout)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(cfg.Redacted()); err != nil {
+		return err
+	}
+	if err := encoder.Close(); err != nil {
+		return err
+	}
+
+	// Signaler les problèmes sans échouer : la commande sert justement à diagnostiquer
+	if err := cfg.Validate(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+	}
+	return nil
+}
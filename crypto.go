@@ -0,0 +1,388 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+// Erreurs renvoyées par le chiffrement
+var (
+	errDecrypt        = errors.New("déchiffrement impossible : clé incorrecte ou données altérées")
+	errStreamFormat   = errors.New("format de fichier chiffré inconnu")
+	errStreamTruncate = errors.New("fichier chiffré tronqué")
+)
+
+// Taille des clés symétriques (clés de données, clés utilisateur, clé maîtresse)
+const keySize = chacha20poly1305.KeySize
+
+// Format des fichiers chiffrés : en-tête "CFE" + version, préfixe de nonce, puis segments AEAD de 64 Kio.
+// Chaque segment a son propre nonce (préfixe + compteur + indicateur de dernier segment),
+// ce qui empêche de réordonner, dupliquer ou tronquer les segments sans être détecté.
+const (
+	streamMagic       = "CFE\x01"
+	streamPrefixSize  = 16
+	streamHeaderSize  = len(streamMagic) + streamPrefixSize
+	streamSegmentSize = 64 << 10
+)
+
+// KeyProvider protège les clés avec la clé maîtresse du serveur.
+// La clé maîtresse ne sort jamais du fournisseur, ce qui permet de brancher plus tard un KMS ou un HSM.
+type KeyProvider interface {
+	Wrap(ctx context.Context, plaintext, associatedData []byte) ([]byte, error)
+	Unwrap(ctx context.Context, wrapped, associatedData []byte) ([]byte, error)
+}
+
+// Fournisseurs de clé maîtresse reconnus dans la configuration
+const keyProviderFile = "file"
+
+// Fonction pour ouvrir le fournisseur de clé maîtresse configuré
+func openKeyProvider(cfg Config) (KeyProvider, error) {
+	switch cfg.Crypto.KeyProvider {
+	case keyProviderFile:
+		return loadLocalKeyProvider(cfg.Crypto.MasterKeyFile, cfg.Mode == modeDevelopment)
+	default:
+		return nil, fmt.Errorf("fournisseur de clé maîtresse inconnu : %q", cfg.Crypto.KeyProvider)
+	}
+}
+
+// LocalKeyProvider garde la clé maîtresse dans un fichier local (64 caractères hexadécimaux)
+type LocalKeyProvider struct {
+	key []byte
+}
+
+// Fonction pour charger la clé maîtresse depuis un fichier.
+// En développement, le fichier est créé s'il n'existe pas ; en production son absence est une erreur.
+func loadLocalKeyProvider(path string, create bool) (*LocalKeyProvider, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) && create {
+		key, err := randomBytes(keySize)
+		if err != nil {
+			return nil, err
+		}
+		if err := os.WriteFile(path, []byte(hex.EncodeToString(key)+"\n"), 0600); err != nil {
+			return nil, err
+		}
+		fmt.Printf("Clé maîtresse générée dans %s : à sauvegarder, sans elle les fichiers sont illisibles.\n", path)
+		return &LocalKeyProvider{key: key}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("lecture de la clé maîtresse : %w (générer avec `openssl rand -hex 32 > %s`)", err, path)
+	}
+
+	key, err := hex.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil || len(key) != keySize {
+		return nil, fmt.Errorf("clé maîtresse invalide dans %s : 64 caractères hexadécimaux attendus", path)
+	}
+	return &LocalKeyProvider{key: key}, nil
+}
+
+// Fonction pour protéger une clé avec la clé maîtresse
+func (p *LocalKeyProvider) Wrap(ctx context.Context, plaintext, associatedData []byte) ([]byte, error) {
+	return sealKey(p.key, plaintext, associatedData)
+}
+
+// Fonction pour retrouver une clé protégée par la clé maîtresse
+func (p *LocalKeyProvider) Unwrap(ctx context.Context, wrapped, associatedData []byte) ([]byte, error) {
+	return openKey(p.key, wrapped, associatedData)
+}
+
+// Fonction pour tirer des octets aléatoires
+func randomBytes(n int) ([]byte, error) {
+	buf := make([]byte, n)
+	if _, err := rand.Read(buf); err != nil {
+		return nil, err
+	}
+	return buf, nil
+}
+
+// Fonction pour chiffrer une clé (ou tout petit secret) : nonce aléatoire de 24 octets suivi du chiffré
+func sealKey(key, plaintext, associatedData []byte) ([]byte, error) {
+	aead, err := chacha20poly1305.NewX(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce, err := randomBytes(aead.NonceSize())
+	if err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plaintext, associatedData), nil
+}
+
+// Fonction pour déchiffrer une clé chiffrée par sealKey
+func openKey(key, sealed, associatedData []byte) ([]byte, error) {
+	aead, err := chacha20poly1305.NewX(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < aead.NonceSize()+aead.Overhead() {
+		return nil, errDecrypt
+	}
+	plaintext, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], associatedData)
+	if err != nil {
+		return nil, errDecrypt
+	}
+	return plaintext, nil
+}
+
+// Données associées qui lient la clé de données d'un fichier à son propriétaire
+func fileKeyAD(ownerID int) []byte {
+	return []byte(fmt.Sprintf("coffrefort:file-key:%d", ownerID))
+}
+
+// Données associées qui lient la clé de données d'une note à son propriétaire
+func noteKeyAD(ownerID int) []byte {
+	return []byte(fmt.Sprintf("coffrefort:note-key:%d", ownerID))
+}
+
+// Fonction pour générer une clé de données et la protéger avec la clé de l'utilisateur
+func newDataKey(userKey, associatedData []byte) (dataKey, wrapped []byte, err error) {
+	dataKey, err = randomBytes(keySize)
+	if err != nil {
+		return nil, nil, err
+	}
+	wrapped, err = sealKey(userKey, dataKey, associatedData)
+	return dataKey, wrapped, err
+}
+
+// Fonction pour générer la clé de données d'un fichier
+func newFileKey(userKey []byte, ownerID int) (dataKey, wrapped []byte, err error) {
+	return newDataKey(userKey, fileKeyAD(ownerID))
+}
+
+// Fonction pour retrouver la clé de données d'un fichier
+func openFileKey(userKey, wrapped []byte, ownerID int) ([]byte, error) {
+	return openDataKey(userKey, wrapped, fileKeyAD(ownerID), ownerID)
+}
+
+// Fonction pour calculer la clé de stockage d'un contenu à partir de son empreinte SHA-256 en clair.
+// L'empreinte passe dans un HMAC dont la clé dérive de celle de l'utilisateur : deux dépôts identiques du même
+// utilisateur partagent leur blob, sans que le stockage révèle l'empreinte ni que deux utilisateurs ont le même fichier.
+// La déduplication s'arrête donc à chaque utilisateur : chacun chiffre ses contenus avec ses propres clés.
+func contentStorageKey(userKey []byte, ownerID int, digest []byte) string {
+	derive := hmac.New(sha256.New, userKey)
+	derive.Write([]byte(fmt.Sprintf("coffrefort:dedup-key:%d", ownerID)))
+	mac := hmac.New(sha256.New, derive.Sum(nil))
+	mac.Write(digest)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Fonction pour calculer le nonce d'un segment
+func segmentNonce(prefix []byte, counter uint64, last bool) []byte {
+	nonce := make([]byte, chacha20poly1305.NonceSizeX)
+	copy(nonce, prefix)
+	binary.BigEndian.PutUint64(nonce[streamPrefixSize:], counter<<8)
+	if last {
+		nonce[len(nonce)-1] = 1
+	}
+	return nonce
+}
+
+// encryptReader chiffre un flux à la volée : on lit le chiffré, segment par segment
+type encryptReader struct {
+	aead    cipher.AEAD
+	src     *bufio.Reader
+	header  []byte
+	counter uint64
+	plain   []byte
+	sealed  []byte
+	out     []byte // Chiffré prêt à être lu
+	done    bool
+}
+
+// Fonction pour chiffrer un flux avec une clé de données
+func encryptStream(dataKey []byte, src io.Reader) (io.Reader, error) {
+	prefix, err := randomBytes(streamPrefixSize)
+	if err != nil {
+		return nil, err
+	}
+	return newEncryptReader(dataKey, prefix, src)
+}
+
+// Fonction pour chiffrer le contenu d'un blob dédupliqué. Le préfixe de nonce découle de la clé de données
+// et de la clé de stockage : deux écritures simultanées du même contenu produisent exactement le même chiffré
+// (donc la même empreinte), et comme la clé de stockage découle du contenu, un nonce ne sert jamais à deux contenus différents.
+func encryptBlobStream(dataKey []byte, storageKey string, src io.Reader) (io.Reader, error) {
+	mac := hmac.New(sha256.New, dataKey)
+	mac.Write([]byte("coffrefort:blob-nonce:" + storageKey))
+	return newEncryptReader(dataKey, mac.Sum(nil)[:streamPrefixSize], src)
+}
+
+// Fonction pour créer le lecteur chiffrant avec un préfixe de nonce donné
+func newEncryptReader(dataKey, prefix []byte, src io.Reader) (io.Reader, error) {
+	aead, err := chacha20poly1305.NewX(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	header := append([]byte(streamMagic), prefix...)
+	return &encryptReader{
+		aead:   aead,
+		src:    bufio.NewReaderSize(src, streamSegmentSize),
+		header: header,
+		plain:  make([]byte, streamSegmentSize),
+		sealed: make([]byte, 0, streamSegmentSize+aead.Overhead()),
+		out:    append([]byte(nil), header...),
+	}, nil
+}
+
+func (r *encryptReader) Read(p []byte) (int, error) {
+	for len(r.out) == 0 {
+		if r.done {
+			return 0, io.EOF
+		}
+		n, err := io.ReadFull(r.src, r.plain)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return 0, err
+		}
+		// Dernier segment : flux épuisé pendant ou juste après la lecture
+		last := err != nil
+		if !last {
+			if _, err := r.src.Peek(1); err == io.EOF {
+				last = true
+			} else if err != nil {
+				return 0, err
+			}
+		}
+		nonce := segmentNonce(r.header[len(streamMagic):], r.counter, last)
+		r.out = r.aead.Seal(r.sealed[:0], nonce, r.plain[:n], r.header)
+		r.counter++
+		r.done = last
+	}
+	n := copy(p, r.out)
+	r.out = r.out[n:]
+	return n, nil
+}
+
+// Taille d'un segment chiffré (segment en clair + étiquette d'authentification)
+const streamSealedSegmentSize = streamSegmentSize + chacha20poly1305.Overhead
+
+// Fonction pour calculer la taille en clair d'un fichier chiffré à partir de sa taille chiffrée
+func plaintextSize(sealedSize int64) (size, segments int64, err error) {
+	body := sealedSize - int64(streamHeaderSize)
+	if body < chacha20poly1305.Overhead {
+		return 0, 0, errStreamTruncate
+	}
+	segments = (body + streamSealedSegmentSize - 1) / streamSealedSegmentSize
+	size = body - segments*chacha20poly1305.Overhead
+	// Seul un flux vide a un dernier segment sans contenu
+	if last := body - (segments-1)*streamSealedSegmentSize; last <= chacha20poly1305.Overhead && segments > 1 {
+		return 0, 0, errStreamTruncate
+	}
+	return size, segments, nil
+}
+
+// decryptReader déchiffre un fichier produit par encryptStream en accès aléatoire :
+// seul le segment contenant la position demandée est lu et vérifié, ce qui permet les requêtes Range
+type decryptReader struct {
+	aead     cipher.AEAD
+	src      io.ReadSeekCloser
+	header   []byte
+	size     int64 // Taille en clair
+	segments int64
+	pos      int64
+	current  int64 // Index du segment déchiffré dans plain (-1 si aucun)
+	sealed   []byte
+	plain    []byte
+}
+
+// Fonction pour déchiffrer un fichier chiffré de taille connue ; fermer le lecteur ferme la source
+func decryptSeeker(dataKey []byte, src io.ReadSeekCloser, sealedSize int64) (io.ReadSeekCloser, error) {
+	aead, err := chacha20poly1305.NewX(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	size, segments, err := plaintextSize(sealedSize)
+	if err != nil {
+		return nil, err
+	}
+	header := make([]byte, streamHeaderSize)
+	if _, err := io.ReadFull(src, header); err != nil {
+		return nil, errStreamFormat
+	}
+	if string(header[:len(streamMagic)]) != streamMagic {
+		return nil, errStreamFormat
+	}
+	return &decryptReader{
+		aead:     aead,
+		src:      src,
+		header:   header,
+		size:     size,
+		segments: segments,
+		current:  -1,
+		sealed:   make([]byte, streamSealedSegmentSize),
+		plain:    make([]byte, 0, streamSegmentSize),
+	}, nil
+}
+
+// Fonction pour lire et vérifier un segment
+func (r *decryptReader) load(index int64) error {
+	offset := int64(streamHeaderSize) + index*streamSealedSegmentSize
+	if _, err := r.src.Seek(offset, io.SeekStart); err != nil {
+		return err
+	}
+	sealed := r.sealed
+	if index == r.segments-1 {
+		sealed = sealed[:int64(streamHeaderSize)+r.size+r.segments*chacha20poly1305.Overhead-offset]
+	}
+	if _, err := io.ReadFull(r.src, sealed); err != nil {
+		if err == io.ErrUnexpectedEOF || err == io.EOF {
+			return errStreamTruncate
+		}
+		return err
+	}
+
+	// Le dernier segment porte un indicateur : un fichier coupé entre deux segments ne se déchiffre pas
+	nonce := segmentNonce(r.header[len(streamMagic):], uint64(index), index == r.segments-1)
+	plain, err := r.aead.Open(r.plain[:0], nonce, sealed, r.header)
+	if err != nil {
+		r.current = -1
+		return errDecrypt
+	}
+	r.plain, r.current = plain, index
+	return nil
+}
+
+func (r *decryptReader) Read(p []byte) (int, error) {
+	if r.pos >= r.size {
+		return 0, io.EOF
+	}
+	index := r.pos / streamSegmentSize
+	if index != r.current {
+		if err := r.load(index); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, r.plain[r.pos-index*streamSegmentSize:])
+	r.pos += int64(n)
+	return n, nil
+}
+
+func (r *decryptReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += r.pos
+	case io.SeekEnd:
+		offset += r.size
+	}
+	if offset < 0 {
+		return 0, errors.New("position négative")
+	}
+	r.pos = offset
+	return offset, nil
+}
+
+func (r *decryptReader) Close() error {
+	return r.src.Close()
+}
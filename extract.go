@@ -0,0 +1,473 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+)
+
+// Formats d'archive décompressables au dépôt
+const (
+	extractZip   = "zip"
+	extractTarGz = "tar.gz"
+)
+
+// Profondeur maximale d'un chemin dans une archive décompressée
+const maxExtractDepth = 32
+
+// Taille en dessous de laquelle le taux de compression d'une entrée n'est pas contrôlé (un petit fichier vide compresse très bien)
+const extractRatioFloor = 1 << 20
+
+// Erreur renvoyée pour une archive refusée avant toute écriture dans le coffre
+var errArchiveRejected = errors.New("archive refusée")
+
+// Définir une structure pour représenter une entrée d'archive à importer
+type extractEntry struct {
+	Path string // Chemin nettoyé, sans "/" final
+	Dir  bool
+	Size int64
+	File *zip.File // Entrée zip (nil pour un tar.gz, relu dans une seconde passe)
+}
+
+// Fonction pour reconnaître une archive décompressable à ses premiers octets ; renvoie "" pour un autre contenu
+func detectArchive(file *multipart.FileHeader) (string, error) {
+	src, err := file.Open()
+	if err != nil {
+		return "", err
+	}
+	defer src.Close()
+	magic := make([]byte, 4)
+	n, err := io.ReadFull(src, magic)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		return "", err
+	}
+	switch {
+	case bytes.HasPrefix(magic[:n], []byte("PK\x03\x04")):
+		return extractZip, nil
+	case bytes.HasPrefix(magic[:n], []byte{0x1f, 0x8b}):
+		return extractTarGz, nil
+	}
+	return "", nil
+}
+
+// Fonction pour nettoyer le chemin d'une entrée : les chemins absolus, les « .. », les barres obliques inverses
+// et les noms de lecteur sont refusés plutôt que corrigés, car ils trahissent une archive malveillante
+func cleanEntryPath(name string) (string, error) {
+	trimmed := strings.TrimSuffix(name, "/")
+	switch {
+	case trimmed == "", strings.HasPrefix(name, "/"), strings.Contains(name, `\`),
+		len(name) >= 2 && name[1] == ':':
+		return "", fmt.Errorf("%w : chemin interdit %q", errArchiveRejected, name)
+	}
+	parts := strings.Split(trimmed, "/")
+	if len(parts) > maxExtractDepth {
+		return "", fmt.Errorf("%w : chemin trop profond %q", errArchiveRejected, name)
+	}
+	for _, part := range parts {
+		if part == ".." {
+			return "", fmt.Errorf("%w : chemin interdit %q", errArchiveRejected, name)
+		}
+		if strings.ContainsRune(part, 0) {
+			return "", fmt.Errorf("%w : chemin interdit %q", errArchiveRejected, name)
+		}
+	}
+	cleaned := path.Clean(trimmed)
+	if cleaned == "." {
+		return "", fmt.Errorf("%w : chemin interdit %q", errArchiveRejected, name)
+	}
+	return cleaned, nil
+}
+
+// Fonction pour ignorer les fichiers techniques ajoutés par macOS aux archives
+func ignoredEntry(p string) bool {
+	return p == "__MACOSX" || strings.HasPrefix(p, "__MACOSX/") || path.Base(p) == ".DS_Store"
+}
+
+// extractBudget vérifie les limites d'une archive au fur et à mesure de son inventaire
+type extractBudget struct {
+	limits   ExtractConfig
+	quota    int64 // Espace restant de l'utilisateur (-1 : illimité)
+	entries  int
+	total    int64
+	maxTotal int64
+}
+
+func newExtractBudget(limits ExtractConfig, remaining int64, limited bool) *extractBudget {
+	b := &extractBudget{limits: limits, quota: -1, maxTotal: int64(limits.MaxSizeMB) << 20}
+	if limited {
+		b.quota = remaining
+	}
+	return b
+}
+
+// Fonction pour compter une entrée ; compressed vaut -1 si sa taille compressée n'est pas connue
+func (b *extractBudget) add(size, compressed int64) error {
+	b.entries++
+	if b.entries > b.limits.MaxFiles {
+		return fmt.Errorf("%w : plus de %d entrées", errArchiveRejected, b.limits.MaxFiles)
+	}
+	if size < 0 {
+		return fmt.Errorf("%w : taille d'entrée invalide", errArchiveRejected)
+	}
+	if compressed >= 0 && size > extractRatioFloor && size/maxInt64(compressed, 1) > int64(b.limits.MaxRatio) {
+		return fmt.Errorf("%w : taux de compression supérieur à %d", errArchiveRejected, b.limits.MaxRatio)
+	}
+	b.total += size
+	if b.total > b.maxTotal {
+		return fmt.Errorf("%w : plus de %d Mio une fois décompressée", errArchiveRejected, b.limits.MaxSizeMB)
+	}
+	if b.quota >= 0 && b.total > b.quota {
+		return errQuotaExceeded
+	}
+	return nil
+}
+
+func maxInt64(a, b int64) int64 {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+// Fonction pour inventorier une archive zip sans rien décompresser : les tailles annoncées sont ensuite
+// vérifiées par archive/zip pendant la lecture (une entrée plus longue qu'annoncé est une erreur)
+func planZip(r *zip.Reader, budget *extractBudget) ([]extractEntry, error) {
+	var entries []extractEntry
+	for _, f := range r.File {
+		p, err := cleanEntryPath(f.Name)
+		if err != nil {
+			return nil, err
+		}
+		if ignoredEntry(p) {
+			continue
+		}
+		mode := f.Mode()
+		switch {
+		case mode.IsDir():
+			entries = append(entries, extractEntry{Path: p, Dir: true})
+			err = budget.add(0, 0)
+		case mode.IsRegular():
+			if f.UncompressedSize64 > uint64(budget.maxTotal) {
+				return nil, fmt.Errorf("%w : plus de %d Mio une fois décompressée", errArchiveRejected, budget.limits.MaxSizeMB)
+			}
+			entries = append(entries, extractEntry{Path: p, Size: int64(f.UncompressedSize64), File: f})
+			err = budget.add(int64(f.UncompressedSize64), int64(f.CompressedSize64))
+		default:
+			return nil, fmt.Errorf("%w : lien ou fichier spécial %q", errArchiveRejected, f.Name)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return entries, nil
+}
+
+// Fonction pour ouvrir le flux tar d'un tar.gz ; la lecture décompressée est bornée par le taux de compression maximal
+func openTarGz(file *multipart.FileHeader, limits ExtractConfig) (*tar.Reader, io.Closer, error) {
+	src, err := file.Open()
+	if err != nil {
+		return nil, nil, err
+	}
+	gz, err := gzip.NewReader(src)
+	if err != nil {
+		src.Close()
+		return nil, nil, fmt.Errorf("%w : %v", errArchiveRejected, err)
+	}
+	limit := maxInt64(file.Size*int64(limits.MaxRatio), extractRatioFloor)
+	return tar.NewReader(&ratioReader{r: gz, left: limit}), src, nil
+}
+
+// ratioReader coupe un flux décompressé qui dépasse la taille permise par le taux de compression
+type ratioReader struct {
+	r    io.Reader
+	left int64
+}
+
+func (r *ratioReader) Read(p []byte) (int, error) {
+	if r.left <= 0 {
+		return 0, fmt.Errorf("%w : taux de compression supérieur à la limite", errArchiveRejected)
+	}
+	if int64(len(p)) > r.left {
+		p = p[:r.left]
+	}
+	n, err := r.r.Read(p)
+	r.left -= int64(n)
+	return n, err
+}
+
+// Fonction pour inventorier un tar.gz : une première lecture complète vérifie les chemins, les types et les tailles
+func planTarGz(file *multipart.FileHeader, budget *extractBudget) ([]extractEntry, error) {
+	tr, closer, err := openTarGz(file, budget.limits)
+	if err != nil {
+		return nil, err
+	}
+	defer closer.Close()
+
+	var entries []extractEntry
+	for {
+		header, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return entries, nil
+		}
+		if err != nil {
+			if !errors.Is(err, errArchiveRejected) {
+				err = fmt.Errorf("%w : %v", errArchiveRejected, err)
+			}
+			return nil, err
+		}
+		if header.Typeflag == tar.TypeXGlobalHeader {
+			continue
+		}
+		p, err := cleanEntryPath(header.Name)
+		if err != nil {
+			return nil, err
+		}
+		switch header.Typeflag {
+		case tar.TypeDir:
+			err = budget.add(0, -1)
+			if !ignoredEntry(p) {
+				entries = append(entries, extractEntry{Path: p, Dir: true})
+			}
+		case tar.TypeReg:
+			err = budget.add(header.Size, -1)
+			if !ignoredEntry(p) {
+				entries = append(entries, extractEntry{Path: p, Size: header.Size})
+			}
+		default:
+			return nil, fmt.Errorf("%w : lien ou fichier spécial %q", errArchiveRejected, header.Name)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// Gestionnaire de la décompression d'une archive déposée : l'archive est entièrement inventoriée et vérifiée,
+// puis chaque fichier passe par le même enregistrement qu'un dépôt ordinaire (quota, métadonnées, vignette)
+// dans un nouveau dossier qui porte le nom de l'archive
+func (s *Server) extractUpload(c echo.Context, userID int, userKey []byte, file *multipart.FileHeader, kind string, parentID sql.NullInt64) error {
+	ctx := c.Request().Context()
+	remaining, limited, err := s.remainingStorage(ctx, userID)
+	if err != nil {
+		log.Println("Erreur lors de la lecture de l'espace disponible :", err)
+		return err
+	}
+	budget := newExtractBudget(s.extract, remaining, limited)
+
+	var entries []extractEntry
+	switch kind {
+	case extractZip:
+		src, err := file.Open()
+		if err != nil {
+			log.Println("Erreur lors de la lecture du fichier :", err)
+			return err
+		}
+		defer src.Close()
+		r, err := zip.NewReader(src, file.Size)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"message": fmt.Sprintf("%v : %v", errArchiveRejected, err)})
+		}
+		entries, err = planZip(r, budget)
+		if err != nil {
+			return extractErrorResponse(c, err, 0)
+		}
+	case extractTarGz:
+		entries, err = planTarGz(file, budget)
+		if err != nil {
+			return extractErrorResponse(c, err, 0)
+		}
+	}
+
+	// Dossier qui reçoit le contenu de l'archive, nommé d'après elle
+	name := cleanDisplayName(file.Filename)
+	for _, ext := range []string{".tar.gz", ".tgz", ".zip", ".gz"} {
+		if len(name) > len(ext) && strings.EqualFold(name[len(name)-len(ext):], ext) {
+			name = name[:len(name)-len(ext)]
+			break
+		}
+	}
+	if name, err = cleanFolderName(name); err != nil {
+		name = "archive"
+	}
+	rootID, err := s.folders.Create(ctx, userID, name, parentID)
+	if err != nil {
+		return folderErrorResponse(c, err)
+	}
+	root := sql.NullInt64{Int64: rootID, Valid: true}
+	x := &extraction{s: s, userID: userID, userKey: userKey, folders: map[string]sql.NullInt64{"": root}}
+
+	switch kind {
+	case extractZip:
+		for _, entry := range entries {
+			entry := entry
+			err = x.add(ctx, entry, func() (io.ReadCloser, error) { return entry.File.Open() })
+			if err != nil {
+				break
+			}
+		}
+	case extractTarGz:
+		err = x.addTarGz(ctx, file, entries)
+	}
+	// Une seule extension des liens et des accès pour toute l'archive, même interrompue en cours de route
+	if x.imported > 0 {
+		go s.refreshAccess(context.Background(), userID, userKey)
+	}
+	if err != nil {
+		return extractErrorResponse(c, err, x.imported)
+	}
+
+	// Ouvrir le nouveau dossier après la décompression
+	return c.Redirect(http.StatusSeeOther, welcomeURL(root))
+}
+
+// extraction recrée l'arborescence d'une archive dans le coffre et y importe ses fichiers
+type extraction struct {
+	s        *Server
+	userID   int
+	userKey  []byte
+	folders  map[string]sql.NullInt64 // Dossiers déjà créés, par chemin dans l'archive
+	imported int
+}
+
+// Fonction pour retrouver ou créer le dossier d'un chemin de l'archive, avec ses parents
+func (x *extraction) folder(ctx context.Context, dir string) (sql.NullInt64, error) {
+	if id, ok := x.folders[dir]; ok {
+		return id, nil
+	}
+	parentDir := path.Dir(dir)
+	if parentDir == "." {
+		parentDir = ""
+	}
+	parent, err := x.folder(ctx, parentDir)
+	if err != nil {
+		return sql.NullInt64{}, err
+	}
+	name, err := cleanFolderName(cleanDisplayName(path.Base(dir)))
+	if err != nil {
+		return sql.NullInt64{}, err
+	}
+	id, err := x.s.folders.Create(ctx, x.userID, name, parent)
+	if err != nil {
+		return sql.NullInt64{}, err
+	}
+	x.folders[dir] = sql.NullInt64{Int64: id, Valid: true}
+	return x.folders[dir], nil
+}
+
+// Fonction pour importer une entrée : un dossier est créé, un fichier passe par importFile
+func (x *extraction) add(ctx context.Context, entry extractEntry, open func() (io.ReadCloser, error)) error {
+	if entry.Dir {
+		_, err := x.folder(ctx, entry.Path)
+		return err
+	}
+	dir := path.Dir(entry.Path)
+	if dir == "." {
+		dir = ""
+	}
+	folderID, err := x.folder(ctx, dir)
+	if err != nil {
+		return err
+	}
+	err = x.s.importFile(ctx, x.userID, x.userKey, incomingFile{
+		Name:     cleanDisplayName(path.Base(entry.Path)),
+		Size:     entry.Size,
+		FolderID: folderID,
+		Open:     open,
+	})
+	if isUploadPolicyError(err) {
+		return fmt.Errorf("%w (%s)", err, entry.Path)
+	}
+	if err == nil {
+		x.imported++
+	}
+	return err
+}
+
+// Fonction pour importer les entrées d'un tar.gz : le flux ne se relit pas, chaque fichier est donc copié
+// dans un fichier temporaire (supprimé aussitôt après) pour que importFile puisse le lire deux fois
+func (x *extraction) addTarGz(ctx context.Context, file *multipart.FileHeader, entries []extractEntry) error {
+	tr, closer, err := openTarGz(file, x.s.extract)
+	if err != nil {
+		return err
+	}
+	defer closer.Close()
+
+	for _, entry := range entries {
+		// Avancer jusqu'à l'entrée suivante de l'inventaire (les entrées ignorées sont sautées)
+		var header *tar.Header
+		for {
+			if header, err = tr.Next(); err != nil {
+				return err
+			}
+			if p, err := cleanEntryPath(header.Name); err == nil && p == entry.Path && header.Typeflag != tar.TypeXGlobalHeader {
+				break
+			}
+		}
+		if entry.Dir {
+			if err := x.add(ctx, entry, nil); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := x.addSpooled(ctx, entry, tr); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Fonction pour importer une entrée tar en passant par un fichier temporaire
+func (x *extraction) addSpooled(ctx context.Context, entry extractEntry, src io.Reader) error {
+	tmp, err := os.CreateTemp("", "coffre-extract-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	_, err = io.CopyN(tmp, src, entry.Size)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return x.add(ctx, entry, func() (io.ReadCloser, error) { return os.Open(tmp.Name()) })
+}
+
+// Fonction pour traduire une erreur de décompression en réponse JSON, avec le nombre de fichiers déjà importés
+func extractErrorResponse(c echo.Context, err error, imported int) error {
+	status, message := http.StatusInternalServerError, "Erreur lors de la décompression de l'archive"
+	switch {
+	case errors.Is(err, errArchiveRejected):
+		status, message = http.StatusBadRequest, err.Error()
+	case errors.Is(err, errQuotaExceeded):
+		status, message = http.StatusRequestEntityTooLarge, errQuotaExceeded.Error()
+	case errors.Is(err, errFolderName):
+		status, message = http.StatusBadRequest, err.Error()
+	case errors.Is(err, errFileTooLarge):
+		status, message = http.StatusRequestEntityTooLarge, err.Error()
+	case errors.Is(err, errTypeDenied):
+		status, message = http.StatusUnsupportedMediaType, err.Error()
+	case errors.Is(err, errScanUnavailable):
+		status, message = http.StatusServiceUnavailable, err.Error()
+	default:
+		log.Println("Erreur lors de la décompression de l'archive :", err)
+	}
+	if imported > 0 {
+		message = fmt.Sprintf("%s (%d fichier(s) déjà importé(s))", message, imported)
+	}
+	return c.JSON(status, map[string]string{"message": message})
+}
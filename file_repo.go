@@ -0,0 +1,531 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Erreur renvoyée quand le fichier demandé n'existe pas
+var errFileNotFound = errors.New("fichier introuvable")
+
+// Définir une structure pour représenter un fichier téléchargé
+type UploadedFile struct {
+	ID         int           // ID du fichier
+	UserID     int           // ID de l'utilisateur qui a téléchargé le fichier
+	FileName   string        // Nom d'origine du fichier (affichage uniquement)
+	StorageKey string        // Clé opaque du contenu dans le dossier des fichiers déposés (vide tant que non réconcilié)
+	WrappedKey []byte        // Clé de données protégée par la clé du propriétaire (nil pour un ancien fichier en clair)
+	Size       int64         // Taille du contenu en clair, en octets (0 tant qu'elle n'est pas calculée)
+	UploadedAt time.Time     // Date et heure du téléchargement
+	FolderID   sql.NullInt64 // Dossier contenant le fichier (NULL pour la racine)
+	Metadata   FileMetadata  // Empreinte, types et caractéristiques d'image du contenu
+}
+
+// FileRepo regroupe les requêtes sur la table files (les fichiers à la corbeille sont ignorés, voir TrashRepo)
+type FileRepo struct {
+	db *sql.DB
+}
+
+// Définir une structure pour représenter le tri et le filtre d'une liste de fichiers
+type FileListOptions struct {
+	Sort string // Critère de tri (clé de fileSortColumns ; vide : ordre d'envoi)
+	Desc bool   // Tri décroissant
+	Kind string // Famille de types gardée (clé de fileKindFilters ; vide : tous les fichiers)
+}
+
+// Colonnes de tri autorisées pour la liste des fichiers
+var fileSortColumns = map[string]string{
+	"name":  "filename",
+	"size":  "size",
+	"date":  "uploaded_at",
+	"type":  "mime_type",
+	"taken": "taken_at",
+}
+
+// Conditions de filtre par famille de types (type reconnu à l'envoi)
+var fileKindFilters = map[string]string{
+	"image": "mime_type LIKE 'image/%'",
+	"video": "mime_type LIKE 'video/%'",
+	"audio": "mime_type LIKE 'audio/%'",
+	"pdf":   "mime_type = 'application/pdf'",
+	"text":  "mime_type LIKE 'text/%'",
+	"other": "(mime_type IS NULL OR NOT (mime_type LIKE 'image/%' OR mime_type LIKE 'video/%' OR mime_type LIKE 'audio/%' OR mime_type = 'application/pdf' OR mime_type LIKE 'text/%'))",
+}
+
+// Fonction pour lister les fichiers d'un utilisateur dans un dossier (ou à la racine), triés et filtrés selon options
+func (r *FileRepo) ListByFolder(ctx context.Context, userID int, folderID sql.NullInt64, options FileListOptions) ([]UploadedFile, error) {
+	query := "SELECT id, user_id, filename, storage_key, wrapped_key, size, uploaded_at, folder_id, " + metadataColumns + " FROM files WHERE user_id = ? AND folder_id <=> ? AND deleted_at IS NULL"
+	if filter, ok := fileKindFilters[options.Kind]; ok {
+		query += " AND " + filter
+	}
+	order := "ASC"
+	if options.Desc {
+		order = "DESC"
+	}
+	if column, ok := fileSortColumns[options.Sort]; ok {
+		query += " ORDER BY " + column + " " + order + ", id " + order
+	} else {
+		query += " ORDER BY id " + order
+	}
+
+	rows, err := r.db.QueryContext(ctx, query, userID, folderID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var files []UploadedFile
+	for rows.Next() {
+		var file UploadedFile
+		var name, key sql.NullString
+		var size sql.NullInt64
+		var uploadedAt []byte
+		var meta metadataRow
+		dest := append([]interface{}{&file.ID, &file.UserID, &name, &key, &file.WrappedKey, &size, &uploadedAt, &file.FolderID}, meta.dest()...)
+		if err := rows.Scan(dest...); err != nil {
+			return nil, err
+		}
+		file.FileName, file.StorageKey, file.Size = name.String, key.String, size.Int64
+		file.UploadedAt, file.Metadata = parseDBTime(uploadedAt), meta.metadata()
+		files = append(files, file)
+	}
+	return files, rows.Err()
+}
+
+// Fonction pour récupérer un fichier par son ID, quel que soit son propriétaire (contrôle fait par l'Authorizer)
+func (r *FileRepo) Get(ctx context.Context, fileID int64) (UploadedFile, error) {
+	var file UploadedFile
+	var userID sql.NullInt64
+	var name, key sql.NullString
+	var size sql.NullInt64
+	var uploadedAt []byte
+	var meta metadataRow
+	err := r.db.QueryRowContext(ctx, "SELECT id, user_id, filename, storage_key, wrapped_key, size, uploaded_at, folder_id, "+metadataColumns+" FROM files WHERE id = ? AND deleted_at IS NULL", fileID).
+		Scan(append([]interface{}{&file.ID, &userID, &name, &key, &file.WrappedKey, &size, &uploadedAt, &file.FolderID}, meta.dest()...)...)
+	if errors.Is(err, sql.ErrNoRows) {
+		return file, errFileNotFound
+	}
+	file.UserID, file.FileName, file.StorageKey, file.Size = int(userID.Int64), name.String, key.String, size.Int64
+	file.UploadedAt, file.Metadata = parseDBTime(uploadedAt), meta.metadata()
+	return file, err
+}
+
+// Fonction pour enregistrer un fichier déposé. Si un fichier du même nom existe déjà dans le dossier,
+// son contenu devient une ancienne version et la ligne existante pointe vers le nouveau contenu.
+// Renvoie l'ID du fichier et indique s'il s'agit d'une nouvelle version.
+func (r *FileRepo) CreateOrReplace(ctx context.Context, file UploadedFile) (int64, bool, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, false, err
+	}
+	defer tx.Rollback()
+
+	// Les anciennes installations peuvent avoir plusieurs lignes du même nom : la plus récente porte l'historique
+	var current UploadedFile
+	var key sql.NullString
+	var size sql.NullInt64
+	var uploadedAt []byte
+	var meta metadataRow
+	err = tx.QueryRowContext(ctx, "SELECT id, storage_key, wrapped_key, size, uploaded_at, "+metadataColumns+" FROM files WHERE user_id = ? AND folder_id <=> ? AND filename = ? AND deleted_at IS NULL ORDER BY id DESC LIMIT 1 FOR UPDATE",
+		file.UserID, file.FolderID, file.FileName).Scan(append([]interface{}{&current.ID, &key, &current.WrappedKey, &size, &uploadedAt}, meta.dest()...)...)
+	if errors.Is(err, sql.ErrNoRows) {
+		result, err := tx.ExecContext(ctx, "INSERT INTO files (user_id, filename, storage_key, wrapped_key, size, uploaded_at, folder_id, "+metadataColumns+") VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
+			append([]interface{}{file.UserID, file.FileName, file.StorageKey, file.WrappedKey, file.Size, file.UploadedAt, file.FolderID}, file.Metadata.values()...)...)
+		if err != nil {
+			return 0, false, err
+		}
+		id, err := result.LastInsertId()
+		if err != nil {
+			return 0, false, err
+		}
+		return id, false, tx.Commit()
+	}
+	if err != nil {
+		return 0, false, err
+	}
+
+	// Archiver le contenu courant (une ligne jamais réconciliée n'a pas de contenu à garder)
+	if key.Valid {
+		_, err = tx.ExecContext(ctx, "INSERT INTO file_versions (file_id, storage_key, wrapped_key, size, uploaded_at, replaced_at, "+metadataColumns+") VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
+			append([]interface{}{current.ID, key.String, current.WrappedKey, size, nullableTime(uploadedAt), file.UploadedAt}, meta.values()...)...)
+		if err != nil {
+			return 0, false, err
+		}
+	}
+	_, err = tx.ExecContext(ctx, "UPDATE files SET storage_key = ?, wrapped_key = ?, size = ?, uploaded_at = ?, file_path = NULL, "+metadataAssignments+" WHERE id = ?",
+		append(append([]interface{}{file.StorageKey, file.WrappedKey, file.Size, file.UploadedAt}, file.Metadata.values()...), current.ID)...)
+	if err != nil {
+		return 0, false, err
+	}
+	return int64(current.ID), true, tx.Commit()
+}
+
+// Fonction pour lire une date de la base : le DSN n'active pas parseTime, les colonnes DATETIME
+// arrivent donc sous forme de texte et sont lues dans un []byte (date nulle si la valeur est NULL)
+func parseDBTime(value []byte) time.Time {
+	t, _ := time.Parse("2006-01-02 15:04:05", string(value))
+	return t
+}
+
+// Fonction pour recopier une date lue en texte telle quelle (NULL si absente)
+func nullableTime(value []byte) interface{} {
+	if value == nil {
+		return nil
+	}
+	return string(value)
+}
+
+// Fonction pour lire une liste de clés de stockage dans une transaction (les valeurs NULL sont ignorées)
+func queryKeys(ctx context.Context, tx *sql.Tx, query string, args ...interface{}) ([]string, error) {
+	rows, err := tx.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var keys []string
+	for rows.Next() {
+		var key sql.NullString
+		if err := rows.Scan(&key); err != nil {
+			return nil, err
+		}
+		if key.Valid {
+			keys = append(keys, key.String)
+		}
+	}
+	return keys, rows.Err()
+}
+
+// Fonction pour ranger un fichier de son propriétaire dans un dossier
+func (r *FileRepo) MoveToFolder(ctx context.Context, ownerID int, fileID int64, folderID sql.NullInt64) error {
+	_, err := r.db.ExecContext(ctx, "UPDATE files SET folder_id = ? WHERE id = ? AND user_id = ?", folderID, fileID, ownerID)
+	return err
+}
+
+// Fonction pour lister les fichiers encore en clair d'un utilisateur (déposés avant le chiffrement)
+func (r *FileRepo) ListPlaintext(ctx context.Context, userID int) ([]UploadedFile, error) {
+	rows, err := r.db.QueryContext(ctx, "SELECT id, user_id, filename, storage_key FROM files WHERE user_id = ? AND wrapped_key IS NULL AND storage_key IS NOT NULL", userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var files []UploadedFile
+	for rows.Next() {
+		var file UploadedFile
+		var name sql.NullString
+		if err := rows.Scan(&file.ID, &file.UserID, &name, &file.StorageKey); err != nil {
+			return nil, err
+		}
+		file.FileName = name.String
+		files = append(files, file)
+	}
+	return files, rows.Err()
+}
+
+// Fonction pour remplacer le contenu en clair d'un fichier par sa version chiffrée.
+// Renvoie false si la ligne a changé entre-temps (fichier supprimé ou déjà chiffré),
+// et sinon l'ancienne clé de stockage si plus rien ne la référence, à effacer par l'appelant.
+func (r *FileRepo) SetEncrypted(ctx context.Context, fileID int, oldKey, newKey string, wrappedKey []byte) (bool, []string, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, nil, err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, "UPDATE files SET storage_key = ?, wrapped_key = ? WHERE id = ? AND storage_key = ? AND wrapped_key IS NULL", newKey, wrappedKey, fileID, oldKey)
+	if err != nil {
+		return false, nil, err
+	}
+	if n, err := result.RowsAffected(); err != nil || n == 0 {
+		return false, nil, err
+	}
+	keys, err := releaseBlobs(ctx, tx, []string{oldKey})
+	if err != nil {
+		return false, nil, err
+	}
+	return true, keys, tx.Commit()
+}
+
+// Définir une structure pour représenter une ligne antérieure aux clés de stockage
+type legacyFile struct {
+	ID       int
+	UserID   int
+	FileName string
+	FilePath string // Ancien chemin enregistré (souvent faux, voir le réconciliateur)
+}
+
+// Fonction pour lister les fichiers qui n'ont pas encore de clé de stockage
+func (r *FileRepo) ListWithoutStorageKey(ctx context.Context) ([]legacyFile, error) {
+	rows, err := r.db.QueryContext(ctx, "SELECT id, user_id, filename, file_path FROM files WHERE storage_key IS NULL ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var files []legacyFile
+	for rows.Next() {
+		var file legacyFile
+		var userID sql.NullInt64
+		var name, path sql.NullString
+		if err := rows.Scan(&file.ID, &userID, &name, &path); err != nil {
+			return nil, err
+		}
+		file.UserID, file.FileName, file.FilePath = int(userID.Int64), name.String, path.String
+		files = append(files, file)
+	}
+	return files, rows.Err()
+}
+
+// Fonction pour attribuer une clé de stockage à une ligne existante (l'ancien chemin est effacé)
+func (r *FileRepo) SetStorageKey(ctx context.Context, fileID int, key string) error {
+	_, err := r.db.ExecContext(ctx, "UPDATE files SET storage_key = ?, file_path = NULL WHERE id = ? AND storage_key IS NULL", key, fileID)
+	return err
+}
+
+// Fonction pour récupérer l'ensemble des clés de stockage connues en base (versions courantes et anciennes,
+// blobs en cours d'écriture, vignettes)
+func (r *FileRepo) StorageKeys(ctx context.Context) (map[string]bool, error) {
+	rows, err := r.db.QueryContext(ctx, "SELECT storage_key FROM files WHERE storage_key IS NOT NULL UNION ALL SELECT storage_key FROM file_versions UNION ALL SELECT storage_key FROM blobs UNION ALL SELECT thumbnail_key FROM blobs WHERE thumbnail_key IS NOT NULL")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := make(map[string]bool)
+	for rows.Next() {
+		var key string
+		if err := rows.Scan(&key); err != nil {
+			return nil, err
+		}
+		keys[key] = true
+	}
+	return keys, rows.Err()
+}
+
+// Fonction pour décrire les lignes (fichiers courants et anciennes versions) qui désignent une clé de stockage ;
+// une liste vide indique que la clé n'est plus utilisée
+func (r *FileRepo) References(ctx context.Context, key string) ([]string, error) {
+	rows, err := r.db.QueryContext(ctx, "SELECT id, filename, user_id, FALSE FROM files WHERE storage_key = ? UNION ALL SELECT f.id, f.filename, f.user_id, TRUE FROM file_versions v JOIN files f ON f.id = v.file_id WHERE v.storage_key = ?", key, key)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var references []string
+	for rows.Next() {
+		var (
+			fileID   int
+			fileName string
+			userID   sql.NullInt64
+			version  bool
+		)
+		if err := rows.Scan(&fileID, &fileName, &userID, &version); err != nil {
+			return nil, err
+		}
+		reference := fmt.Sprintf("fichier %d %q (utilisateur %d)", fileID, fileName, userID.Int64)
+		if version {
+			reference = "ancienne version du " + reference
+		}
+		references = append(references, reference)
+	}
+	return references, rows.Err()
+}
+
+// Définir une structure pour représenter un contenu (fichier courant ou ancienne version) dont la taille n'est pas connue
+type unsizedContent struct {
+	ID         int
+	Version    bool // Ligne de file_versions plutôt que de files
+	StorageKey string
+	Encrypted  bool // Contenu chiffré : sa taille en clair se déduit de celle du blob
+}
+
+// Fonction pour lister les contenus enregistrés avant le calcul des tailles
+func (r *FileRepo) ListWithoutSize(ctx context.Context) ([]unsizedContent, error) {
+	rows, err := r.db.QueryContext(ctx, "SELECT id, FALSE, storage_key, wrapped_key IS NOT NULL FROM files WHERE size IS NULL AND storage_key IS NOT NULL UNION ALL SELECT id, TRUE, storage_key, wrapped_key IS NOT NULL FROM file_versions WHERE size IS NULL")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var contents []unsizedContent
+	for rows.Next() {
+		var content unsizedContent
+		if err := rows.Scan(&content.ID, &content.Version, &content.StorageKey, &content.Encrypted); err != nil {
+			return nil, err
+		}
+		contents = append(contents, content)
+	}
+	return contents, rows.Err()
+}
+
+// Fonction pour enregistrer la taille en clair d'un contenu (sans effet si elle a été fixée entre-temps)
+func (r *FileRepo) SetSize(ctx context.Context, content unsizedContent, size int64) error {
+	table := "files"
+	if content.Version {
+		table = "file_versions"
+	}
+	_, err := r.db.ExecContext(ctx, "UPDATE "+table+" SET size = ? WHERE id = ? AND storage_key = ? AND size IS NULL", size, content.ID, content.StorageKey)
+	return err
+}
+
+// Définir une structure pour représenter un contenu (fichier courant ou ancienne version) dont les métadonnées ne sont pas relevées
+type undescribedContent struct {
+	ID         int
+	Version    bool // Ligne de file_versions plutôt que de files
+	StorageKey string
+	WrappedKey []byte // nil pour un ancien contenu en clair
+}
+
+// Fonction pour lister les contenus d'un utilisateur enregistrés avant le relevé des métadonnées
+func (r *FileRepo) ListUndescribed(ctx context.Context, userID int) ([]undescribedContent, error) {
+	rows, err := r.db.QueryContext(ctx, "SELECT id, FALSE, storage_key, wrapped_key FROM files WHERE user_id = ? AND sha256 IS NULL AND storage_key IS NOT NULL UNION ALL SELECT v.id, TRUE, v.storage_key, v.wrapped_key FROM file_versions v JOIN files f ON f.id = v.file_id WHERE f.user_id = ? AND v.sha256 IS NULL", userID, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var contents []undescribedContent
+	for rows.Next() {
+		var content undescribedContent
+		if err := rows.Scan(&content.ID, &content.Version, &content.StorageKey, &content.WrappedKey); err != nil {
+			return nil, err
+		}
+		contents = append(contents, content)
+	}
+	return contents, rows.Err()
+}
+
+// Définir une structure pour représenter un contenu dont la vignette reste à générer
+type thumbnailSource struct {
+	StorageKey string
+	WrappedKey []byte // nil pour un ancien contenu en clair
+	MIMEType   string
+}
+
+// Fonction pour lister les contenus des fichiers d'un utilisateur qui ont une vignette à générer
+func (r *FileRepo) ListWithoutThumbnail(ctx context.Context, userID int) ([]thumbnailSource, error) {
+	rows, err := r.db.QueryContext(ctx, "SELECT DISTINCT f.storage_key, f.wrapped_key, f.mime_type FROM files f JOIN blobs b ON b.storage_key = f.storage_key WHERE f.user_id = ? AND b.thumbnail IS NULL AND f.mime_type IN ('image/png', 'image/jpeg', 'image/gif', 'application/pdf')", userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var sources []thumbnailSource
+	for rows.Next() {
+		var source thumbnailSource
+		if err := rows.Scan(&source.StorageKey, &source.WrappedKey, &source.MIMEType); err != nil {
+			return nil, err
+		}
+		sources = append(sources, source)
+	}
+	return sources, rows.Err()
+}
+
+// Définir une structure pour représenter un contenu à analyser par l'antivirus
+type scanSource struct {
+	StorageKey string
+	WrappedKey []byte
+}
+
+// Fonction pour lister les contenus des fichiers et anciennes versions d'un utilisateur jamais analysés
+// ou analysés avec d'autres signatures que celles de la version donnée
+func (r *FileRepo) ListUnscanned(ctx context.Context, userID int, signatures string) ([]scanSource, error) {
+	rows, err := r.db.QueryContext(ctx, "SELECT b.storage_key, b.wrapped_key FROM blobs b WHERE b.stored AND b.ref_count > 0 AND (b.scan_signatures IS NULL OR b.scan_signatures <> ?) AND b.storage_key IN "+
+		"(SELECT storage_key FROM files WHERE user_id = ? UNION SELECT v.storage_key FROM file_versions v JOIN files f ON f.id = v.file_id WHERE f.user_id = ?)", signatures, userID, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var sources []scanSource
+	for rows.Next() {
+		var source scanSource
+		if err := rows.Scan(&source.StorageKey, &source.WrappedKey); err != nil {
+			return nil, err
+		}
+		sources = append(sources, source)
+	}
+	return sources, rows.Err()
+}
+
+// Fonction pour mettre en quarantaine les fichiers et anciennes versions qui désignent un contenu.
+// Renvoie le nom des fichiers concernés ; une quarantaine déjà posée garde sa raison.
+func (r *FileRepo) QuarantineContent(ctx context.Context, key, reason string) ([]string, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, "SELECT DISTINCT f.filename FROM files f LEFT JOIN file_versions v ON v.file_id = f.id AND v.storage_key = ? WHERE f.storage_key = ? OR v.id IS NOT NULL", key, key)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for rows.Next() {
+		var name sql.NullString
+		if err := rows.Scan(&name); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		names = append(names, name.String)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if _, err := tx.ExecContext(ctx, "UPDATE files SET quarantine = ? WHERE storage_key = ? AND quarantine IS NULL", reason, key); err != nil {
+		return nil, err
+	}
+	if _, err := tx.ExecContext(ctx, "UPDATE file_versions SET quarantine = ? WHERE storage_key = ? AND quarantine IS NULL", reason, key); err != nil {
+		return nil, err
+	}
+	return names, tx.Commit()
+}
+
+// Fonction pour enregistrer les métadonnées d'un contenu (sans effet si le contenu a changé entre-temps)
+func (r *FileRepo) SetMetadata(ctx context.Context, content undescribedContent, meta FileMetadata) error {
+	table := "files"
+	if content.Version {
+		table = "file_versions"
+	}
+	_, err := r.db.ExecContext(ctx, "UPDATE "+table+" SET "+metadataAssignments+" WHERE id = ? AND storage_key = ? AND sha256 IS NULL",
+		append(meta.values(), content.ID, content.StorageKey)...)
+	return err
+}
+
+// Définir une structure pour représenter un fichier à (ré)indexer pour la recherche
+type searchSource struct {
+	FileID     int
+	FileName   string
+	StorageKey string
+	WrappedKey []byte // nil pour un ancien contenu en clair
+	MIMEType   string
+}
+
+// Fonction pour lister les fichiers d'un utilisateur absents de l'index de recherche, ou indexés avec un autre contenu
+// (version restaurée, ancien fichier chiffré depuis)
+func (r *FileRepo) ListUnindexed(ctx context.Context, userID int) ([]searchSource, error) {
+	rows, err := r.db.QueryContext(ctx, "SELECT f.id, f.filename, f.storage_key, f.wrapped_key, f.mime_type FROM files f LEFT JOIN search_documents d ON d.file_id = f.id WHERE f.user_id = ? AND f.storage_key IS NOT NULL AND (d.id IS NULL OR NOT d.storage_key <=> f.storage_key)", userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var sources []searchSource
+	for rows.Next() {
+		var source searchSource
+		var name, mimeType sql.NullString
+		if err := rows.Scan(&source.FileID, &name, &source.StorageKey, &source.WrappedKey, &mimeType); err != nil {
+			return nil, err
+		}
+		source.FileName, source.MIMEType = name.String, mimeType.String
+		sources = append(sources, source)
+	}
+	return sources, rows.Err()
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+)
+
+// Définir une structure pour représenter un dossier de l'utilisateur
+type Folder struct {
+	ID       int           // ID du dossier
+	UserID   int           // ID du propriétaire
+	Name     string        // Nom affiché du dossier
+	ParentID sql.NullInt64 // Dossier parent (NULL pour la racine)
+}
+
+// FolderRepo regroupe les requêtes sur la table folders (les dossiers à la corbeille sont ignorés, voir TrashRepo)
+type FolderRepo struct {
+	db *sql.DB
+}
+
+// Fonction pour lire une liste de dossiers
+func scanFolders(rows *sql.Rows) ([]Folder, error) {
+	defer rows.Close()
+
+	var folders []Folder
+	for rows.Next() {
+		var folder Folder
+		if err := rows.Scan(&folder.ID, &folder.UserID, &folder.Name, &folder.ParentID); err != nil {
+			return nil, err
+		}
+		folders = append(folders, folder)
+	}
+	return folders, rows.Err()
+}
+
+// Fonction pour récupérer un dossier appartenant à l'utilisateur
+func (r *FolderRepo) Get(ctx context.Context, userID int, folderID int64) (Folder, error) {
+	var folder Folder
+	err := r.db.QueryRowContext(ctx, "SELECT id, user_id, folder_name, parent_folder_id FROM folders WHERE id = ? AND user_id = ? AND deleted_at IS NULL", folderID, userID).
+		Scan(&folder.ID, &folder.UserID, &folder.Name, &folder.ParentID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return folder, errFolderNotFound
+	}
+	return folder, err
+}
+
+// Fonction pour récupérer un dossier par son ID, quel que soit son propriétaire (contrôle fait par l'Authorizer)
+func (r *FolderRepo) GetByID(ctx context.Context, folderID int64) (Folder, error) {
+	var folder Folder
+	var userID sql.NullInt64
+	var name sql.NullString
+	err := r.db.QueryRowContext(ctx, "SELECT id, user_id, folder_name, parent_folder_id FROM folders WHERE id = ? AND deleted_at IS NULL", folderID).
+		Scan(&folder.ID, &userID, &name, &folder.ParentID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return folder, errFolderNotFound
+	}
+	folder.UserID, folder.Name = int(userID.Int64), name.String
+	return folder, err
+}
+
+// Fonction pour lister les sous-dossiers directs d'un dossier (ou de la racine), hors corbeille
+func (r *FolderRepo) ListChildren(ctx context.Context, userID int, parentID sql.NullInt64) ([]Folder, error) {
+	rows, err := r.db.QueryContext(ctx, "SELECT id, user_id, folder_name, parent_folder_id FROM folders WHERE user_id = ? AND parent_folder_id <=> ? AND deleted_at IS NULL ORDER BY folder_name", userID, parentID)
+	if err != nil {
+		return nil, err
+	}
+	return scanFolders(rows)
+}
+
+// Fonction pour lister tous les dossiers d'un utilisateur
+func (r *FolderRepo) ListAll(ctx context.Context, userID int) ([]Folder, error) {
+	rows, err := r.db.QueryContext(ctx, "SELECT id, user_id, folder_name, parent_folder_id FROM folders WHERE user_id = ? AND deleted_at IS NULL", userID)
+	if err != nil {
+		return nil, err
+	}
+	return scanFolders(rows)
+}
+
+// Fonction pour construire le fil d'Ariane de la racine jusqu'au dossier courant
+func (r *FolderRepo) Breadcrumbs(ctx context.Context, userID int, folderID sql.NullInt64) ([]Folder, error) {
+	var path []Folder
+	seen := make(map[int64]bool)
+	for folderID.Valid {
+		// Protection contre une éventuelle boucle déjà présente en base
+		if seen[folderID.Int64] {
+			return nil, errFolderCycle
+		}
+		seen[folderID.Int64] = true
+
+		folder, err := r.Get(ctx, userID, folderID.Int64)
+		if err != nil {
+			return nil, err
+		}
+		path = append([]Folder{folder}, path...)
+		folderID = folder.ParentID
+	}
+	return path, nil
+}
+
+// Fonction pour collecter un dossier et tous ses descendants hors corbeille (parcours en largeur)
+func (r *FolderRepo) Tree(ctx context.Context, userID int, rootID int64) ([]int64, error) {
+	tree := []int64{rootID}
+	for i := 0; i < len(tree); i++ {
+		children, err := r.ListChildren(ctx, userID, sql.NullInt64{Int64: tree[i], Valid: true})
+		if err != nil {
+			return nil, err
+		}
+		for _, child := range children {
+			tree = append(tree, int64(child.ID))
+		}
+	}
+	return tree, nil
+}
+
+// Fonction pour créer un dossier
+func (r *FolderRepo) Create(ctx context.Context, userID int, name string, parentID sql.NullInt64) (int64, error) {
+	result, err := r.db.ExecContext(ctx, "INSERT INTO folders (user_id, folder_name, parent_folder_id) VALUES (?, ?, ?)", userID, name, parentID)
+	if err != nil {
+		return 0, err
+	}
+	return result.LastInsertId()
+}
+
+// Fonction pour renommer un dossier
+func (r *FolderRepo) Rename(ctx context.Context, userID int, folderID int64, name string) error {
+	if _, err := r.Get(ctx, userID, folderID); err != nil {
+		return err
+	}
+	_, err := r.db.ExecContext(ctx, "UPDATE folders SET folder_name = ? WHERE id = ? AND user_id = ?", name, folderID, userID)
+	return err
+}
+
+// Fonction pour déplacer un dossier (et donc tout son sous-arbre) sous un nouveau parent.
+// Les déplacements d'un même utilisateur sont sérialisés par le verrou de sa ligne users, et la remontée
+// des ancêtres verrouille chaque dossier traversé jusqu'à la mise à jour : deux déplacements croisés
+// (A dans B, B dans A) ne peuvent pas chacun valider un chemin que l'autre est en train de modifier.
+func (r *FolderRepo) Move(ctx context.Context, userID int, folderID int64, newParentID sql.NullInt64) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var locked int
+	if err := tx.QueryRowContext(ctx, "SELECT id FROM users WHERE id = ? FOR UPDATE", userID).Scan(&locked); err != nil {
+		return err
+	}
+	var parentID sql.NullInt64
+	err = tx.QueryRowContext(ctx, "SELECT parent_folder_id FROM folders WHERE id = ? AND user_id = ? AND deleted_at IS NULL FOR UPDATE", folderID, userID).
+		Scan(&parentID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return errFolderNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	// Remonter depuis le nouveau parent : si on croise le dossier déplacé, on créerait un cycle
+	seen := make(map[int64]bool)
+	for ancestorID := newParentID; ancestorID.Valid; {
+		if ancestorID.Int64 == folderID {
+			return errFolderCycle
+		}
+		// Protection contre une éventuelle boucle déjà présente en base
+		if seen[ancestorID.Int64] {
+			return errFolderCycle
+		}
+		seen[ancestorID.Int64] = true
+
+		err := tx.QueryRowContext(ctx, "SELECT parent_folder_id FROM folders WHERE id = ? AND user_id = ? AND deleted_at IS NULL FOR UPDATE", ancestorID.Int64, userID).
+			Scan(&ancestorID)
+		if errors.Is(err, sql.ErrNoRows) {
+			return errFolderNotFound
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	if _, err := tx.ExecContext(ctx, "UPDATE folders SET parent_folder_id = ? WHERE id = ? AND user_id = ?", newParentID, folderID, userID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// Fonction pour préparer l'arborescence 1 > 2 > 3 de l'utilisateur 1, et un dossier 4 à sa racine
+func folderTree() *fakeTables {
+	folder := func(id int64, parent driver.Value) map[string]driver.Value {
+		return map[string]driver.Value{"id": id, "user_id": int64(1), "parent_folder_id": parent}
+	}
+	return &fakeTables{tables: map[string][]map[string]driver.Value{
+		"users":   {{"id": int64(1)}},
+		"folders": {folder(1, nil), folder(2, int64(1)), folder(3, int64(2)), folder(4, nil)},
+	}}
+}
+
+func TestFolderMoveLocksAncestorsInTransaction(t *testing.T) {
+	tables := folderTree()
+	repo := &FolderRepo{db: tables.open()}
+	if err := repo.Move(context.Background(), 1, 4, sql.NullInt64{Int64: 3, Valid: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Verrou de l'utilisateur, du dossier déplacé, puis de chaque ancêtre du nouveau parent
+	if len(tables.queries) != 5 || !strings.HasPrefix(tables.queries[0], "SELECT id FROM users") {
+		t.Fatalf("lectures = %q", tables.queries)
+	}
+	for _, query := range tables.queries {
+		if !strings.HasSuffix(query, "FOR UPDATE") {
+			t.Fatalf("lecture sans verrou : %q", query)
+		}
+	}
+	if len(tables.execs) != 2 || !strings.HasPrefix(tables.execs[0], "UPDATE folders SET parent_folder_id") || tables.execs[1] != "COMMIT" {
+		t.Fatalf("écritures = %q, attendu la mise à jour dans la transaction", tables.execs)
+	}
+}
+
+func TestFolderMoveRejectsCycle(t *testing.T) {
+	for _, parent := range []int64{1, 2, 3} {
+		tables := folderTree()
+		repo := &FolderRepo{db: tables.open()}
+		err := repo.Move(context.Background(), 1, 1, sql.NullInt64{Int64: parent, Valid: true})
+		if !errors.Is(err, errFolderCycle) {
+			t.Fatalf("déplacement de 1 dans %d : erreur = %v, attendu errFolderCycle", parent, err)
+		}
+		if len(tables.execs) != 0 {
+			t.Fatalf("écritures = %q, attendu aucune", tables.execs)
+		}
+	}
+}
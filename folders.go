@@ -0,0 +1,277 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"html/template"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// Erreurs renvoyées par les opérations sur les dossiers
+var (
+	errFolderNotFound = errors.New("dossier introuvable")
+	errFolderCycle    = errors.New("impossible de déplacer un dossier dans lui-même ou dans un de ses sous-dossiers")
+	errFolderName     = errors.New("nom de dossier invalide")
+)
+
+// Fonction pour lire un identifiant de dossier optionnel (vide = racine)
+func parseFolderID(value string) (sql.NullInt64, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return sql.NullInt64{}, nil
+	}
+	id, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || id <= 0 {
+		return sql.NullInt64{}, errFolderNotFound
+	}
+	return sql.NullInt64{Int64: id, Valid: true}, nil
+}
+
+// Fonction pour valider le nom d'un dossier
+func cleanFolderName(name string) (string, error) {
+	name = strings.TrimSpace(name)
+	if name == "" || len(name) > 255 || strings.ContainsAny(name, "/\\") {
+		return "", errFolderName
+	}
+	return name, nil
+}
+
+// Fonction pour traduire une erreur de dossier en réponse JSON
+func folderErrorResponse(c echo.Context, err error) error {
+	switch {
+	case errors.Is(err, errFolderNotFound), errors.Is(err, errNotVisible):
+		return c.JSON(http.StatusNotFound, map[string]string{"message": err.Error()})
+	case errors.Is(err, errFolderCycle), errors.Is(err, errFolderName):
+		return c.JSON(http.StatusBadRequest, map[string]string{"message": err.Error()})
+	default:
+		log.Println("Erreur lors de l'opération sur le dossier :", err)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"message": "Erreur lors de l'opération sur le dossier"})
+	}
+}
+
+// Gestionnaire de route pour créer un dossier
+func (s *Server) createFolderHandler(c echo.Context) error {
+	ctx := c.Request().Context()
+	userID := currentUserID(c)
+
+	name, err := cleanFolderName(c.FormValue("name"))
+	if err != nil {
+		return folderErrorResponse(c, err)
+	}
+	parentID, err := parseFolderID(c.FormValue("parent_id"))
+	if err != nil {
+		return folderErrorResponse(c, err)
+	}
+	// Un sous-dossier créé dans un dossier partagé appartient au propriétaire du dossier parent
+	ownerID, err := s.authz.ContentOwner(ctx, userID, parentID)
+	if err != nil {
+		return folderErrorResponse(c, err)
+	}
+
+	id, err := s.folders.Create(ctx, ownerID, name, parentID)
+	if err != nil {
+		return folderErrorResponse(c, err)
+	}
+
+	return c.JSON(http.StatusOK, map[string]string{"message": "Dossier créé avec succès", "id": strconv.FormatInt(id, 10)})
+}
+
+// Gestionnaire de route pour renommer un dossier
+func (s *Server) renameFolderHandler(c echo.Context) error {
+	ctx := c.Request().Context()
+	folderID, err := parseObjectID(c.Param("id"))
+	if err != nil {
+		return folderErrorResponse(c, err)
+	}
+	name, err := cleanFolderName(c.FormValue("name"))
+	if err != nil {
+		return folderErrorResponse(c, err)
+	}
+
+	folder, err := s.authz.Folder(ctx, currentUserID(c), folderID, AccessWrite)
+	if err != nil {
+		return folderErrorResponse(c, err)
+	}
+	if err := s.folders.Rename(ctx, folder.UserID, folderID, name); err != nil {
+		return folderErrorResponse(c, err)
+	}
+
+	return c.JSON(http.StatusOK, map[string]string{"message": "Dossier renommé avec succès"})
+}
+
+// Gestionnaire de route pour déplacer un dossier avec tout son contenu
+func (s *Server) moveFolderHandler(c echo.Context) error {
+	ctx := c.Request().Context()
+	userID := currentUserID(c)
+	folderID, err := parseObjectID(c.Param("id"))
+	if err != nil {
+		return folderErrorResponse(c, err)
+	}
+	parentID, err := parseFolderID(c.FormValue("parent_id"))
+	if err != nil {
+		return folderErrorResponse(c, err)
+	}
+
+	folder, err := s.authz.Folder(ctx, userID, folderID, AccessManage)
+	if err != nil {
+		return folderErrorResponse(c, err)
+	}
+	if err := s.authz.Destination(ctx, userID, folder.UserID, parentID); err != nil {
+		return folderErrorResponse(c, err)
+	}
+	if err := s.folders.Move(ctx, folder.UserID, folderID, parentID); err != nil {
+		return folderErrorResponse(c, err)
+	}
+	// Le dossier a pu entrer dans un dossier partagé
+	s.refreshAccessLater(c, folder.UserID)
+
+	return c.JSON(http.StatusOK, map[string]string{"message": "Dossier déplacé avec succès"})
+}
+
+// Gestionnaire de route pour mettre un dossier et tout son contenu à la corbeille
+func (s *Server) deleteFolderHandler(c echo.Context) error {
+	ctx := c.Request().Context()
+	folderID, err := parseObjectID(c.Param("id"))
+	if err != nil {
+		return folderErrorResponse(c, err)
+	}
+
+	folder, err := s.authz.Folder(ctx, currentUserID(c), folderID, AccessManage)
+	if err != nil {
+		return folderErrorResponse(c, err)
+	}
+	if err := s.trash.Put(ctx, folder.UserID, resourceFolder, folderID, time.Now()); err != nil {
+		return folderErrorResponse(c, err)
+	}
+
+	return c.JSON(http.StatusOK, map[string]string{"message": "Dossier placé dans la corbeille"})
+}
+
+// Gestionnaire de route pour ranger une note dans un dossier
+func (s *Server) moveNoteHandler(c echo.Context) error {
+	ctx := c.Request().Context()
+	userID := currentUserID(c)
+	noteID, err := parseObjectID(c.Param("id"))
+	if err != nil {
+		return folderErrorResponse(c, err)
+	}
+	folderID, err := parseFolderID(c.FormValue("folder_id"))
+	if err != nil {
+		return folderErrorResponse(c, err)
+	}
+
+	note, err := s.authz.Note(ctx, userID, noteID, AccessWrite)
+	if err == nil {
+		err = s.authz.Destination(ctx, userID, note.UserID, folderID)
+	}
+	if err == nil {
+		err = s.notes.MoveToFolder(ctx, note.UserID, noteID, folderID)
+	}
+	if err != nil {
+		return folderErrorResponse(c, err)
+	}
+	s.refreshAccessLater(c, note.UserID)
+
+	return c.JSON(http.StatusOK, map[string]string{"message": "Élément déplacé avec succès"})
+}
+
+// Gestionnaire de route pour ranger un fichier dans un dossier
+func (s *Server) moveFileHandler(c echo.Context) error {
+	ctx := c.Request().Context()
+	userID := currentUserID(c)
+	fileID, err := parseObjectID(c.Param("id"))
+	if err != nil {
+		return folderErrorResponse(c, err)
+	}
+	folderID, err := parseFolderID(c.FormValue("folder_id"))
+	if err != nil {
+		return folderErrorResponse(c, err)
+	}
+
+	file, err := s.authz.File(ctx, userID, fileID, AccessWrite)
+	if err == nil {
+		err = s.authz.Destination(ctx, userID, file.UserID, folderID)
+	}
+	if err == nil {
+		err = s.files.MoveToFolder(ctx, file.UserID, fileID, folderID)
+	}
+	if err != nil {
+		return folderErrorResponse(c, err)
+	}
+	s.refreshAccessLater(c, file.UserID)
+
+	return c.JSON(http.StatusOK, map[string]string{"message": "Élément déplacé avec succès"})
+}
+
+// Fonction pour générer le fil d'Ariane et la liste des sous-dossiers de la page d'accueil
+func renderFolderNavigation(current sql.NullInt64, breadcrumbs []Folder, subfolders []Folder, folderOptions string) string {
+	navHTML := `<div id="breadcrumbs"><a href="/welcome">Mon coffre</a>`
+	for _, folder := range breadcrumbs {
+		navHTML += ` / <a href="/welcome?folder=` + strconv.Itoa(folder.ID) + `">` + template.HTMLEscapeString(folder.Name) + `</a>`
+	}
+	navHTML += `</div>`
+
+	parentValue := ""
+	if current.Valid {
+		parentValue = strconv.FormatInt(current.Int64, 10)
+	}
+
+	navHTML += `<div id="foldersContainer">`
+	for _, folder := range subfolders {
+		id := strconv.Itoa(folder.ID)
+		navHTML += `<div class="folder">
+        <a href="/welcome?folder=` + id + `">📁 ` + template.HTMLEscapeString(folder.Name) + `</a>
+        <button onclick="renameFolder(` + id + `)">Renommer</button>
+        <a href="/shares/new?folder=` + id + `">Partager</a>
+        <a href="/grants/new?folder=` + id + `">Accès</a>
+        <select onchange="moveFolder(` + id + `, this.value)"><option value="" selected disabled>Déplacer vers…</option>` + folderOptions + `</select>
+        <button onclick="deleteFolder(` + id + `)">Supprimer</button>
+    </div>`
+	}
+	navHTML += `<form id="createFolderForm">
+        <input type="hidden" name="parent_id" value="` + parentValue + `">
+        <input type="text" name="name" placeholder="Nouveau dossier" required>
+        <button type="submit">Créer le dossier</button>
+    </form>
+    </div>`
+
+	return navHTML
+}
+
+// Fonction pour générer les options de destination (chemin complet de chaque dossier)
+func renderFolderOptions(folders []Folder) string {
+	byID := make(map[int64]Folder, len(folders))
+	for _, folder := range folders {
+		byID[int64(folder.ID)] = folder
+	}
+
+	optionsHTML := `<option value="">Mon coffre</option>`
+	for _, folder := range folders {
+		path := folder.Name
+		parent := folder.ParentID
+		for depth := 0; parent.Valid && depth < len(folders); depth++ {
+			p, ok := byID[parent.Int64]
+			if !ok {
+				break
+			}
+			path = p.Name + " / " + path
+			parent = p.ParentID
+		}
+		optionsHTML += `<option value="` + strconv.Itoa(folder.ID) + `">` + template.HTMLEscapeString("Mon coffre / "+path) + `</option>`
+	}
+	return optionsHTML
+}
+
+// Fonction pour construire l'URL de la page d'accueil ouverte sur un dossier
+func welcomeURL(folderID sql.NullInt64) string {
+	if !folderID.Valid {
+		return "/welcome"
+	}
+	return "/welcome?folder=" + strconv.FormatInt(folderID.Int64, 10)
+}
@@ -0,0 +1,263 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+)
+
+// Erreur renvoyée quand un accès accordé n'existe pas (ou plus)
+var errGrantNotFound = errors.New("accès introuvable")
+
+// Définir une structure pour représenter un accès accordé par un utilisateur à un autre sur un fichier, une note ou un dossier
+type Grant struct {
+	ID          int
+	OwnerID     int
+	GranteeID   int
+	FileID      sql.NullInt64
+	NoteID      sql.NullInt64
+	FolderID    sql.NullInt64
+	Access      Access
+	OwnerKey    []byte // Clé de l'accès, chiffrée par la clé du propriétaire
+	GranteeKey  []byte // Clé de l'accès, chiffrée pour la clé publique du bénéficiaire
+	CreatedAt   time.Time
+	OwnerName   string // Noms affichés dans les listes d'accès
+	GranteeName string
+	TargetName  string // Nom du fichier ou du dossier (vide pour une note, dont le titre est chiffré)
+}
+
+// Fonction pour connaître le type et l'ID de l'objet couvert par un accès
+func (g Grant) Resource() (string, int64) {
+	switch {
+	case g.FileID.Valid:
+		return resourceFile, g.FileID.Int64
+	case g.NoteID.Valid:
+		return resourceNote, g.NoteID.Int64
+	default:
+		return resourceFolder, g.FolderID.Int64
+	}
+}
+
+// Colonnes lues pour construire un Grant, dans l'ordre de scanGrant
+const grantColumns = "g.id, g.owner_id, g.grantee_id, g.file_id, g.note_id, g.folder_id, g.access, g.owner_key, g.grantee_key, g.created_at, COALESCE(o.username, ''), COALESCE(u.username, ''), COALESCE(f.filename, d.folder_name, '')"
+
+// Jointures qui donnent les noms des deux utilisateurs et de l'objet couvert ; les objets à la corbeille n'ont plus d'accès
+const grantJoins = " FROM grants g LEFT JOIN users o ON o.ID = g.owner_id LEFT JOIN users u ON u.ID = g.grantee_id" +
+	" LEFT JOIN files f ON f.id = g.file_id LEFT JOIN notes n ON n.id = g.note_id LEFT JOIN folders d ON d.id = g.folder_id" +
+	" WHERE f.deleted_at IS NULL AND n.deleted_at IS NULL AND d.deleted_at IS NULL"
+
+// Fonction pour lire un accès depuis une ligne
+func scanGrant(scan func(dest ...interface{}) error) (Grant, error) {
+	var grant Grant
+	var createdAt []byte
+	err := scan(&grant.ID, &grant.OwnerID, &grant.GranteeID, &grant.FileID, &grant.NoteID, &grant.FolderID, &grant.Access,
+		&grant.OwnerKey, &grant.GranteeKey, &createdAt, &grant.OwnerName, &grant.GranteeName, &grant.TargetName)
+	grant.CreatedAt = parseDBTime(createdAt)
+	return grant, err
+}
+
+// GrantRepo regroupe les requêtes sur les accès accordés (grants et grant_keys) ; il sert de GrantChecker à l'Authorizer
+type GrantRepo struct {
+	db *sql.DB
+}
+
+// Fonction pour lister des accès
+func (r *GrantRepo) list(ctx context.Context, where string, args ...interface{}) ([]Grant, error) {
+	rows, err := r.db.QueryContext(ctx, "SELECT "+grantColumns+grantJoins+" AND "+where, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var grants []Grant
+	for rows.Next() {
+		grant, err := scanGrant(rows.Scan)
+		if err != nil {
+			return nil, err
+		}
+		grants = append(grants, grant)
+	}
+	return grants, rows.Err()
+}
+
+// Fonction pour accorder un accès, ou changer son niveau s'il existe déjà pour ce bénéficiaire et cet objet
+// (les clés de l'accès existant sont alors gardées). Renvoie l'ID de l'accès et s'il vient d'être créé.
+func (r *GrantRepo) Upsert(ctx context.Context, grant Grant) (int64, bool, error) {
+	result, err := r.db.ExecContext(ctx, "INSERT INTO grants (owner_id, grantee_id, file_id, note_id, folder_id, access, owner_key, grantee_key) VALUES (?, ?, ?, ?, ?, ?, ?, ?)"+
+		" ON DUPLICATE KEY UPDATE access = VALUES(access), id = LAST_INSERT_ID(id)",
+		grant.OwnerID, grant.GranteeID, grant.FileID, grant.NoteID, grant.FolderID, grant.Access, grant.OwnerKey, grant.GranteeKey)
+	if err != nil {
+		return 0, false, err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return 0, false, err
+	}
+	// MySQL compte 1 ligne pour une insertion, 2 pour une mise à jour et 0 si rien n'a changé
+	n, err := result.RowsAffected()
+	return id, n == 1, err
+}
+
+// Fonction pour récupérer un accès
+func (r *GrantRepo) Get(ctx context.Context, grantID int64) (Grant, error) {
+	grants, err := r.list(ctx, "g.id = ?", grantID)
+	if err == nil && len(grants) == 0 {
+		err = errGrantNotFound
+	}
+	if err != nil {
+		return Grant{}, err
+	}
+	return grants[0], nil
+}
+
+// Fonction pour lister les accès accordés par un utilisateur
+func (r *GrantRepo) ListByOwner(ctx context.Context, ownerID int) ([]Grant, error) {
+	return r.list(ctx, "g.owner_id = ? ORDER BY g.id DESC", ownerID)
+}
+
+// Fonction pour lister les accès accordés à un utilisateur
+func (r *GrantRepo) ListByGrantee(ctx context.Context, granteeID int) ([]Grant, error) {
+	return r.list(ctx, "g.grantee_id = ? ORDER BY o.username, g.id DESC", granteeID)
+}
+
+// Fonction pour lister les accès accordés sur un objet précis
+func (r *GrantRepo) ListForObject(ctx context.Context, resource string, id int64) ([]Grant, error) {
+	column, err := grantColumn(resource)
+	if err != nil {
+		return nil, err
+	}
+	return r.list(ctx, "g."+column+" = ? ORDER BY u.username", id)
+}
+
+// Fonction pour retirer un accès, par son propriétaire ou par son bénéficiaire ; les clés qu'il protégeait partent avec lui
+func (r *GrantRepo) Delete(ctx context.Context, userID int, grantID int64) error {
+	result, err := r.db.ExecContext(ctx, "DELETE FROM grants WHERE id = ? AND (owner_id = ? OR grantee_id = ?)", grantID, userID, userID)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err != nil || n == 0 {
+		if err == nil {
+			err = errGrantNotFound
+		}
+		return err
+	}
+	return nil
+}
+
+// Fonction pour donner la colonne de grants qui désigne un type d'objet
+func grantColumn(resource string) (string, error) {
+	switch resource {
+	case resourceFile:
+		return "file_id", nil
+	case resourceNote:
+		return "note_id", nil
+	case resourceFolder:
+		return "folder_id", nil
+	}
+	return "", errGrantNotFound
+}
+
+// Fonction pour lister les accès d'un utilisateur qui couvrent un objet : accordés sur l'objet lui-même
+// ou sur l'un des dossiers qui le contiennent, du meilleur niveau au moins bon
+func (r *GrantRepo) Covering(ctx context.Context, granteeID int, resource string, id int64) ([]Grant, error) {
+	column, err := grantColumn(resource)
+	if err != nil {
+		return nil, err
+	}
+
+	// Propriétaire de l'objet et premier dossier à remonter
+	var ownerID sql.NullInt64
+	var folderID sql.NullInt64
+	switch resource {
+	case resourceFile:
+		err = r.db.QueryRowContext(ctx, "SELECT user_id, folder_id FROM files WHERE id = ? AND deleted_at IS NULL", id).Scan(&ownerID, &folderID)
+	case resourceNote:
+		err = r.db.QueryRowContext(ctx, "SELECT user_id, folder_id FROM notes WHERE id = ? AND deleted_at IS NULL", id).Scan(&ownerID, &folderID)
+	case resourceFolder:
+		err = r.db.QueryRowContext(ctx, "SELECT user_id, parent_folder_id FROM folders WHERE id = ? AND deleted_at IS NULL", id).Scan(&ownerID, &folderID)
+	}
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && !ownerID.Valid) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Remonter l'arborescence jusqu'à la racine (protection contre une éventuelle boucle déjà présente en base)
+	conditions := []string{"g." + column + " = ?"}
+	args := []interface{}{granteeID, ownerID.Int64, id}
+	seen := map[int64]bool{}
+	if resource == resourceFolder {
+		seen[id] = true
+	}
+	for folderID.Valid && !seen[folderID.Int64] {
+		seen[folderID.Int64] = true
+		conditions = append(conditions, "g.folder_id = ?")
+		args = append(args, folderID.Int64)
+		var parentID sql.NullInt64
+		err := r.db.QueryRowContext(ctx, "SELECT parent_folder_id FROM folders WHERE id = ? AND user_id = ? AND deleted_at IS NULL", folderID.Int64, ownerID.Int64).Scan(&parentID)
+		if errors.Is(err, sql.ErrNoRows) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		folderID = parentID
+	}
+	return r.list(ctx, "g.grantee_id = ? AND g.owner_id = ? AND ("+strings.Join(conditions, " OR ")+") ORDER BY g.access DESC, g.id", args...)
+}
+
+// Fonction pour savoir si un utilisateur a reçu, sur un objet ou sur un dossier qui le contient, un accès au moins égal à celui demandé
+func (r *GrantRepo) HasGrant(ctx context.Context, userID int, resource string, id int64, access Access) (bool, error) {
+	grants, err := r.Covering(ctx, userID, resource, id)
+	if err != nil {
+		return false, err
+	}
+	return len(grants) > 0 && grants[0].Access >= access, nil
+}
+
+// Fonction pour lister les empreintes des clés de données déjà protégées par la clé d'un accès
+func (r *GrantRepo) KeyedContents(ctx context.Context, grantID int) (map[string]bool, error) {
+	rows, err := r.db.QueryContext(ctx, "SELECT key_hash FROM grant_keys WHERE grant_id = ?", grantID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := make(map[string]bool)
+	for rows.Next() {
+		var hash []byte
+		if err := rows.Scan(&hash); err != nil {
+			return nil, err
+		}
+		keys[string(hash)] = true
+	}
+	return keys, rows.Err()
+}
+
+// Fonction pour ajouter des clés de données protégées par la clé d'un accès (empreinte -> clé chiffrée)
+func (r *GrantRepo) AddKeys(ctx context.Context, grantID int, keys map[string][]byte) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	values := make([]string, 0, len(keys))
+	args := make([]interface{}, 0, 3*len(keys))
+	for hash, wrapped := range keys {
+		values = append(values, "(?, ?, ?)")
+		args = append(args, grantID, []byte(hash), wrapped)
+	}
+	_, err := r.db.ExecContext(ctx, "INSERT IGNORE INTO grant_keys (grant_id, key_hash, wrapped_key) VALUES "+strings.Join(values, ", "), args...)
+	return err
+}
+
+// Fonction pour lire une clé de données protégée par la clé d'un accès
+func (r *GrantRepo) Key(ctx context.Context, grantID int, keyHash []byte) ([]byte, error) {
+	var wrapped []byte
+	err := r.db.QueryRowContext(ctx, "SELECT wrapped_key FROM grant_keys WHERE grant_id = ? AND key_hash = ?", grantID, keyHash).Scan(&wrapped)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, errGrantNotFound
+	}
+	return wrapped, err
+}
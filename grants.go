@@ -0,0 +1,840 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdh"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+)
+
+// Les accès accordés entre utilisateurs suivent le modèle des liens de partage : chaque accès a sa propre clé,
+// qui protège une copie des clés de données des contenus couverts (grant_keys). La clé de l'accès est gardée
+// chiffrée par la clé du propriétaire, pour étendre l'accès aux contenus ajoutés plus tard, et chiffrée pour
+// la clé publique X25519 du bénéficiaire, qui l'ouvre avec sa clé privée. Le bénéficiaire ne peut donc lire que
+// les contenus que le propriétaire lui a confiés. Un accès en écriture permet d'ajouter des notes et des fichiers
+// au dossier partagé : ils appartiennent au propriétaire, et leur clé de données est déposée sous la clé de l'accès.
+
+// Erreurs renvoyées par les accès accordés
+var (
+	errGrantKeyMissing = errors.New("ce contenu n'est pas encore accessible : il le sera à la prochaine connexion de son propriétaire")
+	errGrantInput      = errors.New("paramètres d'accès invalides")
+)
+
+// Niveaux d'accès proposés dans le formulaire
+var grantAccessValues = map[string]Access{"read": AccessRead, "write": AccessWrite, "manage": AccessManage}
+
+// Données associées qui lient la clé privée de partage à son utilisateur
+func userPrivateKeyAD(userID int) []byte {
+	return []byte(fmt.Sprintf("coffrefort:private-key:%d", userID))
+}
+
+// Données associées qui lient la clé d'un accès à son propriétaire
+func grantOwnerAD(ownerID int) []byte {
+	return []byte(fmt.Sprintf("coffrefort:grant-key:%d", ownerID))
+}
+
+// Données associées qui lient la clé d'un accès à son propriétaire et à son bénéficiaire
+func grantGranteeAD(ownerID, granteeID int) []byte {
+	return []byte(fmt.Sprintf("coffrefort:grant-key:%d:%d", ownerID, granteeID))
+}
+
+// Données associées qui lient une clé de données protégée par un accès à la clé protégée du propriétaire
+func grantContentAD(keyHash []byte) []byte {
+	return append([]byte("coffrefort:grant-content:"), keyHash...)
+}
+
+// Fonction pour calculer l'empreinte d'une clé de données protégée par le propriétaire, qui l'identifie dans grant_keys.
+// Elle vaut pour le fichier, ses anciennes versions et sa vignette, comme pour une note.
+func grantKeyHash(wrapped []byte) []byte {
+	digest := sha256.Sum256(wrapped)
+	return digest[:]
+}
+
+// Les notes et fichiers ajoutés par un bénéficiaire d'un accès en écriture appartiennent au propriétaire du dossier,
+// mais le bénéficiaire n'a pas la clé du propriétaire : leur clé de données est déposée sous la clé de l'accès,
+// précédée de la copie de celle-ci que garde le propriétaire. Le propriétaire l'ouvre donc avec sa seule clé,
+// même une fois l'accès révoqué, et le dépôt reste valable quel que soit le moment où il se reconnecte.
+const depositMagic = "CFD\x01"
+
+// Fonction pour déposer la clé de données d'un contenu ajouté sous un accès
+func depositDataKey(grant Grant, grantKey, dataKey, associatedData []byte) ([]byte, error) {
+	sealed, err := sealKey(grantKey, dataKey, associatedData)
+	if err != nil {
+		return nil, err
+	}
+	deposit := make([]byte, 0, len(depositMagic)+2+len(grant.OwnerKey)+len(sealed))
+	deposit = append(deposit, depositMagic...)
+	deposit = binary.BigEndian.AppendUint16(deposit, uint16(len(grant.OwnerKey)))
+	deposit = append(deposit, grant.OwnerKey...)
+	return append(deposit, sealed...), nil
+}
+
+// Fonction pour ouvrir avec la clé du propriétaire une clé de données déposée par un bénéficiaire
+func openDeposit(ownerKey, deposit, associatedData []byte, ownerID int) ([]byte, error) {
+	rest := deposit[len(depositMagic):]
+	if len(rest) < 2 || len(rest[2:]) < int(binary.BigEndian.Uint16(rest)) {
+		return nil, errDecrypt
+	}
+	n := int(binary.BigEndian.Uint16(rest))
+	grantKey, err := openKey(ownerKey, rest[2:2+n], grantOwnerAD(ownerID))
+	if err != nil {
+		return nil, err
+	}
+	return openKey(grantKey, rest[2+n:], associatedData)
+}
+
+// Fonction pour retrouver avec la clé de son propriétaire la clé de données d'un contenu, qu'elle soit protégée
+// par cette clé ou déposée par un bénéficiaire. Une clé protégée peut commencer par hasard comme un dépôt :
+// elle est alors ouverte directement si le dépôt ne s'ouvre pas.
+func openDataKey(ownerKey, wrapped, associatedData []byte, ownerID int) ([]byte, error) {
+	if bytes.HasPrefix(wrapped, []byte(depositMagic)) {
+		if dataKey, err := openDeposit(ownerKey, wrapped, associatedData, ownerID); err == nil {
+			return dataKey, nil
+		}
+	}
+	return openKey(ownerKey, wrapped, associatedData)
+}
+
+// Définir une structure pour représenter la clé de données d'un contenu ajouté dans le dossier d'un autre propriétaire
+type contentDeposit struct {
+	Grant    Grant  // Accès en écriture du déposant sur le dossier
+	GrantKey []byte // Clé de cet accès
+	DataKey  []byte
+	Wrapped  []byte // Clé de données déposée, enregistrée avec le contenu
+}
+
+// Fonction pour créer la clé de données d'un contenu que l'utilisateur connecté ajoute dans le dossier d'un autre
+// propriétaire, sous l'accès en écriture qui couvre ce dossier
+func (s *Server) newDeposit(ctx context.Context, userID int, userKey []byte, folderID int64, associatedData []byte) (contentDeposit, error) {
+	var deposit contentDeposit
+	grants, err := s.grants.Covering(ctx, userID, resourceFolder, folderID)
+	if err != nil {
+		return deposit, err
+	}
+	if len(grants) == 0 || grants[0].Access < AccessWrite {
+		return deposit, errNotVisible
+	}
+	deposit.Grant = grants[0]
+	if deposit.GrantKey, err = s.openGrantKey(ctx, userKey, deposit.Grant); err != nil {
+		return deposit, err
+	}
+	if deposit.DataKey, err = randomBytes(keySize); err != nil {
+		return deposit, err
+	}
+	deposit.Wrapped, err = depositDataKey(deposit.Grant, deposit.GrantKey, deposit.DataKey, associatedData)
+	return deposit, err
+}
+
+// Fonction pour confier à l'accès du déposant la clé du contenu qu'il vient d'ajouter, pour qu'il puisse le relire.
+// Les autres accès et liens du propriétaire la recevront avec sa clé : tout de suite si elle est en mémoire,
+// sinon à sa prochaine connexion.
+func (s *Server) keepDeposit(ctx context.Context, deposit contentDeposit) error {
+	hash := grantKeyHash(deposit.Wrapped)
+	sealed, err := sealKey(deposit.GrantKey, deposit.DataKey, grantContentAD(hash))
+	if err != nil {
+		return err
+	}
+	if err := s.grants.AddKeys(ctx, deposit.Grant.ID, map[string][]byte{string(hash): sealed}); err != nil {
+		return err
+	}
+	if ownerKey, ok := s.keys.ring.Get(deposit.Grant.OwnerID); ok {
+		go s.refreshAccess(context.Background(), deposit.Grant.OwnerID, ownerKey)
+	}
+	return nil
+}
+
+// Fonction pour tirer la clé de chiffrement d'un échange X25519
+func recipientSealKey(shared, ephemeral, recipient []byte) []byte {
+	mac := hmac.New(sha256.New, shared)
+	mac.Write([]byte("coffrefort:recipient-key"))
+	mac.Write(ephemeral)
+	mac.Write(recipient)
+	return mac.Sum(nil)
+}
+
+// Fonction pour chiffrer une clé pour le détenteur d'une clé publique X25519 : clé publique éphémère suivie du chiffré
+func sealForUser(publicKey, plaintext, associatedData []byte) ([]byte, error) {
+	recipient, err := ecdh.X25519().NewPublicKey(publicKey)
+	if err != nil {
+		return nil, err
+	}
+	ephemeral, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	shared, err := ephemeral.ECDH(recipient)
+	if err != nil {
+		return nil, err
+	}
+	ephemeralKey := ephemeral.PublicKey().Bytes()
+	sealed, err := sealKey(recipientSealKey(shared, ephemeralKey, publicKey), plaintext, associatedData)
+	if err != nil {
+		return nil, err
+	}
+	return append(ephemeralKey, sealed...), nil
+}
+
+// Fonction pour déchiffrer une clé chiffrée par sealForUser avec la clé privée correspondante
+func openForUser(privateKey, sealed, associatedData []byte) ([]byte, error) {
+	private, err := ecdh.X25519().NewPrivateKey(privateKey)
+	if err != nil {
+		return nil, err
+	}
+	const ephemeralSize = 32
+	if len(sealed) < ephemeralSize {
+		return nil, errDecrypt
+	}
+	ephemeral, err := ecdh.X25519().NewPublicKey(sealed[:ephemeralSize])
+	if err != nil {
+		return nil, errDecrypt
+	}
+	shared, err := private.ECDH(ephemeral)
+	if err != nil {
+		return nil, errDecrypt
+	}
+	return openKey(recipientSealKey(shared, sealed[:ephemeralSize], private.PublicKey().Bytes()), sealed[ephemeralSize:], associatedData)
+}
+
+// Fonction pour créer la paire de clés de partage d'un utilisateur qui n'en a pas encore (inscription, connexion)
+func (s *Server) ensureKeyPair(ctx context.Context, userID int, userKey []byte) error {
+	_, _, err := s.keys.keys.KeyPair(ctx, userID)
+	if !errors.Is(err, errKeyPairNotFound) {
+		return err
+	}
+	private, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return err
+	}
+	sealedPrivate, err := sealKey(userKey, private.Bytes(), userPrivateKeyAD(userID))
+	if err != nil {
+		return err
+	}
+	return s.keys.keys.SetKeyPair(ctx, userID, private.PublicKey().Bytes(), sealedPrivate)
+}
+
+// Fonction pour ouvrir la clé d'un accès avec la clé privée de son bénéficiaire
+func (s *Server) openGrantKey(ctx context.Context, granteeKey []byte, grant Grant) ([]byte, error) {
+	_, sealedPrivate, err := s.keys.keys.KeyPair(ctx, grant.GranteeID)
+	if err != nil {
+		return nil, err
+	}
+	private, err := openKey(granteeKey, sealedPrivate, userPrivateKeyAD(grant.GranteeID))
+	if err != nil {
+		return nil, err
+	}
+	return openForUser(private, grant.GranteeKey, grantGranteeAD(grant.OwnerID, grant.GranteeID))
+}
+
+// Définir une structure pour représenter une clé de données couverte par un accès
+type grantContent struct {
+	WrappedKey []byte // Clé de données protégée par la clé du propriétaire
+	AD         []byte // Données associées de cette protection (fichier ou note)
+}
+
+// Fonction pour lister les clés de données des contenus couverts par un accès : le fichier et ses anciennes versions,
+// la note, ou tout le contenu du dossier et de ses sous-dossiers. Les anciens contenus en clair n'ont pas de clé.
+func (s *Server) grantContents(ctx context.Context, grant Grant) ([]grantContent, error) {
+	var contents []grantContent
+	addFile := func(file UploadedFile) error {
+		contents = append(contents, grantContent{WrappedKey: file.WrappedKey, AD: fileKeyAD(grant.OwnerID)})
+		versions, err := s.versions.List(ctx, file.ID)
+		for _, version := range versions {
+			contents = append(contents, grantContent{WrappedKey: version.WrappedKey, AD: fileKeyAD(grant.OwnerID)})
+		}
+		return err
+	}
+
+	switch {
+	case grant.FileID.Valid:
+		file, err := s.files.Get(ctx, grant.FileID.Int64)
+		if err != nil {
+			return nil, err
+		}
+		return contents, addFile(file)
+	case grant.NoteID.Valid:
+		note, err := s.notes.Get(ctx, grant.NoteID.Int64)
+		if err != nil {
+			return nil, err
+		}
+		return append(contents, grantContent{WrappedKey: note.WrappedKey, AD: noteKeyAD(grant.OwnerID)}), nil
+	}
+
+	tree, err := s.folders.Tree(ctx, grant.OwnerID, grant.FolderID.Int64)
+	if err != nil {
+		return nil, err
+	}
+	for _, folderID := range tree {
+		folder := sql.NullInt64{Int64: folderID, Valid: true}
+		notes, err := s.notes.ListByFolder(ctx, grant.OwnerID, folder)
+		if err != nil {
+			return nil, err
+		}
+		for _, note := range notes {
+			contents = append(contents, grantContent{WrappedKey: note.WrappedKey, AD: noteKeyAD(grant.OwnerID)})
+		}
+		files, err := s.files.ListByFolder(ctx, grant.OwnerID, folder, FileListOptions{})
+		if err != nil {
+			return nil, err
+		}
+		for _, file := range files {
+			if err := addFile(file); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return contents, nil
+}
+
+// Fonction pour protéger avec la clé d'un accès les clés de données des contenus qu'il couvre et qui ne le sont pas encore
+func (s *Server) coverGrant(ctx context.Context, ownerKey []byte, grant Grant, grantKey []byte) error {
+	contents, err := s.grantContents(ctx, grant)
+	if err != nil {
+		return err
+	}
+	known, err := s.grants.KeyedContents(ctx, grant.ID)
+	if err != nil {
+		return err
+	}
+	keys := make(map[string][]byte)
+	for _, content := range contents {
+		if content.WrappedKey == nil {
+			continue
+		}
+		hash := grantKeyHash(content.WrappedKey)
+		if known[string(hash)] {
+			continue
+		}
+		dataKey, err := openDataKey(ownerKey, content.WrappedKey, content.AD, grant.OwnerID)
+		if err != nil {
+			return err
+		}
+		if keys[string(hash)], err = sealKey(grantKey, dataKey, grantContentAD(hash)); err != nil {
+			return err
+		}
+		known[string(hash)] = true
+		if len(keys) == shareKeyBatch {
+			if err := s.grants.AddKeys(ctx, grant.ID, keys); err != nil {
+				return err
+			}
+			keys = make(map[string][]byte)
+		}
+	}
+	return s.grants.AddKeys(ctx, grant.ID, keys)
+}
+
+// Fonction pour étendre les accès accordés par un utilisateur aux contenus arrivés depuis leur création
+func (s *Server) refreshGrants(ctx context.Context, ownerID int, ownerKey []byte) {
+	grants, err := s.grants.ListByOwner(ctx, ownerID)
+	if err != nil {
+		log.Println("Erreur lors de la récupération des accès accordés :", err)
+		return
+	}
+	for _, grant := range grants {
+		grantKey, err := openKey(ownerKey, grant.OwnerKey, grantOwnerAD(ownerID))
+		if err == nil {
+			err = s.coverGrant(ctx, ownerKey, grant, grantKey)
+		}
+		if err != nil && !errors.Is(err, errFileNotFound) && !errors.Is(err, errNoteNotFound) {
+			log.Printf("Erreur lors de la mise à jour de l'accès %d : %v", grant.ID, err)
+		}
+	}
+}
+
+// Fonction pour étendre les liens de partage et les accès accordés d'un utilisateur à ses nouveaux contenus
+func (s *Server) refreshAccess(ctx context.Context, userID int, userKey []byte) {
+	s.refreshShares(ctx, userID, userKey)
+	s.refreshGrants(ctx, userID, userKey)
+}
+
+// Fonction pour étendre en arrière-plan les liens et les accès d'un propriétaire, si sa clé est disponible
+func (s *Server) refreshAccessLater(c echo.Context, userID int) {
+	if userKey, err := s.keys.ForRequest(c, userID); err == nil {
+		go s.refreshAccess(context.Background(), userID, userKey)
+	}
+}
+
+// Fonction pour retrouver, pour l'utilisateur connecté, la clé de données d'un contenu d'un autre propriétaire
+// à partir des accès qui le couvrent
+func (s *Server) grantedDataKey(c echo.Context, resource string, id int64, wrapped []byte) ([]byte, error) {
+	ctx := c.Request().Context()
+	userID := currentUserID(c)
+	userKey, err := s.keys.ForRequest(c, userID)
+	if err != nil {
+		return nil, err
+	}
+	grants, err := s.grants.Covering(ctx, userID, resource, id)
+	if err != nil {
+		return nil, err
+	}
+	hash := grantKeyHash(wrapped)
+	for _, grant := range grants {
+		sealed, err := s.grants.Key(ctx, grant.ID, hash)
+		if errors.Is(err, errGrantNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		grantKey, err := s.openGrantKey(ctx, userKey, grant)
+		if err != nil {
+			return nil, err
+		}
+		return openKey(grantKey, sealed, grantContentAD(hash))
+	}
+	return nil, errGrantKeyMissing
+}
+
+// Fonction pour déchiffrer une note pour l'utilisateur connecté : avec sa clé s'il en est propriétaire,
+// sinon avec la clé confiée à un accès qui la couvre
+func (s *Server) openNoteFor(c echo.Context, userKey []byte, note *Note) error {
+	if note.WrappedKey == nil || note.UserID == currentUserID(c) {
+		return openNote(userKey, note)
+	}
+	dataKey, err := s.grantedDataKey(c, resourceNote, int64(note.ID), note.WrappedKey)
+	if err != nil {
+		return err
+	}
+	return openNoteFields(dataKey, note)
+}
+
+// Fonction pour donner le paramètre de formulaire qui désigne un type d'objet
+func grantParam(resource string) string {
+	switch resource {
+	case resourceFile:
+		return "file"
+	case resourceNote:
+		return "note"
+	}
+	return "folder"
+}
+
+// Fonction pour lire l'objet visé par le formulaire d'accès (file, note ou folder) ; seul son propriétaire
+// peut en accorder l'accès, puisqu'il est seul à pouvoir confier ses clés de données
+func (s *Server) grantTarget(c echo.Context, value func(string) string) (resource string, id int64, name string, err error) {
+	ctx := c.Request().Context()
+	userID := currentUserID(c)
+	ownerID := 0
+	switch {
+	case value("file") != "":
+		resource = resourceFile
+		if id, err = parseObjectID(value("file")); err == nil {
+			var file UploadedFile
+			file, err = s.authz.File(ctx, userID, id, AccessManage)
+			name, ownerID = "le fichier « "+file.FileName+" »", file.UserID
+		}
+	case value("note") != "":
+		resource = resourceNote
+		if id, err = parseObjectID(value("note")); err == nil {
+			var note Note
+			note, err = s.authz.Note(ctx, userID, id, AccessManage)
+			name, ownerID = "la note n°"+strconv.FormatInt(id, 10), note.UserID
+		}
+	default:
+		resource = resourceFolder
+		if id, err = parseObjectID(value("folder")); err == nil {
+			var folder Folder
+			folder, err = s.authz.Folder(ctx, userID, id, AccessManage)
+			name, ownerID = "le dossier « "+folder.Name+" »", folder.UserID
+		}
+	}
+	if err == nil && ownerID != userID {
+		log.Printf("Accès refusé : utilisateur %d, partage de %s %d dont il n'est pas propriétaire", userID, resource, id)
+		err = errNotVisible
+	}
+	return resource, id, name, err
+}
+
+// Gestionnaire de route pour afficher les accès accordés sur un objet et le formulaire pour en accorder (?file=, ?note= ou ?folder=)
+func (s *Server) newGrantHandler(c echo.Context) error {
+	resource, id, name, err := s.grantTarget(c, c.QueryParam)
+	if err != nil {
+		return accessErrorResponse(c, err, "Erreur lors de la préparation du partage")
+	}
+	grants, err := s.grants.ListForObject(c.Request().Context(), resource, id)
+	if err != nil {
+		log.Println("Erreur lors de la récupération des accès :", err)
+		return err
+	}
+
+	param := grantParam(resource)
+	htmlContent := `
+        <h1>Accès à ` + template.HTMLEscapeString(name) + `</h1>
+        <p>Un accès à un dossier vaut pour tout son contenu, sous-dossiers compris. Lecture : ouvrir et télécharger.
+        Écriture : en plus, renommer, ranger, créer des sous-dossiers, ajouter des fichiers et des notes et restaurer des versions.
+        Gestion : en plus, supprimer. Les fichiers et notes ajoutés vous appartiennent et comptent dans votre quota.</p>
+        <ul>`
+	for _, grant := range grants {
+		htmlContent += `
+            <li>` + template.HTMLEscapeString(grant.GranteeName) + ` : ` + grant.Access.String() + `
+                <form action="/grants/` + strconv.Itoa(grant.ID) + `/revoke" method="post" style="display:inline">
+                    <button type="submit">Retirer</button>
+                </form>
+            </li>`
+	}
+	if len(grants) == 0 {
+		htmlContent += `
+            <li>Aucun accès accordé.</li>`
+	}
+	htmlContent += `
+        </ul>
+        <form action="/grants" method="post">
+            <input type="hidden" name="` + param + `" value="` + strconv.FormatInt(id, 10) + `">
+            <label>Utilisateur : <input type="text" name="username" required></label>
+            <select name="access">
+                <option value="read">Lecture</option>
+                <option value="write">Écriture</option>
+                <option value="manage">Gestion</option>
+            </select>
+            <button type="submit">Accorder</button>
+        </form>
+        <a href="/welcome">Retour à la page </a>
+    `
+	return c.HTML(http.StatusOK, htmlContent)
+}
+
+// Traitement du formulaire d'accès : accorde l'accès, ou change son niveau s'il existe déjà
+func (s *Server) createGrantPostHandler(c echo.Context) error {
+	ctx := c.Request().Context()
+	userID := currentUserID(c)
+	resource, id, name, err := s.grantTarget(c, c.FormValue)
+	if err != nil {
+		return accessErrorResponse(c, err, "Erreur lors du partage")
+	}
+	access, ok := grantAccessValues[c.FormValue("access")]
+	if !ok {
+		return c.JSON(http.StatusBadRequest, map[string]string{"message": errGrantInput.Error() + " : niveau d'accès"})
+	}
+	grantee, err := s.users.FindByUsername(ctx, strings.TrimSpace(c.FormValue("username")))
+	if errors.Is(err, errUserNotFound) || (err == nil && grantee.ID == userID) {
+		return c.JSON(http.StatusBadRequest, map[string]string{"message": errGrantInput.Error() + " : utilisateur"})
+	}
+	if err != nil {
+		return err
+	}
+	publicKey, _, err := s.keys.keys.KeyPair(ctx, grantee.ID)
+	if errors.Is(err, errKeyPairNotFound) {
+		return c.JSON(http.StatusConflict, map[string]string{"message": err.Error()})
+	}
+	if err != nil {
+		return err
+	}
+	userKey, err := s.keys.ForRequest(c, userID)
+	if err != nil {
+		return keyringLockedResponse(c)
+	}
+
+	grant := Grant{OwnerID: userID, GranteeID: grantee.ID, Access: access}
+	target := sql.NullInt64{Int64: id, Valid: true}
+	switch resource {
+	case resourceFile:
+		grant.FileID = target
+	case resourceNote:
+		grant.NoteID = target
+	default:
+		grant.FolderID = target
+	}
+	grantKey, err := randomBytes(keySize)
+	if err != nil {
+		return err
+	}
+	if grant.OwnerKey, err = sealKey(userKey, grantKey, grantOwnerAD(userID)); err != nil {
+		return err
+	}
+	if grant.GranteeKey, err = sealForUser(publicKey, grantKey, grantGranteeAD(userID, grantee.ID)); err != nil {
+		return err
+	}
+	grantID, created, err := s.grants.Upsert(ctx, grant)
+	if err != nil {
+		log.Println("Erreur lors de l'enregistrement de l'accès :", err)
+		return err
+	}
+
+	// Un accès existant garde sa clé : c'est elle qui protège les clés de données déjà confiées
+	if grant, err = s.grants.Get(ctx, grantID); err != nil {
+		return err
+	}
+	if grantKey, err = openKey(userKey, grant.OwnerKey, grantOwnerAD(userID)); err != nil {
+		return err
+	}
+	if err := s.coverGrant(ctx, userKey, grant, grantKey); err != nil {
+		log.Println("Erreur lors de la préparation des clés de l'accès :", err)
+		return err
+	}
+	if created {
+		s.notify(ctx, grantee.ID, fmt.Sprintf("%s a partagé avec vous %s (%s).", grant.OwnerName, name, access))
+	}
+	log.Printf("Accès %d (%s) accordé par l'utilisateur %d à l'utilisateur %d sur %s %d", grantID, access, userID, grantee.ID, resource, id)
+	return c.Redirect(http.StatusSeeOther, "/grants/new?"+grantParam(resource)+"="+strconv.FormatInt(id, 10))
+}
+
+// Traitement du bouton de retrait d'un accès, par son propriétaire ou par son bénéficiaire
+func (s *Server) revokeGrantPostHandler(c echo.Context) error {
+	ctx := c.Request().Context()
+	userID := currentUserID(c)
+	grantID, err := parseObjectID(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"message": errGrantNotFound.Error()})
+	}
+	grant, err := s.grants.Get(ctx, grantID)
+	if err == nil {
+		err = s.grants.Delete(ctx, userID, grantID)
+	}
+	if errors.Is(err, errGrantNotFound) {
+		return c.JSON(http.StatusNotFound, map[string]string{"message": errGrantNotFound.Error()})
+	}
+	if err != nil {
+		log.Println("Erreur lors du retrait de l'accès :", err)
+		return err
+	}
+	if grant.OwnerID != userID {
+		return c.Redirect(http.StatusSeeOther, "/welcome")
+	}
+	resource, id := grant.Resource()
+	return c.Redirect(http.StatusSeeOther, "/grants/new?"+grantParam(resource)+"="+strconv.FormatInt(id, 10))
+}
+
+// Fonction pour construire l'URL d'un dossier partagé avec l'utilisateur (racine : sa page d'accueil)
+func sharedFolderURL(folderID sql.NullInt64) string {
+	if !folderID.Valid {
+		return "/welcome"
+	}
+	return "/shared/folders/" + strconv.FormatInt(folderID.Int64, 10)
+}
+
+// Gestionnaire de route pour parcourir un dossier d'un autre utilisateur, d'après les accès reçus
+func (s *Server) sharedFolderHandler(c echo.Context) error {
+	ctx := c.Request().Context()
+	userID := currentUserID(c)
+	folderID, err := parseObjectID(c.Param("id"))
+	if err != nil {
+		return accessErrorResponse(c, err, "Erreur lors de la lecture du dossier")
+	}
+	folder, err := s.authz.Folder(ctx, userID, folderID, AccessRead)
+	if err != nil {
+		return accessErrorResponse(c, err, "Erreur lors de la lecture du dossier")
+	}
+	current := sql.NullInt64{Int64: folderID, Valid: true}
+	if folder.UserID == userID {
+		return c.Redirect(http.StatusSeeOther, welcomeURL(current))
+	}
+
+	// Niveau d'accès sur ce dossier, pour ne proposer que les actions permises
+	access := AccessRead
+	if grants, err := s.grants.Covering(ctx, userID, resourceFolder, folderID); err == nil && len(grants) > 0 {
+		access = grants[0].Access
+	}
+	subfolders, err := s.folders.ListChildren(ctx, folder.UserID, current)
+	if err != nil {
+		log.Println("Erreur lors de la récupération des dossiers :", err)
+		return err
+	}
+	notes, err := s.notes.ListByFolder(ctx, folder.UserID, current)
+	if err != nil {
+		log.Println("Erreur lors de la récupération des notes :", err)
+		return err
+	}
+	files, err := s.files.ListByFolder(ctx, folder.UserID, current, FileListOptions{Sort: "name"})
+	if err != nil {
+		log.Println("Erreur lors de la récupération des fichiers :", err)
+		return err
+	}
+
+	// Le parent n'est proposé que s'il est lui aussi partagé avec l'utilisateur
+	back := "/welcome"
+	if folder.ParentID.Valid {
+		if ok, err := s.grants.HasGrant(ctx, userID, resourceFolder, folder.ParentID.Int64, AccessRead); err == nil && ok {
+			back = sharedFolderURL(folder.ParentID)
+		}
+	}
+
+	htmlContent := `
+        <h1>📁 ` + template.HTMLEscapeString(folder.Name) + `</h1>
+        <p>Partagé avec vous (` + access.String() + `).</p>
+        <ul>`
+	for _, subfolder := range subfolders {
+		id := strconv.Itoa(subfolder.ID)
+		htmlContent += `
+            <li><a href="/shared/folders/` + id + `">📁 ` + template.HTMLEscapeString(subfolder.Name) + `</a>`
+		if access >= AccessManage {
+			htmlContent += `
+                <form action="/delete-folder/` + id + `" method="post" style="display:inline"><button type="submit">Supprimer</button></form>`
+		}
+		htmlContent += `</li>`
+	}
+
+	userKey, keyErr := s.keys.ForRequest(c, userID)
+	for _, note := range notes {
+		if keyErr != nil {
+			note.Title, note.Content = "Note chiffrée", errKeyringLocked.Error()
+		} else if err := s.openNoteFor(c, userKey, &note); errors.Is(err, errGrantKeyMissing) {
+			note.Title, note.Content = "Note", err.Error()
+		} else if err != nil {
+			log.Printf("Erreur lors du déchiffrement de la note %d : %v", note.ID, err)
+			note.Title, note.Content = "Note illisible", "Le déchiffrement de la note a échoué."
+		}
+		htmlContent += `
+            <li><a href="/shared/notes/` + strconv.Itoa(note.ID) + `">📝 ` + template.HTMLEscapeString(note.Title) + `</a></li>`
+	}
+
+	for _, file := range files {
+		id := strconv.Itoa(file.ID)
+		htmlContent += `
+            <li>` + template.HTMLEscapeString(file.FileName) + ` ` + renderFileDetails(file) + `
+                — <a href="/view-file/` + id + `" target="_blank">Ouvrir</a>
+                <a href="/view-file/` + id + `?download=1">Télécharger</a>
+                <a href="/files/` + id + `/versions">Versions</a>`
+		if access >= AccessManage {
+			htmlContent += `
+                <form action="/delete-file/` + id + `" method="post" style="display:inline"><button type="submit">Supprimer</button></form>`
+		}
+		htmlContent += `
+            </li>`
+	}
+	if len(subfolders)+len(notes)+len(files) == 0 {
+		htmlContent += `
+            <li>Ce dossier est vide.</li>`
+	}
+	htmlContent += `
+        </ul>`
+	if access >= AccessWrite {
+		id := strconv.FormatInt(folderID, 10)
+		htmlContent += `
+        <form action="/create-folder" method="post">
+            <input type="hidden" name="parent_id" value="` + id + `">
+            <input type="text" name="name" placeholder="Nouveau dossier" required>
+            <button type="submit">Créer le dossier</button>
+        </form>
+        <form action="/upload-file?folder_id=` + id + `" method="post" enctype="multipart/form-data">
+            <input type="hidden" name="folder_id" value="` + id + `">
+            <input type="file" name="file" required>
+            <button type="submit">Ajouter le fichier</button>
+        </form>
+        <form action="/create-note" method="post">
+            <input type="hidden" name="folder_id" value="` + id + `">
+            <input type="text" name="title" placeholder="Titre de la note" required><br>
+            <textarea name="content" rows="5" cols="50" placeholder="Contenu de la note" required></textarea><br>
+            <button type="submit">Ajouter la note</button>
+        </form>`
+	}
+	htmlContent += `
+        <a href="` + back + `">Retour</a>
+    `
+	return c.HTML(http.StatusOK, htmlContent)
+}
+
+// Gestionnaire de route pour lire une note d'un autre utilisateur, d'après les accès reçus
+func (s *Server) sharedNoteHandler(c echo.Context) error {
+	ctx := c.Request().Context()
+	userID := currentUserID(c)
+	noteID, err := parseObjectID(c.Param("id"))
+	if err != nil {
+		return accessErrorResponse(c, err, "Erreur lors de la lecture de la note")
+	}
+	note, err := s.authz.Note(ctx, userID, noteID, AccessRead)
+	if err != nil {
+		return accessErrorResponse(c, err, "Erreur lors de la lecture de la note")
+	}
+	if note.UserID == userID {
+		return c.Redirect(http.StatusSeeOther, welcomeURL(note.FolderID))
+	}
+	userKey, err := s.keys.ForRequest(c, userID)
+	if err != nil {
+		return keyringLockedResponse(c)
+	}
+	err = s.openNoteFor(c, userKey, &note)
+	if errors.Is(err, errGrantKeyMissing) {
+		return c.JSON(http.StatusServiceUnavailable, map[string]string{"message": err.Error()})
+	}
+	if err != nil {
+		log.Printf("Erreur lors du déchiffrement de la note %d : %v", note.ID, err)
+		return err
+	}
+
+	back := "/welcome"
+	if note.FolderID.Valid {
+		if ok, err := s.grants.HasGrant(ctx, userID, resourceFolder, note.FolderID.Int64, AccessRead); err == nil && ok {
+			back = sharedFolderURL(note.FolderID)
+		}
+	}
+	htmlContent := `
+        <h1>` + template.HTMLEscapeString(note.Title) + `</h1>
+        <p style="white-space: pre-wrap">` + template.HTMLEscapeString(note.Content) + `</p>
+        <a href="` + back + `">Retour</a>
+    `
+	return c.HTML(http.StatusOK, htmlContent)
+}
+
+// Fonction pour décrire l'objet couvert par un accès dans les listes
+func grantTargetLabel(grant Grant) string {
+	resource, id := grant.Resource()
+	if resource == resourceNote {
+		return "note n°" + strconv.FormatInt(id, 10)
+	}
+	return resource + " « " + grant.TargetName + " »"
+}
+
+// Fonction pour générer la liste des accès accordés par l'utilisateur
+func renderGrants(grants []Grant) string {
+	if len(grants) == 0 {
+		return ""
+	}
+	htmlContent := `<div class="grants">
+        <h2>Accès accordés</h2>
+        <ul>`
+	for _, grant := range grants {
+		resource, id := grant.Resource()
+		htmlContent += `
+            <li>` + template.HTMLEscapeString(grantTargetLabel(grant)+" · "+grant.GranteeName+" · "+grant.Access.String()) + `
+                <a href="/grants/new?` + grantParam(resource) + `=` + strconv.FormatInt(id, 10) + `">Modifier</a>
+                <form action="/grants/` + strconv.Itoa(grant.ID) + `/revoke" method="post" style="display:inline">
+                    <button type="submit">Retirer</button>
+                </form>
+            </li>`
+	}
+	return htmlContent + `
+        </ul>
+    </div>`
+}
+
+// Fonction pour générer la section « Partagés avec moi » : les objets que d'autres utilisateurs ont partagés avec l'utilisateur
+func renderSharedWithMe(grants []Grant) string {
+	if len(grants) == 0 {
+		return ""
+	}
+	htmlContent := `<div class="shared-with-me">
+        <h2>Partagés avec moi</h2>
+        <ul>`
+	for _, grant := range grants {
+		resource, id := grant.Resource()
+		href := "/shared/folders/" + strconv.FormatInt(id, 10)
+		switch resource {
+		case resourceFile:
+			href = "/view-file/" + strconv.FormatInt(id, 10)
+		case resourceNote:
+			href = "/shared/notes/" + strconv.FormatInt(id, 10)
+		}
+		htmlContent += `
+            <li><a href="` + href + `">` + template.HTMLEscapeString(grantTargetLabel(grant)) + `</a>
+                (` + template.HTMLEscapeString(grant.OwnerName) + `, ` + grant.Access.String() + `)
+                <form action="/grants/` + strconv.Itoa(grant.ID) + `/revoke" method="post" style="display:inline">
+                    <button type="submit">Ne plus voir</button>
+                </form>
+            </li>`
+	}
+	return htmlContent + `
+        </ul>
+    </div>`
+}
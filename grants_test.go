@@ -0,0 +1,235 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdh"
+	"crypto/rand"
+	"database/sql/driver"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/sessions"
+	"github.com/labstack/echo-contrib/session"
+	"github.com/labstack/echo/v4"
+)
+
+// sharedFolderVault prépare le dossier 1 de l'utilisateur 1, partagé avec l'utilisateur 2 au niveau access.
+// La clé de l'utilisateur 2 est en mémoire et sa session est ouverte ; celle du propriétaire ne l'est pas.
+type sharedFolderVault struct {
+	tables   *fakeTables
+	server   *Server
+	ownerKey []byte
+	grantKey []byte
+	token    []byte
+}
+
+func newSharedFolderVault(t *testing.T, access Access) *sharedFolderVault {
+	v := &sharedFolderVault{}
+	var err error
+	for _, key := range []*[]byte{&v.ownerKey, &v.grantKey, &v.token} {
+		if *key, err = randomBytes(keySize); err != nil {
+			t.Fatal(err)
+		}
+	}
+	granteeKey, err := randomBytes(keySize)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Paire de clés de partage du bénéficiaire et clé de l'accès, chiffrée pour chacun des deux utilisateurs
+	private, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealedPrivate, err := sealKey(granteeKey, private.Bytes(), userPrivateKeyAD(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ownerCopy, err := sealKey(v.ownerKey, v.grantKey, grantOwnerAD(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	granteeCopy, err := sealForUser(private.PublicKey().Bytes(), v.grantKey, grantGranteeAD(1, 2))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	grant := map[string]driver.Value{}
+	values := []driver.Value{int64(5), int64(1), int64(2), nil, nil, int64(1), int64(access), ownerCopy, granteeCopy, nil, "parent", "enfant", "Maison"}
+	for i, column := range selectColumns("SELECT " + grantColumns + " FROM grants") {
+		grant[column] = values[i]
+	}
+	v.tables = &fakeTables{tables: map[string][]map[string]driver.Value{
+		"users": {
+			{"id": int64(1), "username": "parent", "role": "utilisateur", "storage_used": int64(0), "storage_quota": nil},
+			{"id": int64(2), "username": "enfant", "role": "utilisateur", "storage_used": int64(0), "storage_quota": nil},
+		},
+		"folders":      {{"id": int64(1), "user_id": int64(1), "folder_name": "Maison", "parent_folder_id": nil}},
+		"grants":       {grant},
+		"user_keys":    {{"public_key": private.PublicKey().Bytes(), "private_key": sealedPrivate}},
+		"session_keys": {{"id": sessionKeyID(v.token), "wrapped_key": []byte("copie")}},
+		"blobs":        {{"wrapped_key": nil, "ref_count": int64(0), "stored": false}},
+	}}
+
+	db := v.tables.open()
+	s := &Server{db: db, blobs: &LocalStore{dir: t.TempDir()}, blobRefs: &BlobRepo{db: db}, users: &UserRepo{db: db},
+		notes: &NoteRepo{db: db}, files: &FileRepo{db: db}, folders: &FolderRepo{db: db}, grants: &GrantRepo{db: db}}
+	s.authz = &Authorizer{notes: s.notes, files: s.files, folders: s.folders, grants: s.grants}
+	s.keys = &KeyManager{keys: &UserKeyRepo{db: db}, sessions: &SessionKeyRepo{db: db}, ring: NewKeyring()}
+	s.keys.ring.Put(2, granteeKey)
+	v.server = s
+	return v
+}
+
+// Fonction pour préparer la requête du bénéficiaire, avec sa session ouverte
+func (v *sharedFolderVault) context(t *testing.T, req *http.Request) (echo.Context, *httptest.ResponseRecorder) {
+	rec := httptest.NewRecorder()
+	c := echo.New().NewContext(req, rec)
+	c.Set("_session_store", sessions.NewCookieStore([]byte("secret-de-test")))
+	sess, err := session.Get("session", c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sess.Values[sessionTokenKey] = v.token
+	c.Set(userIDContextKey, 2)
+	return c, rec
+}
+
+// Fonction pour construire l'envoi d'un fichier dans le dossier partagé
+func uploadRequest(t *testing.T, name, content string) *http.Request {
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	form.WriteField("folder_id", "1")
+	part, err := form.CreateFormFile("file", name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	part.Write([]byte(content))
+	form.Close()
+	req := httptest.NewRequest(http.MethodPost, "/upload-file?folder_id=1", &body)
+	req.Header.Set(echo.HeaderContentType, form.FormDataContentType())
+	return req
+}
+
+func TestWriteGranteeUploadsIntoSharedFolder(t *testing.T) {
+	v := newSharedFolderVault(t, AccessWrite)
+	c, rec := v.context(t, uploadRequest(t, "facture.txt", "Facture d'électricité de janvier"))
+	if err := v.server.uploadFilePostHandler(c); err != nil {
+		t.Fatal(err)
+	}
+	if rec.Code != http.StatusSeeOther || rec.Header().Get("Location") != "/shared/folders/1" {
+		t.Fatalf("statut = %d, Location = %q (%s)", rec.Code, rec.Header().Get("Location"), rec.Body.String())
+	}
+
+	// Le fichier appartient au propriétaire du dossier et compte dans son quota
+	args, ok := v.tables.execWith("INSERT INTO files")
+	if !ok {
+		t.Fatalf("fichier non enregistré : %q", v.tables.execs)
+	}
+	if args[0] != int64(1) || args[6] != int64(1) {
+		t.Fatalf("propriétaire = %v, dossier = %v, attendus 1 et 1", args[0], args[6])
+	}
+	if reserve, ok := v.tables.execWith("UPDATE users SET storage_used = storage_used +"); !ok || reserve[1] != int64(1) {
+		t.Fatalf("réservation du quota = %v", reserve)
+	}
+
+	// Le propriétaire ouvre la clé déposée avec sa seule clé et lit le contenu
+	storageKey, wrapped := args[2].(string), args[3].([]byte)
+	dataKey, err := openFileKey(v.ownerKey, wrapped, 1)
+	if err != nil {
+		t.Fatalf("clé déposée illisible pour le propriétaire : %v", err)
+	}
+	content, err := openContent(context.Background(), v.server.blobs, storageKey, dataKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer content.Close()
+	if data, _ := io.ReadAll(content); string(data) != "Facture d'électricité de janvier" {
+		t.Fatalf("contenu = %q", data)
+	}
+
+	// Le déposant garde la clé sous son accès pour relire le fichier
+	kept, ok := v.tables.execWith("INSERT IGNORE INTO grant_keys")
+	if !ok || kept[0] != int64(5) {
+		t.Fatalf("clé non confiée à l'accès : %v", kept)
+	}
+	hash := grantKeyHash(wrapped)
+	if !bytes.Equal(kept[1].([]byte), hash) {
+		t.Fatal("empreinte de la clé confiée différente de celle du fichier")
+	}
+	if granted, err := openKey(v.grantKey, kept[2].([]byte), grantContentAD(hash)); err != nil || !bytes.Equal(granted, dataKey) {
+		t.Fatalf("clé confiée à l'accès illisible : %v", err)
+	}
+}
+
+func TestWriteGranteeCreatesNoteInSharedFolder(t *testing.T) {
+	v := newSharedFolderVault(t, AccessWrite)
+	form := url.Values{"folder_id": {"1"}, "title": {"Courses"}, "content": {"Pain, lait"}}
+	req := httptest.NewRequest(http.MethodPost, "/create-note", strings.NewReader(form.Encode()))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationForm)
+	c, rec := v.context(t, req)
+	if err := v.server.createNotePostHandler(c); err != nil {
+		t.Fatal(err)
+	}
+	if rec.Code != http.StatusOK {
+		t.Fatalf("statut = %d (%s)", rec.Code, rec.Body.String())
+	}
+
+	args, ok := v.tables.execWith("INSERT INTO notes")
+	if !ok {
+		t.Fatalf("note non enregistrée : %q", v.tables.execs)
+	}
+	note := Note{UserID: int(args[0].(int64)), WrappedKey: args[1].([]byte), SealedTitle: args[2].([]byte), SealedContent: args[3].([]byte)}
+	if note.UserID != 1 {
+		t.Fatalf("propriétaire = %d, attendu 1", note.UserID)
+	}
+	if err := openNote(v.ownerKey, &note); err != nil || note.Title != "Courses" || note.Content != "Pain, lait" {
+		t.Fatalf("note illisible pour le propriétaire : %v (%q, %q)", err, note.Title, note.Content)
+	}
+	if _, ok := v.tables.execWith("INSERT IGNORE INTO grant_keys"); !ok {
+		t.Fatal("clé de la note non confiée à l'accès")
+	}
+}
+
+func TestReadGranteeCannotAddToSharedFolder(t *testing.T) {
+	cases := []struct {
+		name    string
+		request func(t *testing.T) *http.Request
+		handler func(s *Server) echo.HandlerFunc
+	}{
+		{"fichier", func(t *testing.T) *http.Request {
+			return uploadRequest(t, "facture.txt", "Facture")
+		}, func(s *Server) echo.HandlerFunc { return s.uploadFilePostHandler }},
+		{"note", func(t *testing.T) *http.Request {
+			form := url.Values{"folder_id": {"1"}, "title": {"Courses"}, "content": {"Pain"}}
+			req := httptest.NewRequest(http.MethodPost, "/create-note", strings.NewReader(form.Encode()))
+			req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationForm)
+			return req
+		}, func(s *Server) echo.HandlerFunc { return s.createNotePostHandler }},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			v := newSharedFolderVault(t, AccessRead)
+			c, rec := v.context(t, tc.request(t))
+			logs := captureLog(t)
+			if err := tc.handler(v.server)(c); err != nil {
+				t.Fatal(err)
+			}
+			if rec.Code != http.StatusNotFound {
+				t.Fatalf("statut = %d, attendu 404 (%s)", rec.Code, rec.Body.String())
+			}
+			if !strings.Contains(logs.String(), "Accès refusé : utilisateur 2, dossier 1") {
+				t.Fatalf("refus non journalisé : %q", logs.String())
+			}
+			if len(v.tables.execs) != 0 {
+				t.Fatalf("écritures inattendues : %q", v.tables.execs)
+			}
+		})
+	}
+}
@@ -0,0 +1,275 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/sessions"
+	"github.com/labstack/echo-contrib/session"
+	"github.com/labstack/echo/v4"
+	"golang.org/x/crypto/argon2"
+)
+
+// Erreur renvoyée quand la clé de l'utilisateur n'est pas disponible (session antérieure au chiffrement, serveur redémarré...)
+var errKeyringLocked = errors.New("clé de chiffrement indisponible, reconnectez-vous")
+
+// Paramètres Argon2id de dérivation du mot de passe (enregistrés avec chaque clé pour pouvoir évoluer)
+const (
+	kdfTime    = 3
+	kdfMemory  = 64 * 1024
+	kdfThreads = 4
+	kdfSaltLen = 16
+)
+
+// Clé de la session contenant le jeton qui donne accès à la copie de la clé utilisateur gardée côté serveur
+const sessionTokenKey = "keyToken"
+
+// Durée de vie de la copie de la clé d'une session dont le cookie n'a pas de durée (30 jours, comme les cookies par défaut)
+const sessionKeyLifetime = 30 * 24 * time.Hour
+
+// Keyring garde en mémoire les clés des utilisateurs connectés
+type Keyring struct {
+	mu   sync.RWMutex
+	keys map[int][]byte
+}
+
+// Fonction pour créer un trousseau vide
+func NewKeyring() *Keyring {
+	return &Keyring{keys: make(map[int][]byte)}
+}
+
+// Fonction pour récupérer la clé d'un utilisateur
+func (k *Keyring) Get(userID int) ([]byte, bool) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	key, ok := k.keys[userID]
+	return key, ok
+}
+
+// Fonction pour ajouter la clé d'un utilisateur
+func (k *Keyring) Put(userID int, key []byte) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.keys[userID] = key
+}
+
+// Fonction pour oublier la clé d'un utilisateur (déconnexion, suppression du compte)
+func (k *Keyring) Forget(userID int) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	delete(k.keys, userID)
+}
+
+// Fonction pour obtenir une copie des clés des utilisateurs connectés (traitements d'arrière-plan)
+func (k *Keyring) Unlocked() map[int][]byte {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	keys := make(map[int][]byte, len(k.keys))
+	for userID, key := range k.keys {
+		keys[userID] = key
+	}
+	return keys
+}
+
+// KeyManager gère la hiérarchie de clés : clé maîtresse -> clé utilisateur (protégée par le mot de passe) -> clés de données
+type KeyManager struct {
+	provider KeyProvider
+	keys     *UserKeyRepo
+	sessions *SessionKeyRepo // Copies de la clé utilisateur des sessions ouvertes
+	ring     *Keyring
+}
+
+// Données associées qui lient une clé protégée à son utilisateur
+func userKeyAD(userID int) []byte {
+	return []byte(fmt.Sprintf("coffrefort:user-key:%d", userID))
+}
+
+// Données associées de la copie de la clé utilisateur gardée pour une session
+func sessionKeyAD(userID int) []byte {
+	return []byte(fmt.Sprintf("coffrefort:session-key:%d", userID))
+}
+
+// Fonction pour protéger une clé utilisateur avec un mot de passe puis avec la clé maîtresse
+func (m *KeyManager) protect(ctx context.Context, userID int, password string, userKey []byte) (UserKeyRecord, error) {
+	salt, err := randomBytes(kdfSaltLen)
+	if err != nil {
+		return UserKeyRecord{}, err
+	}
+	rec := UserKeyRecord{UserID: userID, Salt: salt, KDFTime: kdfTime, KDFMemory: kdfMemory, KDFThreads: kdfThreads}
+
+	kek := argon2.IDKey([]byte(password), rec.Salt, rec.KDFTime, rec.KDFMemory, rec.KDFThreads, keySize)
+	inner, err := sealKey(kek, userKey, userKeyAD(userID))
+	if err != nil {
+		return rec, err
+	}
+	rec.WrappedKey, err = m.provider.Wrap(ctx, inner, userKeyAD(userID))
+	return rec, err
+}
+
+// Fonction pour retrouver une clé utilisateur à partir du mot de passe
+func (m *KeyManager) unprotect(ctx context.Context, rec UserKeyRecord, password string) ([]byte, error) {
+	inner, err := m.provider.Unwrap(ctx, rec.WrappedKey, userKeyAD(rec.UserID))
+	if err != nil {
+		return nil, err
+	}
+	kek := argon2.IDKey([]byte(password), rec.Salt, rec.KDFTime, rec.KDFMemory, rec.KDFThreads, keySize)
+	return openKey(kek, inner, userKeyAD(rec.UserID))
+}
+
+// Fonction pour déverrouiller la clé d'un utilisateur à la connexion ; la clé est créée au premier passage
+func (m *KeyManager) Unlock(ctx context.Context, userID int, password string) ([]byte, error) {
+	rec, err := m.keys.Get(ctx, userID)
+	if errors.Is(err, errUserKeyNotFound) {
+		return m.Create(ctx, userID, password)
+	}
+	if err != nil {
+		return nil, err
+	}
+	userKey, err := m.unprotect(ctx, rec, password)
+	if err != nil {
+		return nil, err
+	}
+	m.ring.Put(userID, userKey)
+	return userKey, nil
+}
+
+// Fonction pour créer la clé d'un utilisateur (inscription ou première connexion après la mise en place du chiffrement)
+func (m *KeyManager) Create(ctx context.Context, userID int, password string) ([]byte, error) {
+	userKey, err := randomBytes(keySize)
+	if err != nil {
+		return nil, err
+	}
+	rec, err := m.protect(ctx, userID, password, userKey)
+	if err != nil {
+		return nil, err
+	}
+	if err := m.keys.Create(ctx, rec); err != nil {
+		return nil, err
+	}
+	m.ring.Put(userID, userKey)
+	return userKey, nil
+}
+
+// Fonction pour protéger à nouveau la clé avec un nouveau mot de passe.
+// Seule la clé utilisateur change de protection : les clés de données et les fichiers ne sont pas touchés.
+func (m *KeyManager) Rewrap(ctx context.Context, userID int, oldPassword, newPassword string) (UserKeyRecord, error) {
+	rec, err := m.keys.Get(ctx, userID)
+	if err != nil {
+		return rec, err
+	}
+	userKey, err := m.unprotect(ctx, rec, oldPassword)
+	if err != nil {
+		return rec, err
+	}
+	return m.protect(ctx, userID, newPassword, userKey)
+}
+
+// Une session ouverte garde une copie de la clé utilisateur pour survivre à un redémarrage du serveur.
+// Le cookie (signé mais non chiffré) ne contient qu'un jeton aléatoire ; la copie est en base, chiffrée par
+// une clé dérivée de ce jeton puis par la clé maîtresse, et retrouvée par l'empreinte du jeton. Il faut donc
+// à la fois le cookie, la base et la clé maîtresse pour la retrouver sans le mot de passe : un cookie volé seul
+// ne suffit pas, la base et la clé maîtresse seules non plus. Supprimer la ligne (déconnexion, changement
+// de mot de passe, expiration) rend le cookie inutilisable même s'il a été copié.
+
+// Fonction pour calculer l'identifiant en base d'un jeton de session (son empreinte)
+func sessionKeyID(token []byte) []byte {
+	sum := sha256.Sum256(token)
+	return sum[:]
+}
+
+// Fonction pour dériver du jeton de session la clé qui chiffre la copie de la clé utilisateur
+func sessionTokenKEK(token []byte) []byte {
+	return hmacSHA256(token, "coffrefort:session-kek")
+}
+
+// Fonction pour ouvrir une session : la copie de la clé utilisateur est enregistrée en base et seul le jeton
+// qui la protège est placé dans la session. Une copie précédente de la même session est supprimée.
+func (m *KeyManager) StartSession(ctx context.Context, sess *sessions.Session, userID int, userKey []byte) error {
+	m.EndSession(ctx, sess)
+	if err := m.sessions.DeleteExpired(ctx, time.Now()); err != nil {
+		log.Println("Erreur lors de la purge des sessions expirées :", err)
+	}
+
+	token, err := randomBytes(keySize)
+	if err != nil {
+		return err
+	}
+	inner, err := sealKey(sessionTokenKEK(token), userKey, sessionKeyAD(userID))
+	if err != nil {
+		return err
+	}
+	wrapped, err := m.provider.Wrap(ctx, inner, sessionKeyAD(userID))
+	if err != nil {
+		return err
+	}
+	lifetime := sessionKeyLifetime
+	if sess.Options != nil && sess.Options.MaxAge > 0 {
+		lifetime = time.Duration(sess.Options.MaxAge) * time.Second
+	}
+	if err := m.sessions.Create(ctx, sessionKeyID(token), userID, wrapped, time.Now().Add(lifetime)); err != nil {
+		return err
+	}
+	sess.Values[sessionTokenKey] = token
+	return nil
+}
+
+// Fonction pour fermer une session : la copie de la clé utilisateur est supprimée de la base
+func (m *KeyManager) EndSession(ctx context.Context, sess *sessions.Session) {
+	token, ok := sess.Values[sessionTokenKey].([]byte)
+	if !ok {
+		return
+	}
+	delete(sess.Values, sessionTokenKey)
+	if err := m.sessions.Delete(ctx, sessionKeyID(token)); err != nil {
+		log.Println("Erreur lors de la suppression de la clé de session :", err)
+	}
+}
+
+// Fonction pour récupérer la clé de l'utilisateur connecté. La session doit avoir une copie valide en base
+// (sinon elle a été fermée ou a expiré) ; la clé vient du trousseau en mémoire, ou à défaut de cette copie.
+func (m *KeyManager) ForRequest(c echo.Context, userID int) ([]byte, error) {
+	sess, err := session.Get("session", c)
+	if err != nil {
+		return nil, errKeyringLocked
+	}
+	token, ok := sess.Values[sessionTokenKey].([]byte)
+	if !ok {
+		return nil, errKeyringLocked
+	}
+	ctx := c.Request().Context()
+	wrapped, err := m.sessions.Get(ctx, sessionKeyID(token), userID, time.Now())
+	if errors.Is(err, errSessionKeyNotFound) {
+		return nil, errKeyringLocked
+	}
+	if err != nil {
+		return nil, err
+	}
+	if key, ok := m.ring.Get(userID); ok {
+		return key, nil
+	}
+
+	inner, err := m.provider.Unwrap(ctx, wrapped, sessionKeyAD(userID))
+	if err != nil {
+		return nil, errKeyringLocked
+	}
+	userKey, err := openKey(sessionTokenKEK(token), inner, sessionKeyAD(userID))
+	if err != nil {
+		return nil, errKeyringLocked
+	}
+	m.ring.Put(userID, userKey)
+	return userKey, nil
+}
+
+// Fonction pour répondre quand la clé de l'utilisateur n'est pas disponible
+func keyringLockedResponse(c echo.Context) error {
+	if c.Request().Method == http.MethodGet {
+		return c.Redirect(http.StatusSeeOther, "/login")
+	}
+	return c.JSON(http.StatusUnauthorized, map[string]string{"message": errKeyringLocked.Error()})
+}
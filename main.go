@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 	"fmt"
@@ -8,14 +9,13 @@ import (
 	"io"
 	"io/ioutil"
 	"log"
+	"mime"
 	"mime/multipart"
 	"net/http"
-	"net/url"
 	"os"
-	"path/filepath"
 	"strconv"
-	"time"
 	"strings"
+	"time"
 
 	"github.com/gorilla/sessions"
 	"github.com/labstack/echo-contrib/session"
@@ -27,136 +27,102 @@ import (
 	_ "github.com/go-sql-driver/mysql"
 )
 
-var db *sql.DB
-
 // Fonction principale
 func main() {
-	// Créer une instance d'Echo
-	e := echo.New()
-
-	// Utiliser le middleware pour les sessions
-	e.Use(session.Middleware(sessions.NewCookieStore([]byte("secret"))))
-
-	// Middleware
-	e.Use(middleware.Logger())
-	e.Use(middleware.Recover())
-
-	// Connexion à MySQL
-	db, err := sql.Open("mysql", "root:root@tcp(localhost:3306)/")
-    if err != nil {
-        log.Fatal(err)
-    }
-    defer db.Close()
-
-    // Assurez-vous que la connexion est établie
-    err = db.Ping()
-    if err != nil {
-        log.Fatal(err)
-    }
-
-    // Créer la base de données CoffreFortDb si elle n'existe pas
-    _, err = db.Exec("CREATE DATABASE IF NOT EXISTS CoffreFortDb")
-    if err != nil {
-        log.Fatal("Failed to create database:", err)
-    }
-
-    // Utiliser la base de données CoffreFortDb
-    _, err = db.Exec("USE CoffreFortDb")
-    if err != nil {
-        log.Fatal("Failed to select database:", err)
-    }
+	// Charger la configuration : fichier, puis variables d'environnement, puis options
+	cfg, args, err := LoadConfig(os.Args[1:])
+	if err != nil {
+		log.Fatal(err)
+	}
 
-    // Lire le fichier SQL
-    sqlFile, err := ioutil.ReadFile("sauvergarde.sql")
-    if err != nil {
-        log.Fatal(err)
-    }
-    sqlCommands := string(sqlFile)
-
-    // Exécuter chaque commande SQL du fichier
-    commands := strings.Split(sqlCommands, ";")
-    for _, command := range commands {
-        if strings.TrimSpace(command) == "" {
-            continue
-        }
-        _, err := db.Exec(command)
-        if err != nil {
-            fmt.Println("FAILED to execute command:", command)
-            log.Println(err)
-        }
-    }
+	// Commande `config dump` : afficher la configuration effective sans les secrets
+	if len(args) > 0 && args[0] == "config" {
+		if err := runConfigCommand(cfg, args[1:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	if err := cfg.Validate(); err != nil {
+		log.Fatal(err)
+	}
 
-    fmt.Println("Database has been populated successfully.")
+	// Créer la base de données si elle n'existe pas
+	err = createDatabase(cfg.Database.DSN, cfg.Database.Name)
+	if err != nil {
+		log.Fatal("Failed to create database:", err)
+	}
 
-	// Vérifier si l'utilisateur admin existe déjà
-	var count int
-	err = db.QueryRow("SELECT COUNT(*) FROM users WHERE username = ?", "admin").Scan(&count)
+	// Connexion à MySQL : un seul pool partagé par toute l'application
+	ctx := context.Background()
+	dsn, err := cfg.Database.DatabaseDSN()
 	if err != nil {
 		log.Fatal(err)
 	}
+	db, err := openDatabase(ctx, dsn, cfg.Database)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.Close()
+	fmt.Println("Connected to MySQL!")
 
-	// Si l'utilisateur admin n'existe pas, le créer
-	if count == 0 {
-		// Hacher le mot de passe avec bcrypt
-		hashedPassword, err := bcrypt.GenerateFromPassword([]byte("cle"), bcrypt.DefaultCost)
-		if err != nil {
+	// Commande `migrate status|up|down [n]` : gérer le schéma sans démarrer le serveur
+	if len(args) > 0 && args[0] == "migrate" {
+		if err := runMigrateCommand(ctx, db, cfg, args[1:]); err != nil {
 			log.Fatal(err)
 		}
+		return
+	}
 
-		// Exécuter la requête d'insertion pour créer un nouvel utilisateur admin avec le mot de passe haché
-		_, err = db.Exec("INSERT INTO users (username, password, role) VALUES (?, ?, ?)", "admin", hashedPassword, "admin")
+	// Appliquer les migrations en attente avant d'accepter des requêtes
+	if cfg.Database.AutoMigrate {
+		migrator, err := NewMigrator(db, cfg)
 		if err != nil {
 			log.Fatal(err)
 		}
+		applied, err := migrator.Up(ctx)
+		if err != nil {
+			log.Fatal("Failed to migrate database:", err)
+		}
+		fmt.Printf("Database schema is up to date (%d migration(s) applied).\n", applied)
 	}
 
-	// Test de la connexion à MySQL
-	err = db.Ping()
-	if err != nil {
-		log.Fatal(err)
+	// Commandes `storage reconcile|migrate|gc|scrub` : maintenance du stockage des contenus
+	if len(args) > 0 && args[0] == "storage" {
+		if err := runStorageCommand(ctx, db, cfg, args[1:]); err != nil {
+			log.Fatal(err)
+		}
+		return
 	}
-	fmt.Println("Connected to MySQL!")
 
-	// Routes
-	e.GET("/", homeHandler)
-	e.GET("/users", listUsersHandler)   // Afficher tous les utilisateurs
-	e.GET("/register", registerHandler) // Page d'inscription (affichage du formulaire)
-	e.POST("/register", registerPostHandler)
-	e.GET("/delete", deleteFormHandler) // Afficher le formulaire de suppression
-	e.POST("/delete", deleteHandler)    // Supprimer un utilisateur
-	e.GET("/login", loginHandler)       // Page de connexion
-	e.POST("/login", loginPostHandler)  // Traitement du formulaire de connexion
-	e.POST("/logout", logoutHandler)    // Déconnexion de l'utilisateur
-	e.GET("/welcome", welcomeHandler)
-	e.GET("/create-note", createNoteHandler)      // Afficher le formulaire pour créer une note
-	e.POST("/create-note", createNotePostHandler) // Traitement du formulaire pour créer une note
-	e.GET("/upload-file", uploadFileHandler)      // Afficher le formulaire pour déposer un fichier
-	e.POST("/upload-file", uploadFilePostHandler) // Traitement du formulaire pour déposer un fichier
-	// Route pour visualiser le contenu du fichier
-	e.GET("/view-file/:fileName", viewFileHandler)
-	e.DELETE("/files/:id", deleteFileHandler)
-	// Route pour supprimer un fichier
-	e.POST("/delete-file/:fileName", deleteFileHandler)
-	// Route pour la suppression du compte utilisateur
-	e.POST("/delete-account", deleteAccountHandler)
-	// Route pour afficher la page de confirmation de suppression de compte
-	e.GET("/goodbye", func(c echo.Context) error {
-		return c.File("goodbye.html")
-	})
-	// Ajoutez cette ligne dans votre fonction main() pour configurer la gestion de la route DELETE
-	e.DELETE("/delete-account", deleteAccountHandler)
+	// Créer une instance d'Echo
+	e := echo.New()
+
+	// Utiliser le middleware pour les sessions
+	e.Use(session.Middleware(sessions.NewCookieStore([]byte(cfg.Session.Secret))))
+
+	// Middleware
+	e.Use(middleware.Logger())
+	e.Use(middleware.Recover())
 
-	e.POST("/delete-note/:id", deleteNoteHandler)
-	e.POST("/upload-file", uploadFileHandler)
+	// Routes
+	server, err := NewServer(db, cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+	server.routes(e)
+	go server.runUploadJanitor(ctx)
+	go server.runTrashJanitor(ctx)
+	go server.backfillStorageSizes(ctx)
+	go server.runScrubJanitor(ctx)
+	go server.runScanJanitor(ctx)
 
 	// Démarrage du serveur
-	e.Start(":8081")
+	e.Start(cfg.ListenAddr)
 }
 
 // Fonction pour gérer la page d'accueil et la page d'accueil de l'administrateur
-func welcomeHandler(c echo.Context) error {
-	db, err := sql.Open("mysql", "root:root@tcp(localhost:3306)/CoffreFortDb")
-
+func (s *Server) welcomeHandler(c echo.Context) error {
+	ctx := c.Request().Context()
 	sess, _ := session.Get("session", c)
 	username, ok := sess.Values["username"].(string)
 	if !ok {
@@ -176,52 +142,60 @@ func welcomeHandler(c echo.Context) error {
 		return c.Redirect(http.StatusSeeOther, "/login")
 	}
 
-	rows, err := db.Query("SELECT id, title, content FROM notes WHERE user_id = ?", userID)
+	// Dossier courant (racine du coffre si absent)
+	currentFolder, err := parseFolderID(c.QueryParam("folder"))
 	if err != nil {
-		log.Println("Erreur lors de la récupération des notes :", err)
+		return c.Redirect(http.StatusSeeOther, "/welcome")
+	}
+	breadcrumbs, err := s.folders.Breadcrumbs(ctx, userID, currentFolder)
+	if err != nil {
+		log.Println("Erreur lors de la récupération du dossier courant :", err)
+		return c.Redirect(http.StatusSeeOther, "/welcome")
+	}
+	subfolders, err := s.folders.ListChildren(ctx, userID, currentFolder)
+	if err != nil {
+		log.Println("Erreur lors de la récupération des dossiers :", err)
 		return err
 	}
-	defer rows.Close()
-
-	fileRows, err := db.Query("SELECT filename FROM files WHERE user_id = ?", userID)
+	allFolders, err := s.folders.ListAll(ctx, userID)
 	if err != nil {
-		log.Println("Erreur lors de la récupération des fichiers de l'utilisateur :", err)
+		log.Println("Erreur lors de la récupération des dossiers :", err)
 		return err
 	}
-	defer fileRows.Close()
+	folderOptions := renderFolderOptions(allFolders)
 
-	type Note struct {
-		ID      int
-		Title   string
-		Content string
+	notes, err := s.notes.ListByFolder(ctx, userID, currentFolder)
+	if err != nil {
+		log.Println("Erreur lors de la récupération des notes :", err)
+		return err
 	}
 
-	var notes []Note
-	for rows.Next() {
-		var note Note
-		err := rows.Scan(&note.ID, &note.Title, &note.Content)
-		if err != nil {
-			log.Println("Erreur lors de la lecture des résultats de la requête des notes :", err)
-			return err
-		}
-		notes = append(notes, note)
+	// Tri et filtre de la liste des fichiers, d'après les métadonnées relevées à l'envoi
+	listOptions := parseFileListOptions(c)
+	files, err := s.files.ListByFolder(ctx, userID, currentFolder, listOptions)
+	if err != nil {
+		log.Println("Erreur lors de la récupération des fichiers de l'utilisateur :", err)
+		return err
 	}
 
-	var files []string
-	for fileRows.Next() {
-		var fileName string
-		err := fileRows.Scan(&fileName)
-		if err != nil {
-			log.Println("Erreur lors de la lecture des résultats de la requête des fichiers :", err)
-			return err
+	// Déchiffrer les notes avec la clé de l'utilisateur ; sans clé, seules les anciennes notes en clair sont lisibles
+	userKey, keyErr := s.keys.ForRequest(c, userID)
+	for i := range notes {
+		if notes[i].WrappedKey == nil {
+			continue
+		}
+		if keyErr != nil {
+			notes[i].Title, notes[i].Content = "Note chiffrée", errKeyringLocked.Error()
+		} else if err := openNote(userKey, &notes[i]); err != nil {
+			log.Printf("Erreur lors du déchiffrement de la note %d : %v", notes[i].ID, err)
+			notes[i].Title, notes[i].Content = "Note illisible", "Le déchiffrement de la note a échoué."
 		}
-		files = append(files, fileName)
 	}
 
 	var notesHTML string
 	for _, note := range notes {
-		notesHTML += "<div>"
-		notesHTML += "<span><strong>" + note.Title + "</strong><br>" + note.Content + "</span>"
+		notesHTML += `<div id="note-` + strconv.Itoa(note.ID) + `">`
+		notesHTML += "<span><strong>" + template.HTMLEscapeString(note.Title) + "</strong><br>" + template.HTMLEscapeString(note.Content) + "</span>"
 		notesHTML += `<button class="bin-button" onclick="deleteNote(` + strconv.Itoa(note.ID) + `)">
         <svg class="bin-top" viewBox="0 0 39 7" fill="none" xmlns="http://www.w3.org/2000/svg">
             <line y1="5" x2="39" y2="5" stroke="white" stroke-width="4"></line>
@@ -236,15 +210,46 @@ func welcomeHandler(c echo.Context) error {
             <path d="M21 6V29" stroke="white" stroke-width="4"></path>
         </svg>
     </button>`
+		notesHTML += `<select onchange="moveItem('note', ` + strconv.Itoa(note.ID) + `, this.value)"><option value="" selected disabled>Déplacer vers…</option>` + folderOptions + `</select>`
+		notesHTML += `<a href="/grants/new?note=` + strconv.Itoa(note.ID) + `">Accès</a>`
 		notesHTML += "</div>"
 	}
 
-	var filesHTML string
-	for _, fileName := range files {
-		filesHTML += `<div>
-        <span>` + fileName + `</span>
-        <button onclick="deleteFile('` + fileName + `')">Supprimer</button>
-        <button class="open-file" onclick="window.open('/view-file/` + fileName + `', '_blank')">
+	// Affichage des fichiers en liste ou en grille de vignettes, mémorisé dans la session
+	grid, _ := sess.Values["fileGrid"].(bool)
+	if view := c.QueryParam("view"); view != "" {
+		grid = view == "grid"
+		sess.Values["fileGrid"] = grid
+		sess.Save(c.Request(), c.Response())
+	}
+
+	filesHTML := renderFileListControls(currentFolder, listOptions, grid) + renderArchiveForm(currentFolder)
+	if grid {
+		filesHTML += `<div class="file-grid">`
+		for _, file := range files {
+			fileID := strconv.Itoa(file.ID)
+			filesHTML += `<div class="file-card">
+        ` + renderArchiveCheckbox(fileID) + `
+        <a href="/view-file/` + fileID + `" target="_blank"><img src="/files/` + fileID + `/thumbnail" alt="" loading="lazy" width="160" height="160"></a>
+        <span>` + template.HTMLEscapeString(file.FileName) + `</span>
+        ` + renderFileDetails(file) + `
+        <button onclick="deleteFile(` + fileID + `)">Supprimer</button>
+        <a href="/files/` + fileID + `/versions">Versions</a>
+        <a href="/shares/new?file=` + fileID + `">Partager</a>
+        <a href="/grants/new?file=` + fileID + `">Accès</a>
+        <select onchange="moveItem('file', ` + fileID + `, this.value)"><option value="" selected disabled>Déplacer vers…</option>` + folderOptions + `</select>
+    </div>`
+		}
+		filesHTML += `</div>`
+	} else {
+		for _, file := range files {
+			fileID := strconv.Itoa(file.ID)
+			filesHTML += `<div>
+        ` + renderArchiveCheckbox(fileID) + `
+        <span>` + template.HTMLEscapeString(file.FileName) + `</span>
+        ` + renderFileDetails(file) + `
+        <button onclick="deleteFile(` + fileID + `)">Supprimer</button>
+        <button class="open-file" onclick="window.open('/view-file/` + fileID + `', '_blank')">
             <span class="file-wrapper">
                 <svg xmlns="http://www.w3.org/2000/svg" fill="none" viewBox="0 0 71 67">
                     <path stroke-width="5" stroke="black" d="M41.7322 11.7678L42.4645 12.5H43.5H68.5V64.5H2.5V2.5H32.4645L41.7322 11.7678Z"></path>
@@ -253,11 +258,16 @@ func welcomeHandler(c echo.Context) error {
             </span>
             Open file
         </button>
+        <a href="/files/` + fileID + `/versions">Versions</a>
+        <a href="/shares/new?file=` + fileID + `">Partager</a>
+        <a href="/grants/new?file=` + fileID + `">Accès</a>
+        <select onchange="moveItem('file', ` + fileID + `, this.value)"><option value="" selected disabled>Déplacer vers…</option>` + folderOptions + `</select>
     </div>`
+		}
 	}
 
 	// Formulaire de téléchargement de fichier HTML
-    uploadForm := `
+	uploadForm := `
         <style>
             .file-container {
                 display: flex;
@@ -279,19 +289,61 @@ func welcomeHandler(c echo.Context) error {
         <div class="file-container">
             <h2>Télécharger un fichier :</h2>
             <form action="/upload-file" method="post" enctype="multipart/form-data">
+                <input type="hidden" name="folder_id" value="` + template.HTMLEscapeString(c.QueryParam("folder")) + `">
                 <input type="file" name="file" required><br>
+                <label><input type="checkbox" name="extract" value="1"> Décompresser l'archive (zip, tar.gz) dans un nouveau dossier</label><br>
                 <button type="submit">Télécharger</button>
             </form>
         </div>
     `
 
-
 	htmlContent, err := ioutil.ReadFile("welcome.html")
 	if err != nil {
 		return err
 	}
 
-	responseHTML := fmt.Sprintf(string(htmlContent), username, uploadForm, notesHTML, filesHTML)
+	// Fil d'Ariane et sous-dossiers du dossier courant
+	foldersHTML := renderSearchForm("", "", currentFolder, false, "", "") + renderFolderNavigation(currentFolder, breadcrumbs, subfolders, folderOptions)
+
+	// Barre d'occupation du stockage
+	usage, err := s.users.Storage(ctx, userID)
+	if err != nil {
+		log.Println("Erreur lors de la lecture de l'espace utilisé :", err)
+		return err
+	}
+	usageHTML := renderUsageBar(usage.Used, s.quotas.Limit(usage))
+
+	// Messages non lus (fichier mis en quarantaine par l'antivirus...)
+	notifications, err := s.notifications.ListUnread(ctx, userID)
+	if err != nil {
+		log.Println("Erreur lors de la récupération des messages :", err)
+		return err
+	}
+	usageHTML = renderNotifications(notifications) + usageHTML
+
+	// Liens de partage de l'utilisateur, révocables depuis cette page
+	shares, err := s.shares.ListByUser(ctx, userID)
+	if err != nil {
+		log.Println("Erreur lors de la récupération des liens de partage :", err)
+		return err
+	}
+	filesHTML += renderShares(shares)
+
+	// Accès accordés à d'autres utilisateurs, et section « Partagés avec moi » des accès reçus
+	given, err := s.grants.ListByOwner(ctx, userID)
+	if err != nil {
+		log.Println("Erreur lors de la récupération des accès accordés :", err)
+		return err
+	}
+	filesHTML += renderGrants(given)
+	received, err := s.grants.ListByGrantee(ctx, userID)
+	if err != nil {
+		log.Println("Erreur lors de la récupération des accès reçus :", err)
+		return err
+	}
+	foldersHTML += renderSharedWithMe(received)
+
+	responseHTML := fmt.Sprintf(string(htmlContent), username, usageHTML, uploadForm, foldersHTML, notesHTML, filesHTML)
 
 	// Renvoyer la réponse HTML complète
 	return c.HTML(http.StatusOK, responseHTML)
@@ -316,30 +368,73 @@ func homeHandler(c echo.Context) error {
 }
 
 // Gestionnaire de route pour créer une note
-func createNotePostHandler(c echo.Context) error {
-	db, err := sql.Open("mysql", "root:root@tcp(localhost:3306)/CoffreFortDb")
+func (s *Server) createNotePostHandler(c echo.Context) error {
+	ctx := c.Request().Context()
 	// Récupérer le titre et le contenu de la note à partir du formulaire
 	title := c.FormValue("title")
 	content := c.FormValue("content")
 
-	// Récupérer l'ID de l'utilisateur à partir de la session
-	sess, err := session.Get("session", c)
+	// ID de l'utilisateur connecté (placé dans le contexte par requireUser)
+	userID := currentUserID(c)
+
+	// Dossier dans lequel ranger la note (racine si absent) ; dans un dossier partagé en écriture,
+	// la note appartient au propriétaire du dossier
+	folderID, err := parseFolderID(c.FormValue("folder_id"))
+	ownerID := userID
+	if err == nil {
+		ownerID, err = s.authz.ContentOwner(ctx, userID, folderID)
+	}
 	if err != nil {
-		log.Println("Erreur lors de la récupération de la session :", err)
-		return err
+		return folderErrorResponse(c, err)
 	}
-	userID, ok := sess.Values["userID"].(int)
-	if !ok {
-		log.Println("ID de l'utilisateur introuvable dans la session")
+
+	// Chiffrer la note avec la clé de l'utilisateur : seuls les champs chiffrés sont enregistrés.
+	// Dans le dossier d'un autre, la clé de données est déposée sous l'accès qui permet d'y écrire.
+	userKey, err := s.keys.ForRequest(c, userID)
+	if err != nil {
+		return keyringLockedResponse(c)
+	}
+	note := Note{UserID: ownerID, Title: title, Content: content, FolderID: folderID}
+	var deposit contentDeposit
+	if ownerID == userID {
+		err = sealNote(userKey, &note)
+	} else if deposit, err = s.newDeposit(ctx, userID, userKey, folderID.Int64, noteKeyAD(ownerID)); err == nil {
+		note.WrappedKey = deposit.Wrapped
+		err = sealNoteFields(deposit.DataKey, &note)
+	}
+	if errors.Is(err, errNotVisible) {
+		return folderErrorResponse(c, err)
+	}
+	if err != nil {
+		log.Println("Erreur lors du chiffrement de la note :", err)
 		return err
 	}
 
-	// Insérer la note dans la base de données avec l'ID de l'utilisateur
-	_, err = db.Exec("INSERT INTO notes (user_id, title, content) VALUES (?, ?, ?)", userID, title, content)
+	// Insérer la note dans la base de données avec l'ID de son propriétaire
+	noteID, err := s.notes.Create(ctx, note)
 	if err != nil {
 		log.Println("Erreur lors de l'insertion de la note :", err)
 		return err
 	}
+	note.ID = int(noteID)
+
+	if ownerID == userID {
+		// Indexer la note pour la recherche (une note non indexée le sera à la prochaine connexion)
+		if err := s.indexNote(ctx, userKey, note); err != nil {
+			log.Println("Erreur lors de l'indexation de la note :", err)
+		}
+		// Confier sa clé aux accès du dossier qui la contient
+		go s.refreshGrants(context.Background(), userID, userKey)
+	} else {
+		if err := s.keepDeposit(ctx, deposit); err != nil {
+			log.Println("Erreur lors du dépôt de la clé de la note :", err)
+		}
+		if ownerKey, ok := s.keys.ring.Get(ownerID); ok {
+			if err := s.indexNote(ctx, ownerKey, note); err != nil {
+				log.Println("Erreur lors de l'indexation de la note :", err)
+			}
+		}
+	}
 
 	// Construire une structure de réponse JSON contenant les détails de la note créée
 	response := struct {
@@ -367,109 +462,186 @@ func createNoteHandler(c echo.Context) error {
     `
 	return c.HTML(http.StatusOK, htmlContent)
 }
-func deleteNoteHandler(c echo.Context) error {
-	db, err := sql.Open("mysql", "root:root@tcp(localhost:3306)/CoffreFortDb")
-	noteID := c.Param("id")
 
-	// Supprimer la note correspondante dans la base de données
-	_, err = db.Exec("DELETE FROM notes WHERE id = ?", noteID)
+// Gestionnaire de route pour supprimer une note
+func (s *Server) deleteNoteHandler(c echo.Context) error {
+	ctx := c.Request().Context()
+	noteID, err := parseObjectID(c.Param("id"))
 	if err != nil {
-		log.Println("Erreur lors de la suppression de la note :", err)
-		return c.JSON(http.StatusInternalServerError, map[string]string{"message": "Erreur lors de la suppression de la note"})
+		return accessErrorResponse(c, err, "Erreur lors de la suppression de la note")
+	}
+
+	// Vérifier que l'utilisateur a le droit de supprimer la note
+	note, err := s.authz.Note(ctx, currentUserID(c), noteID, AccessManage)
+	if err != nil {
+		return accessErrorResponse(c, err, "Erreur lors de la suppression de la note")
+	}
+
+	// Mettre la note à la corbeille (elle reste restaurable jusqu'à sa purge)
+	if err := s.trash.Put(ctx, note.UserID, resourceNote, noteID, time.Now()); err != nil {
+		return accessErrorResponse(c, err, "Erreur lors de la suppression de la note")
 	}
 
 	// Répondre avec un code de succès
-	return c.JSON(http.StatusOK, map[string]string{"message": "Note supprimée avec succès"})
+	return c.JSON(http.StatusOK, map[string]string{"message": "Note placée dans la corbeille"})
 }
 
 // Fonction pour gérer le téléchargement de fichiers
-func uploadFilePostHandler(c echo.Context) error {
-	// Vérifier si le dossier "uploads" existe, sinon le créer
-	if _, err := os.Stat("uploads"); os.IsNotExist(err) {
-		err := os.Mkdir("uploads", 0755)
-		if err != nil {
-			log.Println("Erreur lors de la création du dossier 'uploads' :", err)
-			return err
+func (s *Server) uploadFilePostHandler(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	// ID de l'utilisateur connecté (placé dans le contexte par requireUser)
+	userID := currentUserID(c)
+
+	// Le fichier compte dans le quota du propriétaire du dossier de destination. Un dossier partagé est aussi
+	// indiqué dans l'URL pour connaître ce propriétaire avant de lire le formulaire ; il est vérifié plus bas.
+	quotaOwner := userID
+	if folderID, err := parseFolderID(c.QueryParam("folder_id")); err == nil && folderID.Valid {
+		if ownerID, err := s.authz.ContentOwner(ctx, userID, folderID); err == nil {
+			quotaOwner = ownerID
 		}
 	}
 
+	// Refuser dès l'en-tête un envoi qui ne tiendrait pas dans le quota, et ne jamais lire au-delà
+	remaining, limited, err := s.remainingStorage(ctx, quotaOwner)
+	if err != nil {
+		log.Println("Erreur lors de la lecture de l'espace disponible :", err)
+		return err
+	}
+	if limited {
+		if c.Request().ContentLength > remaining+multipartOverhead {
+			return quotaExceededResponse(c)
+		}
+	}
+	// La taille maximale d'un fichier borne aussi la lecture du formulaire, même sans quota
+	maxSize := s.uploadPolicy.MaxSize
+	if maxSize > 0 && c.Request().ContentLength > maxSize+multipartOverhead {
+		return uploadPolicyResponse(c, s.uploadPolicy.tooLarge())
+	}
+	limit := int64(-1)
+	if limited {
+		limit = remaining
+	}
+	if maxSize > 0 && (limit < 0 || maxSize < limit) {
+		limit = maxSize
+	}
+	if limit >= 0 {
+		c.Request().Body = http.MaxBytesReader(c.Response(), c.Request().Body, limit+multipartOverhead)
+	}
+
 	// Récupérer le fichier depuis le formulaire
 	file, err := c.FormFile("file")
 	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			if limited && tooLarge.Limit == remaining+multipartOverhead {
+				return quotaExceededResponse(c)
+			}
+			return uploadPolicyResponse(c, s.uploadPolicy.tooLarge())
+		}
 		log.Println("Erreur lors de la récupération du fichier :", err)
 		return err
 	}
 
-	// Récupérer l'ID de l'utilisateur à partir de la session
-	sess, _ := session.Get("session", c)
-	userID := sess.Values["userID"].(int)
-
-	// Enregistrer le fichier sur le serveur
-	err = SaveFileToFileSystem(file, userID)
+	// Dossier de destination (racine si absent), à l'utilisateur ou partagé avec lui en écriture
+	folderID, err := parseFolderID(c.FormValue("folder_id"))
+	ownerID := userID
+	if err == nil {
+		ownerID, err = s.authz.ContentOwner(ctx, userID, folderID)
+	}
 	if err != nil {
-		log.Println("Erreur lors de l'enregistrement du fichier sur le système de fichiers :", err)
-		return err
+		return folderErrorResponse(c, err)
 	}
 
-	// Enregistrer les détails du fichier dans la base de données
-	uploadedFile := UploadedFile{
-		UserID:     userID,
-		FileName:   file.Filename,
-		FilePath:   "uploads/" + file.Filename,
-		UploadedAt: time.Now(),
+	userKey, err := s.keys.ForRequest(c, userID)
+	if err != nil {
+		return keyringLockedResponse(c)
 	}
-
-	if err := saveUploadedFileToDatabase(uploadedFile); err != nil {
-		log.Println("Erreur lors de l'enregistrement du fichier dans la base de données :", err)
-		return err
+	if ownerID != userID {
+		return s.depositFile(c, userID, userKey, ownerID, file, folderID)
 	}
 
-	// Rediriger vers la page de bienvenue après avoir déposé le fichier
-	return c.Redirect(http.StatusSeeOther, "/welcome")
-}
+	// Une archive peut être décompressée dans un nouveau dossier, chaque entrée devenant un fichier du coffre
+	if c.FormValue("extract") != "" {
+		if kind, err := detectArchive(file); err != nil {
+			log.Println("Erreur lors de la lecture du fichier :", err)
+			return err
+		} else if kind != "" {
+			return s.extractUpload(c, userID, userKey, file, kind, folderID)
+		}
+	}
 
-// Fonction pour enregistrer le fichier sur le système de fichiers
-func SaveFileToFileSystem(file *multipart.FileHeader, userID int) error {
-	src, err := file.Open()
+	err = s.importFile(ctx, userID, userKey, incomingFile{
+		Name:         cleanDisplayName(file.Filename),
+		Size:         file.Size,
+		DeclaredType: cleanDeclaredType(file.Header.Get(echo.HeaderContentType)),
+		FolderID:     folderID,
+		Open:         func() (io.ReadCloser, error) { return file.Open() },
+	})
+	if errors.Is(err, errQuotaExceeded) {
+		return quotaExceededResponse(c)
+	}
+	if isUploadPolicyError(err) {
+		return uploadPolicyResponse(c, err)
+	}
+	if errors.Is(err, errScanUnavailable) {
+		return c.JSON(http.StatusServiceUnavailable, map[string]string{"message": err.Error()})
+	}
 	if err != nil {
+		log.Println("Erreur lors de l'enregistrement du fichier :", err)
 		return err
 	}
-	defer src.Close()
+	// Ouvrir le nouveau fichier aux liens de partage et aux accès qui couvrent son dossier
+	go s.refreshAccess(context.Background(), userID, userKey)
 
-	// Encodage du nom de fichier
-	encodedFileName := url.PathEscape(file.Filename)
+	// Rediriger vers le dossier de destination après avoir déposé le fichier
+	return c.Redirect(http.StatusSeeOther, welcomeURL(folderID))
+}
 
-	// Créer un répertoire pour chaque utilisateur s'il n'existe pas déjà
-	userDir := fmt.Sprintf("uploads/%d", userID)
-	if _, err := os.Stat(userDir); os.IsNotExist(err) {
-		err := os.Mkdir(userDir, 0755)
-		if err != nil {
-			return err
-		}
+// Fonction pour ajouter un fichier dans le dossier d'un autre propriétaire, sous l'accès en écriture qui le permet :
+// le fichier appartient au propriétaire du dossier et sa clé de données est déposée sous la clé de l'accès
+func (s *Server) depositFile(c echo.Context, userID int, userKey []byte, ownerID int, file *multipart.FileHeader, folderID sql.NullInt64) error {
+	ctx := c.Request().Context()
+	if c.FormValue("extract") != "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{"message": "Une archive ne peut être décompressée que dans votre coffre"})
+	}
+	deposit, err := s.newDeposit(ctx, userID, userKey, folderID.Int64, fileKeyAD(ownerID))
+	if errors.Is(err, errNotVisible) {
+		return folderErrorResponse(c, err)
 	}
-
-	// Créer un fichier de destination dans le répertoire de l'utilisateur
-	dst, err := os.Create(filepath.Join(userDir, encodedFileName))
 	if err != nil {
+		log.Println("Erreur lors de la préparation du dépôt :", err)
 		return err
 	}
-	defer dst.Close()
 
-	// Copier le contenu du fichier téléchargé dans le fichier de destination
-	_, err = io.Copy(dst, src)
+	// La clé du propriétaire, si elle est en mémoire, sert à générer tout de suite la vignette et l'index
+	ownerKey, _ := s.keys.ring.Get(ownerID)
+	err = s.importFile(ctx, ownerID, ownerKey, incomingFile{
+		Name:         cleanDisplayName(file.Filename),
+		Size:         file.Size,
+		DeclaredType: cleanDeclaredType(file.Header.Get(echo.HeaderContentType)),
+		FolderID:     folderID,
+		Open:         func() (io.ReadCloser, error) { return file.Open() },
+		WrappedKey:   deposit.Wrapped,
+		DataKey:      deposit.DataKey,
+	})
+	if errors.Is(err, errQuotaExceeded) {
+		return quotaExceededResponse(c)
+	}
+	if isUploadPolicyError(err) {
+		return uploadPolicyResponse(c, err)
+	}
+	if errors.Is(err, errScanUnavailable) {
+		return c.JSON(http.StatusServiceUnavailable, map[string]string{"message": err.Error()})
+	}
 	if err != nil {
+		log.Println("Erreur lors de l'enregistrement du fichier :", err)
 		return err
 	}
-
-	return nil
-}
-
-// Définir une structure pour représenter un fichier téléchargé
-type UploadedFile struct {
-	UserID     int       // ID de l'utilisateur qui a téléchargé le fichier
-	FileName   string    // Nom du fichier
-	FilePath   string    // Chemin d'accès complet du fichier sur le serveur
-	UploadedAt time.Time // Date et heure du téléchargement
+	if err := s.keepDeposit(ctx, deposit); err != nil {
+		log.Println("Erreur lors du dépôt de la clé du fichier :", err)
+	}
+	return c.Redirect(http.StatusSeeOther, sharedFolderURL(folderID))
 }
 
 // Fonction pour récupérer l'ID de l'utilisateur à partir de la session
@@ -491,91 +663,32 @@ func getUserIDFromSession(c echo.Context) (int, error) {
 	return userID, nil
 }
 
-// Fonction pour gérer le téléchargement de fichiers
-func uploadFileHandler(c echo.Context) error {
-	if _, err := os.Stat("uploads"); os.IsNotExist(err) {
-		err := os.Mkdir("uploads", 0755)
-		if err != nil {
-			log.Println("Erreur lors de la création du dossier 'uploads' :", err)
-			return err
-		}
-	}
-	// Gérer le fichier uploadé
-	file, err := c.FormFile("file")
-	if err != nil {
-		log.Println("Erreur lors du téléchargement du fichier :", err)
-		return err
-	}
-
-	// Sauvegarder le fichier sur le serveur
-	src, err := file.Open()
-	if err != nil {
-		log.Println("Erreur lors de l'ouverture du fichier uploadé :", err)
-		return err
-	}
-	defer src.Close()
-
-	// Créer un fichier de destination
-	dst, err := os.Create("uploads/" + file.Filename)
-	if err != nil {
-		log.Println("Erreur lors de la création du fichier de destination :", err)
-		return err
-	}
-	defer dst.Close()
-
-	// Copier le contenu du fichier uploadé dans le fichier de destination
-	if _, err = io.Copy(dst, src); err != nil {
-		log.Println("Erreur lors de la copie du contenu du fichier :", err)
-		return err
-	}
-
-	// Enregistrement du fichier dans la base de données
-	userID, err := getUserIDFromSession(c)
-	if err != nil {
-		log.Println("Erreur lors de la récupération de l'ID de l'utilisateur :", err)
-		return err
-	}
-
-	uploadedFile := UploadedFile{
-		UserID:     userID,
-		FileName:   file.Filename,
-		FilePath:   "uploads/" + file.Filename,
-		UploadedAt: time.Now(),
-	}
-
-	if err := saveUploadedFileToDatabase(uploadedFile); err != nil {
-		log.Println("Erreur lors de l'enregistrement du fichier dans la base de données :", err)
-		return err
-	}
-
-	// Rediriger l'utilisateur vers la page d'accueil après le téléchargement du fichier
-	return c.Redirect(http.StatusSeeOther, "/welcome")
+// Fonction pour gérer le téléchargement de fichiers (route historique, même traitement que uploadFilePostHandler)
+func (s *Server) uploadFileHandler(c echo.Context) error {
+	return s.uploadFilePostHandler(c)
 }
 
-// Fonction pour enregistrer le fichier téléchargé dans la base de données
-func saveUploadedFileToDatabase(file UploadedFile) error {
-	
-	// Ouvrir une connexion à la base de données
-	db, err := sql.Open("mysql", "root:root@tcp(127.0.0.1:3306)/CoffreFortDb")
-	if err != nil {
-		return err
+// Fonction pour enregistrer le fichier téléchargé dans la base de données.
+// Un fichier du même nom dans le même dossier reçoit une nouvelle version au lieu d'une seconde ligne.
+// Renvoie l'ID du fichier.
+func (s *Server) saveUploadedFileToDatabase(ctx context.Context, file UploadedFile) (int64, error) {
+	fileID, replaced, err := s.files.CreateOrReplace(ctx, file)
+	if err != nil || !replaced {
+		return fileID, err
 	}
-	defer db.Close()
 
-	// Préparer la requête d'insertion
-	stmt, err := db.Prepare("INSERT INTO files (user_id, filename, file_path, uploaded_at) VALUES (?, ?, ?, ?)")
+	// Appliquer la politique de conservation de l'utilisateur aux anciennes versions
+	policy, err := s.versionPolicy(ctx, file.UserID)
 	if err != nil {
-		return err
+		log.Println("Erreur lors de la lecture de la politique de conservation :", err)
+		return fileID, nil
 	}
-	defer stmt.Close()
-
-	// Exécuter la requête d'insertion avec les valeurs du fichier téléchargé
-	_, err = stmt.Exec(file.UserID, file.FileName, file.FilePath, file.UploadedAt)
+	keys, err := s.versions.Prune(ctx, int(fileID), policy, time.Now())
 	if err != nil {
-		return err
+		log.Println("Erreur lors de la suppression des anciennes versions :", err)
 	}
-
-	return nil
+	removeBlobs(ctx, s.blobRefs, s.blobs, keys)
+	return fileID, nil
 }
 
 // Page de connexion (affichage du formulaire)
@@ -598,16 +711,13 @@ func loginHandler(c echo.Context) error {
 }
 
 // Traitement du formulaire de connexion
-func loginPostHandler(c echo.Context) error {
-	db, err := sql.Open("mysql", "root:root@tcp(localhost:3306)/CoffreFortDb")
+func (s *Server) loginPostHandler(c echo.Context) error {
 	// Récupérer le nom d'utilisateur et le mot de passe à partir du formulaire
 	username := c.FormValue("username")
 	password := c.FormValue("password")
 
 	// Vérifier si les informations d'identification sont correctes en comparant avec celles stockées dans la base de données
-	var storedPassword string
-	var userID int
-	err = db.QueryRow("SELECT id, password FROM users WHERE username = ?", username).Scan(&userID, &storedPassword)
+	user, err := s.users.FindByUsername(c.Request().Context(), username)
 	if err != nil {
 		// Gérer le cas où l'utilisateur n'existe pas
 		log.Println("Utilisateur non trouvé :", err)
@@ -615,7 +725,7 @@ func loginPostHandler(c echo.Context) error {
 	}
 
 	// Vérifier si le mot de passe correspond
-	err = bcrypt.CompareHashAndPassword([]byte(storedPassword), []byte(password))
+	err = bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password))
 	if err != nil {
 		// Gérer le cas où le mot de passe est incorrect
 		log.Println("Mot de passe incorrect :", err)
@@ -628,8 +738,25 @@ func loginPostHandler(c echo.Context) error {
 		log.Println("Erreur lors de la récupération de la session :", err)
 		return err
 	}
-	sess.Values["userID"] = userID
+	sess.Values["userID"] = user.ID
 	sess.Values["username"] = username
+
+	// Déverrouiller la clé de chiffrement de l'utilisateur ; une copie est gardée côté serveur pour la session,
+	// qui ne reçoit que le jeton qui la protège (voir StartSession)
+	s.keys.EndSession(c.Request().Context(), sess)
+	userKey, err := s.keys.Unlock(c.Request().Context(), user.ID, password)
+	if err != nil {
+		log.Println("Erreur lors du déverrouillage de la clé de chiffrement :", err)
+	} else if err := s.keys.StartSession(c.Request().Context(), sess, user.ID, userKey); err != nil {
+		log.Println("Erreur lors de la protection de la clé de chiffrement :", err)
+	} else {
+		// Paire de clés de partage des comptes créés avant les accès entre utilisateurs
+		if err := s.ensureKeyPair(c.Request().Context(), user.ID, userKey); err != nil {
+			log.Println("Erreur lors de la création de la paire de clés de partage :", err)
+		}
+		// Chiffrer en arrière-plan les notes et fichiers créés avant la mise en place du chiffrement
+		go s.encryptPlaintextData(context.Background(), user.ID, userKey)
+	}
 	sess.Save(c.Request(), c.Response())
 
 	// Redirection vers la page de bienvenue
@@ -637,31 +764,13 @@ func loginPostHandler(c echo.Context) error {
 }
 
 // Page pour afficher tous les utilisateurs existants
-func listUsersHandler(c echo.Context) error {
-	db, err := sql.Open("mysql", "root:root@tcp(localhost:3306)/CoffreFortDb")
-	// Exécuter une requête SELECT pour récupérer tous les utilisateurs avec leurs informations
-	rows, err := db.Query("SELECT username, created_at FROM users")
+func (s *Server) listUsersHandler(c echo.Context) error {
+	// Récupérer tous les utilisateurs avec leurs informations
+	users, err := s.users.List(c.Request().Context())
 	if err != nil {
 		log.Println("Erreur lors de la récupération des utilisateurs :", err)
 		return err
 	}
-	defer rows.Close()
-
-	type User struct {
-		Username  string
-		CreatedAt string // Modifier le type en string
-	}
-
-	var users []User
-	for rows.Next() {
-		var user User
-		err := rows.Scan(&user.Username, &user.CreatedAt)
-		if err != nil {
-			log.Println("Erreur lors de la lecture des résultats de la requête :", err)
-			return err
-		}
-		users = append(users, user)
-	}
 
 	// Créer une liste HTML des utilisateurs avec leurs informations supplémentaires
 	userListHTML := "<h1>Liste des utilisateurs</h1><ul>"
@@ -682,21 +791,18 @@ func registerHandler(c echo.Context) error {
 }
 
 // Traitement du formulaire d'inscription
-func registerPostHandler(c echo.Context) error {
-	db, err := sql.Open("mysql", "root:root@tcp(localhost:3306)/CoffreFortDb")
-	
-	
+func (s *Server) registerPostHandler(c echo.Context) error {
+	ctx := c.Request().Context()
 	username := c.FormValue("username")
 	password := c.FormValue("password")
 	confirm_password := c.FormValue("confirm_password") // Récupérer le champ de confirmation du mot de passe
 	// Vérifier si l'utilisateur existe déjà
-	var count int
-	err = db.QueryRow("SELECT COUNT(*) FROM users WHERE username = ?", username).Scan(&count)
+	exists, err := s.users.Exists(ctx, username)
 	if err != nil {
 		log.Println("Erreur lors de la vérification de l'existence de l'utilisateur :", err)
 		return err
 	}
-	if count > 0 {
+	if exists {
 		return c.File("userExisting.html")
 	}
 
@@ -714,13 +820,19 @@ func registerPostHandler(c echo.Context) error {
 		return err
 	}
 
-	insertQuery := "INSERT INTO users (username, password, role) VALUES (?, ?, ?)"
-	_, err = db.Exec(insertQuery, username, hashedPassword, role)
+	id, err := s.users.Create(ctx, username, hashedPassword, role)
 	if err != nil {
 		log.Println("Erreur lors de l'insertion dans la base de données :", err)
 		return err
 	}
 
+	// Créer la clé de chiffrement de l'utilisateur et sa paire de clés de partage (à défaut, à la première connexion)
+	if userKey, err := s.keys.Create(ctx, int(id), password); err != nil {
+		log.Println("Erreur lors de la création de la clé de chiffrement :", err)
+	} else if err := s.ensureKeyPair(ctx, int(id), userKey); err != nil {
+		log.Println("Erreur lors de la création de la paire de clés de partage :", err)
+	}
+
 	fmt.Printf("Utilisateur enregistré : %s\n", username)
 
 	return c.File("successCreateUser.html")
@@ -786,15 +898,14 @@ func deleteFormHandler(c echo.Context) error {
 }
 
 // Fonction pour supprimer un utilisateur
-func deleteHandler(c echo.Context) error {
-	db, err := sql.Open("mysql", "root:root@tcp(localhost:3306)/CoffreFortDb")
+func (s *Server) deleteHandler(c echo.Context) error {
+	ctx := c.Request().Context()
 	// Récupérer le nom d'utilisateur et le mot de passe à partir du formulaire
 	username := c.FormValue("username")
 	password := c.FormValue("password")
 
 	// Vérifier si l'utilisateur existe
-	var storedPassword string
-	err = db.QueryRow("SELECT password FROM users WHERE username = ?", username).Scan(&storedPassword)
+	user, err := s.users.FindByUsername(ctx, username)
 	if err != nil {
 		// L'utilisateur n'existe pas dans la base de données
 		log.Println("Erreur lors de la vérification de l'existence de l'utilisateur :", err)
@@ -802,7 +913,7 @@ func deleteHandler(c echo.Context) error {
 	}
 
 	// Vérifier si le mot de passe est correct en comparant avec le mot de passe haché stocké dans la base de données
-	err = bcrypt.CompareHashAndPassword([]byte(storedPassword), []byte(password))
+	err = bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password))
 	if err != nil {
 		// Le mot de passe est incorrect
 		log.Println("Mot de passe incorrect :", err)
@@ -810,8 +921,7 @@ func deleteHandler(c echo.Context) error {
 	}
 
 	// Le nom d'utilisateur et le mot de passe sont corrects, supprimer l'utilisateur de la base de données
-	deleteQuery := "DELETE FROM users WHERE username = ?"
-	_, err = db.Exec(deleteQuery, username)
+	err = s.deleteUser(ctx, user.ID)
 	if err != nil {
 		log.Println("Erreur lors de la suppression de l'utilisateur :", err)
 		return err
@@ -822,10 +932,98 @@ func deleteHandler(c echo.Context) error {
 	return c.Redirect(http.StatusSeeOther, "/users") // Redirige vers la page des utilisateurs
 }
 
+// Page pour afficher le formulaire de changement de mot de passe
+func changePasswordHandler(c echo.Context) error {
+	htmlContent := `
+        <h1>Changer le mot de passe</h1>
+        <form action='/change-password' method='post'>
+            <input type='password' name='current_password' placeholder='Mot de passe actuel' required />
+            <input type='password' name='new_password' placeholder='Nouveau mot de passe' required />
+            <input type='password' name='confirm_password' placeholder='Confirmer le mot de passe' required />
+            <button type='submit'>Changer le mot de passe</button>
+        </form>
+        <a href="/welcome">Retour à la page </a>
+    `
+	return c.HTML(http.StatusOK, htmlContent)
+}
+
+// Traitement du formulaire de changement de mot de passe.
+// Seule la protection de la clé de chiffrement change : les clés de données et les fichiers restent intacts.
+func (s *Server) changePasswordPostHandler(c echo.Context) error {
+	ctx := c.Request().Context()
+	userID := currentUserID(c)
+	currentPassword := c.FormValue("current_password")
+	newPassword := c.FormValue("new_password")
+
+	if newPassword == "" || newPassword != c.FormValue("confirm_password") {
+		return c.File("wrongMDP.html")
+	}
+
+	// Vérifier le mot de passe actuel
+	user, err := s.users.FindByID(ctx, userID)
+	if err != nil {
+		log.Println("Erreur lors de la récupération de l'utilisateur :", err)
+		return err
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(currentPassword)); err != nil {
+		log.Println("Mot de passe incorrect :", err)
+		return c.HTML(http.StatusUnauthorized, "<h1>Changer le mot de passe</h1><p>Mot de passe incorrect.</p><a href='/change-password'>Réessayer</a>")
+	}
+
+	// Protéger la clé de chiffrement avec le nouveau mot de passe (créée au besoin pour un ancien compte)
+	rec, err := s.keys.Rewrap(ctx, userID, currentPassword, newPassword)
+	if errors.Is(err, errUserKeyNotFound) {
+		if _, err = s.keys.Create(ctx, userID, currentPassword); err == nil {
+			rec, err = s.keys.Rewrap(ctx, userID, currentPassword, newPassword)
+		}
+	}
+	if err != nil {
+		log.Println("Erreur lors de la protection de la clé de chiffrement :", err)
+		return err
+	}
+
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(newPassword), bcrypt.DefaultCost)
+	if err != nil {
+		log.Println("Erreur lors du hachage du mot de passe :", err)
+		return err
+	}
+
+	// Le mot de passe et la clé protégée changent ensemble
+	if err := s.keys.keys.ChangePassword(ctx, userID, hashedPassword, rec); err != nil {
+		log.Println("Erreur lors du changement de mot de passe :", err)
+		return err
+	}
+
+	// Fermer les autres sessions : un cookie copié avant le changement ne donne plus accès à la clé
+	userKey, keyErr := s.keys.ForRequest(c, userID)
+	if err := s.keys.sessions.DeleteByUser(ctx, userID); err != nil {
+		log.Println("Erreur lors de la fermeture des sessions :", err)
+	}
+	if sess, err := session.Get("session", c); err == nil {
+		delete(sess.Values, sessionTokenKey)
+		if keyErr == nil {
+			if err := s.keys.StartSession(ctx, sess, userID, userKey); err != nil {
+				log.Println("Erreur lors de la protection de la clé de chiffrement :", err)
+			}
+		}
+		sess.Save(c.Request(), c.Response())
+	}
+
+	fmt.Printf("Mot de passe changé : %s\n", user.Username)
+
+	return c.Redirect(http.StatusSeeOther, "/welcome")
+}
+
 // Page de déconnexion
-func logoutHandler(c echo.Context) error {
-	// Supprimer toutes les informations de session
+func (s *Server) logoutHandler(c echo.Context) error {
+	// Oublier la clé de chiffrement gardée en mémoire
+	if userID, err := getUserIDFromSession(c); err == nil {
+		s.keys.ring.Forget(userID)
+	}
+
+	// Supprimer toutes les informations de session, et la copie de la clé gardée côté serveur
 	sess, _ := session.Get("session", c)
+	s.keys.EndSession(c.Request().Context(), sess)
 	sess.Options = &sessions.Options{MaxAge: -1} // Définir l'âge maximum de la session à -1 pour la supprimer
 	sess.Save(c.Request(), c.Response())
 
@@ -834,80 +1032,156 @@ func logoutHandler(c echo.Context) error {
 }
 
 // Gestionnaire de route pour visualiser le contenu du fichier
-func viewFileHandler(c echo.Context) error {
-	fileName := c.Param("fileName")
+func (s *Server) viewFileHandler(c echo.Context) error {
+	fileID, err := parseObjectID(c.Param("id"))
+	if err != nil {
+		return accessErrorResponse(c, err, "Erreur lors de la lecture du fichier")
+	}
 
-	// Décodage du nom de fichier
-	decodedFileName, err := url.PathUnescape(fileName)
+	// Vérifier que l'utilisateur a le droit de lire le fichier
+	file, err := s.authz.File(c.Request().Context(), currentUserID(c), fileID, AccessRead)
 	if err != nil {
-		return err
+		return accessErrorResponse(c, err, "Erreur lors de la lecture du fichier")
 	}
+	return s.serveFile(c, file)
+}
 
-	// Récupérer le chemin d'accès complet du fichier
-	filePath := "uploads/" + decodedFileName
+// Fonction pour envoyer le contenu d'un fichier (version courante ou ancienne version) à son propriétaire
+// ou à un utilisateur qui a reçu un accès
+func (s *Server) serveFile(c echo.Context, file UploadedFile) error {
+	return s.serveFileContent(c, file, func() (io.ReadSeekCloser, error) { return s.openFileContent(c, &file) }, false)
+}
 
-	// Lire le contenu du fichier
-	fileContent, err := ioutil.ReadFile(filePath)
+// Fonction pour envoyer un contenu ouvert par open (clé du propriétaire ou d'un lien de partage) ;
+// attachment impose le téléchargement
+func (s *Server) serveFileContent(c echo.Context, file UploadedFile, open func() (io.ReadSeekCloser, error), attachment bool) error {
+	// Un contenu en quarantaine n'est plus servi tant qu'un administrateur ne l'a pas libéré
+	if file.Metadata.Quarantine != "" {
+		return c.JSON(http.StatusForbidden, map[string]string{"message": errQuarantined.Error()})
+	}
+	// Lire le contenu du fichier à partir de la clé de stockage enregistrée en base
+	if file.StorageKey == "" {
+		log.Printf("Fichier %d sans clé de stockage (lancer `storage reconcile`)", file.ID)
+		return accessErrorResponse(c, errNotVisible, "Erreur lors de la lecture du fichier")
+	}
+	// Le contenu est déchiffré à la volée, segment par segment, sans être chargé entièrement en mémoire
+	content, err := open()
+	if errors.Is(err, errKeyringLocked) {
+		return keyringLockedResponse(c)
+	}
+	if errors.Is(err, errGrantKeyMissing) {
+		return c.JSON(http.StatusServiceUnavailable, map[string]string{"message": err.Error()})
+	}
+	if errors.Is(err, errBlobNotFound) {
+		log.Printf("Contenu du fichier %d introuvable dans le stockage", file.ID)
+		return accessErrorResponse(c, errNotVisible, "Erreur lors de la lecture du fichier")
+	}
 	if err != nil {
 		log.Println("Erreur lors de la lecture du fichier :", err)
 		return err
 	}
+	defer content.Close()
 
-	// Déterminer le type de contenu en fonction de l'extension du fichier
-	contentType := http.DetectContentType(fileContent)
+	// Type de contenu reconnu à l'envoi ; un fichier pas encore décrit est reconnu à partir de ses premiers octets
+	contentType := file.Metadata.MIMEType
+	if contentType == "" {
+		head := make([]byte, 512)
+		n, err := io.ReadFull(content, head)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			log.Println("Erreur lors de la lecture du fichier :", err)
+			return err
+		}
+		if _, err := content.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		contentType = sniffContentType(head[:n])
+	}
 
-	// Retourner le contenu en fonction du type de fichier
-	switch contentType {
-	case "application/pdf":
+	// Les types affichables par le navigateur sont servis en ligne, les autres en téléchargement
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		mediaType = ""
+	}
+	disposition := "inline"
+	switch {
+	case mediaType == "application/pdf":
 		// Afficher le contenu PDF sur une nouvelle page
-		return c.Blob(http.StatusOK, contentType, fileContent)
-	case "image/png", "image/jpeg":
+	case mediaType == "image/png", mediaType == "image/jpeg":
 		// Afficher l'image sur une nouvelle page
-		return c.Blob(http.StatusOK, contentType, fileContent)
-	case "text/plain":
-		// Afficher le contenu du fichier texte sur une nouvelle page
-		return c.HTMLBlob(http.StatusOK, fileContent)
+	case strings.HasPrefix(mediaType, "audio/"), strings.HasPrefix(mediaType, "video/"):
+		// Lecteurs audio et vidéo du navigateur (ils s'appuient sur les requêtes Range pour avancer)
+	case mediaType == "text/plain":
+		// Afficher le fichier texte tel quel : jamais interprété comme du HTML
+		contentType = echo.MIMETextPlainCharsetUTF8
 	default:
-		// Gérer les autres types de fichiers (facultatif)
-		return c.String(http.StatusOK, "Contenu du fichier non pris en charge")
-	}
+		contentType, disposition = echo.MIMEOctetStream, "attachment"
+	}
+	if attachment || s.uploadPolicy.SafeServing || c.QueryParam("download") != "" {
+		disposition = "attachment"
+	}
+
+	// ServeContent gère Range, If-Range, If-None-Match, If-Modified-Since et Content-Length.
+	// La clé de stockage change à chaque nouveau contenu : elle sert d'ETag sans rien révéler du fichier.
+	header := c.Response().Header()
+	header.Set(echo.HeaderContentType, contentType)
+	header.Set(echo.HeaderContentDisposition, mime.FormatMediaType(disposition, map[string]string{"filename": file.FileName}))
+	header.Set("ETag", `"`+file.StorageKey+`"`)
+	header.Set("Cache-Control", "private")
+	// Le navigateur ne doit ni deviner un autre type ni exécuter quoi que ce soit venant du contenu servi.
+	// Pas de sandbox pour les PDF : la visionneuse intégrée des navigateurs refuse de s'afficher dans un document isolé.
+	header.Set("X-Content-Type-Options", "nosniff")
+	if mediaType != "application/pdf" {
+		header.Set("Content-Security-Policy", "default-src 'none'; img-src 'self' data:; media-src 'self'; style-src 'unsafe-inline'; sandbox")
+	}
+	http.ServeContent(c.Response(), c.Request(), "", file.UploadedAt, content)
+	return nil
 }
 
-// Fonction pour supprimer un fichier de la base de données et du système de fichiers
-func deleteFileHandler(c echo.Context) error {
-	db, err := sql.Open("mysql", "root:root@tcp(localhost:3306)/CoffreFortDb")
-	fileName := c.Param("fileName")
-
-	// Supprimer le fichier de la base de données
-	_, err = db.Exec("DELETE FROM files WHERE filename = ?", fileName)
+// Fonction pour mettre un fichier à la corbeille (son contenu n'est effacé qu'à la purge)
+func (s *Server) deleteFileHandler(c echo.Context) error {
+	ctx := c.Request().Context()
+	fileID, err := parseObjectID(c.Param("id"))
 	if err != nil {
-		log.Println("Erreur lors de la suppression du fichier de la base de données :", err)
-		return c.JSON(http.StatusInternalServerError, map[string]string{"message": "Erreur lors de la suppression du fichier de la base de données"})
+		return accessErrorResponse(c, err, "Erreur lors de la suppression du fichier")
 	}
 
-	// Supprimer le fichier du système de fichiers
-	err = os.Remove("uploads/" + fileName)
+	// Vérifier que l'utilisateur a le droit de supprimer le fichier
+	file, err := s.authz.File(ctx, currentUserID(c), fileID, AccessManage)
 	if err != nil {
-		log.Println("Erreur lors de la suppression du fichier du système de fichiers :", err)
-		return c.JSON(http.StatusInternalServerError, map[string]string{"message": "Erreur lors de la suppression du fichier du système de fichiers"})
+		return accessErrorResponse(c, err, "Erreur lors de la suppression du fichier")
+	}
+
+	// Mettre le fichier à la corbeille
+	if err := s.trash.Put(ctx, file.UserID, resourceFile, fileID, time.Now()); err != nil {
+		return accessErrorResponse(c, err, "Erreur lors de la suppression du fichier")
 	}
 
 	// Répondre avec un code de succès
-	return c.JSON(http.StatusOK, map[string]string{"message": "Fichier supprimé avec succès"})
+	return c.JSON(http.StatusOK, map[string]string{"message": "Fichier placé dans la corbeille"})
 }
 
-func deleteAccountHandler(c echo.Context) error {
-	db, err := sql.Open("mysql", "root:root@tcp(localhost:3306)/CoffreFortDb")
-	// Récupérer l'ID de l'utilisateur à partir de la session
-	userID, err := getUserIDFromSession(c)
+// Fonction pour supprimer un compte avec toutes ses données, puis effacer du stockage les contenus
+// qui ne sont plus référencés et les morceaux de ses envois en cours
+func (s *Server) deleteUser(ctx context.Context, userID int) error {
+	keys, uploadIDs, err := s.users.Delete(ctx, userID)
 	if err != nil {
-		// Gérer l'erreur
 		return err
 	}
+	removeBlobs(ctx, s.blobRefs, s.blobs, keys)
+	for _, uploadID := range uploadIDs {
+		s.staging.remove(uploadID)
+	}
+	s.keys.ring.Forget(userID)
+	return nil
+}
 
-	// Supprimer le compte de l'utilisateur de la base de données
-	_, err = db.Exec("DELETE FROM users WHERE id = ?", userID)
-	if err != nil {
+func (s *Server) deleteAccountHandler(c echo.Context) error {
+	ctx := c.Request().Context()
+	// ID de l'utilisateur connecté (placé dans le contexte par requireUser)
+	userID := currentUserID(c)
+
+	// Supprimer le compte de l'utilisateur et toutes ses données (sa clé de chiffrement part avec lui)
+	if err := s.deleteUser(ctx, userID); err != nil {
 		// Gérer l'erreur
 		return err
 	}
@@ -0,0 +1,400 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/binary"
+	"encoding/hex"
+	"hash"
+	"html/template"
+	"image"
+	_ "image/gif"  // Dimensions des images GIF
+	_ "image/jpeg" // Dimensions des images JPEG
+	_ "image/png"  // Dimensions des images PNG
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// Taille du début de contenu gardé pour reconnaître le type, les dimensions et la date de prise de vue
+// (les segments EXIF d'un JPEG précèdent ses dimensions et peuvent occuper plusieurs dizaines de Kio)
+const inspectHeadSize = 256 << 10
+
+// Définir une structure pour représenter les métadonnées d'un contenu, relevées à l'envoi
+type FileMetadata struct {
+	SHA256       string    // Empreinte SHA-256 du contenu en clair, en hexadécimal (vide tant qu'il n'est pas décrit)
+	MIMEType     string    // Type reconnu d'après les premiers octets
+	DeclaredType string    // Type annoncé par le client (vide s'il n'en a pas donné)
+	Width        int       // Largeur d'une image, en pixels (0 pour un autre contenu)
+	Height       int       // Hauteur d'une image, en pixels
+	TakenAt      time.Time // Date de prise de vue d'une photo (EXIF), zéro si inconnue
+	Quarantine   string    // Raison de la mise en quarantaine (vide : contenu servi normalement)
+}
+
+// contentInspector relève les métadonnées d'un contenu pendant qu'on le lit une première fois
+type contentInspector struct {
+	digest hash.Hash
+	head   []byte
+}
+
+// Fonction pour créer un inspecteur de contenu
+func newContentInspector() *contentInspector {
+	return &contentInspector{digest: sha256.New()}
+}
+
+func (w *contentInspector) Write(p []byte) (int, error) {
+	w.digest.Write(p)
+	if room := inspectHeadSize - len(w.head); room > 0 {
+		if room > len(p) {
+			room = len(p)
+		}
+		w.head = append(w.head, p[:room]...)
+	}
+	return len(p), nil
+}
+
+// Fonction pour obtenir l'empreinte du contenu lu
+func (w *contentInspector) Sum() []byte {
+	return w.digest.Sum(nil)
+}
+
+// Fonction pour obtenir les métadonnées du contenu lu (le type annoncé est renseigné par l'appelant)
+func (w *contentInspector) Metadata() FileMetadata {
+	meta := FileMetadata{SHA256: hex.EncodeToString(w.Sum()), MIMEType: sniffContentType(w.head)}
+	if strings.HasPrefix(meta.MIMEType, "image/") {
+		if config, _, err := image.DecodeConfig(bytes.NewReader(w.head)); err == nil {
+			meta.Width, meta.Height = config.Width, config.Height
+		}
+		if meta.MIMEType == "image/jpeg" {
+			meta.TakenAt = jpegTakenAt(w.head)
+		}
+	}
+	return meta
+}
+
+// Signatures reconnues en plus de celles de http.DetectContentType : exécutables et scripts, que la politique
+// d'envoi doit pouvoir refuser quelle que soit leur extension
+var extraSignatures = []struct {
+	prefix   string
+	mimeType string
+}{
+	{"\x7fELF", "application/x-executable"},
+	{"\xcf\xfa\xed\xfe", "application/x-mach-binary"},
+	{"\xce\xfa\xed\xfe", "application/x-mach-binary"},
+	{"\xca\xfe\xba\xbe", "application/x-mach-binary"},
+	{"#!", "text/x-shellscript"},
+}
+
+// Fonction pour reconnaître le type d'un contenu d'après ses premiers octets (jamais d'après son nom)
+func sniffContentType(head []byte) string {
+	// Exécutable Windows : en-tête MZ qui désigne un en-tête PE
+	if bytes.HasPrefix(head, []byte("MZ")) && len(head) >= 64 {
+		if offset := int(binary.LittleEndian.Uint32(head[60:])); offset >= 0 && offset+4 <= len(head) && bytes.Equal(head[offset:offset+4], []byte("PE\x00\x00")) {
+			return "application/x-msdownload"
+		}
+	}
+	for _, signature := range extraSignatures {
+		if bytes.HasPrefix(head, []byte(signature.prefix)) {
+			return signature.mimeType
+		}
+	}
+	mimeType := http.DetectContentType(head)
+	// Une image SVG est du XML (ou du texte) qui peut embarquer des scripts
+	if strings.HasPrefix(mimeType, "text/xml") || strings.HasPrefix(mimeType, "text/plain") {
+		sample := head
+		if len(sample) > 1024 {
+			sample = sample[:1024]
+		}
+		if bytes.Contains(bytes.ToLower(sample), []byte("<svg")) {
+			return "image/svg+xml"
+		}
+	}
+	return mimeType
+}
+
+// Fonction pour nettoyer le type annoncé par le client (vide s'il est absent ou mal formé)
+func cleanDeclaredType(value string) string {
+	mediaType, _, err := mime.ParseMediaType(value)
+	if err != nil || len(mediaType) > 255 {
+		return ""
+	}
+	return mediaType
+}
+
+// Fonction pour lire la date de prise de vue d'une photo JPEG dans ses données EXIF (DateTimeOriginal, sinon DateTime)
+func jpegTakenAt(data []byte) time.Time {
+	if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+		return time.Time{}
+	}
+	for pos := 2; pos+4 <= len(data); {
+		if data[pos] != 0xFF {
+			return time.Time{}
+		}
+		marker := data[pos+1]
+		if marker == 0xDA || marker == 0xD9 {
+			return time.Time{} // Début des données de l'image : plus d'en-tête à lire
+		}
+		length := int(binary.BigEndian.Uint16(data[pos+2:]))
+		end := pos + 2 + length
+		if length < 2 || end > len(data) {
+			return time.Time{}
+		}
+		if segment := data[pos+4 : end]; marker == 0xE1 && bytes.HasPrefix(segment, []byte("Exif\x00\x00")) {
+			return exifTakenAt(segment[6:])
+		}
+		pos = end
+	}
+	return time.Time{}
+}
+
+// Étiquettes EXIF utiles
+const (
+	exifTagDateTime         = 0x0132
+	exifTagExifIFD          = 0x8769
+	exifTagDateTimeOriginal = 0x9003
+)
+
+// Fonction pour lire la date de prise de vue dans un bloc TIFF/EXIF
+func exifTakenAt(tiff []byte) time.Time {
+	if len(tiff) < 8 {
+		return time.Time{}
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return time.Time{}
+	}
+
+	ifd0 := readIFD(tiff, order, order.Uint32(tiff[4:]))
+	if entry, ok := ifd0[exifTagExifIFD]; ok {
+		exif := readIFD(tiff, order, order.Uint32(entry[8:]))
+		if value, ok := exif[exifTagDateTimeOriginal]; ok {
+			if t := parseExifTime(tiff, order, value); !t.IsZero() {
+				return t
+			}
+		}
+	}
+	if value, ok := ifd0[exifTagDateTime]; ok {
+		return parseExifTime(tiff, order, value)
+	}
+	return time.Time{}
+}
+
+// Fonction pour lire les entrées d'un répertoire EXIF : étiquette -> champ de 12 octets
+func readIFD(tiff []byte, order binary.ByteOrder, offset uint32) map[uint16][]byte {
+	entries := make(map[uint16][]byte)
+	if offset < 8 || uint64(offset)+2 > uint64(len(tiff)) {
+		return entries
+	}
+	count := int(order.Uint16(tiff[offset:]))
+	for i := 0; i < count; i++ {
+		start := int(offset) + 2 + i*12
+		if start+12 > len(tiff) {
+			break
+		}
+		entries[order.Uint16(tiff[start:])] = tiff[start : start+12]
+	}
+	return entries
+}
+
+// Fonction pour lire une date EXIF ("2006:01:02 15:04:05", texte de 20 octets rangé hors de l'entrée)
+func parseExifTime(tiff []byte, order binary.ByteOrder, entry []byte) time.Time {
+	const exifASCII = 2
+	if order.Uint16(entry[2:]) != exifASCII || order.Uint32(entry[4:]) < 19 {
+		return time.Time{}
+	}
+	offset := order.Uint32(entry[8:])
+	if uint64(offset)+19 > uint64(len(tiff)) {
+		return time.Time{}
+	}
+	t, err := time.Parse("2006:01:02 15:04:05", string(tiff[offset:offset+19]))
+	if err != nil {
+		return time.Time{}
+	}
+	return t
+}
+
+// Colonnes des métadonnées d'un contenu, communes à files et file_versions
+const metadataColumns = "sha256, mime_type, declared_type, width, height, taken_at, quarantine"
+
+// Affectations des colonnes de métadonnées, dans l'ordre de metadataColumns
+const metadataAssignments = "sha256 = ?, mime_type = ?, declared_type = ?, width = ?, height = ?, taken_at = ?, quarantine = ?"
+
+// metadataRow reçoit les colonnes de métadonnées lues en base (toutes peuvent être NULL)
+type metadataRow struct {
+	sha256, mimeType, declaredType sql.NullString
+	width, height                  sql.NullInt64
+	takenAt                        []byte
+	quarantine                     sql.NullString
+}
+
+// Fonction pour obtenir les destinations de Scan des colonnes de métadonnées
+func (m *metadataRow) dest() []interface{} {
+	return []interface{}{&m.sha256, &m.mimeType, &m.declaredType, &m.width, &m.height, &m.takenAt, &m.quarantine}
+}
+
+// Fonction pour recopier telles quelles les métadonnées lues vers une autre ligne
+func (m *metadataRow) values() []interface{} {
+	return []interface{}{m.sha256, m.mimeType, m.declaredType, m.width, m.height, nullableTime(m.takenAt), m.quarantine}
+}
+
+// Fonction pour convertir les métadonnées lues en base
+func (m *metadataRow) metadata() FileMetadata {
+	return FileMetadata{
+		SHA256:       m.sha256.String,
+		MIMEType:     m.mimeType.String,
+		DeclaredType: m.declaredType.String,
+		Width:        int(m.width.Int64),
+		Height:       int(m.height.Int64),
+		TakenAt:      parseDBTime(m.takenAt),
+		Quarantine:   m.quarantine.String,
+	}
+}
+
+// Fonction pour obtenir les valeurs à enregistrer (NULL pour les métadonnées inconnues)
+func (m FileMetadata) values() []interface{} {
+	values := []interface{}{nullString(m.SHA256), nullString(m.MIMEType), nullString(m.DeclaredType), nil, nil, nil, nullString(m.Quarantine)}
+	if m.Width > 0 && m.Height > 0 {
+		values[3], values[4] = m.Width, m.Height
+	}
+	if !m.TakenAt.IsZero() {
+		values[5] = m.TakenAt
+	}
+	return values
+}
+
+// Fonction pour enregistrer une chaîne vide comme NULL
+func nullString(value string) sql.NullString {
+	return sql.NullString{String: value, Valid: value != ""}
+}
+
+// Fonction pour décrire en arrière-plan les contenus d'un utilisateur enregistrés avant le relevé des métadonnées.
+// Il faut la clé de l'utilisateur pour lire ses contenus : la description se fait à sa connexion.
+func (s *Server) describeFiles(ctx context.Context, userID int, userKey []byte) {
+	contents, err := s.files.ListUndescribed(ctx, userID)
+	if err != nil {
+		log.Println("Erreur lors de la recherche des fichiers à décrire :", err)
+		return
+	}
+
+	for _, content := range contents {
+		meta, err := s.inspectStoredContent(ctx, content, userID, userKey)
+		if err != nil {
+			log.Printf("Erreur lors de la description du contenu %s : %v", content.StorageKey, err)
+			continue
+		}
+		if err := s.files.SetMetadata(ctx, content, meta); err != nil {
+			log.Printf("Erreur lors de l'enregistrement des métadonnées du contenu %s : %v", content.StorageKey, err)
+		}
+	}
+}
+
+// Fonction pour relever les métadonnées d'un contenu déjà enregistré
+func (s *Server) inspectStoredContent(ctx context.Context, content undescribedContent, userID int, userKey []byte) (FileMetadata, error) {
+	var dataKey []byte
+	if content.WrappedKey != nil {
+		var err error
+		if dataKey, err = openFileKey(userKey, content.WrappedKey, userID); err != nil {
+			return FileMetadata{}, err
+		}
+	}
+	src, err := openContent(ctx, s.blobs, content.StorageKey, dataKey)
+	if err != nil {
+		return FileMetadata{}, err
+	}
+	defer src.Close()
+
+	inspector := newContentInspector()
+	if _, err := io.Copy(inspector, src); err != nil {
+		return FileMetadata{}, err
+	}
+	return inspector.Metadata(), nil
+}
+
+// Fonction pour lire le tri et le filtre de la liste des fichiers dans l'URL (valeurs inconnues ignorées)
+func parseFileListOptions(c echo.Context) FileListOptions {
+	options := FileListOptions{Desc: c.QueryParam("order") == "desc"}
+	if _, ok := fileSortColumns[c.QueryParam("sort")]; ok {
+		options.Sort = c.QueryParam("sort")
+	}
+	if _, ok := fileKindFilters[c.QueryParam("kind")]; ok {
+		options.Kind = c.QueryParam("kind")
+	}
+	return options
+}
+
+// Fonction pour construire une liste déroulante dont l'option courante est sélectionnée
+func renderSelect(name string, labels [][2]string, current string) string {
+	selectHTML := `<select name="` + name + `">`
+	for _, label := range labels {
+		selected := ""
+		if label[0] == current {
+			selected = " selected"
+		}
+		selectHTML += `<option value="` + label[0] + `"` + selected + `>` + label[1] + `</option>`
+	}
+	return selectHTML + `</select>`
+}
+
+// Fonction pour construire le formulaire de tri, de filtre et d'affichage (liste ou grille) des fichiers
+func renderFileListControls(folder sql.NullInt64, options FileListOptions, grid bool) string {
+	folderValue := ""
+	if folder.Valid {
+		folderValue = strconv.FormatInt(folder.Int64, 10)
+	}
+	order := "asc"
+	if options.Desc {
+		order = "desc"
+	}
+	view := "list"
+	if grid {
+		view = "grid"
+	}
+	return `<form id="fileListControls" action="/welcome" method="get">
+        <input type="hidden" name="folder" value="` + folderValue + `">
+        Trier par ` + renderSelect("sort", [][2]string{{"", "ordre d'envoi"}, {"name", "nom"}, {"size", "taille"}, {"date", "date d'envoi"}, {"type", "type"}, {"taken", "date de prise de vue"}}, options.Sort) + `
+        ` + renderSelect("order", [][2]string{{"asc", "croissant"}, {"desc", "décroissant"}}, order) + `
+        Afficher ` + renderSelect("kind", [][2]string{{"", "tous les fichiers"}, {"image", "images"}, {"video", "vidéos"}, {"audio", "audio"}, {"pdf", "PDF"}, {"text", "textes"}, {"other", "autres"}}, options.Kind) + `
+        en ` + renderSelect("view", [][2]string{{"list", "liste"}, {"grid", "grille"}}, view) + `
+        <button type="submit">Appliquer</button>
+    </form>`
+}
+
+// Fonction pour afficher les métadonnées d'un fichier dans la liste
+func renderFileDetails(file UploadedFile) string {
+	meta := file.Metadata
+	details := []string{formatBytes(file.Size)}
+	if meta.MIMEType != "" {
+		mediaType, _, _ := mime.ParseMediaType(meta.MIMEType)
+		label := mediaType
+		if meta.DeclaredType != "" && meta.DeclaredType != mediaType {
+			label += " (annoncé : " + meta.DeclaredType + ")"
+		}
+		details = append(details, label)
+	}
+	if meta.Width > 0 && meta.Height > 0 {
+		details = append(details, strconv.Itoa(meta.Width)+" × "+strconv.Itoa(meta.Height)+" px")
+	}
+	if !meta.TakenAt.IsZero() {
+		details = append(details, "pris le "+meta.TakenAt.Format("02/01/2006 15:04"))
+	}
+	if !file.UploadedAt.IsZero() {
+		details = append(details, "envoyé le "+file.UploadedAt.Format("02/01/2006 15:04"))
+	}
+	if meta.Quarantine != "" {
+		details = append(details, "en quarantaine ("+meta.Quarantine+")")
+	}
+	return `<small class="file-details">` + template.HTMLEscapeString(strings.Join(details, " · ")) + `</small>`
+}
@@ -0,0 +1,502 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"embed"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Migrations SQL embarquées dans le binaire (NNNN_nom.up.sql / NNNN_nom.down.sql)
+//
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// Nom du verrou consultatif MySQL qui empêche deux instances de migrer en même temps
+const migrationLockName = "coffrefort_schema_migrations"
+
+// Durée maximale d'attente du verrou de migration
+const migrationLockTimeout = 60 * time.Second
+
+// Mot de passe historique du compte admin, utilisé par défaut en développement
+const defaultAdminPassword = "cle"
+
+var migrationFileName = regexp.MustCompile(`^(\d+)_([a-z0-9_]+)\.(up|down)\.sql$`)
+
+// Définir une structure pour représenter une migration du schéma
+type Migration struct {
+	Version  int    // Numéro de la migration (ordre d'application)
+	Name     string // Nom lisible de la migration
+	UpSQL    string // Script d'application (migrations SQL)
+	DownSQL  string // Script d'annulation (migrations SQL)
+	Checksum string // Empreinte SHA-256 du contenu, vérifiée à chaque démarrage
+
+	// Migrations écrites en Go (données d'amorçage qui dépendent de la configuration)
+	UpFunc   func(ctx context.Context, conn *sql.Conn) error
+	DownFunc func(ctx context.Context, conn *sql.Conn) error
+}
+
+// Définir une structure pour représenter l'état d'une migration en base
+type MigrationStatus struct {
+	Migration
+	AppliedAt       time.Time // Date d'application (zéro si en attente)
+	Applied         bool      // La migration est enregistrée dans schema_migrations
+	AppliedChecksum string    // Empreinte enregistrée lors de l'application
+	Missing         bool      // Appliquée en base mais absente du binaire
+}
+
+// Modified indique que le contenu de la migration a changé depuis son application
+func (s MigrationStatus) Modified() bool {
+	return s.Applied && !s.Missing && s.AppliedChecksum != s.Checksum
+}
+
+// Migrator applique les migrations sous un verrou consultatif MySQL
+type Migrator struct {
+	db         *sql.DB
+	migrations []Migration
+}
+
+// Fonction pour créer un migrateur avec les migrations SQL embarquées et les migrations Go
+func NewMigrator(db *sql.DB, cfg Config) (*Migrator, error) {
+	migrations, err := loadSQLMigrations(migrationFiles)
+	if err != nil {
+		return nil, err
+	}
+	migrations = append(migrations, goMigrations(cfg)...)
+
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	for i := 1; i < len(migrations); i++ {
+		if migrations[i].Version == migrations[i-1].Version {
+			return nil, fmt.Errorf("migration %04d déclarée deux fois", migrations[i].Version)
+		}
+	}
+
+	return &Migrator{db: db, migrations: migrations}, nil
+}
+
+// Migrations écrites en Go, numérotées dans la même séquence que les fichiers SQL
+func goMigrations(cfg Config) []Migration {
+	return []Migration{
+		{
+			Version:  2,
+			Name:     "seed_admin",
+			Checksum: checksum("go:seed_admin:v1"),
+			UpFunc:   seedAdminUp(cfg.Admin.InitialPassword),
+			DownFunc: nil, // Le compte admin n'est jamais supprimé automatiquement
+		},
+		{
+			Version:  20,
+			Name:     "folder_columns",
+			Checksum: checksum("go:folder_columns:v1"),
+			UpFunc:   addFolderColumnsUp,
+			DownFunc: nil, // La colonne peut venir d'un schéma antérieur aux migrations : on ne la retire pas
+		},
+	}
+}
+
+// Colonnes folder_id des notes et fichiers, avec leur index et leur clé étrangère vers folders
+var folderColumns = []struct{ table, alter string }{
+	{"notes", "ALTER TABLE `notes` ADD COLUMN `folder_id` int DEFAULT NULL, ADD KEY `notes_folder_id` (`folder_id`), " +
+		"ADD CONSTRAINT `notes_ibfk_2` FOREIGN KEY (`folder_id`) REFERENCES `folders` (`id`)"},
+	{"files", "ALTER TABLE `files` ADD COLUMN `folder_id` int DEFAULT NULL, ADD KEY `folder_id` (`folder_id`), " +
+		"ADD CONSTRAINT `files_ibfk_1` FOREIGN KEY (`folder_id`) REFERENCES `folders` (`id`)"},
+}
+
+// Migration de rangement dans les dossiers : ajouter folder_id aux tables qui ne l'ont pas encore.
+// Les bases chargées depuis l'ancien dump sauvergarde.sql ont déjà notes et files sans cette colonne,
+// ce que le CREATE TABLE IF NOT EXISTS de la migration 0001 ne peut pas corriger.
+func addFolderColumnsUp(ctx context.Context, conn *sql.Conn) error {
+	for _, c := range folderColumns {
+		var count int
+		err := conn.QueryRowContext(ctx, "SELECT COUNT(*) FROM information_schema.COLUMNS WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = ? AND COLUMN_NAME = 'folder_id'", c.table).Scan(&count)
+		if err != nil {
+			return err
+		}
+		if count > 0 {
+			continue
+		}
+		if _, err := conn.ExecContext(ctx, c.alter); err != nil {
+			return fmt.Errorf("%w\n%s", err, c.alter)
+		}
+	}
+	return nil
+}
+
+// Migration d'amorçage : créer le compte admin s'il n'existe pas encore (idempotent).
+// Le mot de passe vient de la configuration et ne fait pas partie de l'empreinte.
+func seedAdminUp(password string) func(ctx context.Context, conn *sql.Conn) error {
+	return func(ctx context.Context, conn *sql.Conn) error {
+		var count int
+		err := conn.QueryRowContext(ctx, "SELECT COUNT(*) FROM users WHERE username = ?", "admin").Scan(&count)
+		if err != nil || count > 0 {
+			return err
+		}
+
+		hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+		if err != nil {
+			return err
+		}
+		_, err = conn.ExecContext(ctx, "INSERT INTO users (username, password, role) VALUES (?, ?, ?)", "admin", hashedPassword, "admin")
+		return err
+	}
+}
+
+// Fonction pour charger les migrations SQL d'un système de fichiers
+func loadSQLMigrations(fsys fs.FS) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, "migrations")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[int]*Migration)
+	for _, entry := range entries {
+		match := migrationFileName.FindStringSubmatch(entry.Name())
+		if match == nil {
+			return nil, fmt.Errorf("nom de fichier de migration invalide : %s", entry.Name())
+		}
+		version, _ := strconv.Atoi(match[1])
+		content, err := fs.ReadFile(fsys, path.Join("migrations", entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+
+		migration, ok := byVersion[version]
+		if !ok {
+			migration = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = migration
+		}
+		if migration.Name != match[2] {
+			return nil, fmt.Errorf("migration %04d : noms différents pour up et down", version)
+		}
+		if match[3] == "up" {
+			migration.UpSQL = string(content)
+		} else {
+			migration.DownSQL = string(content)
+		}
+	}
+
+	var migrations []Migration
+	for _, migration := range byVersion {
+		if migration.UpSQL == "" {
+			return nil, fmt.Errorf("migration %04d : fichier up manquant", migration.Version)
+		}
+		migration.Checksum = checksum(migration.UpSQL + "\x00" + migration.DownSQL)
+		migrations = append(migrations, *migration)
+	}
+	return migrations, nil
+}
+
+// Fonction pour calculer l'empreinte SHA-256 d'une migration
+func checksum(content string) string {
+	sum := sha256.Sum256([]byte(content))
+	return hex.EncodeToString(sum[:])
+}
+
+// Fonction pour exécuter fn sur une connexion dédiée qui détient le verrou de migration
+func (m *Migrator) withLock(ctx context.Context, fn func(conn *sql.Conn) error) error {
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	// GET_LOCK est lié à la session : toutes les requêtes doivent passer par cette connexion
+	var acquired sql.NullInt64
+	err = conn.QueryRowContext(ctx, "SELECT GET_LOCK(?, ?)", migrationLockName, int(migrationLockTimeout.Seconds())).Scan(&acquired)
+	if err != nil {
+		return err
+	}
+	if !acquired.Valid || acquired.Int64 != 1 {
+		return errors.New("impossible d'obtenir le verrou de migration : une autre instance migre déjà")
+	}
+	defer conn.ExecContext(context.Background(), "DO RELEASE_LOCK(?)", migrationLockName)
+
+	_, err = conn.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+		version int NOT NULL,
+		name varchar(255) NOT NULL,
+		checksum char(64) NOT NULL,
+		applied_at timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY (version)
+	) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4`)
+	if err != nil {
+		return err
+	}
+
+	return fn(conn)
+}
+
+// Fonction pour croiser les migrations connues avec celles enregistrées en base
+func (m *Migrator) status(ctx context.Context, conn *sql.Conn) ([]MigrationStatus, error) {
+	rows, err := conn.QueryContext(ctx, "SELECT version, name, checksum, applied_at FROM schema_migrations ORDER BY version")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := make(map[int]MigrationStatus)
+	for rows.Next() {
+		var s MigrationStatus
+		var appliedAt []byte
+		if err := rows.Scan(&s.Version, &s.Name, &s.AppliedChecksum, &appliedAt); err != nil {
+			return nil, err
+		}
+		s.Applied = true
+		s.AppliedAt = parseDBTime(appliedAt)
+		applied[s.Version] = s
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var statuses []MigrationStatus
+	for _, migration := range m.migrations {
+		s := MigrationStatus{Migration: migration}
+		if a, ok := applied[migration.Version]; ok {
+			s.Applied, s.AppliedAt, s.AppliedChecksum = true, a.AppliedAt, a.AppliedChecksum
+			delete(applied, migration.Version)
+		}
+		statuses = append(statuses, s)
+	}
+	for _, a := range applied {
+		a.Missing = true
+		statuses = append(statuses, a)
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Version < statuses[j].Version })
+	return statuses, nil
+}
+
+// Status renvoie l'état de chaque migration
+func (m *Migrator) Status(ctx context.Context) ([]MigrationStatus, error) {
+	var statuses []MigrationStatus
+	err := m.withLock(ctx, func(conn *sql.Conn) error {
+		var err error
+		statuses, err = m.status(ctx, conn)
+		return err
+	})
+	return statuses, err
+}
+
+// Up applique toutes les migrations en attente et renvoie le nombre de migrations appliquées
+func (m *Migrator) Up(ctx context.Context) (int, error) {
+	count := 0
+	err := m.withLock(ctx, func(conn *sql.Conn) error {
+		statuses, err := m.status(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		// Refuser de migrer si une migration déjà appliquée a été modifiée
+		for _, s := range statuses {
+			if s.Modified() {
+				return fmt.Errorf("migration %04d_%s modifiée après application (empreinte %s, attendue %s)", s.Version, s.Name, s.Checksum, s.AppliedChecksum)
+			}
+		}
+
+		for _, s := range statuses {
+			if s.Applied {
+				continue
+			}
+			log.Printf("Application de la migration %04d_%s", s.Version, s.Name)
+			if err := runMigration(ctx, conn, s.UpSQL, s.UpFunc); err != nil {
+				return fmt.Errorf("migration %04d_%s : %w", s.Version, s.Name, err)
+			}
+			_, err := conn.ExecContext(ctx, "INSERT INTO schema_migrations (version, name, checksum) VALUES (?, ?, ?)", s.Version, s.Name, s.Checksum)
+			if err != nil {
+				return err
+			}
+			count++
+		}
+		return nil
+	})
+	return count, err
+}
+
+// Down annule les `steps` dernières migrations appliquées
+func (m *Migrator) Down(ctx context.Context, steps int) (int, error) {
+	count := 0
+	err := m.withLock(ctx, func(conn *sql.Conn) error {
+		statuses, err := m.status(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		for i := len(statuses) - 1; i >= 0 && count < steps; i-- {
+			s := statuses[i]
+			if !s.Applied {
+				continue
+			}
+			if s.Missing {
+				return fmt.Errorf("migration %04d_%s absente du binaire : impossible de l'annuler", s.Version, s.Name)
+			}
+			switch {
+			case s.DownSQL == "" && s.DownFunc == nil && s.UpFunc != nil:
+				// Migration d'amorçage sans annulation : on oublie simplement qu'elle a été appliquée
+				log.Printf("Migration %04d_%s sans script d'annulation, désenregistrée uniquement", s.Version, s.Name)
+			case s.DownSQL == "" && s.DownFunc == nil:
+				return fmt.Errorf("migration %04d_%s : pas de script down", s.Version, s.Name)
+			default:
+				log.Printf("Annulation de la migration %04d_%s", s.Version, s.Name)
+				if err := runMigration(ctx, conn, s.DownSQL, s.DownFunc); err != nil {
+					return fmt.Errorf("migration %04d_%s : %w", s.Version, s.Name, err)
+				}
+			}
+			if _, err := conn.ExecContext(ctx, "DELETE FROM schema_migrations WHERE version = ?", s.Version); err != nil {
+				return err
+			}
+			count++
+		}
+		return nil
+	})
+	return count, err
+}
+
+// Fonction pour exécuter une migration SQL ou Go sur la connexion verrouillée.
+// Attention : MySQL valide implicitement les instructions DDL, une migration interrompue
+// peut donc être partiellement appliquée ; les scripts utilisent IF (NOT) EXISTS autant que possible.
+func runMigration(ctx context.Context, conn *sql.Conn, script string, fn func(context.Context, *sql.Conn) error) error {
+	if fn != nil {
+		return fn(ctx, conn)
+	}
+	for _, statement := range splitSQLStatements(script) {
+		if _, err := conn.ExecContext(ctx, statement); err != nil {
+			return fmt.Errorf("%w\n%s", err, statement)
+		}
+	}
+	return nil
+}
+
+// Fonction pour découper un script SQL en instructions (en ignorant les ; dans les chaînes et commentaires)
+func splitSQLStatements(script string) []string {
+	var statements []string
+	var current strings.Builder
+	var quote byte
+	lineComment := false
+
+	flush := func() {
+		if statement := strings.TrimSpace(current.String()); statement != "" {
+			statements = append(statements, statement)
+		}
+		current.Reset()
+	}
+
+	for i := 0; i < len(script); i++ {
+		ch := script[i]
+		switch {
+		case lineComment:
+			if ch == '\n' {
+				lineComment = false
+				current.WriteByte(ch)
+			}
+			continue
+		case quote != 0:
+			current.WriteByte(ch)
+			if ch == '\\' && i+1 < len(script) {
+				i++
+				current.WriteByte(script[i])
+			} else if ch == quote {
+				quote = 0
+			}
+			continue
+		case ch == '-' && strings.HasPrefix(script[i:], "-- "), ch == '#':
+			lineComment = true
+			continue
+		case ch == '\'' || ch == '"' || ch == '`':
+			quote = ch
+		case ch == ';':
+			flush()
+			continue
+		}
+		current.WriteByte(ch)
+	}
+	flush()
+	return statements
+}
+
+// Fonction pour exécuter la commande `migrate status|up|down [n]`
+func runMigrateCommand(ctx context.Context, db *sql.DB, cfg Config, args []string) error {
+	migrator, err := NewMigrator(db, cfg)
+	if err != nil {
+		return err
+	}
+
+	action := "status"
+	if len(args) > 0 {
+		action = args[0]
+	}
+
+	switch action {
+	case "status":
+		statuses, err := migrator.Status(ctx)
+		if err != nil {
+			return err
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "VERSION\tNOM\tÉTAT\tAPPLIQUÉE LE")
+		for _, s := range statuses {
+			state, appliedAt := "en attente", ""
+			switch {
+			case s.Missing:
+				state = "absente du binaire"
+			case s.Modified():
+				state = "MODIFIÉE"
+			case s.Applied:
+				state = "appliquée"
+			}
+			if s.Applied {
+				appliedAt = s.AppliedAt.Format("2006-01-02 15:04:05")
+			}
+			fmt.Fprintf(w, "%04d\t%s\t%s\t%s\n", s.Version, s.Name, state, appliedAt)
+		}
+		return w.Flush()
+	case "up":
+		count, err := migrator.Up(ctx)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%d migration(s) appliquée(s)\n", count)
+		return nil
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			steps, err = strconv.Atoi(args[1])
+			if err != nil || steps < 1 {
+				return fmt.Errorf("nombre de migrations à annuler invalide : %s", args[1])
+			}
+		}
+		count, err := migrator.Down(ctx, steps)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%d migration(s) annulée(s)\n", count)
+		return nil
+	default:
+		return fmt.Errorf("usage : migrate status|up|down [n]")
+	}
+}
+
+// Fonction pour créer la base de données si elle n'existe pas (connexion sans base sélectionnée)
+func createDatabase(serverDSN, name string) error {
+	db, err := sql.Open("mysql", serverDSN)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	_, err = db.Exec("CREATE DATABASE IF NOT EXISTS `" + strings.ReplaceAll(name, "`", "``") + "`")
+	return err
+}
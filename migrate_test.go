@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"database/sql/driver"
+	"strings"
+	"testing"
+)
+
+// Fonction pour exécuter la migration folder_columns sur une base en mémoire dont information_schema
+// répond count pour chaque table
+func runFolderColumns(t *testing.T, count int64) []string {
+	tables := &fakeTables{tables: map[string][]map[string]driver.Value{
+		"information_schema.COLUMNS": {{"COUNT(*)": count}},
+	}}
+	db := tables.open()
+	defer db.Close()
+	conn, err := db.Conn(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if err := addFolderColumnsUp(context.Background(), conn); err != nil {
+		t.Fatal(err)
+	}
+	return tables.execs
+}
+
+func TestFolderColumnsOnBaselineDump(t *testing.T) {
+	// Base chargée depuis sauvergarde.sql : ni notes ni files n'ont folder_id
+	execs := runFolderColumns(t, 0)
+	if len(execs) != 2 {
+		t.Fatalf("instructions = %q, attendu un ALTER TABLE par table", execs)
+	}
+	for i, table := range []string{"notes", "files"} {
+		if !strings.HasPrefix(execs[i], "ALTER TABLE `"+table+"` ADD COLUMN `folder_id`") ||
+			!strings.Contains(execs[i], "ADD KEY") || !strings.Contains(execs[i], "FOREIGN KEY (`folder_id`) REFERENCES `folders` (`id`)") {
+			t.Fatalf("instruction %d = %q", i, execs[i])
+		}
+	}
+}
+
+func TestFolderColumnsAlreadyPresent(t *testing.T) {
+	if execs := runFolderColumns(t, 1); len(execs) != 0 {
+		t.Fatalf("instructions = %q, attendu aucune", execs)
+	}
+}
+
+func TestBaselineSchemaMatchesDump(t *testing.T) {
+	// La migration 0001 reste le schéma de l'ancien dump : folder_id arrive par la migration 0020
+	migrations, err := loadSQLMigrations(migrationFiles)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, m := range migrations {
+		if m.Version != 1 {
+			continue
+		}
+		for _, statement := range splitSQLStatements(m.UpSQL) {
+			if (strings.Contains(statement, "`notes`") || strings.Contains(statement, "`files`")) && strings.Contains(statement, "folder_id") {
+				t.Fatalf("folder_id dans le schéma initial :\n%s", statement)
+			}
+		}
+		return
+	}
+	t.Fatal("migration 0001 introuvable")
+}
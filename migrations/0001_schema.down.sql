@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS `files`;
+DROP TABLE IF EXISTS `notes`;
+DROP TABLE IF EXISTS `folders`;
+DROP TABLE IF EXISTS `users`;
@@ -0,0 +1,3 @@
+ALTER TABLE `files`
+  DROP INDEX `files_storage_key`,
+  DROP COLUMN `storage_key`;
@@ -0,0 +1,6 @@
+-- Clé de stockage opaque de chaque fichier : le nom d'origine n'est plus qu'une métadonnée d'affichage.
+-- Les lignes existantes gardent storage_key NULL jusqu'au passage de `storage reconcile`.
+
+ALTER TABLE `files`
+  ADD COLUMN `storage_key` char(32) DEFAULT NULL AFTER `filename`,
+  ADD UNIQUE KEY `files_storage_key` (`storage_key`);
@@ -0,0 +1,4 @@
+ALTER TABLE `files`
+  DROP COLUMN `wrapped_key`;
+
+DROP TABLE IF EXISTS `user_keys`;
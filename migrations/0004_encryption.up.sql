@@ -0,0 +1,18 @@
+-- Chiffrement au repos : clé de chaque utilisateur (protégée par son mot de passe et la clé maîtresse)
+-- et clé de données de chaque fichier (protégée par la clé de son propriétaire).
+-- Les fichiers existants gardent wrapped_key NULL (en clair) jusqu'à la prochaine connexion de leur propriétaire.
+
+CREATE TABLE IF NOT EXISTS `user_keys` (
+  `user_id` int NOT NULL,
+  `kdf_salt` varbinary(32) NOT NULL,
+  `kdf_time` int unsigned NOT NULL,
+  `kdf_memory` int unsigned NOT NULL,
+  `kdf_threads` tinyint unsigned NOT NULL,
+  `wrapped_key` varbinary(255) NOT NULL,
+  `updated_at` timestamp NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+  PRIMARY KEY (`user_id`),
+  CONSTRAINT `user_keys_ibfk_1` FOREIGN KEY (`user_id`) REFERENCES `users` (`ID`) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_0900_ai_ci;
+
+ALTER TABLE `files`
+  ADD COLUMN `wrapped_key` varbinary(255) DEFAULT NULL AFTER `storage_key`;
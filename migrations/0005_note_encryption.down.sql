@@ -0,0 +1,7 @@
+-- Attention : les notes déjà chiffrées sont perdues (le serveur ne peut pas les déchiffrer sans la clé de leur propriétaire).
+DELETE FROM `notes` WHERE `wrapped_key` IS NOT NULL;
+
+ALTER TABLE `notes`
+  DROP COLUMN `sealed_content`,
+  DROP COLUMN `sealed_title`,
+  DROP COLUMN `wrapped_key`;
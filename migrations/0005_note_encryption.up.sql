@@ -0,0 +1,9 @@
+-- Chiffrement des notes avec la même hiérarchie de clés que les fichiers :
+-- chaque note a sa clé de données, protégée par la clé de son propriétaire.
+-- Les notes existantes gardent title/content en clair (wrapped_key NULL) jusqu'à la prochaine connexion
+-- de leur propriétaire, puis ces colonnes sont vidées.
+
+ALTER TABLE `notes`
+  ADD COLUMN `wrapped_key` varbinary(255) DEFAULT NULL AFTER `title`,
+  ADD COLUMN `sealed_title` blob AFTER `wrapped_key`,
+  ADD COLUMN `sealed_content` mediumblob AFTER `sealed_title`;
@@ -0,0 +1,18 @@
+-- Envois reprenables (protocole tus) : un envoi n'entre dans `files` qu'une fois complet.
+-- Les morceaux reçus sont gardés chiffrés dans storage.staging_dir/<id>/ ; `upload_offset` est la référence.
+
+CREATE TABLE IF NOT EXISTS `uploads` (
+  `id` char(32) NOT NULL,
+  `user_id` int NOT NULL,
+  `filename` varchar(255) NOT NULL,
+  `folder_id` int DEFAULT NULL,
+  `upload_length` bigint NOT NULL,
+  `upload_offset` bigint NOT NULL DEFAULT 0,
+  `wrapped_key` varbinary(255) NOT NULL,
+  `expires_at` timestamp NOT NULL,
+  `created_at` timestamp NULL DEFAULT CURRENT_TIMESTAMP,
+  PRIMARY KEY (`id`),
+  KEY `uploads_expires_at` (`expires_at`),
+  CONSTRAINT `uploads_ibfk_1` FOREIGN KEY (`user_id`) REFERENCES `users` (`ID`) ON DELETE CASCADE,
+  CONSTRAINT `uploads_ibfk_2` FOREIGN KEY (`folder_id`) REFERENCES `folders` (`id`) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_0900_ai_ci;
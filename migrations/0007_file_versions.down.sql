@@ -0,0 +1,5 @@
+ALTER TABLE `users`
+  DROP COLUMN `version_days`,
+  DROP COLUMN `version_limit`;
+
+DROP TABLE IF EXISTS `file_versions`;
@@ -0,0 +1,20 @@
+-- Historique des versions : un nouvel envoi sous le même nom, dans le même dossier, remplace le contenu
+-- de la ligne `files` (version courante) et archive l'ancien contenu ici, avec sa propre clé de données.
+
+CREATE TABLE IF NOT EXISTS `file_versions` (
+  `id` int NOT NULL AUTO_INCREMENT,
+  `file_id` int NOT NULL,
+  `storage_key` char(32) NOT NULL,
+  `wrapped_key` varbinary(255) DEFAULT NULL,
+  `uploaded_at` timestamp NULL DEFAULT NULL,
+  `replaced_at` timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  PRIMARY KEY (`id`),
+  UNIQUE KEY `file_versions_storage_key` (`storage_key`),
+  KEY `file_versions_file_id` (`file_id`, `replaced_at`),
+  CONSTRAINT `file_versions_ibfk_1` FOREIGN KEY (`file_id`) REFERENCES `files` (`id`) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_0900_ai_ci;
+
+-- Politique de conservation propre à chaque utilisateur (NULL : valeurs par défaut de la configuration)
+ALTER TABLE `users`
+  ADD COLUMN `version_limit` int DEFAULT NULL,
+  ADD COLUMN `version_days` int DEFAULT NULL;
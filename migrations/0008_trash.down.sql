@@ -0,0 +1,18 @@
+-- Attention : les éléments encore dans la corbeille redeviennent visibles.
+ALTER TABLE `files`
+  DROP KEY `files_deleted_with`,
+  DROP KEY `files_deleted_at`,
+  DROP COLUMN `deleted_with`,
+  DROP COLUMN `deleted_at`;
+
+ALTER TABLE `notes`
+  DROP KEY `notes_deleted_with`,
+  DROP KEY `notes_deleted_at`,
+  DROP COLUMN `deleted_with`,
+  DROP COLUMN `deleted_at`;
+
+ALTER TABLE `folders`
+  DROP KEY `folders_deleted_with`,
+  DROP KEY `folders_deleted_at`,
+  DROP COLUMN `deleted_with`,
+  DROP COLUMN `deleted_at`;
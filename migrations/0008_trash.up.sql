@@ -0,0 +1,21 @@
+-- Corbeille : une note, un fichier ou un dossier supprimé garde sa ligne avec `deleted_at` renseigné
+-- jusqu'à sa restauration ou sa purge. Le contenu d'un dossier mis à la corbeille porte l'ID de ce dossier
+-- dans `deleted_with` : il est restauré ou purgé avec lui et n'apparaît pas seul dans la corbeille.
+
+ALTER TABLE `folders`
+  ADD COLUMN `deleted_at` timestamp NULL DEFAULT NULL,
+  ADD COLUMN `deleted_with` int DEFAULT NULL,
+  ADD KEY `folders_deleted_at` (`deleted_at`),
+  ADD KEY `folders_deleted_with` (`deleted_with`);
+
+ALTER TABLE `notes`
+  ADD COLUMN `deleted_at` timestamp NULL DEFAULT NULL,
+  ADD COLUMN `deleted_with` int DEFAULT NULL,
+  ADD KEY `notes_deleted_at` (`deleted_at`),
+  ADD KEY `notes_deleted_with` (`deleted_with`);
+
+ALTER TABLE `files`
+  ADD COLUMN `deleted_at` timestamp NULL DEFAULT NULL,
+  ADD COLUMN `deleted_with` int DEFAULT NULL,
+  ADD KEY `files_deleted_at` (`deleted_at`),
+  ADD KEY `files_deleted_with` (`deleted_with`);
@@ -0,0 +1,9 @@
+ALTER TABLE `users`
+  DROP COLUMN `storage_quota`,
+  DROP COLUMN `storage_used`;
+
+ALTER TABLE `file_versions`
+  DROP COLUMN `size`;
+
+ALTER TABLE `files`
+  DROP COLUMN `size`;
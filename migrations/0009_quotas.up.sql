@@ -0,0 +1,14 @@
+-- Quotas de stockage : taille en clair de chaque contenu et compteur d'occupation par utilisateur.
+-- Le compteur inclut les fichiers courants, leurs anciennes versions, la corbeille et les envois reprenables
+-- en cours (réservés pour leur taille annoncée). Les tailles des lignes existantes sont calculées au démarrage.
+
+ALTER TABLE `files`
+  ADD COLUMN `size` bigint DEFAULT NULL AFTER `wrapped_key`;
+
+ALTER TABLE `file_versions`
+  ADD COLUMN `size` bigint DEFAULT NULL AFTER `wrapped_key`;
+
+-- `storage_quota` NULL : quota du rôle de l'utilisateur (configuration quotas.roles_mb)
+ALTER TABLE `users`
+  ADD COLUMN `storage_used` bigint NOT NULL DEFAULT 0,
+  ADD COLUMN `storage_quota` bigint DEFAULT NULL;
@@ -0,0 +1,12 @@
+-- Échoue tant que des lignes partagent une clé de stockage ou portent une clé de 64 caractères
+ALTER TABLE `file_versions`
+  DROP INDEX `file_versions_storage_key`,
+  MODIFY COLUMN `storage_key` char(32) NOT NULL,
+  ADD UNIQUE KEY `file_versions_storage_key` (`storage_key`);
+
+ALTER TABLE `files`
+  DROP INDEX `files_storage_key`,
+  MODIFY COLUMN `storage_key` char(32) DEFAULT NULL,
+  ADD UNIQUE KEY `files_storage_key` (`storage_key`);
+
+DROP TABLE IF EXISTS `blobs`;
@@ -0,0 +1,32 @@
+-- Déduplication : un blob est désigné par l'empreinte de son contenu (clé de 64 caractères hexadécimaux)
+-- et partagé par toutes les lignes de `files` et `file_versions` qui ont ce contenu.
+-- `ref_count` compte ces lignes ; `stored` indique que le contenu a bien été écrit dans le stockage.
+-- Une référence est prise avant l'écriture du contenu : un blob sans ligne ici n'appartient à aucun envoi en cours.
+
+CREATE TABLE IF NOT EXISTS `blobs` (
+  `storage_key` char(64) NOT NULL,
+  `wrapped_key` varbinary(255) DEFAULT NULL,
+  `ref_count` int NOT NULL DEFAULT 0,
+  `stored` tinyint(1) NOT NULL DEFAULT 0,
+  `created_at` timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  PRIMARY KEY (`storage_key`),
+  KEY `blobs_ref_count` (`ref_count`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_0900_ai_ci;
+
+-- Les contenus existants ont chacun leur propre clé aléatoire : une référence par ligne
+INSERT INTO `blobs` (`storage_key`, `wrapped_key`, `ref_count`, `stored`)
+  SELECT `storage_key`, `wrapped_key`, 1, TRUE FROM `files` WHERE `storage_key` IS NOT NULL;
+INSERT INTO `blobs` (`storage_key`, `wrapped_key`, `ref_count`, `stored`)
+  SELECT `storage_key`, `wrapped_key`, 1, TRUE FROM `file_versions`
+  ON DUPLICATE KEY UPDATE `ref_count` = `blobs`.`ref_count` + 1;
+
+-- Plusieurs lignes peuvent désormais partager une clé de stockage
+ALTER TABLE `files`
+  MODIFY COLUMN `storage_key` char(64) DEFAULT NULL,
+  DROP INDEX `files_storage_key`,
+  ADD KEY `files_storage_key` (`storage_key`);
+
+ALTER TABLE `file_versions`
+  MODIFY COLUMN `storage_key` char(64) NOT NULL,
+  DROP INDEX `file_versions_storage_key`,
+  ADD KEY `file_versions_storage_key` (`storage_key`);
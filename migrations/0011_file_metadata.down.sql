@@ -0,0 +1,19 @@
+ALTER TABLE `uploads`
+  DROP COLUMN `declared_type`;
+
+ALTER TABLE `file_versions`
+  DROP COLUMN `taken_at`,
+  DROP COLUMN `height`,
+  DROP COLUMN `width`,
+  DROP COLUMN `declared_type`,
+  DROP COLUMN `mime_type`,
+  DROP COLUMN `sha256`;
+
+ALTER TABLE `files`
+  DROP KEY `files_mime_type`,
+  DROP COLUMN `taken_at`,
+  DROP COLUMN `height`,
+  DROP COLUMN `width`,
+  DROP COLUMN `declared_type`,
+  DROP COLUMN `mime_type`,
+  DROP COLUMN `sha256`;
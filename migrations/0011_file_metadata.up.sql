@@ -0,0 +1,24 @@
+-- Métadonnées des contenus, relevées à l'envoi : empreinte SHA-256 du contenu en clair, type MIME reconnu
+-- d'après les premiers octets et type annoncé par le client, dimensions et date de prise de vue (EXIF) des images.
+-- Les contenus existants sont décrits à la prochaine connexion de leur propriétaire (il faut sa clé pour les lire).
+
+ALTER TABLE `files`
+  ADD COLUMN `sha256` char(64) DEFAULT NULL AFTER `size`,
+  ADD COLUMN `mime_type` varchar(255) DEFAULT NULL AFTER `sha256`,
+  ADD COLUMN `declared_type` varchar(255) DEFAULT NULL AFTER `mime_type`,
+  ADD COLUMN `width` int DEFAULT NULL AFTER `declared_type`,
+  ADD COLUMN `height` int DEFAULT NULL AFTER `width`,
+  ADD COLUMN `taken_at` datetime DEFAULT NULL AFTER `height`,
+  ADD KEY `files_mime_type` (`user_id`, `mime_type`);
+
+ALTER TABLE `file_versions`
+  ADD COLUMN `sha256` char(64) DEFAULT NULL AFTER `size`,
+  ADD COLUMN `mime_type` varchar(255) DEFAULT NULL AFTER `sha256`,
+  ADD COLUMN `declared_type` varchar(255) DEFAULT NULL AFTER `mime_type`,
+  ADD COLUMN `width` int DEFAULT NULL AFTER `declared_type`,
+  ADD COLUMN `height` int DEFAULT NULL AFTER `width`,
+  ADD COLUMN `taken_at` datetime DEFAULT NULL AFTER `height`;
+
+-- Type annoncé à la création d'un envoi reprenable, reporté sur le fichier à la fin de l'envoi
+ALTER TABLE `uploads`
+  ADD COLUMN `declared_type` varchar(255) DEFAULT NULL AFTER `filename`;
@@ -0,0 +1,5 @@
+DROP TABLE IF EXISTS `scrub_issues`;
+DROP TABLE IF EXISTS `scrub_runs`;
+
+ALTER TABLE `blobs`
+  DROP COLUMN `checksum`;
@@ -0,0 +1,32 @@
+-- Vérification d'intégrité du stockage : empreinte SHA-256 des octets stockés de chaque blob (chiffrés),
+-- relevée à l'écriture, ou au premier passage du vérificateur pour les blobs plus anciens.
+
+ALTER TABLE `blobs`
+  ADD COLUMN `checksum` char(64) DEFAULT NULL AFTER `stored`;
+
+-- Bilan de chaque passage du vérificateur, affiché sur la page d'administration
+CREATE TABLE IF NOT EXISTS `scrub_runs` (
+  `id` int NOT NULL AUTO_INCREMENT,
+  `started_at` timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  `finished_at` timestamp NULL DEFAULT NULL,
+  `checked` int NOT NULL DEFAULT 0,
+  `missing` int NOT NULL DEFAULT 0,
+  `corrupt` int NOT NULL DEFAULT 0,
+  `orphans` int NOT NULL DEFAULT 0,
+  `unreconciled` int NOT NULL DEFAULT 0,
+  `repaired` int NOT NULL DEFAULT 0,
+  `error` varchar(1024) DEFAULT NULL,
+  PRIMARY KEY (`id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_0900_ai_ci;
+
+CREATE TABLE IF NOT EXISTS `scrub_issues` (
+  `id` int NOT NULL AUTO_INCREMENT,
+  `run_id` int NOT NULL,
+  `kind` varchar(32) NOT NULL,
+  `storage_key` varchar(64) DEFAULT NULL,
+  `detail` varchar(1024) NOT NULL,
+  `repaired` tinyint(1) NOT NULL DEFAULT 0,
+  PRIMARY KEY (`id`),
+  KEY `scrub_issues_run_id` (`run_id`),
+  CONSTRAINT `scrub_issues_ibfk_1` FOREIGN KEY (`run_id`) REFERENCES `scrub_runs` (`id`) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_0900_ai_ci;
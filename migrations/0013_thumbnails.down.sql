@@ -0,0 +1,4 @@
+ALTER TABLE `blobs`
+  DROP COLUMN `page_count`,
+  DROP COLUMN `thumbnail_key`,
+  DROP COLUMN `thumbnail`;
@@ -0,0 +1,9 @@
+-- Vignettes des images et des PDF, générées en arrière-plan après l'envoi. Une vignette appartient au blob :
+-- les fichiers au contenu identique la partagent, et elle est effacée avec lui.
+-- thumbnail : NULL tant qu'elle n'est pas générée, 'image' (aperçu chiffré sous thumbnail_key),
+-- 'pdf' (icône et nombre de pages) ou 'none' (contenu illisible ou trop grand).
+
+ALTER TABLE `blobs`
+  ADD COLUMN `thumbnail` varchar(8) DEFAULT NULL AFTER `checksum`,
+  ADD COLUMN `thumbnail_key` char(64) DEFAULT NULL AFTER `thumbnail`,
+  ADD COLUMN `page_count` int DEFAULT NULL AFTER `thumbnail_key`;
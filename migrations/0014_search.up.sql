@@ -0,0 +1,33 @@
+-- Index de recherche des notes et des fichiers. Les notes et les contenus sont chiffrés : l'index ne garde
+-- aucun mot en clair, seulement un HMAC de chaque mot et de chacun de ses préfixes, calculé avec une clé
+-- dérivée de celle de l'utilisateur (deux utilisateurs n'ont donc pas les mêmes termes pour un même mot).
+-- Le texte extrait d'un fichier est gardé chiffré avec la clé de données du fichier pour construire les extraits.
+-- Un document disparaît avec sa note ou son fichier (ON DELETE CASCADE), donc à la purge de la corbeille.
+
+CREATE TABLE IF NOT EXISTS `search_documents` (
+  `id` int NOT NULL AUTO_INCREMENT,
+  `user_id` int NOT NULL,
+  `note_id` int DEFAULT NULL,
+  `file_id` int DEFAULT NULL,
+  `storage_key` char(64) DEFAULT NULL,
+  `length` int NOT NULL DEFAULT 0,
+  `sealed_text` mediumblob,
+  `indexed_at` timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  PRIMARY KEY (`id`),
+  UNIQUE KEY `search_documents_note_id` (`note_id`),
+  UNIQUE KEY `search_documents_file_id` (`file_id`),
+  KEY `search_documents_user_id` (`user_id`),
+  CONSTRAINT `search_documents_ibfk_1` FOREIGN KEY (`user_id`) REFERENCES `users` (`ID`) ON DELETE CASCADE,
+  CONSTRAINT `search_documents_ibfk_2` FOREIGN KEY (`note_id`) REFERENCES `notes` (`id`) ON DELETE CASCADE,
+  CONSTRAINT `search_documents_ibfk_3` FOREIGN KEY (`file_id`) REFERENCES `files` (`id`) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_0900_ai_ci;
+
+-- frequency : occurrences pondérées du terme (un mot du titre ou du nom de fichier compte triple)
+CREATE TABLE IF NOT EXISTS `search_terms` (
+  `document_id` int NOT NULL,
+  `term` binary(16) NOT NULL,
+  `frequency` int NOT NULL,
+  PRIMARY KEY (`document_id`, `term`),
+  KEY `search_terms_term` (`term`),
+  CONSTRAINT `search_terms_ibfk_1` FOREIGN KEY (`document_id`) REFERENCES `search_documents` (`id`) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_0900_ai_ci;
@@ -0,0 +1,6 @@
+ALTER TABLE `file_versions`
+  DROP COLUMN `quarantine`;
+
+ALTER TABLE `files`
+  DROP KEY `files_quarantine`,
+  DROP COLUMN `quarantine`;
@@ -0,0 +1,9 @@
+-- Quarantaine des contenus suspects : raison de la mise en quarantaine (NULL : contenu servi normalement).
+-- Un contenu en quarantaine reste dans le coffre mais n'est plus servi, jusqu'à sa levée par un administrateur.
+
+ALTER TABLE `files`
+  ADD COLUMN `quarantine` varchar(255) DEFAULT NULL AFTER `taken_at`,
+  ADD KEY `files_quarantine` (`quarantine`);
+
+ALTER TABLE `file_versions`
+  ADD COLUMN `quarantine` varchar(255) DEFAULT NULL AFTER `taken_at`;
@@ -0,0 +1,6 @@
+DROP TABLE IF EXISTS `notifications`;
+
+ALTER TABLE `blobs`
+  DROP COLUMN `infection`,
+  DROP COLUMN `scanned_at`,
+  DROP COLUMN `scan_signatures`;
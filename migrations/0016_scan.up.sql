@@ -0,0 +1,20 @@
+-- Analyse antivirus : le verdict appartient au blob, partagé par les fichiers et versions au contenu identique.
+-- scan_signatures : version des signatures utilisées pour la dernière analyse (NULL : jamais analysé) ;
+-- un contenu analysé avec d'anciennes signatures est réanalysé. infection : menace reconnue (NULL : sain).
+
+ALTER TABLE `blobs`
+  ADD COLUMN `scan_signatures` varchar(64) DEFAULT NULL AFTER `page_count`,
+  ADD COLUMN `scanned_at` datetime DEFAULT NULL AFTER `scan_signatures`,
+  ADD COLUMN `infection` varchar(255) DEFAULT NULL AFTER `scanned_at`;
+
+-- Messages adressés à un utilisateur (contenu infecté...), affichés sur sa page d'accueil jusqu'à ce qu'il les lise
+CREATE TABLE IF NOT EXISTS `notifications` (
+  `id` int NOT NULL AUTO_INCREMENT,
+  `user_id` int NOT NULL,
+  `message` varchar(1024) NOT NULL,
+  `created_at` timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  `read_at` datetime DEFAULT NULL,
+  PRIMARY KEY (`id`),
+  KEY `notifications_user_id` (`user_id`, `read_at`),
+  CONSTRAINT `notifications_ibfk_1` FOREIGN KEY (`user_id`) REFERENCES `users` (`ID`) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_0900_ai_ci;
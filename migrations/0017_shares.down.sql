@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS `share_keys`;
+DROP TABLE IF EXISTS `shares`;
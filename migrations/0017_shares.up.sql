@@ -0,0 +1,34 @@
+-- Liens de partage publics d'un fichier ou d'un dossier. Seule l'empreinte SHA-256 du jeton est enregistrée.
+-- Chaque lien a sa propre clé, qui protège une copie des clés de données des contenus partagés (share_keys) :
+-- sealed_key la garde chiffrée par une clé tirée du jeton (accès public), owner_key par la clé du propriétaire
+-- (ajout des contenus déposés plus tard dans un dossier partagé).
+
+CREATE TABLE IF NOT EXISTS `shares` (
+  `id` int NOT NULL AUTO_INCREMENT,
+  `user_id` int NOT NULL,
+  `file_id` int DEFAULT NULL,
+  `folder_id` int DEFAULT NULL,
+  `token_hash` binary(32) NOT NULL,
+  `sealed_key` varbinary(255) NOT NULL,
+  `owner_key` varbinary(255) NOT NULL,
+  `password` varchar(255) DEFAULT NULL,
+  `download_only` tinyint(1) NOT NULL DEFAULT 0,
+  `max_downloads` int DEFAULT NULL,
+  `downloads` int NOT NULL DEFAULT 0,
+  `expires_at` datetime DEFAULT NULL,
+  `created_at` timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  PRIMARY KEY (`id`),
+  UNIQUE KEY `shares_token_hash` (`token_hash`),
+  KEY `shares_user_id` (`user_id`),
+  CONSTRAINT `shares_ibfk_1` FOREIGN KEY (`user_id`) REFERENCES `users` (`ID`) ON DELETE CASCADE,
+  CONSTRAINT `shares_ibfk_2` FOREIGN KEY (`file_id`) REFERENCES `files` (`id`) ON DELETE CASCADE,
+  CONSTRAINT `shares_ibfk_3` FOREIGN KEY (`folder_id`) REFERENCES `folders` (`id`) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_0900_ai_ci;
+
+CREATE TABLE IF NOT EXISTS `share_keys` (
+  `share_id` int NOT NULL,
+  `storage_key` char(64) NOT NULL,
+  `wrapped_key` varbinary(255) NOT NULL,
+  PRIMARY KEY (`share_id`, `storage_key`),
+  CONSTRAINT `share_keys_ibfk_1` FOREIGN KEY (`share_id`) REFERENCES `shares` (`id`) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_0900_ai_ci;
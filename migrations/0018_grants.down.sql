@@ -0,0 +1,6 @@
+DROP TABLE IF EXISTS `grant_keys`;
+DROP TABLE IF EXISTS `grants`;
+
+ALTER TABLE `user_keys`
+  DROP COLUMN `private_key`,
+  DROP COLUMN `public_key`;
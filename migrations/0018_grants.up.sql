@@ -0,0 +1,40 @@
+-- Paire de clés X25519 de chaque utilisateur : la clé publique sert à lui confier la clé d'un accès accordé,
+-- la clé privée est chiffrée par sa clé utilisateur (créée à la connexion pour les comptes existants).
+ALTER TABLE `user_keys`
+  ADD COLUMN `public_key` varbinary(32) DEFAULT NULL AFTER `wrapped_key`,
+  ADD COLUMN `private_key` varbinary(255) DEFAULT NULL AFTER `public_key`;
+
+-- Accès accordés par un utilisateur à un autre sur un fichier, une note ou un dossier (hérité par tout son contenu).
+-- access : 0 lecture, 1 écriture, 2 gestion. Chaque accès a sa propre clé, qui protège une copie des clés de données
+-- des contenus couverts (grant_keys, indexée par l'empreinte SHA-256 de la clé de données protégée du propriétaire) :
+-- owner_key la garde chiffrée par la clé du propriétaire, grantee_key par la clé publique du bénéficiaire.
+CREATE TABLE IF NOT EXISTS `grants` (
+  `id` int NOT NULL AUTO_INCREMENT,
+  `owner_id` int NOT NULL,
+  `grantee_id` int NOT NULL,
+  `file_id` int DEFAULT NULL,
+  `note_id` int DEFAULT NULL,
+  `folder_id` int DEFAULT NULL,
+  `access` tinyint unsigned NOT NULL DEFAULT 0,
+  `owner_key` varbinary(255) NOT NULL,
+  `grantee_key` varbinary(255) NOT NULL,
+  `created_at` timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  PRIMARY KEY (`id`),
+  UNIQUE KEY `grants_grantee_file` (`grantee_id`, `file_id`),
+  UNIQUE KEY `grants_grantee_note` (`grantee_id`, `note_id`),
+  UNIQUE KEY `grants_grantee_folder` (`grantee_id`, `folder_id`),
+  KEY `grants_owner_id` (`owner_id`),
+  CONSTRAINT `grants_ibfk_1` FOREIGN KEY (`owner_id`) REFERENCES `users` (`ID`) ON DELETE CASCADE,
+  CONSTRAINT `grants_ibfk_2` FOREIGN KEY (`grantee_id`) REFERENCES `users` (`ID`) ON DELETE CASCADE,
+  CONSTRAINT `grants_ibfk_3` FOREIGN KEY (`file_id`) REFERENCES `files` (`id`) ON DELETE CASCADE,
+  CONSTRAINT `grants_ibfk_4` FOREIGN KEY (`note_id`) REFERENCES `notes` (`id`) ON DELETE CASCADE,
+  CONSTRAINT `grants_ibfk_5` FOREIGN KEY (`folder_id`) REFERENCES `folders` (`id`) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_0900_ai_ci;
+
+CREATE TABLE IF NOT EXISTS `grant_keys` (
+  `grant_id` int NOT NULL,
+  `key_hash` binary(32) NOT NULL,
+  `wrapped_key` varbinary(255) NOT NULL,
+  PRIMARY KEY (`grant_id`, `key_hash`),
+  CONSTRAINT `grant_keys_ibfk_1` FOREIGN KEY (`grant_id`) REFERENCES `grants` (`id`) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_0900_ai_ci;
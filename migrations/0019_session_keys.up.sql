@@ -0,0 +1,14 @@
+-- Copies de la clé utilisateur des sessions ouvertes, gardées côté serveur. Le cookie ne contient qu'un jeton
+-- aléatoire : id est son empreinte SHA-256, et wrapped_key la clé utilisateur chiffrée par une clé dérivée du jeton
+-- puis par la clé maîtresse. La déconnexion, le changement de mot de passe et l'expiration suppriment la ligne.
+CREATE TABLE IF NOT EXISTS `session_keys` (
+  `id` binary(32) NOT NULL,
+  `user_id` int NOT NULL,
+  `wrapped_key` varbinary(255) NOT NULL,
+  `created_at` timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  `expires_at` datetime NOT NULL,
+  PRIMARY KEY (`id`),
+  KEY `session_keys_user_id` (`user_id`),
+  KEY `session_keys_expires_at` (`expires_at`),
+  CONSTRAINT `session_keys_ibfk_1` FOREIGN KEY (`user_id`) REFERENCES `users` (`ID`) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_0900_ai_ci;
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+)
+
+// Erreur renvoyée quand la note demandée n'existe pas
+var errNoteNotFound = errors.New("note introuvable")
+
+// Définir une structure pour représenter une note
+type Note struct {
+	ID       int           // ID de la note
+	UserID   int           // ID du propriétaire
+	Title    string        // Titre de la note (en clair, jamais enregistré pour une note chiffrée)
+	Content  string        // Contenu de la note (idem)
+	FolderID sql.NullInt64 // Dossier contenant la note (NULL pour la racine)
+
+	WrappedKey    []byte // Clé de données protégée par la clé du propriétaire (nil pour une ancienne note en clair)
+	SealedTitle   []byte // Titre chiffré avec la clé de données
+	SealedContent []byte // Contenu chiffré avec la clé de données
+}
+
+// NoteRepo regroupe les requêtes sur la table notes (les notes à la corbeille sont ignorées, voir TrashRepo)
+type NoteRepo struct {
+	db *sql.DB
+}
+
+// Fonction pour lister les notes d'un utilisateur dans un dossier (ou à la racine)
+func (r *NoteRepo) ListByFolder(ctx context.Context, userID int, folderID sql.NullInt64) ([]Note, error) {
+	rows, err := r.db.QueryContext(ctx, "SELECT id, user_id, title, content, wrapped_key, sealed_title, sealed_content, folder_id FROM notes WHERE user_id = ? AND folder_id <=> ? AND deleted_at IS NULL", userID, folderID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var notes []Note
+	for rows.Next() {
+		var note Note
+		var title, content sql.NullString
+		if err := rows.Scan(&note.ID, &note.UserID, &title, &content, &note.WrappedKey, &note.SealedTitle, &note.SealedContent, &note.FolderID); err != nil {
+			return nil, err
+		}
+		note.Title, note.Content = title.String, content.String
+		notes = append(notes, note)
+	}
+	return notes, rows.Err()
+}
+
+// Fonction pour créer une note déjà chiffrée (seules les colonnes chiffrées sont écrites)
+func (r *NoteRepo) Create(ctx context.Context, note Note) (int64, error) {
+	result, err := r.db.ExecContext(ctx, "INSERT INTO notes (user_id, wrapped_key, sealed_title, sealed_content, folder_id) VALUES (?, ?, ?, ?, ?)",
+		note.UserID, note.WrappedKey, note.SealedTitle, note.SealedContent, note.FolderID)
+	if err != nil {
+		return 0, err
+	}
+	return result.LastInsertId()
+}
+
+// Fonction pour récupérer une note par son ID, quel que soit son propriétaire (contrôle fait par l'Authorizer)
+func (r *NoteRepo) Get(ctx context.Context, noteID int64) (Note, error) {
+	var note Note
+	var userID sql.NullInt64
+	var title, content sql.NullString
+	err := r.db.QueryRowContext(ctx, "SELECT id, user_id, title, content, wrapped_key, sealed_title, sealed_content, folder_id FROM notes WHERE id = ? AND deleted_at IS NULL", noteID).
+		Scan(&note.ID, &userID, &title, &content, &note.WrappedKey, &note.SealedTitle, &note.SealedContent, &note.FolderID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return note, errNoteNotFound
+	}
+	note.UserID, note.Title, note.Content = int(userID.Int64), title.String, content.String
+	return note, err
+}
+
+// Fonction pour ranger une note de son propriétaire dans un dossier
+func (r *NoteRepo) MoveToFolder(ctx context.Context, ownerID int, noteID int64, folderID sql.NullInt64) error {
+	_, err := r.db.ExecContext(ctx, "UPDATE notes SET folder_id = ? WHERE id = ? AND user_id = ?", folderID, noteID, ownerID)
+	return err
+}
+
+// Fonction pour lister les notes encore en clair d'un utilisateur (créées avant le chiffrement)
+func (r *NoteRepo) ListPlaintext(ctx context.Context, userID int) ([]Note, error) {
+	rows, err := r.db.QueryContext(ctx, "SELECT id, user_id, title, content FROM notes WHERE user_id = ? AND wrapped_key IS NULL", userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var notes []Note
+	for rows.Next() {
+		var note Note
+		var title, content sql.NullString
+		if err := rows.Scan(&note.ID, &note.UserID, &title, &content); err != nil {
+			return nil, err
+		}
+		note.Title, note.Content = title.String, content.String
+		notes = append(notes, note)
+	}
+	return notes, rows.Err()
+}
+
+// Fonction pour remplacer le titre et le contenu en clair d'une note par leur version chiffrée
+func (r *NoteRepo) SetEncrypted(ctx context.Context, note Note) error {
+	_, err := r.db.ExecContext(ctx, "UPDATE notes SET title = NULL, content = NULL, wrapped_key = ?, sealed_title = ?, sealed_content = ? WHERE id = ? AND wrapped_key IS NULL",
+		note.WrappedKey, note.SealedTitle, note.SealedContent, note.ID)
+	return err
+}
+
+// Fonction pour lister les notes chiffrées d'un utilisateur absentes de l'index de recherche
+func (r *NoteRepo) ListUnindexed(ctx context.Context, userID int) ([]Note, error) {
+	rows, err := r.db.QueryContext(ctx, "SELECT n.id, n.user_id, n.wrapped_key, n.sealed_title, n.sealed_content, n.folder_id FROM notes n LEFT JOIN search_documents d ON d.note_id = n.id WHERE n.user_id = ? AND n.wrapped_key IS NOT NULL AND d.id IS NULL", userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var notes []Note
+	for rows.Next() {
+		var note Note
+		if err := rows.Scan(&note.ID, &note.UserID, &note.WrappedKey, &note.SealedTitle, &note.SealedContent, &note.FolderID); err != nil {
+			return nil, err
+		}
+		notes = append(notes, note)
+	}
+	return notes, rows.Err()
+}
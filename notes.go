@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+)
+
+// Les notes sont chiffrées comme les fichiers : une clé de données par note, protégée par la clé de son
+// propriétaire. La base ne contient donc ni titre ni contenu lisibles, et aucune recherche ne peut être
+// faite en SQL (LIKE, index FULLTEXT) : une recherche sur les notes devra passer par un index construit
+// à partir de la clé de l'utilisateur, jamais par les colonnes title/content des anciennes notes en clair.
+
+// Données associées qui lient un champ chiffré à son propriétaire et à son rôle (titre ou contenu)
+func noteFieldAD(ownerID int, field string) []byte {
+	return []byte(fmt.Sprintf("coffrefort:note-%s:%d", field, ownerID))
+}
+
+// Fonction pour chiffrer le titre et le contenu d'une note avec une nouvelle clé de données
+func sealNote(userKey []byte, note *Note) error {
+	dataKey, wrappedKey, err := newDataKey(userKey, noteKeyAD(note.UserID))
+	if err != nil {
+		return err
+	}
+	note.WrappedKey = wrappedKey
+	return sealNoteFields(dataKey, note)
+}
+
+// Fonction pour chiffrer le titre et le contenu d'une note avec sa clé de données
+func sealNoteFields(dataKey []byte, note *Note) error {
+	sealedTitle, err := sealKey(dataKey, []byte(note.Title), noteFieldAD(note.UserID, "title"))
+	if err != nil {
+		return err
+	}
+	sealedContent, err := sealKey(dataKey, []byte(note.Content), noteFieldAD(note.UserID, "content"))
+	if err != nil {
+		return err
+	}
+	note.SealedTitle, note.SealedContent = sealedTitle, sealedContent
+	return nil
+}
+
+// Fonction pour déchiffrer le titre et le contenu d'une note (sans effet sur une ancienne note en clair)
+func openNote(userKey []byte, note *Note) error {
+	if note.WrappedKey == nil {
+		return nil
+	}
+	dataKey, err := openDataKey(userKey, note.WrappedKey, noteKeyAD(note.UserID), note.UserID)
+	if err != nil {
+		return err
+	}
+	return openNoteFields(dataKey, note)
+}
+
+// Fonction pour déchiffrer le titre et le contenu d'une note avec sa clé de données
+func openNoteFields(dataKey []byte, note *Note) error {
+	title, err := openKey(dataKey, note.SealedTitle, noteFieldAD(note.UserID, "title"))
+	if err != nil {
+		return err
+	}
+	content, err := openKey(dataKey, note.SealedContent, noteFieldAD(note.UserID, "content"))
+	if err != nil {
+		return err
+	}
+	note.Title, note.Content = string(title), string(content)
+	return nil
+}
+
+// Fonction pour chiffrer les notes créées avant la mise en place du chiffrement, à la connexion de leur propriétaire
+func (s *Server) encryptPlaintextNotes(ctx context.Context, userID int, userKey []byte) {
+	notes, err := s.notes.ListPlaintext(ctx, userID)
+	if err != nil {
+		log.Println("Erreur lors de la recherche des notes à chiffrer :", err)
+		return
+	}
+
+	for _, note := range notes {
+		if err := sealNote(userKey, &note); err != nil {
+			log.Printf("Erreur lors du chiffrement de la note %d : %v", note.ID, err)
+			continue
+		}
+		if err := s.notes.SetEncrypted(ctx, note); err != nil {
+			log.Printf("Erreur lors du chiffrement de la note %d : %v", note.ID, err)
+		}
+	}
+}
+
+// Fonction pour chiffrer en arrière-plan les notes et fichiers d'un utilisateur restés en clair,
+// puis relever les métadonnées, générer les vignettes, indexer pour la recherche ce qui ne l'est pas encore
+// et confier aux liens et accès de partage les clés des contenus arrivés depuis
+func (s *Server) encryptPlaintextData(ctx context.Context, userID int, userKey []byte) {
+	s.encryptPlaintextNotes(ctx, userID, userKey)
+	s.encryptPlaintextFiles(ctx, userID, userKey)
+	s.describeFiles(ctx, userID, userKey)
+	s.generateThumbnails(ctx, userID, userKey)
+	s.indexVault(ctx, userID, userKey)
+	s.rescanVault(ctx, userID, userKey)
+	s.refreshAccess(ctx, userID, userKey)
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// Définir une structure pour représenter un message adressé à un utilisateur
+type Notification struct {
+	ID        int
+	Message   string
+	CreatedAt time.Time
+}
+
+// NotificationRepo regroupe les requêtes sur la table notifications
+type NotificationRepo struct {
+	db *sql.DB
+}
+
+// Fonction pour adresser un message à un utilisateur
+func (r *NotificationRepo) Add(ctx context.Context, userID int, message string) error {
+	_, err := r.db.ExecContext(ctx, "INSERT INTO notifications (user_id, message) VALUES (?, ?)", userID, message)
+	return err
+}
+
+// Fonction pour lister les messages non lus d'un utilisateur, du plus récent au plus ancien
+func (r *NotificationRepo) ListUnread(ctx context.Context, userID int) ([]Notification, error) {
+	rows, err := r.db.QueryContext(ctx, "SELECT id, message, created_at FROM notifications WHERE user_id = ? AND read_at IS NULL ORDER BY id DESC", userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var notifications []Notification
+	for rows.Next() {
+		var notification Notification
+		var createdAt []byte
+		if err := rows.Scan(&notification.ID, &notification.Message, &createdAt); err != nil {
+			return nil, err
+		}
+		notification.CreatedAt = parseDBTime(createdAt)
+		notifications = append(notifications, notification)
+	}
+	return notifications, rows.Err()
+}
+
+// Fonction pour marquer un message comme lu (sans effet sur les messages d'un autre utilisateur)
+func (r *NotificationRepo) MarkRead(ctx context.Context, userID int, notificationID int64) error {
+	_, err := r.db.ExecContext(ctx, "UPDATE notifications SET read_at = ? WHERE id = ? AND user_id = ? AND read_at IS NULL", time.Now(), notificationID, userID)
+	return err
+}
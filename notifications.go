@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"html/template"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/labstack/echo/v4"
+)
+
+// Fonction pour adresser un message à un utilisateur, affiché sur sa page d'accueil jusqu'à ce qu'il le lise
+func (s *Server) notify(ctx context.Context, userID int, message string) {
+	if err := s.notifications.Add(ctx, userID, message); err != nil {
+		log.Printf("Erreur lors de l'envoi d'un message à l'utilisateur %d : %v", userID, err)
+	}
+}
+
+// Fonction pour générer le HTML des messages non lus, chacun avec son bouton pour le marquer comme lu
+func renderNotifications(notifications []Notification) string {
+	if len(notifications) == 0 {
+		return ""
+	}
+	htmlContent := `<div class="notifications">`
+	for _, notification := range notifications {
+		htmlContent += `
+        <div class="notification">
+            <span>` + notification.CreatedAt.Format("02/01/2006 15:04") + ` : ` + template.HTMLEscapeString(notification.Message) + `</span>
+            <form action="/notifications/` + strconv.Itoa(notification.ID) + `/read" method="post" style="display:inline">
+                <button type="submit">Marquer comme lu</button>
+            </form>
+        </div>`
+	}
+	return htmlContent + `
+    </div>`
+}
+
+// Traitement du bouton pour marquer un message comme lu
+func (s *Server) readNotificationPostHandler(c echo.Context) error {
+	notificationID, err := parseObjectID(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"message": "message introuvable"})
+	}
+	if err := s.notifications.MarkRead(c.Request().Context(), currentUserID(c), notificationID); err != nil {
+		log.Println("Erreur lors de la lecture du message :", err)
+		return err
+	}
+	return c.Redirect(http.StatusSeeOther, "/welcome")
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"html/template"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+)
+
+// Erreur renvoyée quand un envoi dépasserait le quota de l'utilisateur
+var errQuotaExceeded = errors.New("quota de stockage dépassé")
+
+// Marge accordée aux en-têtes multipart et aux autres champs d'un formulaire de dépôt
+const multipartOverhead = 64 << 10
+
+// QuotaPolicy associe à chaque rôle un quota de stockage en octets (0 : illimité)
+type QuotaPolicy struct {
+	Default int64            // Quota des rôles absents de Roles
+	Roles   map[string]int64 // Quota par rôle
+}
+
+// Fonction pour construire la politique de quotas à partir de la configuration (en Mio)
+func newQuotaPolicy(cfg QuotasConfig) QuotaPolicy {
+	policy := QuotaPolicy{Default: int64(cfg.DefaultMB) << 20, Roles: make(map[string]int64)}
+	for role, mb := range cfg.RolesMB {
+		policy.Roles[role] = int64(mb) << 20
+	}
+	return policy
+}
+
+// Fonction pour obtenir le quota d'un utilisateur : le sien s'il en a un, sinon celui de son rôle
+func (p QuotaPolicy) Limit(usage StorageUsage) int64 {
+	if usage.Quota.Valid {
+		return usage.Quota.Int64
+	}
+	if limit, ok := p.Roles[usage.Role]; ok {
+		return limit
+	}
+	return p.Default
+}
+
+// Fonction pour connaître l'espace encore disponible d'un utilisateur ; limited vaut false sans quota
+func (s *Server) remainingStorage(ctx context.Context, userID int) (remaining int64, limited bool, err error) {
+	usage, err := s.users.Storage(ctx, userID)
+	if err != nil {
+		return 0, false, err
+	}
+	limit := s.quotas.Limit(usage)
+	if limit == 0 {
+		return 0, false, nil
+	}
+	if remaining = limit - usage.Used; remaining < 0 {
+		remaining = 0
+	}
+	return remaining, true, nil
+}
+
+// Fonction pour réserver l'espace d'un contenu avant de l'écrire ; renvoie errQuotaExceeded si le quota serait dépassé
+func (s *Server) reserveStorage(ctx context.Context, userID int, size int64) error {
+	usage, err := s.users.Storage(ctx, userID)
+	if err != nil {
+		return err
+	}
+	ok, err := s.users.ReserveStorage(ctx, userID, size, s.quotas.Limit(usage))
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return errQuotaExceeded
+	}
+	return nil
+}
+
+// Fonction pour rendre l'espace réservé d'un contenu finalement non enregistré
+func (s *Server) releaseStorage(ctx context.Context, userID int, size int64) {
+	if err := s.users.ReleaseStorage(ctx, userID, size); err != nil {
+		log.Println("Erreur lors de la libération de l'espace réservé :", err)
+	}
+}
+
+// Fonction pour répondre à un envoi refusé faute de place
+func quotaExceededResponse(c echo.Context) error {
+	return c.JSON(http.StatusRequestEntityTooLarge, map[string]string{"message": errQuotaExceeded.Error()})
+}
+
+// Fonction pour afficher une taille en octets de façon lisible
+func formatBytes(n int64) string {
+	units := []string{"o", "Ko", "Mo", "Go", "To"}
+	value, unit := float64(n), 0
+	for value >= 1024 && unit < len(units)-1 {
+		value /= 1024
+		unit++
+	}
+	if unit == 0 {
+		return strconv.FormatInt(n, 10) + " " + units[0]
+	}
+	return strings.Replace(strconv.FormatFloat(value, 'f', 1, 64), ".", ",", 1) + " " + units[unit]
+}
+
+// Fonction pour construire la barre d'occupation du stockage affichée sur la page d'accueil
+func renderUsageBar(used, limit int64) string {
+	if limit == 0 {
+		return `<div class="storage-usage">Espace utilisé : ` + formatBytes(used) + ` (pas de limite)</div>`
+	}
+	shown := used
+	if shown > limit {
+		shown = limit
+	}
+	return `<div class="storage-usage">
+        <progress value="` + strconv.FormatInt(shown, 10) + `" max="` + strconv.FormatInt(limit, 10) + `"></progress>
+        <span>` + formatBytes(used) + ` utilisés sur ` + formatBytes(limit) + `</span>
+    </div>`
+}
+
+// Fonction pour calculer la taille des contenus enregistrés avant les quotas, puis l'occupation de chaque utilisateur
+func (s *Server) backfillStorageSizes(ctx context.Context) {
+	contents, err := s.files.ListWithoutSize(ctx)
+	if err != nil {
+		log.Println("Erreur lors de la recherche des fichiers sans taille :", err)
+		return
+	}
+	if len(contents) == 0 {
+		return
+	}
+
+	for _, content := range contents {
+		info, err := s.blobs.Stat(ctx, content.StorageKey)
+		if err != nil {
+			log.Printf("Erreur lors du calcul de la taille du contenu %s : %v", content.StorageKey, err)
+			continue
+		}
+		size := info.Size
+		if content.Encrypted {
+			if size, _, err = plaintextSize(info.Size); err != nil {
+				log.Printf("Erreur lors du calcul de la taille du contenu %s : %v", content.StorageKey, err)
+				continue
+			}
+		}
+		if err := s.files.SetSize(ctx, content, size); err != nil {
+			log.Printf("Erreur lors de l'enregistrement de la taille du contenu %s : %v", content.StorageKey, err)
+		}
+	}
+
+	if err := s.users.RecountStorage(ctx); err != nil {
+		log.Println("Erreur lors du calcul de l'occupation des utilisateurs :", err)
+		return
+	}
+	log.Printf("Taille de %d contenus calculée, occupation des utilisateurs mise à jour", len(contents))
+}
+
+// Page d'administration des quotas : occupation de chaque utilisateur et quota propre éventuel
+func (s *Server) quotasHandler(c echo.Context) error {
+	usages, err := s.users.ListStorage(c.Request().Context())
+	if err != nil {
+		log.Println("Erreur lors de la récupération de l'occupation des utilisateurs :", err)
+		return err
+	}
+
+	htmlContent := `
+        <h1>Quotas de stockage</h1>
+        <p>Laisser le quota vide pour appliquer celui du rôle ; 0 pour ne fixer aucune limite.</p>
+        <ul>`
+	for _, usage := range usages {
+		limit := s.quotas.Limit(usage)
+		quota := ""
+		if usage.Quota.Valid {
+			quota = strconv.FormatInt(usage.Quota.Int64>>20, 10)
+		}
+		htmlContent += `
+            <li>` + template.HTMLEscapeString(usage.Username) + ` (` + template.HTMLEscapeString(usage.Role) + `) : ` + renderUsageBar(usage.Used, limit) + `
+                <form action="/admin/quotas/` + strconv.Itoa(usage.UserID) + `" method="post">
+                    <input type="number" min="0" name="quota_mb" placeholder="Quota du rôle" value="` + quota + `"> Mo
+                    <button type="submit">Enregistrer</button>
+                </form>
+            </li>`
+	}
+	htmlContent += `
+        </ul>
+        <a href="/welcome">Retour à la page </a>
+    `
+	return c.HTML(http.StatusOK, htmlContent)
+}
+
+// Traitement du formulaire de quota propre à un utilisateur
+func (s *Server) quotaPostHandler(c echo.Context) error {
+	userID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"message": errUserNotFound.Error()})
+	}
+
+	var quota sql.NullInt64
+	if value := strings.TrimSpace(c.FormValue("quota_mb")); value != "" {
+		mb, err := strconv.ParseInt(value, 10, 32)
+		if err != nil || mb < 0 {
+			return c.JSON(http.StatusBadRequest, map[string]string{"message": "quota invalide"})
+		}
+		quota = sql.NullInt64{Int64: mb << 20, Valid: true}
+	}
+
+	err = s.users.SetStorageQuota(c.Request().Context(), userID, quota)
+	if errors.Is(err, errUserNotFound) {
+		return c.JSON(http.StatusNotFound, map[string]string{"message": err.Error()})
+	}
+	if err != nil {
+		log.Println("Erreur lors de l'enregistrement du quota :", err)
+		return err
+	}
+	return c.Redirect(http.StatusSeeOther, "/admin/quotas")
+}
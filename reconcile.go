@@ -0,0 +1,209 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Dossier (dans le dossier des fichiers déposés) où sont mis de côté les fichiers sans ligne en base
+const orphansDirName = "orphelins"
+
+// Âge minimal d'un fichier temporaire avant de le considérer comme abandonné
+const staleUploadAge = time.Hour
+
+// Définir une structure pour représenter le bilan d'une réconciliation
+type ReconcileReport struct {
+	Migrated int // Lignes qui ont reçu une clé de stockage
+	Missing  int // Lignes dont le contenu est introuvable sur le disque
+	Orphans  int // Fichiers du disque qui ne correspondent à aucune ligne
+}
+
+// Reconciler rattache les anciennes lignes de `files` à des clés de stockage et met de côté les fichiers orphelins
+type Reconciler struct {
+	files      *FileRepo
+	refs       *BlobRepo // Références vers les blobs, prises pour chaque contenu réconcilié
+	store      BlobStore // Stockage qui reçoit les contenus réconciliés
+	uploadsDir string    // Dossier des anciens fichiers (toujours sur le disque local)
+	dryRun     bool      // Afficher les actions sans rien modifier
+}
+
+// Fonction pour exécuter la réconciliation complète
+func (r *Reconciler) Run(ctx context.Context) (ReconcileReport, error) {
+	var report ReconcileReport
+
+	consumed, err := r.migrateLegacyRows(ctx, &report)
+	if err != nil {
+		return report, err
+	}
+	if err := r.quarantineOrphans(ctx, consumed, &report); err != nil {
+		return report, err
+	}
+	return report, nil
+}
+
+// Fonction pour copier le contenu de chaque ancienne ligne sous une clé de stockage.
+// Renvoie les anciens chemins utilisés, effacés une fois toutes les lignes traitées.
+func (r *Reconciler) migrateLegacyRows(ctx context.Context, report *ReconcileReport) (map[string]bool, error) {
+	rows, err := r.files.ListWithoutStorageKey(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	consumed := make(map[string]bool)
+	for _, row := range rows {
+		path := r.findLegacyContent(row)
+		if path == "" {
+			log.Printf("Fichier %d (%q, utilisateur %d) introuvable sur le disque", row.ID, row.FileName, row.UserID)
+			report.Missing++
+			continue
+		}
+		if consumed[path] {
+			// Avant les clés de stockage, deux dépôts du même nom s'écrasaient : le contenu est ambigu
+			log.Printf("Fichier %d (%q) partage son ancien chemin %s avec une autre ligne", row.ID, row.FileName, path)
+		}
+		consumed[path] = true
+		report.Migrated++
+
+		if r.dryRun {
+			log.Printf("[simulation] fichier %d : %s recevrait une clé de stockage", row.ID, path)
+			continue
+		}
+		key, err := r.copyToBlob(ctx, path)
+		if err != nil {
+			return nil, fmt.Errorf("fichier %d : %w", row.ID, err)
+		}
+		if err := r.files.SetStorageKey(ctx, row.ID, key); err != nil {
+			releaseBlob(ctx, r.refs, r.store, key)
+			return nil, fmt.Errorf("fichier %d : %w", row.ID, err)
+		}
+		log.Printf("Fichier %d : %s -> %s", row.ID, path, key)
+	}
+
+	if !r.dryRun {
+		for path := range consumed {
+			if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+				log.Println("Erreur lors de la suppression de l'ancien fichier :", err)
+			}
+		}
+	}
+	return consumed, nil
+}
+
+// Fonction pour retrouver le contenu d'une ancienne ligne parmi les chemins utilisés par les versions précédentes
+func (r *Reconciler) findLegacyContent(row legacyFile) string {
+	candidates := []string{
+		// Ancien SaveFileToFileSystem : <uploads>/<userID>/<nom échappé>
+		filepath.Join(r.uploadsDir, strconv.Itoa(row.UserID), url.PathEscape(row.FileName)),
+		// Chemin enregistré en base
+		row.FilePath,
+		// uploadFileHandler : <uploads>/<nom brut>
+		filepath.Join(r.uploadsDir, row.FileName),
+	}
+	for _, candidate := range candidates {
+		if candidate == "" || !insideDir(r.uploadsDir, candidate) {
+			continue
+		}
+		// Uniquement des fichiers ordinaires : un lien symbolique pourrait pointer hors du dossier
+		info, err := os.Lstat(candidate)
+		if err == nil && info.Mode().IsRegular() {
+			return candidate
+		}
+	}
+	return ""
+}
+
+// Fonction pour copier un ancien fichier sous une nouvelle clé de stockage
+func (r *Reconciler) copyToBlob(ctx context.Context, path string) (string, error) {
+	src, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer src.Close()
+	return storeBlob(ctx, r.refs, r.store, src)
+}
+
+// Fonction pour déplacer dans le dossier des orphelins tout fichier du disque qui ne correspond à aucune ligne.
+// Les blobs orphelins d'un stockage distant sont seulement signalés.
+func (r *Reconciler) quarantineOrphans(ctx context.Context, consumed map[string]bool, report *ReconcileReport) error {
+	known, err := r.files.StorageKeys(ctx)
+	if err != nil {
+		return err
+	}
+	if _, local := r.store.(*LocalStore); !local {
+		err := r.store.List(ctx, func(info BlobInfo) error {
+			if !known[info.Key] {
+				log.Printf("Blob orphelin dans le stockage : %s (%d octets)", info.Key, info.Size)
+				report.Orphans++
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	orphansDir := filepath.Join(r.uploadsDir, orphansDirName)
+
+	err = filepath.WalkDir(r.uploadsDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if path == orphansDir {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if r.isReferenced(path, d, known) || consumed[path] {
+			return nil
+		}
+
+		report.Orphans++
+		rel, _ := filepath.Rel(r.uploadsDir, path)
+		target := filepath.Join(orphansDir, strings.ReplaceAll(filepath.ToSlash(rel), "/", "_"))
+		if r.dryRun {
+			log.Printf("[simulation] orphelin : %s serait déplacé vers %s", path, target)
+			return nil
+		}
+		if err := os.MkdirAll(orphansDir, 0755); err != nil {
+			return err
+		}
+		log.Printf("Orphelin : %s -> %s", path, target)
+		return os.Rename(path, target)
+	})
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// Fonction pour savoir si un fichier du disque est un blob référencé en base (ou un dépôt en cours)
+func (r *Reconciler) isReferenced(path string, d fs.DirEntry, known map[string]bool) bool {
+	name := d.Name()
+	if strings.HasPrefix(name, ".upload-") {
+		info, err := d.Info()
+		return err == nil && time.Since(info.ModTime()) < staleUploadAge
+	}
+	if !known[name] {
+		return false
+	}
+	expected, err := blobPath(r.uploadsDir, name)
+	return err == nil && expected == filepath.Clean(path)
+}
+
+// Fonction pour vérifier qu'un chemin reste à l'intérieur d'un dossier (pas de ../)
+func insideDir(dir, path string) bool {
+	rel, err := filepath.Rel(dir, path)
+	if err != nil {
+		return false
+	}
+	return rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) && !filepath.IsAbs(rel)
+}
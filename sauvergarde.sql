@@ -1,141 +0,0 @@
--- MySQL dump 10.13  Distrib 8.0.36, for Linux (x86_64)
---
--- Host: localhost    Database: CoffreFortDb
--- ------------------------------------------------------
--- Server version	8.0.36
-
-/*!40101 SET @OLD_CHARACTER_SET_CLIENT=@@CHARACTER_SET_CLIENT */;
-/*!40101 SET @OLD_CHARACTER_SET_RESULTS=@@CHARACTER_SET_RESULTS */;
-/*!40101 SET @OLD_COLLATION_CONNECTION=@@COLLATION_CONNECTION */;
-/*!50503 SET NAMES utf8mb4 */;
-/*!40103 SET @OLD_TIME_ZONE=@@TIME_ZONE */;
-/*!40103 SET TIME_ZONE='+00:00' */;
-/*!40014 SET @OLD_UNIQUE_CHECKS=@@UNIQUE_CHECKS, UNIQUE_CHECKS=0 */;
-/*!40014 SET @OLD_FOREIGN_KEY_CHECKS=@@FOREIGN_KEY_CHECKS, FOREIGN_KEY_CHECKS=0 */;
-/*!40101 SET @OLD_SQL_MODE=@@SQL_MODE, SQL_MODE='NO_AUTO_VALUE_ON_ZERO' */;
-/*!40111 SET @OLD_SQL_NOTES=@@SQL_NOTES, SQL_NOTES=0 */;
-
---
--- Table structure for table `files`
---
-
-DROP TABLE IF EXISTS `files`;
-/*!40101 SET @saved_cs_client     = @@character_set_client */;
-/*!50503 SET character_set_client = utf8mb4 */;
-CREATE TABLE `files` (
-  `id` int NOT NULL AUTO_INCREMENT,
-  `user_id` int DEFAULT NULL,
-  `filename` varchar(255) DEFAULT NULL,
-  `file_path` varchar(255) DEFAULT NULL,
-  `uploaded_at` timestamp NULL DEFAULT CURRENT_TIMESTAMP,
-  PRIMARY KEY (`id`),
-  KEY `user_id` (`user_id`)
-) ENGINE=InnoDB AUTO_INCREMENT=61 DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_0900_ai_ci;
-/*!40101 SET character_set_client = @saved_cs_client */;
-
---
--- Dumping data for table `files`
---
-
-LOCK TABLES `files` WRITE;
-/*!40000 ALTER TABLE `files` DISABLE KEYS */;
-INSERT INTO `files` VALUES (31,7,'Bulletin individuelle d\'affilREMPLI.pdf','uploads/Bulletin individuelle d\'affilREMPLI.pdf','2024-03-21 13:09:24'),(60,80,'PermisDeConduireRecto.pdf','uploads/PermisDeConduireRecto.pdf','2024-03-27 08:06:21');
-/*!40000 ALTER TABLE `files` ENABLE KEYS */;
-UNLOCK TABLES;
-
---
--- Table structure for table `folders`
---
-
-DROP TABLE IF EXISTS `folders`;
-/*!40101 SET @saved_cs_client     = @@character_set_client */;
-/*!50503 SET character_set_client = utf8mb4 */;
-CREATE TABLE `folders` (
-  `id` int NOT NULL AUTO_INCREMENT,
-  `user_id` int DEFAULT NULL,
-  `folder_name` varchar(255) DEFAULT NULL,
-  `parent_folder_id` int DEFAULT NULL,
-  `created_at` timestamp NULL DEFAULT CURRENT_TIMESTAMP,
-  PRIMARY KEY (`id`),
-  KEY `user_id` (`user_id`),
-  KEY `parent_folder_id` (`parent_folder_id`),
-  CONSTRAINT `folders_ibfk_1` FOREIGN KEY (`user_id`) REFERENCES `users` (`ID`),
-  CONSTRAINT `folders_ibfk_2` FOREIGN KEY (`parent_folder_id`) REFERENCES `folders` (`id`)
-) ENGINE=InnoDB AUTO_INCREMENT=13 DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_0900_ai_ci;
-/*!40101 SET character_set_client = @saved_cs_client */;
-
---
--- Dumping data for table `folders`
---
-
-LOCK TABLES `folders` WRITE;
-/*!40000 ALTER TABLE `folders` DISABLE KEYS */;
-/*!40000 ALTER TABLE `folders` ENABLE KEYS */;
-UNLOCK TABLES;
-
---
--- Table structure for table `notes`
---
-
-DROP TABLE IF EXISTS `notes`;
-/*!40101 SET @saved_cs_client     = @@character_set_client */;
-/*!50503 SET character_set_client = utf8mb4 */;
-CREATE TABLE `notes` (
-  `id` int NOT NULL AUTO_INCREMENT,
-  `user_id` int DEFAULT NULL,
-  `content` text,
-  `created_at` timestamp NULL DEFAULT CURRENT_TIMESTAMP,
-  `title` varchar(255) DEFAULT NULL,
-  PRIMARY KEY (`id`),
-  KEY `notes_ibfk_1` (`user_id`),
-  CONSTRAINT `notes_ibfk_1` FOREIGN KEY (`user_id`) REFERENCES `users` (`ID`) ON DELETE SET NULL
-) ENGINE=InnoDB AUTO_INCREMENT=77 DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_0900_ai_ci;
-/*!40101 SET character_set_client = @saved_cs_client */;
-
---
--- Dumping data for table `notes`
---
-
-LOCK TABLES `notes` WRITE;
-/*!40000 ALTER TABLE `notes` DISABLE KEYS */;
-INSERT INTO `notes` VALUES (37,NULL,'zedcz','2024-03-18 10:43:39','test'),(38,NULL,'coucou !','2024-03-18 18:48:29','tedt'),(40,NULL,'coucou','2024-03-19 20:11:26','test'),(47,NULL,'dcds','2024-03-21 10:36:54','dc'),(54,NULL,'fgef\r\n','2024-03-22 16:51:30','fge'),(56,NULL,'sdfsd','2024-03-24 20:37:06','dsfsd'),(59,NULL,'dsc','2024-03-25 00:03:49','sd'),(60,NULL,'csqs','2024-03-25 01:02:50','cdqs'),(61,NULL,'erger','2024-03-25 10:11:24','fve'),(62,NULL,'cdzdczd','2024-03-25 10:38:42','dc'),(65,NULL,'zed','2024-03-25 15:56:04','zed'),(66,NULL,'sdxsq','2024-03-25 17:04:19','qsx'),(67,NULL,'dqds','2024-03-25 17:42:01','sqd'),(68,NULL,'ed','2024-03-25 19:42:26','dz'),(70,NULL,'dze','2024-03-26 07:16:42','dz'),(71,NULL,'zcz','2024-03-26 07:47:13','cdc'),(73,NULL,'cedc','2024-03-26 08:44:06','cdc'),(76,80,'zeda','2024-03-27 09:06:14','dazed');
-/*!40000 ALTER TABLE `notes` ENABLE KEYS */;
-UNLOCK TABLES;
-
---
--- Table structure for table `users`
---
-
-DROP TABLE IF EXISTS `users`;
-/*!40101 SET @saved_cs_client     = @@character_set_client */;
-/*!50503 SET character_set_client = utf8mb4 */;
-CREATE TABLE `users` (
-  `ID` int NOT NULL AUTO_INCREMENT,
-  `username` varchar(255) NOT NULL,
-  `password` varchar(255) NOT NULL,
-  `created_at` timestamp NULL DEFAULT CURRENT_TIMESTAMP,
-  `role` varchar(255) DEFAULT NULL,
-  PRIMARY KEY (`ID`)
-) ENGINE=InnoDB AUTO_INCREMENT=81 DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_0900_ai_ci;
-/*!40101 SET character_set_client = @saved_cs_client */;
-
---
--- Dumping data for table `users`
---
-
-LOCK TABLES `users` WRITE;
-/*!40000 ALTER TABLE `users` DISABLE KEYS */;
-INSERT INTO `users` VALUES (33,'admin','$2a$10$pb1QmeFfoneAJ5YyilYOPOFCqJVuQkcoU7xSuLlyO87D3l33pg5C2','2024-03-20 02:40:05','admin'),(77,'b','$2a$10$wamqpnnnae/VgFH5/ufYwefIMyDW.7y0A2.fr5SC.7s88W.E3XG3u','2024-03-26 08:11:50','utilisateur'),(78,'a','$2a$10$gJ85P4uGVjjAudy31A5.Y.6923xEr0euuIbOBYsmJ4bn.Sn5nXyiu','2024-03-26 14:04:44','utilisateur'),(79,'k','$2a$10$GAwowhC0kApRlw.PCm7Kze/EgD1Mexoo0xW.jE89IQkwxT6toeVpG','2024-03-27 08:48:41','utilisateur'),(80,'joris','$2a$10$UE9jg0NY/ZEo9nUZvYyQDehQ7BDZ9zSEREw76qm4JqIwc4Yn3tb4O','2024-03-27 09:05:57','utilisateur');
-/*!40000 ALTER TABLE `users` ENABLE KEYS */;
-UNLOCK TABLES;
-/*!40103 SET TIME_ZONE=@OLD_TIME_ZONE */;
-
-/*!40101 SET SQL_MODE=@OLD_SQL_MODE */;
-/*!40014 SET FOREIGN_KEY_CHECKS=@OLD_FOREIGN_KEY_CHECKS */;
-/*!40014 SET UNIQUE_CHECKS=@OLD_UNIQUE_CHECKS */;
-/*!40101 SET CHARACTER_SET_CLIENT=@OLD_CHARACTER_SET_CLIENT */;
-/*!40101 SET CHARACTER_SET_RESULTS=@OLD_CHARACTER_SET_RESULTS */;
-/*!40101 SET COLLATION_CONNECTION=@OLD_COLLATION_CONNECTION */;
-/*!40111 SET SQL_NOTES=@OLD_SQL_NOTES */;
-
--- Dump completed on 2024-03-27 11:19:40
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"time"
+)
+
+// Erreur renvoyée quand l'analyse antivirus est obligatoire et que le scanner ne répond pas
+var errScanUnavailable = errors.New("analyse antivirus indisponible, réessayez plus tard")
+
+// Définir une structure pour représenter le verdict d'une analyse
+type ScanResult struct {
+	Infected  bool
+	Signature string // Nom de la menace reconnue
+}
+
+// Scanner analyse les contenus en clair à la recherche de logiciels malveillants
+type Scanner interface {
+	Scan(ctx context.Context, r io.Reader) (ScanResult, error)
+	// Version renvoie la version des signatures : quand elle change, les contenus déjà analysés le sont à nouveau
+	Version(ctx context.Context) (string, error)
+}
+
+// Fonction pour créer le scanner décrit par la configuration (nil : pas d'analyse)
+func openScanner(cfg ScanConfig) Scanner {
+	if cfg.ClamdAddress == "" {
+		return nil
+	}
+	return NewClamdScanner(cfg.ClamdAddress, cfg.Timeout)
+}
+
+// Définir une structure pour représenter l'analyse d'un contenu envoyé, enregistrée sur son blob une fois le fichier enregistré
+type scanVerdict struct {
+	Signatures string // Version des signatures utilisées (vide : pas analysé, à reprendre plus tard)
+	Infection  string // Menace reconnue (vide : contenu sain)
+}
+
+// Fonction pour construire la raison de quarantaine d'un contenu infecté
+func infectionQuarantine(signature string) string {
+	return "logiciel malveillant détecté : " + signature
+}
+
+// Fonction pour analyser un contenu envoyé avant de l'enregistrer. Si le scanner ne répond pas, le contenu est accepté
+// sans verdict et sera analysé par la prochaine réanalyse, sauf si l'analyse est obligatoire.
+func (s *Server) scanUpload(ctx context.Context, open func() (io.ReadCloser, error)) (scanVerdict, error) {
+	if s.scanner == nil {
+		return scanVerdict{}, nil
+	}
+	version, err := s.scanner.Version(ctx)
+	var result ScanResult
+	if err == nil {
+		var src io.ReadCloser
+		if src, err = open(); err != nil {
+			return scanVerdict{}, err
+		}
+		result, err = s.scanner.Scan(ctx, src)
+		src.Close()
+	}
+	if errors.Is(err, errScanSource) {
+		return scanVerdict{}, err
+	}
+	if err != nil {
+		log.Println("Erreur lors de l'analyse antivirus du contenu envoyé :", err)
+		if s.scanRequired {
+			return scanVerdict{}, errScanUnavailable
+		}
+		return scanVerdict{}, nil
+	}
+	if result.Infected {
+		return scanVerdict{Signatures: version, Infection: result.Signature}, nil
+	}
+	return scanVerdict{Signatures: version}, nil
+}
+
+// Fonction pour enregistrer le verdict d'un contenu envoyé et prévenir l'utilisateur si le fichier est infecté
+func (s *Server) recordScan(ctx context.Context, userID int, fileName, storageKey string, verdict scanVerdict) {
+	if verdict.Signatures == "" {
+		return
+	}
+	if err := s.blobRefs.SetScan(ctx, storageKey, verdict.Signatures, verdict.Infection); err != nil {
+		log.Printf("Erreur lors de l'enregistrement de l'analyse du contenu %s : %v", storageKey, err)
+	}
+	if verdict.Infection != "" {
+		log.Printf("Contenu %s de l'utilisateur %d infecté (%s), mis en quarantaine", storageKey, userID, verdict.Infection)
+		s.notifyInfection(ctx, userID, fileName, verdict.Infection)
+	}
+}
+
+// Fonction pour prévenir un utilisateur qu'un de ses fichiers a été mis en quarantaine par l'antivirus
+func (s *Server) notifyInfection(ctx context.Context, userID int, fileName, signature string) {
+	s.notify(ctx, userID, fmt.Sprintf("Le fichier « %s » contient un logiciel malveillant (%s) : il a été mis en quarantaine.", fileName, signature))
+}
+
+// Fonction pour réanalyser les contenus d'un utilisateur jamais analysés ou analysés avec d'anciennes signatures.
+// Les contenus sont chiffrés avec la clé de l'utilisateur : seuls les coffres déverrouillés peuvent être réanalysés.
+func (s *Server) rescanVault(ctx context.Context, userID int, userKey []byte) {
+	if s.scanner == nil {
+		return
+	}
+	version, err := s.scanner.Version(ctx)
+	if err != nil {
+		log.Println("Erreur lors de la lecture de la version des signatures antivirus :", err)
+		return
+	}
+	sources, err := s.files.ListUnscanned(ctx, userID, version)
+	if err != nil {
+		log.Println("Erreur lors de la recherche des contenus à analyser :", err)
+		return
+	}
+	for _, source := range sources {
+		result, err := s.scanContent(ctx, userID, userKey, source)
+		if err != nil {
+			// Contenu illisible ou refusé par le scanner : il sera repris à la prochaine réanalyse.
+			// Si le scanner ne répond plus, la suite attend aussi la prochaine réanalyse.
+			log.Printf("Erreur lors de l'analyse antivirus du contenu %s : %v", source.StorageKey, err)
+			if !errors.Is(err, errScanSource) && !errors.Is(err, errClamd) {
+				return
+			}
+			continue
+		}
+		if !result.Infected {
+			if err := s.blobRefs.SetScan(ctx, source.StorageKey, version, ""); err != nil {
+				log.Printf("Erreur lors de l'enregistrement de l'analyse du contenu %s : %v", source.StorageKey, err)
+			}
+			continue
+		}
+
+		// Un contenu reconnu par de nouvelles signatures met en quarantaine tous les fichiers et versions qui le désignent
+		log.Printf("Contenu %s de l'utilisateur %d infecté (%s), mis en quarantaine", source.StorageKey, userID, result.Signature)
+		names, err := s.files.QuarantineContent(ctx, source.StorageKey, infectionQuarantine(result.Signature))
+		if err != nil {
+			log.Printf("Erreur lors de la mise en quarantaine du contenu %s : %v", source.StorageKey, err)
+			continue
+		}
+		if err := s.blobRefs.SetScan(ctx, source.StorageKey, version, result.Signature); err != nil {
+			log.Printf("Erreur lors de l'enregistrement de l'analyse du contenu %s : %v", source.StorageKey, err)
+		}
+		for _, name := range names {
+			s.notifyInfection(ctx, userID, name, result.Signature)
+		}
+	}
+}
+
+// Fonction pour déchiffrer et analyser un contenu déjà stocké
+func (s *Server) scanContent(ctx context.Context, userID int, userKey []byte, source scanSource) (ScanResult, error) {
+	var dataKey []byte
+	if source.WrappedKey != nil {
+		var err error
+		if dataKey, err = openFileKey(userKey, source.WrappedKey, userID); err != nil {
+			return ScanResult{}, fmt.Errorf("%w : %v", errScanSource, err)
+		}
+	}
+	content, err := openContent(ctx, s.blobs, source.StorageKey, dataKey)
+	if err != nil {
+		return ScanResult{}, fmt.Errorf("%w : %v", errScanSource, err)
+	}
+	defer content.Close()
+	return s.scanner.Scan(ctx, content)
+}
+
+// Fonction pour contrôler régulièrement les signatures et réanalyser les coffres déverrouillés.
+// Les autres coffres sont réanalysés à la prochaine connexion de leur propriétaire.
+func (s *Server) runScanJanitor(ctx context.Context) {
+	if s.scanner == nil || s.rescanInterval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(s.rescanInterval)
+	defer ticker.Stop()
+	last := ""
+	for {
+		version, err := s.scanner.Version(ctx)
+		if err != nil {
+			log.Println("Erreur lors de la lecture de la version des signatures antivirus :", err)
+		} else {
+			if version != last {
+				log.Printf("Signatures antivirus %s : réanalyse des coffres déverrouillés", version)
+				last = version
+			}
+			for userID, userKey := range s.keys.ring.Unlocked() {
+				s.rescanVault(ctx, userID, userKey)
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
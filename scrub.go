@@ -0,0 +1,360 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// Erreur renvoyée quand une vérification est demandée pendant qu'une autre tourne
+var errScrubRunning = errors.New("une vérification est déjà en cours")
+
+// Nombre maximal d'anomalies détaillées dans un bilan (les compteurs restent exacts)
+const maxScrubIssues = 1000
+
+// Types d'anomalies relevées par le vérificateur
+const (
+	scrubMissing      = "manquant"       // Blob désigné par une ligne mais absent du stockage
+	scrubCorrupt      = "altéré"         // Blob dont les octets ne correspondent plus à l'empreinte enregistrée
+	scrubOrphan       = "orphelin"       // Blob du stockage qu'aucune ligne ne désigne
+	scrubUnreconciled = "non réconcilié" // Ancien fichier sans clé de stockage
+)
+
+// Définir une structure pour représenter une anomalie relevée par le vérificateur
+type ScrubIssue struct {
+	Kind       string
+	StorageKey string // Vide pour un fichier non réconcilié
+	Detail     string
+	Repaired   bool // Blob restauré depuis la copie de secours
+}
+
+// Définir une structure pour représenter le bilan d'une vérification
+type ScrubReport struct {
+	ID           int
+	StartedAt    time.Time
+	FinishedAt   time.Time
+	Checked      int // Blobs relus
+	Missing      int
+	Corrupt      int
+	Orphans      int
+	Unreconciled int
+	Repaired     int
+	Error        string // Erreur qui a interrompu la vérification
+	Issues       []ScrubIssue
+}
+
+// Fonction pour ajouter une anomalie au bilan, dans la limite de maxScrubIssues
+func (r *ScrubReport) add(issue ScrubIssue) {
+	if len(r.Issues) < maxScrubIssues {
+		r.Issues = append(r.Issues, issue)
+	}
+}
+
+// Scrubber relit chaque blob désigné par un fichier ou une ancienne version et le compare à son empreinte,
+// relève les blobs que plus rien ne désigne et, s'il y a une copie de secours, répare les blobs manquants ou altérés
+type Scrubber struct {
+	refs    *BlobRepo
+	files   *FileRepo
+	runs    *ScrubRepo
+	store   BlobStore
+	replica BlobStore // Copie de secours (nil : pas de réparation possible)
+	repair  bool      // Réparer par défaut (vérifications planifiées)
+	running atomic.Bool
+}
+
+// Fonction pour savoir si une vérification est en cours dans ce processus
+func (s *Scrubber) Running() bool {
+	return s.running.Load()
+}
+
+// Fonction pour exécuter une vérification complète et enregistrer son bilan
+func (s *Scrubber) Run(ctx context.Context, repair bool) (ScrubReport, error) {
+	if !s.running.CompareAndSwap(false, true) {
+		return ScrubReport{}, errScrubRunning
+	}
+	defer s.running.Store(false)
+
+	report := ScrubReport{StartedAt: time.Now()}
+	err := s.scrub(ctx, &report, repair && s.replica != nil)
+	report.FinishedAt = time.Now()
+	if err != nil {
+		report.Error = err.Error()
+	}
+	if saveErr := s.runs.Save(context.Background(), &report); saveErr != nil {
+		log.Println("Erreur lors de l'enregistrement du bilan de vérification :", saveErr)
+	}
+	return report, err
+}
+
+// Fonction pour vérifier les blobs, puis relever les orphelins et les fichiers non réconciliés
+func (s *Scrubber) scrub(ctx context.Context, report *ScrubReport, repair bool) error {
+	contents, err := s.refs.ListReferenced(ctx)
+	if err != nil {
+		return err
+	}
+	for _, content := range contents {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := s.checkContent(ctx, content, report, repair); err != nil {
+			return fmt.Errorf("blob %s : %w", content.Key, err)
+		}
+	}
+
+	// Un blob écrit après la lecture des clés connues n'est pas un orphelin
+	known, err := s.files.StorageKeys(ctx)
+	if err != nil {
+		return err
+	}
+	err = s.store.List(ctx, func(info BlobInfo) error {
+		if known[info.Key] || !info.ModTime.Before(report.StartedAt) {
+			return nil
+		}
+		report.Orphans++
+		report.add(ScrubIssue{Kind: scrubOrphan, StorageKey: info.Key,
+			Detail: fmt.Sprintf("%s écrit le %s, désigné par aucune ligne : effaçable avec `storage gc`", formatBytes(info.Size), formatVersionTime(info.ModTime))})
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	legacy, err := s.files.ListWithoutStorageKey(ctx)
+	if err != nil {
+		return err
+	}
+	for _, row := range legacy {
+		report.Unreconciled++
+		report.add(ScrubIssue{Kind: scrubUnreconciled,
+			Detail: fmt.Sprintf("fichier %d %q (utilisateur %d) sans clé de stockage : lancer `storage reconcile`", row.ID, row.FileName, row.UserID)})
+	}
+	return nil
+}
+
+// Fonction pour relire un blob et le comparer à son empreinte ; une empreinte inconnue (blob écrit avant son calcul)
+// est relevée à ce premier passage. Seules les erreurs du stockage ou de la base interrompent la vérification.
+func (s *Scrubber) checkContent(ctx context.Context, content storedContent, report *ScrubReport, repair bool) error {
+	report.Checked++
+	sum, err := hashBlob(ctx, s.store, content.Key)
+	var issue ScrubIssue
+	switch {
+	case errors.Is(err, errBlobNotFound):
+		issue = ScrubIssue{Kind: scrubMissing, StorageKey: content.Key, Detail: "absent du stockage"}
+	case err != nil:
+		return err
+	case !content.Checksum.Valid:
+		return s.refs.SetChecksum(ctx, content.Key, sum)
+	case sum != content.Checksum.String:
+		issue = ScrubIssue{Kind: scrubCorrupt, StorageKey: content.Key, Detail: "empreinte " + sum + " au lieu de " + content.Checksum.String}
+	default:
+		return nil
+	}
+
+	// La ligne a pu être supprimée, et le blob effacé, depuis la lecture des clés
+	references, err := s.files.References(ctx, content.Key)
+	if err != nil {
+		return err
+	}
+	if len(references) == 0 {
+		return nil
+	}
+	issue.Detail += " ; utilisé par " + strings.Join(references, ", ")
+
+	if repair {
+		if err := s.repairBlob(ctx, content); err != nil {
+			log.Printf("Réparation du blob %s impossible : %v", content.Key, err)
+			issue.Detail += " ; réparation impossible : " + err.Error()
+		} else {
+			issue.Repaired = true
+			report.Repaired++
+		}
+	}
+	if issue.Kind == scrubMissing {
+		report.Missing++
+	} else {
+		report.Corrupt++
+	}
+	report.add(issue)
+	return nil
+}
+
+// Fonction pour restaurer un blob depuis la copie de secours, après avoir vérifié celle-ci contre l'empreinte attendue.
+// Sans empreinte connue (blob ancien jamais vérifié), la copie de secours fait foi et son empreinte est enregistrée.
+func (s *Scrubber) repairBlob(ctx context.Context, content storedContent) error {
+	sum, err := hashBlob(ctx, s.replica, content.Key)
+	if errors.Is(err, errBlobNotFound) {
+		return errors.New("absent de la copie de secours")
+	}
+	if err != nil {
+		return err
+	}
+	if content.Checksum.Valid && sum != content.Checksum.String {
+		return errors.New("la copie de secours est altérée elle aussi")
+	}
+	if err := copyBlob(ctx, s.replica, s.store, content.Key); err != nil {
+		return err
+	}
+	restored, err := hashBlob(ctx, s.store, content.Key)
+	if err != nil {
+		return err
+	}
+	if restored != sum {
+		return errors.New("le blob restauré ne correspond pas à la copie de secours")
+	}
+	if !content.Checksum.Valid {
+		return s.refs.SetChecksum(ctx, content.Key, sum)
+	}
+	return nil
+}
+
+// Fonction pour calculer l'empreinte SHA-256 des octets stockés d'un blob
+func hashBlob(ctx context.Context, store BlobStore, key string) (string, error) {
+	rc, err := store.Get(ctx, key)
+	if err != nil {
+		return "", err
+	}
+	defer rc.Close()
+
+	digest := sha256.New()
+	if _, err := io.Copy(digest, contextReader{ctx: ctx, r: rc}); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(digest.Sum(nil)), nil
+}
+
+// Fonction pour lancer régulièrement une vérification quand la dernière date de plus de interval (0 : jamais)
+func (s *Server) runScrubJanitor(ctx context.Context) {
+	if s.scrubInterval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+	for {
+		last, err := s.scrubber.runs.LastStartedAt(ctx)
+		if err != nil {
+			log.Println("Erreur lors de la lecture du dernier bilan de vérification :", err)
+		} else if time.Since(last) >= s.scrubInterval {
+			report, err := s.scrubber.Run(ctx, s.scrubber.repair)
+			if err != nil && !errors.Is(err, errScrubRunning) {
+				log.Println("Erreur lors de la vérification du stockage :", err)
+			} else if err == nil {
+				log.Printf("Vérification du stockage : %d blob(s) relu(s), %d manquant(s), %d altéré(s), %d orphelin(s), %d réparé(s)",
+					report.Checked, report.Missing, report.Corrupt, report.Orphans, report.Repaired)
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Page d'administration de l'intégrité du stockage : dernier bilan et lancement d'une vérification
+func (s *Server) scrubHandler(c echo.Context) error {
+	report, found, err := s.scrubber.runs.Latest(c.Request().Context())
+	if err != nil {
+		log.Println("Erreur lors de la récupération du bilan de vérification :", err)
+		return err
+	}
+
+	htmlContent := `
+        <h1>Intégrité du stockage</h1>`
+	if s.scrubber.Running() {
+		htmlContent += `
+        <p>Vérification en cours...</p>`
+	} else {
+		repairOption := ""
+		if s.scrubber.replica != nil {
+			checked := ""
+			if s.scrubber.repair {
+				checked = " checked"
+			}
+			repairOption = `
+            <label><input type="checkbox" name="repair" value="1"` + checked + `> Réparer depuis la copie de secours</label>`
+		}
+		htmlContent += `
+        <form action="/admin/scrub" method="post">` + repairOption + `
+            <button type="submit">Lancer une vérification</button>
+        </form>`
+	}
+
+	if !found {
+		htmlContent += `
+        <p>Aucune vérification n'a encore eu lieu.</p>`
+	} else {
+		htmlContent += `
+        <h2>Dernière vérification (` + formatVersionTime(report.StartedAt) + ` - ` + formatVersionTime(report.FinishedAt) + `)</h2>
+        <p>` + strconv.Itoa(report.Checked) + ` blob(s) relu(s) : ` + strconv.Itoa(report.Missing) + ` manquant(s), ` +
+			strconv.Itoa(report.Corrupt) + ` altéré(s), ` + strconv.Itoa(report.Orphans) + ` orphelin(s), ` +
+			strconv.Itoa(report.Unreconciled) + ` fichier(s) non réconcilié(s), ` + strconv.Itoa(report.Repaired) + ` réparé(s).</p>`
+		if report.Error != "" {
+			htmlContent += `
+        <p>Vérification interrompue : ` + template.HTMLEscapeString(report.Error) + `</p>`
+		}
+		if len(report.Issues) > 0 {
+			htmlContent += `
+        <ul>`
+			for _, issue := range report.Issues {
+				label := template.HTMLEscapeString(issue.Kind)
+				if issue.StorageKey != "" {
+					label += ` <code>` + template.HTMLEscapeString(issue.StorageKey) + `</code>`
+				}
+				if issue.Repaired {
+					label += ` (réparé)`
+				}
+				htmlContent += `
+            <li>` + label + ` : ` + template.HTMLEscapeString(issue.Detail) + `</li>`
+			}
+			htmlContent += `
+        </ul>`
+		}
+	}
+	htmlContent += `
+        <a href="/welcome">Retour à la page </a>
+    `
+	return c.HTML(http.StatusOK, htmlContent)
+}
+
+// Traitement du formulaire de lancement d'une vérification, exécutée en arrière-plan
+func (s *Server) scrubPostHandler(c echo.Context) error {
+	if s.scrubber.Running() {
+		return c.JSON(http.StatusConflict, map[string]string{"message": errScrubRunning.Error()})
+	}
+	repair := c.FormValue("repair") != ""
+	go func() {
+		if _, err := s.scrubber.Run(context.Background(), repair); err != nil && !errors.Is(err, errScrubRunning) {
+			log.Println("Erreur lors de la vérification du stockage :", err)
+		}
+	}()
+	return c.Redirect(http.StatusSeeOther, "/admin/scrub")
+}
+
+// Fonction pour créer le vérificateur d'intégrité du stockage donné à partir de la configuration
+func newScrubber(db *sql.DB, store BlobStore, cfg Config) (*Scrubber, error) {
+	replica, err := openReplicaStore(cfg.Storage)
+	if err != nil {
+		return nil, err
+	}
+	return &Scrubber{
+		refs:    &BlobRepo{db: db},
+		files:   &FileRepo{db: db},
+		runs:    &ScrubRepo{db: db},
+		store:   store,
+		replica: replica,
+		repair:  cfg.Scrub.Repair,
+	}, nil
+}
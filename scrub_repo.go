@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+	"unicode/utf8"
+)
+
+// Nombre de bilans de vérification conservés
+const keptScrubRuns = 30
+
+// ScrubRepo regroupe les requêtes sur les bilans du vérificateur d'intégrité
+type ScrubRepo struct {
+	db *sql.DB
+}
+
+// Fonction pour enregistrer le bilan d'une vérification avec ses anomalies, puis oublier les plus anciens
+func (r *ScrubRepo) Save(ctx context.Context, report *ScrubReport) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var runErr sql.NullString
+	if report.Error != "" {
+		runErr = sql.NullString{String: truncate(report.Error, 1024), Valid: true}
+	}
+	result, err := tx.ExecContext(ctx, "INSERT INTO scrub_runs (started_at, finished_at, checked, missing, corrupt, orphans, unreconciled, repaired, error) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		report.StartedAt, report.FinishedAt, report.Checked, report.Missing, report.Corrupt, report.Orphans, report.Unreconciled, report.Repaired, runErr)
+	if err != nil {
+		return err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return err
+	}
+	report.ID = int(id)
+
+	for _, issue := range report.Issues {
+		var key sql.NullString
+		if issue.StorageKey != "" {
+			key = sql.NullString{String: issue.StorageKey, Valid: true}
+		}
+		_, err := tx.ExecContext(ctx, "INSERT INTO scrub_issues (run_id, kind, storage_key, detail, repaired) VALUES (?, ?, ?, ?, ?)",
+			id, issue.Kind, key, truncate(issue.Detail, 1024), issue.Repaired)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Les anomalies des bilans oubliés disparaissent avec eux (ON DELETE CASCADE)
+	var oldest int
+	err = tx.QueryRowContext(ctx, "SELECT id FROM scrub_runs ORDER BY id DESC LIMIT 1 OFFSET ?", keptScrubRuns).Scan(&oldest)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+	if err == nil {
+		if _, err := tx.ExecContext(ctx, "DELETE FROM scrub_runs WHERE id <= ?", oldest); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// Fonction pour récupérer le dernier bilan avec ses anomalies ; found vaut false si aucune vérification n'a eu lieu
+func (r *ScrubRepo) Latest(ctx context.Context) (report ScrubReport, found bool, err error) {
+	var startedAt, finishedAt []byte
+	var runErr sql.NullString
+	err = r.db.QueryRowContext(ctx, "SELECT id, started_at, finished_at, checked, missing, corrupt, orphans, unreconciled, repaired, error FROM scrub_runs ORDER BY id DESC LIMIT 1").
+		Scan(&report.ID, &startedAt, &finishedAt, &report.Checked, &report.Missing, &report.Corrupt, &report.Orphans, &report.Unreconciled, &report.Repaired, &runErr)
+	if errors.Is(err, sql.ErrNoRows) {
+		return report, false, nil
+	}
+	if err != nil {
+		return report, false, err
+	}
+	report.StartedAt = parseDBTime(startedAt)
+	report.FinishedAt = parseDBTime(finishedAt)
+	report.Error = runErr.String
+
+	rows, err := r.db.QueryContext(ctx, "SELECT kind, storage_key, detail, repaired FROM scrub_issues WHERE run_id = ? ORDER BY id", report.ID)
+	if err != nil {
+		return report, false, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var issue ScrubIssue
+		var key sql.NullString
+		if err := rows.Scan(&issue.Kind, &key, &issue.Detail, &issue.Repaired); err != nil {
+			return report, false, err
+		}
+		issue.StorageKey = key.String
+		report.Issues = append(report.Issues, issue)
+	}
+	return report, true, rows.Err()
+}
+
+// Fonction pour connaître la date de début de la dernière vérification (zéro si aucune)
+func (r *ScrubRepo) LastStartedAt(ctx context.Context) (time.Time, error) {
+	var startedAt []byte
+	err := r.db.QueryRowContext(ctx, "SELECT started_at FROM scrub_runs ORDER BY id DESC LIMIT 1").Scan(&startedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return time.Time{}, nil
+	}
+	if err != nil {
+		return time.Time{}, err
+	}
+	return parseDBTime(startedAt), nil
+}
+
+// Fonction pour couper un texte à la taille d'une colonne sans couper un caractère
+func truncate(s string, max int) string {
+	if len(s) <= max {
+		return s
+	}
+	cut := max
+	for cut > 0 && !utf8.RuneStart(s[cut]) {
+		cut--
+	}
+	return s[:cut]
+}
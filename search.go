@@ -0,0 +1,868 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"log"
+	"math"
+	"mime"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf16"
+	"unicode/utf8"
+
+	"github.com/labstack/echo/v4"
+)
+
+// La recherche passe par un index aveugle : chaque mot (sans casse ni accents) et chacun de ses préfixes
+// est remplacé par un HMAC calculé avec une clé dérivée de celle de l'utilisateur. La base permet de retrouver
+// les documents qui contiennent un terme de la requête sans jamais contenir les mots eux-mêmes.
+
+// Limites de l'indexation
+const (
+	minPrefixLength   = 2       // Préfixe le plus court indexé (et mot le plus court)
+	maxPrefixLength   = 12      // Au-delà, un mot de la requête est cherché par ses 12 premières lettres
+	maxWordLength     = 64      // Les mots plus longs (empreintes, base64...) ne sont pas indexés
+	maxSearchText     = 1 << 20 // Octets de texte extrait indexés par fichier
+	maxDocumentTerms  = 20000   // Termes distincts gardés par document
+	searchTitleWeight = 3       // Un mot du titre ou du nom de fichier compte triple
+)
+
+// Limites d'une recherche
+const (
+	maxQueryWords    = 8
+	maxSearchResults = 50
+	snippetRadius    = 80 // Octets de contexte de part et d'autre du mot trouvé
+)
+
+// Erreur renvoyée pour un filtre de recherche mal formé
+var errSearchFilter = errors.New("filtre de recherche invalide")
+
+// Préfixes des termes : mot entier ou préfixe de mot
+const (
+	termWord   = 'w'
+	termPrefix = 'p'
+)
+
+// Types de contenu dont le texte est extrait pour la recherche
+var searchableTypes = map[string]bool{
+	"text/plain":      true,
+	"application/pdf": true,
+}
+
+// Lettres accentuées ramenées à leur forme simple (français, anglais et langues voisines)
+var accentFolds = map[rune]string{
+	'à': "a", 'â': "a", 'ä': "a", 'á': "a", 'ã': "a", 'å': "a", 'æ': "ae",
+	'ç': "c", 'é': "e", 'è': "e", 'ê': "e", 'ë': "e",
+	'î': "i", 'ï': "i", 'í': "i", 'ì': "i", 'ñ': "n",
+	'ô': "o", 'ö': "o", 'ó': "o", 'ò': "o", 'õ': "o", 'ø': "o", 'œ': "oe",
+	'ù': "u", 'û': "u", 'ü': "u", 'ú': "u", 'ÿ': "y", 'ý': "y", 'ß': "ss",
+}
+
+// Définir une structure pour représenter un mot d'un texte : position dans le texte d'origine et forme normalisée
+type searchToken struct {
+	Start, End int
+	Word       string
+}
+
+// Fonction pour découper un texte en mots normalisés (minuscules, sans accents) ; l'apostrophe sépare les mots,
+// donc « l'école » donne « ecole » (les mots d'une lettre ne sont pas gardés)
+func tokenize(text string) []searchToken {
+	var tokens []searchToken
+	var word strings.Builder
+	start := -1
+	flush := func(end int) {
+		if start >= 0 {
+			if n := utf8.RuneCountInString(word.String()); n >= minPrefixLength && n <= maxWordLength {
+				tokens = append(tokens, searchToken{Start: start, End: end, Word: word.String()})
+			}
+		}
+		word.Reset()
+		start = -1
+	}
+	for i, r := range text {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			flush(i)
+			continue
+		}
+		if start < 0 {
+			start = i
+		}
+		r = unicode.ToLower(r)
+		if folded, ok := accentFolds[r]; ok {
+			word.WriteString(folded)
+		} else {
+			word.WriteRune(r)
+		}
+	}
+	flush(len(text))
+	return tokens
+}
+
+// Fonction pour dériver la clé de l'index de recherche d'un utilisateur
+func searchKey(userKey []byte, ownerID int) []byte {
+	mac := hmac.New(sha256.New, userKey)
+	mac.Write([]byte(fmt.Sprintf("coffrefort:search-key:%d", ownerID)))
+	return mac.Sum(nil)
+}
+
+// Fonction pour calculer le terme aveugle d'un mot ou d'un préfixe (16 octets)
+func blindTerm(key []byte, kind byte, word string) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte{kind})
+	mac.Write([]byte(word))
+	return string(mac.Sum(nil)[:16])
+}
+
+// Fonction pour garder les maxPrefixLength premières lettres d'un mot
+func wordPrefix(word string, n int) string {
+	for i := range word {
+		if n == 0 {
+			return word[:i]
+		}
+		n--
+	}
+	return word
+}
+
+// Définir une structure pour représenter un texte à indexer avec son poids
+type weightedText struct {
+	Text   string
+	Weight int
+}
+
+// Fonction pour calculer les termes d'un document (mots entiers et préfixes) avec leurs occurrences pondérées
+func buildTerms(key []byte, fields ...weightedText) (map[string]int, int) {
+	terms := make(map[string]int)
+	length := 0
+	add := func(term string, weight int) {
+		if _, ok := terms[term]; ok || len(terms) < maxDocumentTerms {
+			terms[term] += weight
+		}
+	}
+	for _, field := range fields {
+		for _, token := range tokenize(field.Text) {
+			length++
+			add(blindTerm(key, termWord, token.Word), field.Weight)
+			n := 0
+			for i := range token.Word {
+				if n >= minPrefixLength {
+					add(blindTerm(key, termPrefix, token.Word[:i]), field.Weight)
+				}
+				if n++; n > maxPrefixLength {
+					break
+				}
+			}
+			if n <= maxPrefixLength {
+				add(blindTerm(key, termPrefix, token.Word), field.Weight)
+			}
+		}
+	}
+	return terms, length
+}
+
+// Données associées qui lient le texte extrait d'un fichier au contenu dont il vient
+func searchTextAD(storageKey string) []byte {
+	return []byte("coffrefort:search-text:" + storageKey)
+}
+
+// Fonction pour indexer une note dont le titre et le contenu sont en clair
+func (s *Server) indexNote(ctx context.Context, userKey []byte, note Note) error {
+	terms, length := buildTerms(searchKey(userKey, note.UserID),
+		weightedText{Text: note.Title, Weight: searchTitleWeight}, weightedText{Text: note.Content, Weight: 1})
+	doc := SearchDocument{UserID: note.UserID, NoteID: sql.NullInt64{Int64: int64(note.ID), Valid: true}, Length: length}
+	return s.search.Index(ctx, doc, terms)
+}
+
+// Fonction pour indexer un fichier : son nom, et le texte de son contenu pour du texte brut ou un PDF.
+// Le texte extrait est gardé chiffré avec la clé de données du fichier pour les extraits des résultats.
+func (s *Server) indexFile(ctx context.Context, userID int, userKey []byte, source searchSource) {
+	var text string
+	var dataKey []byte
+	if source.WrappedKey != nil {
+		var err error
+		if dataKey, err = openFileKey(userKey, source.WrappedKey, userID); err != nil {
+			log.Printf("Erreur lors de l'indexation du fichier %d : %v", source.FileID, err)
+			return
+		}
+	}
+	mediaType, _, _ := mime.ParseMediaType(source.MIMEType)
+	if searchableTypes[mediaType] {
+		var err error
+		if text, err = s.extractText(ctx, source, mediaType, dataKey); err != nil {
+			// Le nom du fichier reste cherchable même si son contenu est illisible
+			log.Printf("Texte du fichier %d impossible à extraire : %v", source.FileID, err)
+		}
+	}
+
+	terms, length := buildTerms(searchKey(userKey, userID),
+		weightedText{Text: source.FileName, Weight: searchTitleWeight}, weightedText{Text: text, Weight: 1})
+	doc := SearchDocument{
+		UserID:     userID,
+		FileID:     sql.NullInt64{Int64: int64(source.FileID), Valid: true},
+		StorageKey: sql.NullString{String: source.StorageKey, Valid: true},
+		Length:     length,
+	}
+	if text != "" && dataKey != nil {
+		sealed, err := sealKey(dataKey, []byte(text), searchTextAD(source.StorageKey))
+		if err != nil {
+			log.Printf("Erreur lors de l'indexation du fichier %d : %v", source.FileID, err)
+			return
+		}
+		doc.SealedText = sealed
+	}
+	if err := s.search.Index(ctx, doc, terms); err != nil {
+		log.Printf("Erreur lors de l'indexation du fichier %d : %v", source.FileID, err)
+	}
+}
+
+// Fonction pour indexer en arrière-plan les notes et fichiers d'un utilisateur qui ne le sont pas encore
+// (créés avant la mise en place de la recherche, ou dont le contenu a changé). Il faut sa clé : cela se fait à sa connexion.
+func (s *Server) indexVault(ctx context.Context, userID int, userKey []byte) {
+	notes, err := s.notes.ListUnindexed(ctx, userID)
+	if err != nil {
+		log.Println("Erreur lors de la recherche des notes à indexer :", err)
+		return
+	}
+	for _, note := range notes {
+		if err := openNote(userKey, &note); err != nil {
+			log.Printf("Erreur lors du déchiffrement de la note %d : %v", note.ID, err)
+			continue
+		}
+		if err := s.indexNote(ctx, userKey, note); err != nil {
+			log.Printf("Erreur lors de l'indexation de la note %d : %v", note.ID, err)
+		}
+	}
+
+	sources, err := s.files.ListUnindexed(ctx, userID)
+	if err != nil {
+		log.Println("Erreur lors de la recherche des fichiers à indexer :", err)
+		return
+	}
+	for _, source := range sources {
+		s.indexFile(ctx, userID, userKey, source)
+	}
+}
+
+// Fonction pour extraire le texte d'un contenu (texte brut ou PDF), limité à maxSearchText octets
+func (s *Server) extractText(ctx context.Context, source searchSource, mediaType string, dataKey []byte) (string, error) {
+	content, err := openContent(ctx, s.blobs, source.StorageKey, dataKey)
+	if err != nil {
+		return "", err
+	}
+	defer content.Close()
+
+	if mediaType == "application/pdf" {
+		data, err := io.ReadAll(io.LimitReader(content, maxPDFScan))
+		if err != nil {
+			return "", err
+		}
+		return pdfText(data), nil
+	}
+	data, err := io.ReadAll(io.LimitReader(content, maxSearchText))
+	if err != nil {
+		return "", err
+	}
+	return decodeText(data), nil
+}
+
+// Fonction pour lire un texte en UTF-8, ou en Latin-1 s'il n'est pas de l'UTF-8 valide
+func decodeText(data []byte) string {
+	// La lecture limitée peut couper le dernier caractère
+	for cut := 0; cut < utf8.UTFMax && cut < len(data); cut++ {
+		if utf8.Valid(data[:len(data)-cut]) {
+			return string(data[:len(data)-cut])
+		}
+	}
+	runes := make([]rune, len(data))
+	for i, b := range data {
+		runes[i] = rune(b)
+	}
+	return string(runes)
+}
+
+// Filtres et types de flux PDF qui ne contiennent pas de texte de page
+var pdfSkippedStreams = [][]byte{[]byte("/Image"), []byte("/FontFile"), []byte("/Length1"), []byte("/XRef"), []byte("/ObjStm"),
+	[]byte("/Metadata"), []byte("/EmbeddedFile"), []byte("/DCTDecode"), []byte("/JPXDecode"), []byte("/CCITTFaxDecode"), []byte("/JBIG2Decode")}
+
+// Fonction pour extraire le texte d'un PDF sans l'interpréter complètement : chaînes des opérateurs de texte
+// (Tj, TJ, ', ") des flux de contenu, décompressés s'ils sont en FlateDecode. Les polices à encodage propre
+// (CID sans table Unicode) donnent des chaînes illisibles, qui sont écartées.
+func pdfText(data []byte) string {
+	var out strings.Builder
+	inflater := newPDFInflater()
+	for rest := data; out.Len() < maxSearchText; {
+		i := bytes.Index(rest, []byte("stream"))
+		if i < 0 {
+			break
+		}
+		dict := rest[:i]
+		if j := bytes.LastIndex(dict, []byte("obj")); j >= 0 {
+			dict = dict[j:]
+		}
+		rest = rest[i+len("stream"):]
+		if bytes.HasPrefix(rest, []byte("\r\n")) {
+			rest = rest[2:]
+		} else if bytes.HasPrefix(rest, []byte("\n")) {
+			rest = rest[1:]
+		} else {
+			continue // « endstream » ou mot dans un texte
+		}
+		end := bytes.Index(rest, []byte("endstream"))
+		if end < 0 {
+			break
+		}
+		body := rest[:end]
+		rest = rest[end+len("endstream"):]
+
+		skip := false
+		for _, marker := range pdfSkippedStreams {
+			if bytes.Contains(dict, marker) {
+				skip = true
+				break
+			}
+		}
+		if skip {
+			continue
+		}
+		if bytes.Contains(dict, []byte("/FlateDecode")) {
+			inflated, err := inflater.inflate(body)
+			if errors.Is(err, errPDFInflateBudget) {
+				break
+			}
+			if err != nil && len(inflated) == 0 {
+				continue
+			}
+			body = inflated
+		} else if bytes.Contains(dict, []byte("/Filter")) {
+			continue // Autre compression, non prise en charge
+		}
+		pdfContentText(body, &out)
+	}
+	text := out.String()
+	if len(text) > maxSearchText {
+		text = strings.ToValidUTF8(text[:maxSearchText], "")
+	}
+	return text
+}
+
+// Fonction pour relever le texte d'un flux de contenu PDF
+func pdfContentText(content []byte, out *strings.Builder) {
+	var pending strings.Builder // Chaînes lues depuis le dernier opérateur
+	inArray := false
+	for i := 0; i < len(content); {
+		c := content[i]
+		switch {
+		case c == '(':
+			raw, next := pdfLiteralString(content, i)
+			pending.WriteString(pdfDecodeString(raw))
+			i = next
+		case c == '<' && i+1 < len(content) && content[i+1] == '<', c == '>' && i+1 < len(content) && content[i+1] == '>':
+			i += 2
+		case c == '<':
+			raw, next := pdfHexString(content, i)
+			pending.WriteString(pdfDecodeString(raw))
+			i = next
+		case c == '[':
+			inArray = true
+			i++
+		case c == ']':
+			inArray = false
+			i++
+		case c == '%':
+			for i < len(content) && content[i] != '\n' && content[i] != '\r' {
+				i++
+			}
+		case c == '/':
+			for i++; i < len(content) && !pdfDelimiter(content[i]); i++ {
+			}
+		case c == '-' || c == '+' || c == '.' || (c >= '0' && c <= '9'):
+			j := i + 1
+			for j < len(content) && (content[j] == '.' || (content[j] >= '0' && content[j] <= '9')) {
+				j++
+			}
+			// Dans un tableau TJ, un grand décalage vers la droite sépare deux mots
+			if n, err := strconv.ParseFloat(string(content[i:j]), 64); err == nil && inArray && n < -200 {
+				pending.WriteByte(' ')
+			}
+			i = j
+		case pdfDelimiter(c):
+			i++
+		default:
+			j := i
+			for j < len(content) && !pdfDelimiter(content[j]) {
+				j++
+			}
+			switch string(content[i:j]) {
+			case "Tj", "TJ":
+				out.WriteString(pending.String())
+			case "'", `"`:
+				out.WriteByte('\n')
+				out.WriteString(pending.String())
+			case "Td", "TD", "T*", "Tm", "ET":
+				out.WriteByte(' ')
+			case "ID":
+				// Image en ligne : données binaires jusqu'à EI
+				if k := bytes.Index(content[j:], []byte("EI")); k >= 0 {
+					j += k + 2
+				} else {
+					j = len(content)
+				}
+			}
+			pending.Reset()
+			i = j
+		}
+	}
+}
+
+// Fonction pour savoir si un octet sépare deux éléments d'un flux PDF
+func pdfDelimiter(c byte) bool {
+	switch c {
+	case ' ', '\t', '\r', '\n', '\f', 0, '(', ')', '<', '>', '[', ']', '{', '}', '/', '%':
+		return true
+	}
+	return false
+}
+
+// Fonction pour lire une chaîne littérale PDF « (...) » avec ses échappements et parenthèses imbriquées
+func pdfLiteralString(content []byte, i int) ([]byte, int) {
+	var raw []byte
+	depth := 0
+	for i++; i < len(content); i++ {
+		c := content[i]
+		switch c {
+		case '(':
+			depth++
+		case ')':
+			if depth == 0 {
+				return raw, i + 1
+			}
+			depth--
+		case '\\':
+			if i+1 >= len(content) {
+				return raw, len(content)
+			}
+			i++
+			switch e := content[i]; e {
+			case 'n':
+				raw = append(raw, '\n')
+			case 'r':
+				raw = append(raw, '\r')
+			case 't':
+				raw = append(raw, '\t')
+			case 'b', 'f':
+			case '\r':
+				if i+1 < len(content) && content[i+1] == '\n' {
+					i++
+				}
+			case '\n':
+			default:
+				if e >= '0' && e <= '7' {
+					value := 0
+					for k := 0; k < 3 && i < len(content) && content[i] >= '0' && content[i] <= '7'; k++ {
+						value = value*8 + int(content[i]-'0')
+						i++
+					}
+					i--
+					raw = append(raw, byte(value))
+				} else {
+					raw = append(raw, e)
+				}
+			}
+			continue
+		}
+		raw = append(raw, c)
+	}
+	return raw, len(content)
+}
+
+// Fonction pour lire une chaîne hexadécimale PDF « <...> »
+func pdfHexString(content []byte, i int) ([]byte, int) {
+	var raw []byte
+	var digits []byte
+	for i++; i < len(content) && content[i] != '>'; i++ {
+		if c := content[i]; (c >= '0' && c <= '9') || (c|0x20 >= 'a' && c|0x20 <= 'f') {
+			digits = append(digits, c)
+		}
+	}
+	if len(digits)%2 == 1 {
+		digits = append(digits, '0')
+	}
+	for k := 0; k < len(digits); k += 2 {
+		v, _ := strconv.ParseUint(string(digits[k:k+2]), 16, 8)
+		raw = append(raw, byte(v))
+	}
+	return raw, i + 1
+}
+
+// Caractères de la plage 0x80-0x9F de l'encodage WinAnsi, le plus courant dans les PDF
+var winAnsiRunes = map[byte]rune{
+	0x80: '€', 0x85: '…', 0x8C: 'Œ', 0x91: '‘', 0x92: '’', 0x93: '“', 0x94: '”', 0x96: '–', 0x97: '—', 0x9C: 'œ',
+}
+
+// Fonction pour décoder une chaîne PDF : UTF-16 si elle commence par une marque d'ordre, WinAnsi sinon.
+// Une chaîne faite surtout de caractères de contrôle vient d'une police à encodage propre : elle est écartée.
+func pdfDecodeString(raw []byte) string {
+	if len(raw) >= 2 && raw[0] == 0xFE && raw[1] == 0xFF {
+		units := make([]uint16, 0, len(raw)/2)
+		for k := 2; k+1 < len(raw); k += 2 {
+			units = append(units, uint16(raw[k])<<8|uint16(raw[k+1]))
+		}
+		return string(utf16.Decode(units))
+	}
+	controls := 0
+	runes := make([]rune, 0, len(raw))
+	for _, b := range raw {
+		switch {
+		case b < 0x20 && b != '\n' && b != '\t':
+			controls++
+		case b >= 0x80 && b < 0xA0:
+			if r, ok := winAnsiRunes[b]; ok {
+				runes = append(runes, r)
+			}
+		default:
+			runes = append(runes, rune(b))
+		}
+	}
+	if controls*4 > len(raw) {
+		return ""
+	}
+	return string(runes)
+}
+
+// Définir une structure pour représenter un résultat de recherche
+type searchResult struct {
+	DocumentID int
+	NoteID     sql.NullInt64
+	FileID     sql.NullInt64
+	Date       time.Time
+	Score      float64
+
+	Title    string
+	Link     string
+	Snippet  string // HTML déjà échappé
+	Location string
+}
+
+// Fonction pour lire les filtres d'une recherche dans l'URL
+func (s *Server) parseSearchFilter(c echo.Context, userID int) (SearchFilter, error) {
+	filter := SearchFilter{Type: c.QueryParam("type")}
+	switch filter.Type {
+	case "", "note", "file", "pdf", "text", "image":
+	default:
+		return filter, fmt.Errorf("%w : type inconnu", errSearchFilter)
+	}
+	if value := strings.TrimSpace(c.QueryParam("from")); value != "" {
+		from, err := time.ParseInLocation("2006-01-02", value, time.Local)
+		if err != nil {
+			return filter, fmt.Errorf("%w : date de début invalide", errSearchFilter)
+		}
+		filter.From = from
+	}
+	if value := strings.TrimSpace(c.QueryParam("to")); value != "" {
+		to, err := time.ParseInLocation("2006-01-02", value, time.Local)
+		if err != nil {
+			return filter, fmt.Errorf("%w : date de fin invalide", errSearchFilter)
+		}
+		filter.To = to.AddDate(0, 0, 1) // La date de fin est comprise
+	}
+	folderID, err := parseFolderID(c.QueryParam("folder"))
+	if err != nil {
+		return filter, err
+	}
+	if folderID.Valid {
+		folder, err := s.authz.Folder(c.Request().Context(), userID, folderID.Int64, AccessRead)
+		if err != nil {
+			return filter, err
+		}
+		if filter.Folders, err = s.folders.Tree(c.Request().Context(), folder.UserID, folderID.Int64); err != nil {
+			return filter, err
+		}
+	}
+	return filter, nil
+}
+
+// Fonction pour chercher les mots d'une requête (chacun comme début de mot) et classer les documents trouvés (BM25).
+// Un document doit contenir tous les mots ; un mot entier compte plus qu'un simple préfixe.
+func (s *Server) runSearch(ctx context.Context, userID int, userKey []byte, words []string, filter SearchFilter) ([]searchResult, error) {
+	key := searchKey(userKey, userID)
+	prefixes := make([]string, len(words))
+	exact := make([]string, len(words))
+	var terms []string
+	for i, word := range words {
+		prefixes[i] = blindTerm(key, termPrefix, wordPrefix(word, maxPrefixLength))
+		exact[i] = blindTerm(key, termWord, word)
+		terms = append(terms, prefixes[i], exact[i])
+	}
+	matches, err := s.search.Match(ctx, userID, terms, filter)
+	if err != nil {
+		return nil, err
+	}
+	count, avgLength, err := s.search.Stats(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	type docMatch struct {
+		result searchResult
+		length int
+		freq   map[string]int
+	}
+	docs := make(map[int]*docMatch)
+	for _, match := range matches {
+		doc, ok := docs[match.DocumentID]
+		if !ok {
+			doc = &docMatch{result: searchResult{DocumentID: match.DocumentID, NoteID: match.NoteID, FileID: match.FileID, Date: match.Date},
+				length: match.Length, freq: make(map[string]int)}
+			docs[match.DocumentID] = doc
+		}
+		doc.freq[match.Term] = match.Frequency
+	}
+	docFreq := make(map[string]int)
+	for _, doc := range docs {
+		for term := range doc.freq {
+			docFreq[term]++
+		}
+	}
+
+	const k1, b = 1.2, 0.75
+	var results []searchResult
+	for _, doc := range docs {
+		score := 0.0
+		for i := range words {
+			if doc.freq[prefixes[i]] == 0 {
+				score = -1
+				break
+			}
+			norm := k1 * (1 - b + b*float64(doc.length)/math.Max(avgLength, 1))
+			for _, term := range []string{prefixes[i], exact[i]} {
+				f := float64(doc.freq[term])
+				if f == 0 {
+					continue
+				}
+				idf := math.Log(1 + (float64(count)-float64(docFreq[term])+0.5)/(float64(docFreq[term])+0.5))
+				score += idf * f * (k1 + 1) / (f + norm)
+			}
+		}
+		if score >= 0 {
+			doc.result.Score = score
+			results = append(results, doc.result)
+		}
+	}
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Score != results[j].Score {
+			return results[i].Score > results[j].Score
+		}
+		return results[i].Date.After(results[j].Date)
+	})
+	if len(results) > maxSearchResults {
+		results = results[:maxSearchResults]
+	}
+	return results, nil
+}
+
+// Fonction pour compléter un résultat : titre, lien et extrait autour du premier mot trouvé.
+// Renvoie false si la note ou le fichier n'est plus lisible (supprimé entre-temps).
+func (s *Server) describeResult(ctx context.Context, userKey []byte, words []string, result *searchResult) bool {
+	if result.NoteID.Valid {
+		note, err := s.notes.Get(ctx, result.NoteID.Int64)
+		if err == nil {
+			err = openNote(userKey, &note)
+		}
+		if err != nil {
+			return false
+		}
+		result.Title = note.Title
+		if strings.TrimSpace(result.Title) == "" {
+			result.Title = "Note sans titre"
+		}
+		result.Link = welcomeURL(note.FolderID) + "#note-" + strconv.Itoa(note.ID)
+		result.Snippet = searchSnippet(note.Content, words)
+		return true
+	}
+
+	file, err := s.files.Get(ctx, result.FileID.Int64)
+	if err != nil {
+		return false
+	}
+	result.Title = file.FileName
+	result.Link = "/view-file/" + strconv.Itoa(file.ID)
+	result.Location = `<a href="` + template.HTMLEscapeString(welcomeURL(file.FolderID)) + `">Ouvrir le dossier</a>`
+	storageKey, sealed, err := s.search.FileText(ctx, file.ID)
+	if err != nil || sealed == nil || storageKey != file.StorageKey || file.WrappedKey == nil {
+		return true
+	}
+	dataKey, err := openFileKey(userKey, file.WrappedKey, file.UserID)
+	if err != nil {
+		return true
+	}
+	if text, err := openKey(dataKey, sealed, searchTextAD(storageKey)); err == nil {
+		result.Snippet = searchSnippet(string(text), words)
+	}
+	return true
+}
+
+// Fonction pour construire l'extrait HTML d'un texte autour du premier mot de la requête, mots trouvés surlignés
+func searchSnippet(text string, words []string) string {
+	if strings.TrimSpace(text) == "" {
+		return ""
+	}
+	tokens := tokenize(text)
+	matches := func(token searchToken) bool {
+		for _, word := range words {
+			if strings.HasPrefix(token.Word, word) {
+				return true
+			}
+		}
+		return false
+	}
+	center := 0
+	for _, token := range tokens {
+		if matches(token) {
+			center = token.Start
+			break
+		}
+	}
+
+	start, end := center-snippetRadius, center+2*snippetRadius
+	if start < 0 {
+		end, start = end-start, 0
+	}
+	if end > len(text) {
+		end = len(text)
+	}
+	for start > 0 && !utf8.RuneStart(text[start]) {
+		start--
+	}
+	for end < len(text) && !utf8.RuneStart(text[end]) {
+		end++
+	}
+
+	var snippet strings.Builder
+	if start > 0 {
+		snippet.WriteString("… ")
+	}
+	at := start
+	for _, token := range tokens {
+		if token.Start < start || token.End > end || !matches(token) {
+			continue
+		}
+		snippet.WriteString(template.HTMLEscapeString(text[at:token.Start]))
+		snippet.WriteString("<mark>" + template.HTMLEscapeString(text[token.Start:token.End]) + "</mark>")
+		at = token.End
+	}
+	snippet.WriteString(template.HTMLEscapeString(text[at:end]))
+	if end < len(text) {
+		snippet.WriteString(" …")
+	}
+	return snippet.String()
+}
+
+// Fonction pour construire le formulaire de recherche, avec ses filtres
+func renderSearchForm(query, kind string, folder sql.NullInt64, inFolder bool, from, to string) string {
+	folderFilter := ""
+	if folder.Valid {
+		checked := ""
+		if inFolder {
+			checked = " checked"
+		}
+		folderFilter = `
+        <label><input type="checkbox" name="folder" value="` + strconv.FormatInt(folder.Int64, 10) + `"` + checked + `> Dans ce dossier</label>`
+	}
+	types := [][2]string{{"", "Tout"}, {"note", "Notes"}, {"file", "Fichiers"}, {"pdf", "PDF"}, {"text", "Textes"}, {"image", "Images"}}
+	return `<form class="search-form" action="/search" method="get">
+        <input type="search" name="q" value="` + template.HTMLEscapeString(query) + `" placeholder="Rechercher dans les notes et les fichiers" required>
+        ` + renderSelect("type", types, kind) + folderFilter + `
+        <label>Du <input type="date" name="from" value="` + template.HTMLEscapeString(from) + `"></label>
+        <label>au <input type="date" name="to" value="` + template.HTMLEscapeString(to) + `"></label>
+        <button type="submit">Rechercher</button>
+    </form>`
+}
+
+// Gestionnaire de route pour la recherche dans les notes, les noms de fichiers et le texte des documents
+func (s *Server) searchHandler(c echo.Context) error {
+	ctx := c.Request().Context()
+	userID := currentUserID(c)
+	query := c.QueryParam("q")
+
+	filter, err := s.parseSearchFilter(c, userID)
+	if errors.Is(err, errSearchFilter) {
+		return c.JSON(http.StatusBadRequest, map[string]string{"message": err.Error()})
+	}
+	if err != nil {
+		return folderErrorResponse(c, err)
+	}
+	userKey, err := s.keys.ForRequest(c, userID)
+	if err != nil {
+		return keyringLockedResponse(c)
+	}
+
+	var words []string
+	seen := make(map[string]bool)
+	for _, token := range tokenize(query) {
+		if !seen[token.Word] && len(words) < maxQueryWords {
+			seen[token.Word] = true
+			words = append(words, token.Word)
+		}
+	}
+
+	var results []searchResult
+	if len(words) > 0 {
+		if results, err = s.runSearch(ctx, userID, userKey, words, filter); err != nil {
+			log.Println("Erreur lors de la recherche :", err)
+			return err
+		}
+	}
+
+	folderID, _ := parseFolderID(c.QueryParam("folder"))
+	htmlContent := `
+        <h1>Recherche</h1>
+        ` + renderSearchForm(query, filter.Type, folderID, folderID.Valid, c.QueryParam("from"), c.QueryParam("to"))
+	found := 0
+	var list strings.Builder
+	for i := range results {
+		result := &results[i]
+		if !s.describeResult(ctx, userKey, words, result) {
+			continue
+		}
+		found++
+		kind := "Fichier"
+		if result.NoteID.Valid {
+			kind = "Note"
+		}
+		list.WriteString(`
+        <li>
+            <strong><a href="` + template.HTMLEscapeString(result.Link) + `">` + template.HTMLEscapeString(result.Title) + `</a></strong>
+            <small>` + kind + ` · ` + result.Date.Format("02/01/2006") + `</small> ` + result.Location + `
+            <p>` + result.Snippet + `</p>
+        </li>`)
+	}
+	switch {
+	case len(words) == 0:
+		htmlContent += `
+        <p>Saisir au moins un mot de deux lettres.</p>`
+	case found == 0:
+		htmlContent += `
+        <p>Aucun résultat.</p>`
+	default:
+		htmlContent += `
+        <p>` + strconv.Itoa(found) + ` résultat(s)</p>
+        <ol>` + list.String() + `
+        </ol>`
+	}
+	htmlContent += `
+        <a href="/welcome">Retour à la page </a>
+    `
+	return c.HTML(http.StatusOK, htmlContent)
+}
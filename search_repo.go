@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+)
+
+// Nombre de termes écrits par requête INSERT lors de l'indexation d'un document
+const searchInsertBatch = 500
+
+// Définir une structure pour représenter un document de l'index : une note ou un fichier
+type SearchDocument struct {
+	UserID     int
+	NoteID     sql.NullInt64
+	FileID     sql.NullInt64
+	StorageKey sql.NullString // Contenu indexé d'un fichier (un autre contenu demande une nouvelle indexation)
+	Length     int            // Nombre de mots du document, pour le classement
+	SealedText []byte         // Texte extrait du fichier, chiffré (nil pour une note ou un fichier sans texte)
+}
+
+// Définir une structure pour représenter les filtres d'une recherche
+type SearchFilter struct {
+	Type    string  // "" (tout), "note", "file", "pdf", "text" ou "image"
+	Folders []int64 // Dossiers où chercher (nil : tout le coffre)
+	From    time.Time
+	To      time.Time // Exclue
+}
+
+// Définir une structure pour représenter un terme de la requête trouvé dans un document
+type searchMatch struct {
+	DocumentID int
+	NoteID     sql.NullInt64
+	FileID     sql.NullInt64
+	Length     int
+	Date       time.Time // Création de la note ou envoi du fichier
+	Term       string
+	Frequency  int
+}
+
+// SearchRepo regroupe les requêtes sur l'index de recherche (search_documents et search_terms)
+type SearchRepo struct {
+	db *sql.DB
+}
+
+// Fonction pour (ré)indexer un document : l'ancienne entrée de la note ou du fichier est remplacée
+func (r *SearchRepo) Index(ctx context.Context, doc SearchDocument, terms map[string]int) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, "DELETE FROM search_documents WHERE note_id = ? OR file_id = ?", doc.NoteID, doc.FileID); err != nil {
+		return err
+	}
+	result, err := tx.ExecContext(ctx, "INSERT INTO search_documents (user_id, note_id, file_id, storage_key, length, sealed_text, indexed_at) VALUES (?, ?, ?, ?, ?, ?, ?)",
+		doc.UserID, doc.NoteID, doc.FileID, doc.StorageKey, doc.Length, doc.SealedText, time.Now())
+	if err != nil {
+		return err
+	}
+	documentID, err := result.LastInsertId()
+	if err != nil {
+		return err
+	}
+
+	values := make([]string, 0, searchInsertBatch)
+	args := make([]interface{}, 0, 3*searchInsertBatch)
+	flush := func() error {
+		if len(values) == 0 {
+			return nil
+		}
+		_, err := tx.ExecContext(ctx, "INSERT INTO search_terms (document_id, term, frequency) VALUES "+strings.Join(values, ", "), args...)
+		values, args = values[:0], args[:0]
+		return err
+	}
+	for term, frequency := range terms {
+		values = append(values, "(?, ?, ?)")
+		args = append(args, documentID, []byte(term), frequency)
+		if len(values) == searchInsertBatch {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+	}
+	if err := flush(); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Fonction pour compter les documents indexés d'un utilisateur et leur longueur moyenne (pour le classement)
+func (r *SearchRepo) Stats(ctx context.Context, userID int) (count int, avgLength float64, err error) {
+	err = r.db.QueryRowContext(ctx, "SELECT COUNT(*), COALESCE(AVG(length), 0) FROM search_documents WHERE user_id = ?", userID).Scan(&count, &avgLength)
+	return count, avgLength, err
+}
+
+// Fonction pour trouver les occurrences des termes dans les documents d'un utilisateur, hors corbeille et selon les filtres
+func (r *SearchRepo) Match(ctx context.Context, userID int, terms []string, filter SearchFilter) ([]searchMatch, error) {
+	if len(terms) == 0 {
+		return nil, nil
+	}
+	query := "SELECT d.id, d.note_id, d.file_id, d.length, COALESCE(n.created_at, f.uploaded_at), t.term, t.frequency FROM search_terms t " +
+		"JOIN search_documents d ON d.id = t.document_id LEFT JOIN notes n ON n.id = d.note_id LEFT JOIN files f ON f.id = d.file_id " +
+		"WHERE d.user_id = ? AND n.deleted_at IS NULL AND f.deleted_at IS NULL AND t.term IN (?" + strings.Repeat(", ?", len(terms)-1) + ")"
+	args := []interface{}{userID}
+	for _, term := range terms {
+		args = append(args, []byte(term))
+	}
+
+	switch filter.Type {
+	case "note":
+		query += " AND d.note_id IS NOT NULL"
+	case "file":
+		query += " AND d.file_id IS NOT NULL"
+	case "pdf":
+		query += " AND f.mime_type = 'application/pdf'"
+	case "text":
+		query += " AND f.mime_type LIKE 'text/%'"
+	case "image":
+		query += " AND f.mime_type LIKE 'image/%'"
+	}
+	if filter.Folders != nil {
+		if len(filter.Folders) == 0 {
+			return nil, nil
+		}
+		query += " AND COALESCE(n.folder_id, f.folder_id) IN (?" + strings.Repeat(", ?", len(filter.Folders)-1) + ")"
+		for _, id := range filter.Folders {
+			args = append(args, id)
+		}
+	}
+	if !filter.From.IsZero() {
+		query += " AND COALESCE(n.created_at, f.uploaded_at) >= ?"
+		args = append(args, filter.From)
+	}
+	if !filter.To.IsZero() {
+		query += " AND COALESCE(n.created_at, f.uploaded_at) < ?"
+		args = append(args, filter.To)
+	}
+
+	rows, err := r.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var matches []searchMatch
+	for rows.Next() {
+		var match searchMatch
+		var date, term []byte
+		if err := rows.Scan(&match.DocumentID, &match.NoteID, &match.FileID, &match.Length, &date, &term, &match.Frequency); err != nil {
+			return nil, err
+		}
+		match.Date, match.Term = parseDBTime(date), string(term)
+		matches = append(matches, match)
+	}
+	return matches, rows.Err()
+}
+
+// Fonction pour récupérer le texte extrait (chiffré) d'un fichier et la clé du contenu dont il vient
+func (r *SearchRepo) FileText(ctx context.Context, fileID int) (storageKey string, sealed []byte, err error) {
+	var key sql.NullString
+	err = r.db.QueryRowContext(ctx, "SELECT storage_key, sealed_text FROM search_documents WHERE file_id = ?", fileID).Scan(&key, &sealed)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil, nil
+	}
+	return key.String, sealed, err
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// Server possède l'unique pool de connexions et les dépôts utilisés par les gestionnaires HTTP
+type Server struct {
+	db       *sql.DB
+	blobs    BlobStore // Stockage des contenus des fichiers (disque local, S3...)
+	blobRefs *BlobRepo // Références des lignes vers les blobs, partagés entre contenus identiques
+
+	users   *UserRepo
+	notes   *NoteRepo
+	files   *FileRepo
+	folders *FolderRepo
+	search  *SearchRepo // Index de recherche des notes et des fichiers
+
+	authz *Authorizer // Contrôle d'accès commun aux notes, fichiers et dossiers
+	keys  *KeyManager // Clés de chiffrement des utilisateurs
+
+	versions *VersionRepo   // Anciennes versions des fichiers
+	trash    *TrashRepo     // Notes, fichiers et dossiers supprimés, restaurables jusqu'à leur purge
+	uploads  *UploadRepo    // Envois reprenables en cours
+	staging  *UploadStaging // Morceaux chiffrés des envois en cours
+
+	versionDefaults VersionPolicy // Conservation des versions pour les utilisateurs sans politique propre
+	trashRetention  time.Duration // Durée de séjour dans la corbeille avant la purge définitive
+	quotas          QuotaPolicy   // Quotas de stockage par rôle
+
+	scrubber      *Scrubber     // Vérificateur d'intégrité du stockage
+	scrubInterval time.Duration // Délai entre deux vérifications planifiées (0 : aucune)
+
+	extract      ExtractConfig // Limites de la décompression des archives déposées
+	uploadPolicy UploadPolicy  // Taille maximale, types acceptés et types mis en quarantaine
+
+	scanner        Scanner       // Antivirus (nil : pas d'analyse)
+	scanRequired   bool          // Refuser les envois quand l'antivirus ne répond pas
+	rescanInterval time.Duration // Délai entre deux contrôles des signatures (0 : pas de réanalyse planifiée)
+	notifications  *NotificationRepo
+
+	shares *ShareRepo // Liens de partage publics
+	grants *GrantRepo // Accès accordés entre utilisateurs
+}
+
+// Fonction pour créer le serveur à partir d'un pool déjà ouvert
+func NewServer(db *sql.DB, cfg Config) (*Server, error) {
+	blobs, err := openBlobStore(cfg.Storage)
+	if err != nil {
+		return nil, err
+	}
+	provider, err := openKeyProvider(cfg)
+	if err != nil {
+		return nil, err
+	}
+	s := &Server{
+		db:       db,
+		blobs:    blobs,
+		blobRefs: &BlobRepo{db: db},
+		users:    &UserRepo{db: db},
+		notes:    &NoteRepo{db: db},
+		files:    &FileRepo{db: db},
+		folders:  &FolderRepo{db: db},
+		search:   &SearchRepo{db: db},
+		versions: &VersionRepo{db: db},
+		uploads:  &UploadRepo{db: db},
+		staging:  NewUploadStaging(cfg.Storage.StagingDir, cfg.Storage.UploadExpiry),
+
+		versionDefaults: VersionPolicy{MaxCount: cfg.Versions.MaxCount, MaxDays: cfg.Versions.MaxDays},
+		trashRetention:  cfg.Trash.Retention,
+		quotas:          newQuotaPolicy(cfg.Quotas),
+		scrubInterval:   cfg.Scrub.Interval,
+		extract:         cfg.Extract,
+		uploadPolicy:    newUploadPolicy(cfg.Upload),
+		scanner:         openScanner(cfg.Scan),
+		scanRequired:    cfg.Scan.Required,
+		rescanInterval:  cfg.Scan.RescanInterval,
+		notifications:   &NotificationRepo{db: db},
+		shares:          &ShareRepo{db: db},
+		grants:          &GrantRepo{db: db},
+	}
+	if s.scrubber, err = newScrubber(db, blobs, cfg); err != nil {
+		return nil, err
+	}
+	s.trash = &TrashRepo{db: db, folders: s.folders}
+	s.authz = &Authorizer{notes: s.notes, files: s.files, folders: s.folders, grants: s.grants}
+	s.keys = &KeyManager{provider: provider, keys: &UserKeyRepo{db: db}, sessions: &SessionKeyRepo{db: db}, ring: NewKeyring()}
+	return s, nil
+}
+
+// Fonction pour ouvrir et vérifier le pool de connexions MySQL
+func openDatabase(ctx context.Context, dsn string, pool DatabaseConfig) (*sql.DB, error) {
+	db, err := sql.Open("mysql", dsn)
+	if err != nil {
+		return nil, err
+	}
+	db.SetMaxOpenConns(pool.MaxOpenConns)
+	db.SetMaxIdleConns(pool.MaxIdleConns)
+	db.SetConnMaxLifetime(pool.ConnMaxLifetime)
+	db.SetConnMaxIdleTime(pool.ConnMaxIdleTime)
+
+	pingCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	if err := db.PingContext(pingCtx); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+// Fonction pour déclarer les routes de l'application
+func (s *Server) routes(e *echo.Echo) {
+	e.GET("/", homeHandler)
+	e.GET("/users", s.listUsersHandler) // Afficher tous les utilisateurs
+	e.GET("/register", registerHandler) // Page d'inscription (affichage du formulaire)
+	e.POST("/register", s.registerPostHandler)
+	e.GET("/delete", deleteFormHandler)  // Afficher le formulaire de suppression
+	e.POST("/delete", s.deleteHandler)   // Supprimer un utilisateur
+	e.GET("/login", loginHandler)        // Page de connexion
+	e.POST("/login", s.loginPostHandler) // Traitement du formulaire de connexion
+	e.POST("/logout", s.logoutHandler)   // Déconnexion de l'utilisateur
+	e.GET("/welcome", s.welcomeHandler)
+	// Toutes les routes sur les notes, fichiers et dossiers exigent une session et passent par l'Authorizer
+	auth := s.requireUser
+	e.GET("/create-note", createNoteHandler, auth)        // Afficher le formulaire pour créer une note
+	e.POST("/create-note", s.createNotePostHandler, auth) // Traitement du formulaire pour créer une note
+	e.GET("/upload-file", s.uploadFileHandler, auth)      // Afficher le formulaire pour déposer un fichier
+	e.POST("/upload-file", s.uploadFilePostHandler, auth) // Traitement du formulaire pour déposer un fichier
+	// Envois reprenables (protocole tus 1.0) pour les gros fichiers
+	e.OPTIONS("/uploads", s.tusOptionsHandler, tusProtocol)
+	e.POST("/uploads", s.tusCreateHandler, auth, tusProtocol)
+	e.HEAD("/uploads/:id", s.tusHeadHandler, auth, tusProtocol)
+	e.PATCH("/uploads/:id", s.tusPatchHandler, auth, tusProtocol)
+	e.DELETE("/uploads/:id", s.tusDeleteHandler, auth, tusProtocol)
+	// Route pour visualiser le contenu du fichier
+	e.GET("/view-file/:id", s.viewFileHandler, auth)
+	e.HEAD("/view-file/:id", s.viewFileHandler, auth)
+	e.GET("/files/:id/thumbnail", s.thumbnailHandler, auth)
+	e.POST("/archive", s.archiveHandler, auth)
+	e.GET("/search", s.searchHandler, auth) // Recherche dans les notes, les noms de fichiers et le texte des documents
+	e.POST("/notifications/:id/read", s.readNotificationPostHandler, auth)
+	// Liens de partage : création et révocation par le propriétaire, accès public par le jeton
+	e.GET("/shares/new", s.newShareHandler, auth)
+	e.POST("/shares", s.createSharePostHandler, auth)
+	e.POST("/shares/:id/revoke", s.revokeSharePostHandler, auth)
+	e.GET("/s/:token", s.publicShareHandler)
+	e.HEAD("/s/:token", s.publicShareHandler)
+	e.POST("/s/:token", s.sharePasswordPostHandler)
+	e.GET("/s/:token/files/:id", s.publicShareFileHandler)
+	e.HEAD("/s/:token/files/:id", s.publicShareFileHandler)
+	e.POST("/s/:token/files/:id", s.sharePasswordPostHandler)
+	// Accès accordés entre utilisateurs (lecture, écriture, gestion), hérités dans l'arborescence
+	e.GET("/grants/new", s.newGrantHandler, auth)
+	e.POST("/grants", s.createGrantPostHandler, auth)
+	e.POST("/grants/:id/revoke", s.revokeGrantPostHandler, auth)
+	e.GET("/shared/folders/:id", s.sharedFolderHandler, auth)
+	e.GET("/shared/notes/:id", s.sharedNoteHandler, auth)
+	e.DELETE("/files/:id", s.deleteFileHandler, auth)
+	// Route pour mettre un fichier à la corbeille
+	e.POST("/delete-file/:id", s.deleteFileHandler, auth)
+	// Historique des versions d'un fichier
+	e.GET("/files/:id/versions", s.fileVersionsHandler, auth)
+	e.GET("/files/:id/versions/:version", s.viewFileVersionHandler, auth)
+	e.HEAD("/files/:id/versions/:version", s.viewFileVersionHandler, auth)
+	e.POST("/files/:id/versions/:version/restore", s.restoreFileVersionHandler, auth)
+	e.GET("/version-policy", s.versionPolicyHandler, auth)
+	e.POST("/version-policy", s.versionPolicyPostHandler, auth)
+	// Corbeille : restauration, suppression définitive et vidage
+	e.GET("/trash", s.trashHandler, auth)
+	e.POST("/trash/empty", s.emptyTrashHandler, auth)
+	e.POST("/trash/:kind/:id/restore", s.restoreTrashHandler, auth)
+	e.POST("/trash/:kind/:id/purge", s.purgeTrashHandler, auth)
+	// Changement du mot de passe (la clé de chiffrement est protégée à nouveau, les fichiers ne sont pas rechiffrés)
+	e.GET("/change-password", changePasswordHandler, auth)
+	e.POST("/change-password", s.changePasswordPostHandler, auth)
+	// Route pour la suppression du compte utilisateur
+	e.POST("/delete-account", s.deleteAccountHandler, auth)
+	// Route pour afficher la page de confirmation de suppression de compte
+	e.GET("/goodbye", func(c echo.Context) error {
+		return c.File("goodbye.html")
+	})
+	// Ajoutez cette ligne dans votre fonction main() pour configurer la gestion de la route DELETE
+	e.DELETE("/delete-account", s.deleteAccountHandler, auth)
+
+	e.POST("/delete-note/:id", s.deleteNoteHandler, auth)
+	e.POST("/upload-file", s.uploadFileHandler, auth)
+
+	// Routes pour l'arborescence de dossiers
+	e.POST("/create-folder", s.createFolderHandler, auth)
+	e.POST("/rename-folder/:id", s.renameFolderHandler, auth)
+	e.POST("/move-folder/:id", s.moveFolderHandler, auth)
+	e.POST("/delete-folder/:id", s.deleteFolderHandler, auth)
+	e.DELETE("/folders/:id", s.deleteFolderHandler, auth)
+	e.POST("/move-file/:id", s.moveFileHandler, auth)
+	e.POST("/move-note/:id", s.moveNoteHandler, auth)
+
+	// Administration des quotas de stockage
+	e.GET("/admin/quotas", s.quotasHandler, s.requireAdmin)
+	e.POST("/admin/quotas/:id", s.quotaPostHandler, s.requireAdmin)
+	e.GET("/admin/scrub", s.scrubHandler, s.requireAdmin)
+	e.POST("/admin/scrub", s.scrubPostHandler, s.requireAdmin)
+	e.GET("/admin/quarantine", s.quarantineHandler, s.requireAdmin)
+	e.POST("/admin/quarantine/:id/release", s.quarantineReleasePostHandler, s.requireAdmin)
+}
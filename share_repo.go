@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+)
+
+// Erreur renvoyée quand un lien de partage n'existe pas (ou plus)
+var errShareNotFound = errors.New("lien de partage introuvable")
+
+// Définir une structure pour représenter un lien de partage public d'un fichier ou d'un dossier
+type Share struct {
+	ID           int
+	UserID       int
+	FileID       sql.NullInt64
+	FolderID     sql.NullInt64
+	TokenHash    []byte         // Empreinte SHA-256 du jeton (le jeton lui-même n'est jamais enregistré)
+	SealedKey    []byte         // Clé du lien, chiffrée par la clé tirée du jeton
+	OwnerKey     []byte         // Clé du lien, chiffrée par la clé du propriétaire
+	Password     sql.NullString // Empreinte bcrypt du mot de passe (NULL : pas de mot de passe)
+	DownloadOnly bool           // Contenu toujours servi en téléchargement
+	MaxDownloads sql.NullInt64
+	Downloads    int
+	ExpiresAt    time.Time // Zéro : pas d'expiration
+	CreatedAt    time.Time
+	TargetName   string // Nom du fichier ou du dossier partagé (liste des liens)
+}
+
+// Colonnes lues pour construire un Share, dans l'ordre de scanShare
+const shareColumns = "s.id, s.user_id, s.file_id, s.folder_id, s.token_hash, s.sealed_key, s.owner_key, s.password, s.download_only, s.max_downloads, s.downloads, s.expires_at, s.created_at, COALESCE(f.filename, d.folder_name, '')"
+
+// Jointures qui donnent le nom de l'objet partagé
+const shareJoins = " FROM shares s LEFT JOIN files f ON f.id = s.file_id LEFT JOIN folders d ON d.id = s.folder_id"
+
+// Fonction pour lire un lien de partage depuis une ligne
+func scanShare(scan func(dest ...interface{}) error) (Share, error) {
+	var share Share
+	var expiresAt, createdAt []byte
+	err := scan(&share.ID, &share.UserID, &share.FileID, &share.FolderID, &share.TokenHash, &share.SealedKey, &share.OwnerKey,
+		&share.Password, &share.DownloadOnly, &share.MaxDownloads, &share.Downloads, &expiresAt, &createdAt, &share.TargetName)
+	share.ExpiresAt, share.CreatedAt = parseDBTime(expiresAt), parseDBTime(createdAt)
+	return share, err
+}
+
+// ShareRepo regroupe les requêtes sur les liens de partage (shares et share_keys)
+type ShareRepo struct {
+	db *sql.DB
+}
+
+// Fonction pour enregistrer un lien de partage
+func (r *ShareRepo) Create(ctx context.Context, share Share) (int64, error) {
+	var expiresAt interface{}
+	if !share.ExpiresAt.IsZero() {
+		expiresAt = share.ExpiresAt
+	}
+	result, err := r.db.ExecContext(ctx, "INSERT INTO shares (user_id, file_id, folder_id, token_hash, sealed_key, owner_key, password, download_only, max_downloads, expires_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		share.UserID, share.FileID, share.FolderID, share.TokenHash, share.SealedKey, share.OwnerKey, share.Password, share.DownloadOnly, share.MaxDownloads, expiresAt)
+	if err != nil {
+		return 0, err
+	}
+	return result.LastInsertId()
+}
+
+// Fonction pour retrouver un lien à partir de l'empreinte de son jeton
+func (r *ShareRepo) GetByToken(ctx context.Context, tokenHash []byte) (Share, error) {
+	share, err := scanShare(r.db.QueryRowContext(ctx, "SELECT "+shareColumns+shareJoins+" WHERE s.token_hash = ?", tokenHash).Scan)
+	if errors.Is(err, sql.ErrNoRows) {
+		return share, errShareNotFound
+	}
+	return share, err
+}
+
+// Fonction pour lister les liens d'un utilisateur, du plus récent au plus ancien
+func (r *ShareRepo) ListByUser(ctx context.Context, userID int) ([]Share, error) {
+	rows, err := r.db.QueryContext(ctx, "SELECT "+shareColumns+shareJoins+" WHERE s.user_id = ? ORDER BY s.id DESC", userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var shares []Share
+	for rows.Next() {
+		share, err := scanShare(rows.Scan)
+		if err != nil {
+			return nil, err
+		}
+		shares = append(shares, share)
+	}
+	return shares, rows.Err()
+}
+
+// Fonction pour révoquer un lien : sa ligne et les clés qu'il protégeait sont supprimées
+func (r *ShareRepo) Delete(ctx context.Context, userID int, shareID int64) error {
+	result, err := r.db.ExecContext(ctx, "DELETE FROM shares WHERE id = ? AND user_id = ?", shareID, userID)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err != nil || n == 0 {
+		if err == nil {
+			err = errShareNotFound
+		}
+		return err
+	}
+	return nil
+}
+
+// Fonction pour compter un téléchargement ; renvoie false si le nombre maximal est déjà atteint
+func (r *ShareRepo) CountDownload(ctx context.Context, shareID int) (bool, error) {
+	result, err := r.db.ExecContext(ctx, "UPDATE shares SET downloads = downloads + 1 WHERE id = ? AND (max_downloads IS NULL OR downloads < max_downloads)", shareID)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
+
+// Fonction pour lister les contenus dont la clé de données est déjà protégée par la clé du lien
+func (r *ShareRepo) KeyedContents(ctx context.Context, shareID int) (map[string]bool, error) {
+	rows, err := r.db.QueryContext(ctx, "SELECT storage_key FROM share_keys WHERE share_id = ?", shareID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := make(map[string]bool)
+	for rows.Next() {
+		var key string
+		if err := rows.Scan(&key); err != nil {
+			return nil, err
+		}
+		keys[key] = true
+	}
+	return keys, rows.Err()
+}
+
+// Fonction pour ajouter des clés de données protégées par la clé du lien (clé de stockage -> clé chiffrée)
+func (r *ShareRepo) AddKeys(ctx context.Context, shareID int, keys map[string][]byte) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	values := make([]string, 0, len(keys))
+	args := make([]interface{}, 0, 3*len(keys))
+	for key, wrapped := range keys {
+		values = append(values, "(?, ?, ?)")
+		args = append(args, shareID, key, wrapped)
+	}
+	_, err := r.db.ExecContext(ctx, "INSERT IGNORE INTO share_keys (share_id, storage_key, wrapped_key) VALUES "+strings.Join(values, ", "), args...)
+	return err
+}
+
+// Fonction pour lire la clé de données d'un contenu protégée par la clé du lien
+func (r *ShareRepo) Key(ctx context.Context, shareID int, storageKey string) ([]byte, error) {
+	var wrapped []byte
+	err := r.db.QueryRowContext(ctx, "SELECT wrapped_key FROM share_keys WHERE share_id = ? AND storage_key = ?", shareID, storageKey).Scan(&wrapped)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, errShareNotFound
+	}
+	return wrapped, err
+}
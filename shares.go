@@ -0,0 +1,532 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"log"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo-contrib/session"
+	"github.com/labstack/echo/v4"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Erreurs renvoyées par les liens de partage
+var (
+	errShareExpired   = errors.New("ce lien de partage a expiré")
+	errShareExhausted = errors.New("ce lien de partage a atteint son nombre maximal de téléchargements")
+	errSharePassword  = errors.New("mot de passe requis")
+	errShareInput     = errors.New("paramètres de partage invalides")
+)
+
+// Taille des jetons de partage, en octets (43 caractères dans l'URL)
+const shareTokenSize = 32
+
+// Nombre de clés de données écrites par requête lors de la couverture d'un dossier partagé
+const shareKeyBatch = 500
+
+// Données associées qui lient la clé d'un lien à son propriétaire
+func shareOwnerAD(ownerID int) []byte {
+	return []byte(fmt.Sprintf("coffrefort:share-key:%d", ownerID))
+}
+
+// Données associées qui lient la clé d'un lien à l'empreinte de son jeton
+func shareTokenAD(tokenHash []byte) []byte {
+	return append([]byte("coffrefort:share-token:"), tokenHash...)
+}
+
+// Données associées qui lient une clé de données protégée par un lien au contenu qu'elle chiffre
+func shareContentAD(storageKey string) []byte {
+	return []byte("coffrefort:share-content:" + storageKey)
+}
+
+// Fonction pour calculer l'empreinte d'un jeton (seule enregistrée) et la clé qui en est tirée
+func shareTokenKeys(token []byte) (tokenHash, tokenKey []byte) {
+	digest := sha256.Sum256(token)
+	mac := hmac.New(sha256.New, token)
+	mac.Write([]byte("coffrefort:share-token-key"))
+	return digest[:], mac.Sum(nil)
+}
+
+// Fonction pour lister les fichiers couverts par un lien (le fichier partagé, ou ceux du dossier et de ses sous-dossiers),
+// avec leur chemin relatif au dossier partagé
+func (s *Server) shareFiles(ctx context.Context, share Share) ([]UploadedFile, []string, error) {
+	if share.FileID.Valid {
+		file, err := s.files.Get(ctx, share.FileID.Int64)
+		if err != nil {
+			return nil, nil, err
+		}
+		return []UploadedFile{file}, []string{file.FileName}, nil
+	}
+
+	tree, err := s.folders.Tree(ctx, share.UserID, share.FolderID.Int64)
+	if err != nil {
+		return nil, nil, err
+	}
+	folders, err := s.folders.ListAll(ctx, share.UserID)
+	if err != nil {
+		return nil, nil, err
+	}
+	byID := make(map[int64]Folder, len(folders))
+	for _, folder := range folders {
+		byID[int64(folder.ID)] = folder
+	}
+	// Chemin de chaque sous-dossier depuis le dossier partagé (le parcours en largeur donne les parents d'abord)
+	dirs := map[int64]string{share.FolderID.Int64: ""}
+	var files []UploadedFile
+	var paths []string
+	for _, folderID := range tree {
+		if folderID != share.FolderID.Int64 {
+			folder := byID[folderID]
+			dirs[folderID] = path.Join(dirs[folder.ParentID.Int64], folder.Name)
+		}
+		children, err := s.files.ListByFolder(ctx, share.UserID, sql.NullInt64{Int64: folderID, Valid: true}, FileListOptions{Sort: "name"})
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, file := range children {
+			files = append(files, file)
+			paths = append(paths, path.Join(dirs[folderID], file.FileName))
+		}
+	}
+	return files, paths, nil
+}
+
+// Fonction pour protéger avec la clé du lien les clés de données des contenus qu'il couvre et qui ne le sont pas encore
+func (s *Server) coverShare(ctx context.Context, userKey []byte, share Share, shareKey []byte) error {
+	files, _, err := s.shareFiles(ctx, share)
+	if err != nil {
+		return err
+	}
+	known, err := s.shares.KeyedContents(ctx, share.ID)
+	if err != nil {
+		return err
+	}
+	keys := make(map[string][]byte)
+	for _, file := range files {
+		// Un ancien fichier en clair n'a pas de clé de données
+		if file.WrappedKey == nil || file.StorageKey == "" || known[file.StorageKey] {
+			continue
+		}
+		dataKey, err := openFileKey(userKey, file.WrappedKey, share.UserID)
+		if err != nil {
+			return err
+		}
+		if keys[file.StorageKey], err = sealKey(shareKey, dataKey, shareContentAD(file.StorageKey)); err != nil {
+			return err
+		}
+		known[file.StorageKey] = true
+		if len(keys) == shareKeyBatch {
+			if err := s.shares.AddKeys(ctx, share.ID, keys); err != nil {
+				return err
+			}
+			keys = make(map[string][]byte)
+		}
+	}
+	return s.shares.AddKeys(ctx, share.ID, keys)
+}
+
+// Fonction pour étendre les liens d'un utilisateur aux contenus arrivés depuis leur création
+// (fichier déposé ou déplacé dans un dossier partagé, nouvelle version, version restaurée)
+func (s *Server) refreshShares(ctx context.Context, userID int, userKey []byte) {
+	shares, err := s.shares.ListByUser(ctx, userID)
+	if err != nil {
+		log.Println("Erreur lors de la récupération des liens de partage :", err)
+		return
+	}
+	for _, share := range shares {
+		shareKey, err := openKey(userKey, share.OwnerKey, shareOwnerAD(userID))
+		if err == nil {
+			err = s.coverShare(ctx, userKey, share, shareKey)
+		}
+		if err != nil && !errors.Is(err, errFileNotFound) {
+			log.Printf("Erreur lors de la mise à jour du lien de partage %d : %v", share.ID, err)
+		}
+	}
+}
+
+// Gestionnaire de route pour afficher le formulaire de création d'un lien (?file=ID ou ?folder=ID)
+func (s *Server) newShareHandler(c echo.Context) error {
+	ctx := c.Request().Context()
+	userID := currentUserID(c)
+
+	var target, name string
+	if value := c.QueryParam("file"); value != "" {
+		fileID, err := parseObjectID(value)
+		if err != nil {
+			return accessErrorResponse(c, err, "Erreur lors de la préparation du partage")
+		}
+		file, err := s.authz.File(ctx, userID, fileID, AccessManage)
+		if err != nil {
+			return accessErrorResponse(c, err, "Erreur lors de la préparation du partage")
+		}
+		target, name = `<input type="hidden" name="file_id" value="`+strconv.Itoa(file.ID)+`">`, "le fichier « "+file.FileName+" »"
+	} else {
+		folderID, err := parseObjectID(c.QueryParam("folder"))
+		if err != nil {
+			return accessErrorResponse(c, err, "Erreur lors de la préparation du partage")
+		}
+		folder, err := s.authz.Folder(ctx, userID, folderID, AccessManage)
+		if err != nil {
+			return accessErrorResponse(c, err, "Erreur lors de la préparation du partage")
+		}
+		target, name = `<input type="hidden" name="folder_id" value="`+strconv.Itoa(folder.ID)+`">`, "le dossier « "+folder.Name+" »"
+	}
+
+	htmlContent := `
+        <h1>Partager ` + template.HTMLEscapeString(name) + `</h1>
+        <p>Toute personne qui a le lien peut ouvrir le contenu partagé, sans compte. Le lien n'est affiché qu'une fois.</p>
+        <form action="/shares" method="post">
+            ` + target + `
+            <label>Expire le : <input type="date" name="expires"></label><br>
+            <label>Mot de passe : <input type="password" name="password" autocomplete="new-password"></label><br>
+            <label>Nombre maximal de téléchargements : <input type="number" name="max_downloads" min="1"></label><br>
+            <label><input type="checkbox" name="download_only" value="1"> Téléchargement uniquement (pas d'affichage dans le navigateur)</label><br>
+            <button type="submit">Créer le lien</button>
+        </form>
+        <a href="/welcome">Retour à la page </a>
+    `
+	return c.HTML(http.StatusOK, htmlContent)
+}
+
+// Traitement du formulaire de création d'un lien de partage
+func (s *Server) createSharePostHandler(c echo.Context) error {
+	ctx := c.Request().Context()
+	userID := currentUserID(c)
+
+	share := Share{UserID: userID, DownloadOnly: c.FormValue("download_only") != ""}
+	var ownerID int
+	if value := c.FormValue("file_id"); value != "" {
+		fileID, err := parseObjectID(value)
+		if err != nil {
+			return accessErrorResponse(c, err, "Erreur lors de la création du partage")
+		}
+		file, err := s.authz.File(ctx, userID, fileID, AccessManage)
+		if err != nil {
+			return accessErrorResponse(c, err, "Erreur lors de la création du partage")
+		}
+		share.FileID, ownerID = sql.NullInt64{Int64: fileID, Valid: true}, file.UserID
+	} else {
+		folderID, err := parseObjectID(c.FormValue("folder_id"))
+		if err != nil {
+			return accessErrorResponse(c, err, "Erreur lors de la création du partage")
+		}
+		folder, err := s.authz.Folder(ctx, userID, folderID, AccessManage)
+		if err != nil {
+			return accessErrorResponse(c, err, "Erreur lors de la création du partage")
+		}
+		share.FolderID, ownerID = sql.NullInt64{Int64: folderID, Valid: true}, folder.UserID
+	}
+	// Les clés de données ne peuvent être confiées au lien que par le propriétaire des contenus
+	if ownerID != userID {
+		return c.JSON(http.StatusForbidden, map[string]string{"message": "seul le propriétaire peut créer un lien de partage"})
+	}
+
+	if value := c.FormValue("expires"); value != "" {
+		day, err := time.ParseInLocation("2006-01-02", value, time.Local)
+		if err != nil || !day.AddDate(0, 0, 1).After(time.Now()) {
+			return c.JSON(http.StatusBadRequest, map[string]string{"message": errShareInput.Error() + " : date d'expiration"})
+		}
+		// Le lien reste valable jusqu'à la fin du jour choisi
+		share.ExpiresAt = day.AddDate(0, 0, 1)
+	}
+	if value := c.FormValue("max_downloads"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 {
+			return c.JSON(http.StatusBadRequest, map[string]string{"message": errShareInput.Error() + " : nombre de téléchargements"})
+		}
+		share.MaxDownloads = sql.NullInt64{Int64: int64(n), Valid: true}
+	}
+	if password := c.FormValue("password"); password != "" {
+		hashed, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+		if err != nil {
+			return err
+		}
+		share.Password = sql.NullString{String: string(hashed), Valid: true}
+	}
+
+	userKey, err := s.keys.ForRequest(c, userID)
+	if err != nil {
+		return keyringLockedResponse(c)
+	}
+	token, err := randomBytes(shareTokenSize)
+	if err != nil {
+		return err
+	}
+	shareKey, err := randomBytes(keySize)
+	if err != nil {
+		return err
+	}
+	tokenHash, tokenKey := shareTokenKeys(token)
+	share.TokenHash = tokenHash
+	if share.SealedKey, err = sealKey(tokenKey, shareKey, shareTokenAD(tokenHash)); err != nil {
+		return err
+	}
+	if share.OwnerKey, err = sealKey(userKey, shareKey, shareOwnerAD(userID)); err != nil {
+		return err
+	}
+	shareID, err := s.shares.Create(ctx, share)
+	if err != nil {
+		log.Println("Erreur lors de l'enregistrement du lien de partage :", err)
+		return err
+	}
+	share.ID = int(shareID)
+	if err := s.coverShare(ctx, userKey, share, shareKey); err != nil {
+		s.shares.Delete(ctx, userID, shareID)
+		log.Println("Erreur lors de la préparation des clés du lien de partage :", err)
+		return err
+	}
+
+	link := c.Scheme() + "://" + c.Request().Host + "/s/" + base64.RawURLEncoding.EncodeToString(token)
+	htmlContent := `
+        <h1>Lien de partage créé</h1>
+        <p>Copiez ce lien maintenant : il ne sera plus affiché.</p>
+        <p><input type="text" value="` + template.HTMLEscapeString(link) + `" size="80" readonly onclick="this.select()"></p>
+        <a href="/welcome">Retour à la page </a>
+    `
+	return c.HTML(http.StatusCreated, htmlContent)
+}
+
+// Traitement du bouton de révocation d'un lien de partage
+func (s *Server) revokeSharePostHandler(c echo.Context) error {
+	shareID, err := parseObjectID(c.Param("id"))
+	if err == nil {
+		err = s.shares.Delete(c.Request().Context(), currentUserID(c), shareID)
+	}
+	if errors.Is(err, errShareNotFound) || errors.Is(err, errNotVisible) {
+		return c.JSON(http.StatusNotFound, map[string]string{"message": errShareNotFound.Error()})
+	}
+	if err != nil {
+		log.Println("Erreur lors de la révocation du lien de partage :", err)
+		return err
+	}
+	return c.Redirect(http.StatusSeeOther, "/welcome")
+}
+
+// Fonction pour générer la liste des liens de l'utilisateur, avec un bouton de révocation pour chacun
+func renderShares(shares []Share) string {
+	if len(shares) == 0 {
+		return ""
+	}
+	htmlContent := `<div class="shares">
+        <h2>Mes liens de partage</h2>
+        <ul>`
+	for _, share := range shares {
+		kind := "fichier"
+		if share.FolderID.Valid {
+			kind = "dossier"
+		}
+		details := []string{kind + " « " + share.TargetName + " »", "créé le " + share.CreatedAt.Format("02/01/2006")}
+		if !share.ExpiresAt.IsZero() {
+			details = append(details, "expire le "+share.ExpiresAt.Add(-time.Second).Format("02/01/2006"))
+		}
+		downloads := strconv.Itoa(share.Downloads) + " téléchargement(s)"
+		if share.MaxDownloads.Valid {
+			downloads += " sur " + strconv.FormatInt(share.MaxDownloads.Int64, 10)
+		}
+		details = append(details, downloads)
+		if share.Password.Valid {
+			details = append(details, "mot de passe")
+		}
+		if share.DownloadOnly {
+			details = append(details, "téléchargement uniquement")
+		}
+		htmlContent += `
+            <li>` + template.HTMLEscapeString(strings.Join(details, " · ")) + `
+                <form action="/shares/` + strconv.Itoa(share.ID) + `/revoke" method="post" style="display:inline">
+                    <button type="submit">Révoquer</button>
+                </form>
+            </li>`
+	}
+	return htmlContent + `
+        </ul>
+    </div>`
+}
+
+// Fonction pour retrouver le lien désigné par le jeton de l'URL, vérifier qu'il est encore valable
+// et ouvrir sa clé. Le mot de passe, s'il y en a un, est vérifié une fois par session.
+func (s *Server) openShare(c echo.Context) (Share, []byte, error) {
+	token, err := base64.RawURLEncoding.DecodeString(c.Param("token"))
+	if err != nil || len(token) != shareTokenSize {
+		return Share{}, nil, errShareNotFound
+	}
+	tokenHash, tokenKey := shareTokenKeys(token)
+	share, err := s.shares.GetByToken(c.Request().Context(), tokenHash)
+	if err != nil {
+		return share, nil, err
+	}
+	if !share.ExpiresAt.IsZero() && !time.Now().Before(share.ExpiresAt) {
+		return share, nil, errShareExpired
+	}
+	if share.MaxDownloads.Valid && int64(share.Downloads) >= share.MaxDownloads.Int64 {
+		return share, nil, errShareExhausted
+	}
+	if share.Password.Valid {
+		sess, err := session.Get("session", c)
+		if err != nil {
+			return share, nil, errSharePassword
+		}
+		if unlocked, _ := sess.Values[shareSessionKey(share.ID)].(bool); !unlocked {
+			return share, nil, errSharePassword
+		}
+	}
+	shareKey, err := openKey(tokenKey, share.SealedKey, shareTokenAD(tokenHash))
+	return share, shareKey, err
+}
+
+// Clé de la session qui retient qu'un visiteur a donné le mot de passe d'un lien
+func shareSessionKey(shareID int) string {
+	return "share:" + strconv.Itoa(shareID)
+}
+
+// Fonction pour répondre à un visiteur quand un lien ne peut pas être ouvert
+func shareErrorResponse(c echo.Context, err error) error {
+	switch {
+	case errors.Is(err, errSharePassword):
+		return c.HTML(http.StatusUnauthorized, `
+        <h1>Contenu protégé</h1>
+        <form method="post">
+            <label>Mot de passe : <input type="password" name="password" required autofocus></label>
+            <button type="submit">Ouvrir</button>
+        </form>
+    `)
+	case errors.Is(err, errShareNotFound), errors.Is(err, errFileNotFound), errors.Is(err, errFolderNotFound), errors.Is(err, errNotVisible):
+		return c.JSON(http.StatusNotFound, map[string]string{"message": errShareNotFound.Error()})
+	case errors.Is(err, errShareExpired), errors.Is(err, errShareExhausted):
+		return c.JSON(http.StatusGone, map[string]string{"message": err.Error()})
+	}
+	log.Println("Erreur lors de l'ouverture du lien de partage :", err)
+	return c.JSON(http.StatusInternalServerError, map[string]string{"message": "Erreur lors de l'ouverture du lien de partage"})
+}
+
+// Traitement du mot de passe d'un lien protégé
+func (s *Server) sharePasswordPostHandler(c echo.Context) error {
+	share, _, err := s.openShare(c)
+	if err != nil && !errors.Is(err, errSharePassword) {
+		return shareErrorResponse(c, err)
+	}
+	if share.Password.Valid {
+		if bcrypt.CompareHashAndPassword([]byte(share.Password.String), []byte(c.FormValue("password"))) != nil {
+			log.Printf("Mot de passe incorrect pour le lien de partage %d", share.ID)
+			return shareErrorResponse(c, errSharePassword)
+		}
+		sess, err := session.Get("session", c)
+		if err != nil {
+			return err
+		}
+		sess.Values[shareSessionKey(share.ID)] = true
+		sess.Save(c.Request(), c.Response())
+	}
+	return c.Redirect(http.StatusSeeOther, c.Request().URL.Path)
+}
+
+// Gestionnaire de route public d'un lien (GET /s/:token) : le fichier partagé, ou la liste des fichiers du dossier partagé
+func (s *Server) publicShareHandler(c echo.Context) error {
+	share, shareKey, err := s.openShare(c)
+	if err != nil {
+		return shareErrorResponse(c, err)
+	}
+	if share.FileID.Valid {
+		file, err := s.files.Get(c.Request().Context(), share.FileID.Int64)
+		if err != nil {
+			return shareErrorResponse(c, err)
+		}
+		return s.serveSharedFile(c, share, shareKey, file)
+	}
+
+	if _, err := s.folders.GetByID(c.Request().Context(), share.FolderID.Int64); err != nil {
+		return shareErrorResponse(c, err)
+	}
+	files, paths, err := s.shareFiles(c.Request().Context(), share)
+	if err != nil {
+		return shareErrorResponse(c, err)
+	}
+	base := "/s/" + c.Param("token") + "/files/"
+	htmlContent := `
+        <h1>` + template.HTMLEscapeString(share.TargetName) + `</h1>
+        <ul>`
+	for i, file := range files {
+		htmlContent += `
+            <li><a href="` + base + strconv.Itoa(file.ID) + `">` + template.HTMLEscapeString(paths[i]) + `</a> (` + formatBytes(file.Size) + `)</li>`
+	}
+	if len(files) == 0 {
+		htmlContent += `
+            <li>Ce dossier est vide.</li>`
+	}
+	htmlContent += `
+        </ul>
+    `
+	return c.HTML(http.StatusOK, htmlContent)
+}
+
+// Gestionnaire de route public d'un fichier d'un dossier partagé (GET /s/:token/files/:id)
+func (s *Server) publicShareFileHandler(c echo.Context) error {
+	share, shareKey, err := s.openShare(c)
+	if err != nil {
+		return shareErrorResponse(c, err)
+	}
+	fileID, err := parseObjectID(c.Param("id"))
+	if err != nil || !share.FolderID.Valid {
+		return shareErrorResponse(c, errShareNotFound)
+	}
+	// Le fichier doit être encore rangé sous le dossier partagé
+	files, _, err := s.shareFiles(c.Request().Context(), share)
+	if err != nil {
+		return shareErrorResponse(c, err)
+	}
+	for _, file := range files {
+		if int64(file.ID) == fileID {
+			return s.serveSharedFile(c, share, shareKey, file)
+		}
+	}
+	return shareErrorResponse(c, errShareNotFound)
+}
+
+// Fonction pour envoyer un fichier partagé, déchiffré avec la clé de données confiée au lien.
+// Seules les requêtes qui commencent au début du contenu comptent comme un téléchargement.
+func (s *Server) serveSharedFile(c echo.Context, share Share, shareKey []byte, file UploadedFile) error {
+	ctx := c.Request().Context()
+	if file.Metadata.Quarantine != "" {
+		return c.JSON(http.StatusForbidden, map[string]string{"message": errQuarantined.Error()})
+	}
+	var dataKey []byte
+	if file.WrappedKey != nil {
+		wrapped, err := s.shares.Key(ctx, share.ID, file.StorageKey)
+		if errors.Is(err, errShareNotFound) {
+			// Contenu arrivé après la création du lien : il sera couvert à la prochaine visite du propriétaire
+			return c.JSON(http.StatusServiceUnavailable, map[string]string{"message": "ce fichier n'est pas encore disponible par ce lien"})
+		}
+		if err != nil {
+			return shareErrorResponse(c, err)
+		}
+		if dataKey, err = openKey(shareKey, wrapped, shareContentAD(file.StorageKey)); err != nil {
+			return shareErrorResponse(c, err)
+		}
+	}
+
+	rangeHeader := c.Request().Header.Get("Range")
+	if c.Request().Method == http.MethodGet && (rangeHeader == "" || strings.HasPrefix(rangeHeader, "bytes=0-")) {
+		counted, err := s.shares.CountDownload(ctx, share.ID)
+		if err != nil {
+			return shareErrorResponse(c, err)
+		}
+		if !counted {
+			return shareErrorResponse(c, errShareExhausted)
+		}
+	}
+	c.Response().Header().Set("Referrer-Policy", "no-referrer")
+	return s.serveFileContent(c, file, func() (io.ReadSeekCloser, error) {
+		return openContent(ctx, s.blobs, file.StorageKey, dataKey)
+	}, share.DownloadOnly)
+}
@@ -0,0 +1,431 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/labstack/echo/v4"
+)
+
+// Erreur renvoyée pour une clé de stockage mal formée
+var errInvalidStorageKey = errors.New("clé de stockage invalide")
+
+// Une clé de stockage est un identifiant hexadécimal (128 bits aléatoires, ou empreinte jusqu'à 256 bits)
+var storageKeyPattern = regexp.MustCompile(`^[0-9a-f]{32,64}$`)
+
+// Fonction pour générer une nouvelle clé de stockage opaque
+func newStorageKey() (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+// Fonction pour calculer le chemin d'un blob du stockage local : <uploads>/<2 premiers caractères>/<clé>.
+// La clé est validée pour qu'aucun chemin ne puisse sortir du dossier des fichiers déposés.
+func blobPath(uploadsDir, key string) (string, error) {
+	if !storageKeyPattern.MatchString(key) {
+		return "", errInvalidStorageKey
+	}
+	return filepath.Join(uploadsDir, key[:2], key), nil
+}
+
+// Fonction pour nettoyer le nom d'origine d'un fichier, conservé uniquement pour l'affichage
+func cleanDisplayName(name string) string {
+	// Certains navigateurs envoient le chemin complet du poste client
+	name = name[strings.LastIndexAny(name, `/\`)+1:]
+	name = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) {
+			return -1
+		}
+		return r
+	}, name)
+	name = strings.TrimSpace(name)
+	if len(name) > 255 {
+		name = name[:255]
+	}
+	if name == "" || name == "." || name == ".." {
+		name = "fichier"
+	}
+	return name
+}
+
+// Définir une structure pour représenter un contenu reçu à enregistrer comme fichier du coffre
+type incomingFile struct {
+	Name         string // Nom affiché, déjà nettoyé
+	Size         int64
+	DeclaredType string // Type annoncé par le client
+	FolderID     sql.NullInt64
+	Open         func() (io.ReadCloser, error) // Appelée plusieurs fois, voir storeContent
+
+	WrappedKey []byte // Clé de données déjà créée (envoi reprenable, dont les morceaux en sont chiffrés) ; nil : en créer une
+	Reserved   bool   // Taille déjà réservée sur le quota (envoi reprenable) : ni réservée ni rendue ici
+
+	// Clé de données en clair d'un fichier déposé par un bénéficiaire dans un dossier partagé (WrappedKey est alors
+	// le dépôt) : sans la clé du propriétaire, le contenu n'est pas dédupliqué
+	DataKey []byte
+}
+
+// Fonction pour enregistrer un fichier reçu (dépôt par formulaire, entrée d'archive, envoi reprenable terminé) :
+// politique d'envoi et antivirus, réservation sur le quota, contenu chiffré et dédupliqué dans le stockage,
+// ligne en base (nouvelle version si le nom existe déjà dans le dossier), puis vignette en arrière-plan.
+// userID est le propriétaire du fichier ; pour un dépôt, userKey est sa clé si elle est en mémoire, nil sinon
+// (vignette et index attendent alors sa prochaine connexion). Renvoie errQuotaExceeded si le fichier ne tient pas dans le quota.
+func (s *Server) importFile(ctx context.Context, userID int, userKey []byte, file incomingFile) error {
+	// Chaque fichier est chiffré avec sa propre clé de données, protégée par la clé de l'utilisateur
+	wrappedKey := file.WrappedKey
+	if wrappedKey == nil {
+		var err error
+		if _, wrappedKey, err = newFileKey(userKey, userID); err != nil {
+			return err
+		}
+	}
+
+	// Refuser un fichier trop gros ou d'un type interdit d'après ses premiers octets, avant de toucher au quota
+	quarantine, err := s.checkUpload(file.Size, file.Open)
+	if err != nil {
+		return err
+	}
+	// Analyse antivirus du contenu en clair : un contenu infecté est gardé mais mis en quarantaine
+	verdict, err := s.scanUpload(ctx, file.Open)
+	if err != nil {
+		return err
+	}
+	if verdict.Infection != "" {
+		quarantine = infectionQuarantine(verdict.Infection)
+	}
+
+	// Réserver la taille du fichier sur le quota avant de l'écrire dans le stockage ;
+	// en cas d'échec, la réservation faite ici est rendue
+	if !file.Reserved {
+		if err := s.reserveStorage(ctx, userID, file.Size); err != nil {
+			return err
+		}
+	}
+	release := func() {
+		if !file.Reserved {
+			s.releaseStorage(ctx, userID, file.Size)
+		}
+	}
+
+	// Enregistrer le contenu chiffré sous une clé de stockage opaque, indépendante du nom du fichier ;
+	// un contenu que l'utilisateur a déjà déposé n'est pas écrit une seconde fois et garde sa clé de données
+	var storageKey string
+	var meta FileMetadata
+	if file.DataKey != nil {
+		storageKey, meta, err = s.storeDeposit(ctx, file.DataKey, wrappedKey, file.Open)
+	} else {
+		storageKey, wrappedKey, meta, err = s.storeContent(ctx, userID, userKey, wrappedKey, file.Open)
+	}
+	if err != nil {
+		release()
+		return err
+	}
+	meta.DeclaredType = file.DeclaredType
+	meta.Quarantine = quarantine
+
+	// Enregistrer les détails du fichier dans la base de données ; le nom d'origine ne sert qu'à l'affichage
+	fileID, err := s.saveUploadedFileToDatabase(ctx, UploadedFile{
+		UserID:     userID,
+		FileName:   file.Name,
+		StorageKey: storageKey,
+		WrappedKey: wrappedKey,
+		Size:       file.Size,
+		UploadedAt: time.Now(),
+		FolderID:   file.FolderID,
+		Metadata:   meta,
+	})
+	if err != nil {
+		releaseBlob(ctx, s.blobRefs, s.blobs, storageKey)
+		release()
+		return err
+	}
+	s.recordScan(ctx, userID, file.Name, storageKey, verdict)
+
+	// Générer la vignette et indexer le fichier pour la recherche en arrière-plan, sans faire attendre la réponse.
+	// Les liens et accès qui couvrent le dossier sont étendus par l'appelant, une fois pour toute la requête.
+	if userKey == nil {
+		return nil
+	}
+	go func() {
+		ctx := context.Background()
+		s.generateThumbnail(ctx, userID, userKey, thumbnailSource{StorageKey: storageKey, WrappedKey: wrappedKey, MIMEType: meta.MIMEType})
+		s.indexFile(ctx, userID, userKey, searchSource{FileID: int(fileID), FileName: file.Name, StorageKey: storageKey, WrappedKey: wrappedKey, MIMEType: meta.MIMEType})
+	}()
+	return nil
+}
+
+// Fonction pour enregistrer un contenu chiffré une seule fois par utilisateur : la clé de stockage découle du contenu,
+// et un contenu déjà présent est réutilisé avec sa clé de données, qui remplace alors celle proposée.
+// open est appelé deux fois : pour calculer l'empreinte et relever les métadonnées, puis pour écrire le contenu s'il est nouveau.
+// La référence prise sur le blob est rendue avec releaseBlob si la ligne n'est finalement pas enregistrée.
+func (s *Server) storeContent(ctx context.Context, userID int, userKey, wrappedKey []byte, open func() (io.ReadCloser, error)) (string, []byte, FileMetadata, error) {
+	src, err := open()
+	if err != nil {
+		return "", nil, FileMetadata{}, err
+	}
+	inspector := newContentInspector()
+	_, err = io.Copy(inspector, src)
+	src.Close()
+	if err != nil {
+		return "", nil, FileMetadata{}, err
+	}
+	meta := inspector.Metadata()
+
+	key := contentStorageKey(userKey, userID, inspector.Sum())
+	wrappedKey, stored, err := s.blobRefs.Acquire(ctx, key, wrappedKey)
+	if err != nil || stored {
+		return key, wrappedKey, meta, err
+	}
+
+	// Contenu absent du stockage : l'écrire chiffré avec la clé de données du blob
+	dataKey, err := openFileKey(userKey, wrappedKey, userID)
+	if err == nil {
+		err = s.writeContent(ctx, key, dataKey, open)
+	}
+	if err != nil {
+		releaseBlob(ctx, s.blobRefs, s.blobs, key)
+		return "", nil, FileMetadata{}, err
+	}
+	return key, wrappedKey, meta, nil
+}
+
+// Fonction pour enregistrer le contenu d'un fichier déposé par un bénéficiaire : la clé de déduplication dérive
+// de la clé du propriétaire, le contenu reçoit donc une clé de stockage aléatoire et n'est partagé avec aucun autre.
+// La référence prise sur le blob est rendue avec releaseBlob si la ligne n'est finalement pas enregistrée.
+func (s *Server) storeDeposit(ctx context.Context, dataKey, wrappedKey []byte, open func() (io.ReadCloser, error)) (string, FileMetadata, error) {
+	src, err := open()
+	if err != nil {
+		return "", FileMetadata{}, err
+	}
+	inspector := newContentInspector()
+	_, err = io.Copy(inspector, src)
+	src.Close()
+	if err != nil {
+		return "", FileMetadata{}, err
+	}
+
+	key, err := newStorageKey()
+	if err != nil {
+		return "", FileMetadata{}, err
+	}
+	if _, _, err := s.blobRefs.Acquire(ctx, key, wrappedKey); err != nil {
+		return "", FileMetadata{}, err
+	}
+	if err := s.writeContent(ctx, key, dataKey, open); err != nil {
+		releaseBlob(ctx, s.blobRefs, s.blobs, key)
+		return "", FileMetadata{}, err
+	}
+	return key, inspector.Metadata(), nil
+}
+
+// Fonction pour écrire un contenu chiffré sous une clé de stockage déjà référencée
+func (s *Server) writeContent(ctx context.Context, key string, dataKey []byte, open func() (io.ReadCloser, error)) error {
+	src, err := open()
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	encrypted, err := encryptBlobStream(dataKey, key, src)
+	if err != nil {
+		return err
+	}
+	return putBlob(ctx, s.blobRefs, s.blobs, key, encrypted)
+}
+
+// Fonction pour ouvrir le contenu en clair d'un fichier en accès aléatoire : déchiffré à la volée,
+// ou tel quel pour un ancien fichier
+func (s *Server) openFileContent(c echo.Context, file *UploadedFile) (io.ReadSeekCloser, error) {
+	ctx := c.Request().Context()
+	if file.WrappedKey == nil {
+		return openContent(ctx, s.blobs, file.StorageKey, nil)
+	}
+
+	// La clé de données est protégée par la clé du propriétaire ; pour un autre utilisateur,
+	// elle a été confiée à l'accès qui lui a été accordé
+	if file.UserID != currentUserID(c) {
+		dataKey, err := s.grantedDataKey(c, resourceFile, int64(file.ID), file.WrappedKey)
+		if err != nil {
+			return nil, err
+		}
+		return openContent(ctx, s.blobs, file.StorageKey, dataKey)
+	}
+	userKey, err := s.keys.ForRequest(c, file.UserID)
+	if err != nil {
+		return nil, err
+	}
+	dataKey, err := openFileKey(userKey, file.WrappedKey, file.UserID)
+	if err != nil {
+		return nil, err
+	}
+	return openContent(ctx, s.blobs, file.StorageKey, dataKey)
+}
+
+// Fonction pour ouvrir un contenu du stockage en accès aléatoire, déchiffré avec sa clé de données
+// (nil pour un ancien contenu en clair)
+func openContent(ctx context.Context, store BlobStore, key string, dataKey []byte) (io.ReadSeekCloser, error) {
+	blob, err := openBlobReader(ctx, store, key)
+	if err != nil {
+		return nil, err
+	}
+	if dataKey == nil {
+		return blob, nil
+	}
+	plain, err := decryptSeeker(dataKey, blob, blob.size)
+	if err != nil {
+		blob.Close()
+		return nil, err
+	}
+	return plain, nil
+}
+
+// Fonction pour chiffrer les fichiers déposés avant la mise en place du chiffrement, à la connexion de leur propriétaire.
+// Chaque fichier est recopié chiffré sous une nouvelle clé de stockage, puis la ligne est basculée et l'ancien contenu supprimé.
+func (s *Server) encryptPlaintextFiles(ctx context.Context, userID int, userKey []byte) {
+	files, err := s.files.ListPlaintext(ctx, userID)
+	if err != nil {
+		log.Println("Erreur lors de la recherche des fichiers à chiffrer :", err)
+		return
+	}
+
+	for _, file := range files {
+		if err := s.encryptPlaintextFile(ctx, file, userKey); err != nil {
+			log.Printf("Erreur lors du chiffrement du fichier %d : %v", file.ID, err)
+		}
+	}
+}
+
+// Fonction pour chiffrer un ancien fichier en clair
+func (s *Server) encryptPlaintextFile(ctx context.Context, file UploadedFile, userKey []byte) error {
+	_, wrappedKey, err := newFileKey(userKey, file.UserID)
+	if err != nil {
+		return err
+	}
+	newKey, wrappedKey, _, err := s.storeContent(ctx, file.UserID, userKey, wrappedKey, func() (io.ReadCloser, error) {
+		return s.blobs.Get(ctx, file.StorageKey)
+	})
+	if err != nil {
+		return err
+	}
+
+	// La bascule échoue si le fichier a été supprimé ou chiffré entre-temps : le nouveau contenu est alors abandonné
+	swapped, keys, err := s.files.SetEncrypted(ctx, file.ID, file.StorageKey, newKey, wrappedKey)
+	if err != nil || !swapped {
+		releaseBlob(ctx, s.blobRefs, s.blobs, newKey)
+		return err
+	}
+	removeBlobs(ctx, s.blobRefs, s.blobs, keys)
+	return nil
+}
+
+// Fonction pour exécuter les commandes `storage reconcile [-dry-run]`,
+// `storage migrate -from <stockage> -to <stockage> [-delete-source] [-dry-run]` et `storage gc [-grace <durée>] [-dry-run]`
+func runStorageCommand(ctx context.Context, db *sql.DB, cfg Config, args []string) error {
+	if len(args) == 0 {
+		return errors.New("usage : storage reconcile|migrate|gc|scrub [options]")
+	}
+
+	switch args[0] {
+	case "reconcile":
+		flags := flag.NewFlagSet("storage reconcile", flag.ContinueOnError)
+		dryRun := flags.Bool("dry-run", false, "afficher les actions sans rien modifier")
+		if err := flags.Parse(args[1:]); err != nil {
+			return err
+		}
+
+		store, err := openBlobStore(cfg.Storage)
+		if err != nil {
+			return err
+		}
+		reconciler := &Reconciler{files: &FileRepo{db: db}, refs: &BlobRepo{db: db}, store: store, uploadsDir: cfg.Storage.UploadsDir, dryRun: *dryRun}
+		report, err := reconciler.Run(ctx)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%d fichier(s) réconcilié(s), %d introuvable(s), %d orphelin(s)\n", report.Migrated, report.Missing, report.Orphans)
+		return nil
+	case "migrate":
+		flags := flag.NewFlagSet("storage migrate", flag.ContinueOnError)
+		from := flags.String("from", backendLocal, "stockage source (local|s3)")
+		to := flags.String("to", backendS3, "stockage destination (local|s3)")
+		deleteSource := flags.Bool("delete-source", false, "supprimer chaque blob de la source une fois la copie vérifiée")
+		dryRun := flags.Bool("dry-run", false, "afficher les actions sans rien modifier")
+		if err := flags.Parse(args[1:]); err != nil {
+			return err
+		}
+		if *from == *to {
+			return errors.New("les stockages source et destination doivent différer")
+		}
+
+		src, err := newBlobStore(cfg.Storage, *from)
+		if err != nil {
+			return err
+		}
+		dst, err := newBlobStore(cfg.Storage, *to)
+		if err != nil {
+			return err
+		}
+		report, err := migrateBlobs(ctx, src, dst, *deleteSource, *dryRun)
+		fmt.Printf("%d blob(s) copié(s), %d déjà présent(s), %d supprimé(s) de la source\n", report.Copied, report.Skipped, report.Deleted)
+		return err
+	case "gc":
+		flags := flag.NewFlagSet("storage gc", flag.ContinueOnError)
+		grace := flags.Duration("grace", 24*time.Hour, "âge minimal d'un blob inconnu de la base avant de l'effacer")
+		dryRun := flags.Bool("dry-run", false, "afficher les actions sans rien modifier")
+		if err := flags.Parse(args[1:]); err != nil {
+			return err
+		}
+
+		store, err := openBlobStore(cfg.Storage)
+		if err != nil {
+			return err
+		}
+		report, err := collectGarbage(ctx, &BlobRepo{db: db}, store, time.Now().Add(-*grace), *dryRun)
+		fmt.Printf("%d blob(s) sans référence effacé(s), %d blob(s) inconnu(s) effacé(s)\n", report.Unreferenced, report.Unknown)
+		return err
+	case "scrub":
+		flags := flag.NewFlagSet("storage scrub", flag.ContinueOnError)
+		repair := flags.Bool("repair", cfg.Scrub.Repair, "réparer les blobs manquants ou altérés depuis la copie de secours")
+		if err := flags.Parse(args[1:]); err != nil {
+			return err
+		}
+		if *repair && cfg.Storage.Replica == "" {
+			return errors.New("la réparation demande une copie de secours (storage.replica)")
+		}
+
+		store, err := openBlobStore(cfg.Storage)
+		if err != nil {
+			return err
+		}
+		scrubber, err := newScrubber(db, store, cfg)
+		if err != nil {
+			return err
+		}
+		report, err := scrubber.Run(ctx, *repair)
+		for _, issue := range report.Issues {
+			if issue.Repaired {
+				issue.Detail += " (réparé)"
+			}
+			fmt.Printf("%s %s : %s\n", issue.Kind, issue.StorageKey, issue.Detail)
+		}
+		fmt.Printf("%d blob(s) relu(s), %d manquant(s), %d altéré(s), %d orphelin(s), %d fichier(s) non réconcilié(s), %d réparé(s)\n",
+			report.Checked, report.Missing, report.Corrupt, report.Orphans, report.Unreconciled, report.Repaired)
+		return err
+	default:
+		return fmt.Errorf("action de stockage inconnue : %s", args[0])
+	}
+}
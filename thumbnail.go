@@ -0,0 +1,364 @@
+package main
+
+import (
+	"bytes"
+	"compress/zlib"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"io"
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// Types de vignettes enregistrés dans blobs.thumbnail
+const (
+	thumbnailImage = "image" // Aperçu réduit, chiffré dans le stockage
+	thumbnailPDF   = "pdf"   // Icône générée à l'affichage, avec le nombre de pages
+	thumbnailNone  = "none"  // Contenu illisible ou trop grand : icône générique
+)
+
+// Côté maximal d'un aperçu, en pixels
+const thumbnailSize = 256
+
+// Nombre maximal de pixels d'une image à réduire (protège la mémoire contre les images piégées)
+const maxThumbnailPixels = 50 << 20
+
+// Nombre maximal d'octets d'un PDF parcourus pour compter ses pages
+const maxPDFScan = 64 << 20
+
+// Nombre maximal d'octets décompressés pour un même PDF, tous flux confondus (un flux coûte au moins
+// pdfStreamCost, pour borner aussi le nombre de flux ouverts)
+const (
+	maxPDFInflate = 64 << 20
+	pdfStreamCost = 1 << 10
+)
+
+// Erreur renvoyée quand le budget de décompression d'un PDF est épuisé
+var errPDFInflateBudget = errors.New("budget de décompression du PDF épuisé")
+
+// Types de contenu qui reçoivent une vignette
+var thumbnailTypes = map[string]string{
+	"image/png":       thumbnailImage,
+	"image/jpeg":      thumbnailImage,
+	"image/gif":       thumbnailImage,
+	"application/pdf": thumbnailPDF,
+}
+
+// Fonction pour calculer la clé de stockage de l'aperçu d'un blob (dérivée de la sienne, donc propre à l'utilisateur)
+func thumbnailStorageKey(key string) string {
+	sum := sha256.Sum256([]byte("coffrefort:thumbnail:" + key))
+	return hex.EncodeToString(sum[:])
+}
+
+// Fonction pour générer en arrière-plan les vignettes des fichiers d'un utilisateur envoyés avant leur mise en place.
+// Il faut la clé de l'utilisateur pour lire ses contenus : la génération se fait à sa connexion.
+func (s *Server) generateThumbnails(ctx context.Context, userID int, userKey []byte) {
+	sources, err := s.files.ListWithoutThumbnail(ctx, userID)
+	if err != nil {
+		log.Println("Erreur lors de la recherche des fichiers sans vignette :", err)
+		return
+	}
+	for _, source := range sources {
+		s.generateThumbnail(ctx, userID, userKey, source)
+	}
+}
+
+// Fonction pour générer la vignette d'un contenu s'il en reçoit une et qu'elle n'existe pas encore.
+// Un contenu illisible est marqué thumbnailNone pour ne pas être relu à chaque connexion.
+func (s *Server) generateThumbnail(ctx context.Context, userID int, userKey []byte, source thumbnailSource) {
+	kind, ok := thumbnailTypes[source.MIMEType]
+	if !ok {
+		return
+	}
+	current, err := s.blobRefs.Thumbnail(ctx, source.StorageKey)
+	if err != nil {
+		log.Printf("Erreur lors de la lecture de la vignette du contenu %s : %v", source.StorageKey, err)
+		return
+	}
+	if current.Kind != "" {
+		return
+	}
+
+	thumbnail, err := s.buildThumbnail(ctx, userID, userKey, source, kind)
+	if err != nil {
+		log.Printf("Vignette du contenu %s impossible à générer : %v", source.StorageKey, err)
+		thumbnail = Thumbnail{Kind: thumbnailNone}
+	}
+	if err := s.blobRefs.SetThumbnail(ctx, source.StorageKey, thumbnail); err != nil {
+		log.Printf("Erreur lors de l'enregistrement de la vignette du contenu %s : %v", source.StorageKey, err)
+	}
+}
+
+// Fonction pour lire un contenu et en tirer sa vignette ; l'aperçu d'une image est chiffré avec la clé de données du contenu
+func (s *Server) buildThumbnail(ctx context.Context, userID int, userKey []byte, source thumbnailSource, kind string) (Thumbnail, error) {
+	var dataKey []byte
+	if source.WrappedKey != nil {
+		var err error
+		if dataKey, err = openFileKey(userKey, source.WrappedKey, userID); err != nil {
+			return Thumbnail{}, err
+		}
+	}
+	content, err := openContent(ctx, s.blobs, source.StorageKey, dataKey)
+	if err != nil {
+		return Thumbnail{}, err
+	}
+	defer content.Close()
+
+	if kind == thumbnailPDF {
+		data, err := io.ReadAll(io.LimitReader(content, maxPDFScan))
+		if err != nil {
+			return Thumbnail{}, err
+		}
+		return Thumbnail{Kind: thumbnailPDF, PageCount: pdfPageCount(data)}, nil
+	}
+
+	preview, err := scaleImage(content)
+	if err != nil {
+		return Thumbnail{}, err
+	}
+	key := thumbnailStorageKey(source.StorageKey)
+	var src io.Reader = bytes.NewReader(preview)
+	if dataKey != nil {
+		if src, err = encryptBlobStream(dataKey, key, src); err != nil {
+			return Thumbnail{}, err
+		}
+	}
+	if err := s.blobs.Put(ctx, key, src); err != nil {
+		return Thumbnail{}, err
+	}
+	return Thumbnail{Kind: thumbnailImage, Key: key}, nil
+}
+
+// Fonction pour réduire une image PNG, JPEG ou GIF à thumbnailSize pixels de côté au plus, en JPEG sur fond blanc
+func scaleImage(content io.ReadSeeker) ([]byte, error) {
+	config, _, err := image.DecodeConfig(content)
+	if err != nil {
+		return nil, err
+	}
+	if config.Width <= 0 || config.Height <= 0 || config.Width*config.Height > maxThumbnailPixels {
+		return nil, fmt.Errorf("image de %d × %d pixels refusée", config.Width, config.Height)
+	}
+	if _, err := content.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	src, _, err := image.Decode(content)
+	if err != nil {
+		return nil, err
+	}
+
+	bounds := src.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if width > thumbnailSize || height > thumbnailSize {
+		if width >= height {
+			width, height = thumbnailSize, maxInt(1, height*thumbnailSize/bounds.Dx())
+		} else {
+			width, height = maxInt(1, width*thumbnailSize/bounds.Dy()), thumbnailSize
+		}
+	}
+
+	// Moyenne des pixels de la zone source de chaque pixel de l'aperçu (au plus 4 × 4 échantillons)
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		y0 := bounds.Min.Y + y*bounds.Dy()/height
+		y1 := maxInt(y0+1, bounds.Min.Y+(y+1)*bounds.Dy()/height)
+		for x := 0; x < width; x++ {
+			x0 := bounds.Min.X + x*bounds.Dx()/width
+			x1 := maxInt(x0+1, bounds.Min.X+(x+1)*bounds.Dx()/width)
+			var r, g, b, n uint64
+			for sy := y0; sy < y1; sy += maxInt(1, (y1-y0)/4) {
+				for sx := x0; sx < x1; sx += maxInt(1, (x1-x0)/4) {
+					// Composantes prémultipliées : la transparence laisse voir le fond blanc
+					cr, cg, cb, ca := src.At(sx, sy).RGBA()
+					r += uint64(cr + 0xffff - ca)
+					g += uint64(cg + 0xffff - ca)
+					b += uint64(cb + 0xffff - ca)
+					n++
+				}
+			}
+			dst.SetRGBA(x, y, color.RGBA{R: uint8(r / n >> 8), G: uint8(g / n >> 8), B: uint8(b / n >> 8), A: 0xff})
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, dst, &jpeg.Options{Quality: 80}); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Fonction pour obtenir le plus grand de deux entiers
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+// Dictionnaire d'un nœud de l'arbre des pages : le /Count de la racine est le nombre de pages du document
+var pdfPagesPattern = regexp.MustCompile(`/Type\s*/Pages\b[^>]*?/Count\s+(\d+)|/Count\s+(\d+)[^>]*?/Type\s*/Pages\b`)
+
+// Fonction pour compter les pages d'un PDF sans l'interpréter : plus grand /Count des nœuds /Pages,
+// y compris dans les flux d'objets compressés (PDF 1.5 et suivants). Renvoie 0 si le nombre est introuvable.
+func pdfPageCount(data []byte) int {
+	count := maxPagesCount(data)
+	inflater := newPDFInflater()
+	for rest := data; ; {
+		i := bytes.Index(rest, []byte("/ObjStm"))
+		if i < 0 {
+			break
+		}
+		rest = rest[i:]
+		start := bytes.Index(rest, []byte("stream"))
+		if start < 0 {
+			break
+		}
+		rest = rest[start+len("stream"):]
+		body := bytes.TrimPrefix(bytes.TrimPrefix(rest, []byte("\r")), []byte("\n"))
+		inflated, err := inflater.inflate(body)
+		if errors.Is(err, errPDFInflateBudget) {
+			break
+		}
+		if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+			continue
+		}
+		if n := maxPagesCount(inflated); n > count {
+			count = n
+		}
+	}
+	return count
+}
+
+// pdfInflater décompresse les flux FlateDecode d'un même PDF dans la limite d'un budget commun à tous ses flux :
+// un document rempli de petits flux très compressés ne peut pas faire décompresser plus que maxPDFInflate
+type pdfInflater struct {
+	remaining int64
+}
+
+// Fonction pour créer un décompresseur avec un budget neuf
+func newPDFInflater() *pdfInflater {
+	return &pdfInflater{remaining: maxPDFInflate}
+}
+
+// Fonction pour décompresser un flux ; un flux coupé par la fin du budget est renvoyé tronqué (avec io.ErrUnexpectedEOF),
+// et errPDFInflateBudget est renvoyée une fois le budget épuisé
+func (p *pdfInflater) inflate(body []byte) ([]byte, error) {
+	if p.remaining <= 0 {
+		return nil, errPDFInflateBudget
+	}
+	p.remaining -= pdfStreamCost
+	zr, err := zlib.NewReader(bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+
+	limit := p.remaining
+	if limit < 0 {
+		limit = 0
+	}
+	inflated, err := io.ReadAll(io.LimitReader(zr, limit))
+	p.remaining -= int64(len(inflated))
+	if err == nil && int64(len(inflated)) == limit {
+		err = io.ErrUnexpectedEOF
+	}
+	return inflated, err
+}
+
+// Fonction pour trouver le plus grand /Count des nœuds /Pages d'un texte PDF
+func maxPagesCount(data []byte) int {
+	count := 0
+	for _, match := range pdfPagesPattern.FindAllSubmatch(data, -1) {
+		value := match[1]
+		if value == nil {
+			value = match[2]
+		}
+		if n, err := strconv.Atoi(string(value)); err == nil && n > count {
+			count = n
+		}
+	}
+	return count
+}
+
+// Gestionnaire de route pour afficher la vignette d'un fichier (aperçu réduit, ou icône générée)
+func (s *Server) thumbnailHandler(c echo.Context) error {
+	ctx := c.Request().Context()
+	fileID, err := parseObjectID(c.Param("id"))
+	if err != nil {
+		return accessErrorResponse(c, err, "Erreur lors de la lecture de la vignette")
+	}
+
+	// Même droit que pour ouvrir le fichier
+	file, err := s.authz.File(ctx, currentUserID(c), fileID, AccessRead)
+	if err != nil {
+		return accessErrorResponse(c, err, "Erreur lors de la lecture de la vignette")
+	}
+	var thumbnail Thumbnail
+	if file.StorageKey != "" {
+		if thumbnail, err = s.blobRefs.Thumbnail(ctx, file.StorageKey); err != nil {
+			log.Println("Erreur lors de la lecture de la vignette :", err)
+			return err
+		}
+	}
+
+	if thumbnail.Kind == thumbnailImage {
+		preview := file
+		preview.StorageKey = thumbnail.Key
+		content, err := s.openFileContent(c, &preview)
+		if err == nil {
+			defer content.Close()
+			header := c.Response().Header()
+			header.Set(echo.HeaderContentType, "image/jpeg")
+			header.Set("ETag", `"`+thumbnail.Key+`"`)
+			header.Set("Cache-Control", "private")
+			http.ServeContent(c.Response(), c.Request(), "", time.Time{}, content)
+			return nil
+		}
+		// Clé verrouillée, pas encore confiée à l'accès reçu ou aperçu effacé : l'icône générique le remplace
+		if !errors.Is(err, errKeyringLocked) && !errors.Is(err, errGrantKeyMissing) && !errors.Is(err, errBlobNotFound) {
+			log.Println("Erreur lors de la lecture de la vignette :", err)
+		}
+	}
+	return servePlaceholder(c, file, thumbnail)
+}
+
+// Fonction pour envoyer l'icône d'un fichier sans aperçu : type du fichier et, pour un PDF, nombre de pages
+func servePlaceholder(c echo.Context, file UploadedFile, thumbnail Thumbnail) error {
+	label, detail := "FICHIER", ""
+	switch thumbnailTypes[file.Metadata.MIMEType] {
+	case thumbnailPDF:
+		label = "PDF"
+		if thumbnail.PageCount == 1 {
+			detail = "1 page"
+		} else if thumbnail.PageCount > 1 {
+			detail = strconv.Itoa(thumbnail.PageCount) + " pages"
+		}
+	case thumbnailImage:
+		label = "IMAGE"
+	}
+	if thumbnail.Kind == "" && label != "FICHIER" {
+		detail = "aperçu en préparation"
+	}
+
+	svg := `<svg xmlns="http://www.w3.org/2000/svg" width="` + strconv.Itoa(thumbnailSize) + `" height="` + strconv.Itoa(thumbnailSize) + `" viewBox="0 0 256 256">
+    <rect width="256" height="256" fill="#f2f2f2"/>
+    <path d="M72 32h80l40 40v152H72z" fill="#fff" stroke="#888" stroke-width="4"/>
+    <path d="M152 32v40h40" fill="none" stroke="#888" stroke-width="4"/>
+    <text x="132" y="150" font-family="sans-serif" font-size="24" font-weight="bold" text-anchor="middle" fill="#c0392b">` + label + `</text>
+    <text x="132" y="185" font-family="sans-serif" font-size="16" text-anchor="middle" fill="#555">` + detail + `</text>
+</svg>`
+	header := c.Response().Header()
+	header.Set("Content-Security-Policy", "default-src 'none'; style-src 'unsafe-inline'")
+	header.Set("Cache-Control", "private, no-cache")
+	return c.Blob(http.StatusOK, "image/svg+xml", []byte(svg))
+}
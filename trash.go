@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"html/template"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// Fonction pour traduire une erreur de la corbeille en réponse
+func trashErrorResponse(c echo.Context, err error) error {
+	if errors.Is(err, errTrashItemNotFound) {
+		return c.JSON(http.StatusNotFound, map[string]string{"message": err.Error()})
+	}
+	return accessErrorResponse(c, err, "Erreur lors de l'opération sur la corbeille")
+}
+
+// Fonction pour lire le type et l'ID d'un élément de la corbeille dans l'URL
+func trashItemParams(c echo.Context) (string, int64, error) {
+	kind := c.Param("kind")
+	if !validTrashKind(kind) {
+		return "", 0, errTrashItemNotFound
+	}
+	id, err := parseObjectID(c.Param("id"))
+	if err != nil {
+		return "", 0, errTrashItemNotFound
+	}
+	return kind, id, nil
+}
+
+// Page pour afficher la corbeille de l'utilisateur
+func (s *Server) trashHandler(c echo.Context) error {
+	ctx := c.Request().Context()
+	userID := currentUserID(c)
+	items, err := s.trash.List(ctx, userID)
+	if err != nil {
+		log.Println("Erreur lors de la récupération de la corbeille :", err)
+		return err
+	}
+
+	// Les titres des notes sont chiffrés : sans clé, seules les anciennes notes en clair sont lisibles
+	userKey, keyErr := s.keys.ForRequest(c, userID)
+
+	var itemsHTML string
+	for _, item := range items {
+		var label string
+		switch item.Kind {
+		case resourceNote:
+			label = "Note : "
+			if item.Note.WrappedKey != nil && keyErr != nil {
+				item.Note.Title = "note chiffrée"
+			} else if err := openNote(userKey, &item.Note); err != nil {
+				log.Printf("Erreur lors du déchiffrement de la note %d : %v", item.ID, err)
+				item.Note.Title = "note illisible"
+			}
+			label += template.HTMLEscapeString(item.Note.Title)
+		case resourceFile:
+			label = "Fichier : " + template.HTMLEscapeString(item.Name)
+		case resourceFolder:
+			label = "Dossier : " + template.HTMLEscapeString(item.Name) + " (avec son contenu)"
+		}
+		itemURL := "/trash/" + item.Kind + "/" + strconv.Itoa(item.ID)
+		itemsHTML += `
+            <li>` + label + `, mis à la corbeille le ` + item.DeletedAt.Format("02/01/2006 15:04") + `
+                <form action="` + itemURL + `/restore" method="post" style="display:inline">
+                    <button type="submit">Restaurer</button>
+                </form>
+                <form action="` + itemURL + `/purge" method="post" style="display:inline" onsubmit="return confirm('Supprimer définitivement cet élément ?')">
+                    <button type="submit">Supprimer définitivement</button>
+                </form>
+            </li>`
+	}
+	if itemsHTML == "" {
+		itemsHTML = "<li>La corbeille est vide.</li>"
+	}
+
+	htmlContent := `
+        <h1>Corbeille</h1>
+        <p>Les éléments sont supprimés définitivement ` + strconv.Itoa(int(s.trashRetention.Hours()/24)) + ` jours après leur mise à la corbeille.</p>
+        <ul>` + itemsHTML + `
+        </ul>
+        <form action="/trash/empty" method="post" onsubmit="return confirm('Vider la corbeille ? Cette action est irréversible.')">
+            <button type="submit">Vider la corbeille</button>
+        </form>
+        <a href="/welcome">Retour à la page </a>
+    `
+	return c.HTML(http.StatusOK, htmlContent)
+}
+
+// Gestionnaire de route pour sortir un élément de la corbeille
+func (s *Server) restoreTrashHandler(c echo.Context) error {
+	kind, id, err := trashItemParams(c)
+	if err != nil {
+		return trashErrorResponse(c, err)
+	}
+	if err := s.trash.Restore(c.Request().Context(), currentUserID(c), kind, id); err != nil {
+		return trashErrorResponse(c, err)
+	}
+	return c.Redirect(http.StatusSeeOther, "/trash")
+}
+
+// Gestionnaire de route pour supprimer définitivement un élément de la corbeille
+func (s *Server) purgeTrashHandler(c echo.Context) error {
+	ctx := c.Request().Context()
+	kind, id, err := trashItemParams(c)
+	if err != nil {
+		return trashErrorResponse(c, err)
+	}
+	keys, err := s.trash.Purge(ctx, currentUserID(c), kind, id)
+	if err != nil {
+		return trashErrorResponse(c, err)
+	}
+	removeBlobs(ctx, s.blobRefs, s.blobs, keys)
+	return c.Redirect(http.StatusSeeOther, "/trash")
+}
+
+// Gestionnaire de route pour vider la corbeille
+func (s *Server) emptyTrashHandler(c echo.Context) error {
+	ctx := c.Request().Context()
+	items, err := s.trash.List(ctx, currentUserID(c))
+	if err != nil {
+		log.Println("Erreur lors de la récupération de la corbeille :", err)
+		return err
+	}
+	if err := s.purgeTrashItems(ctx, items); err != nil {
+		log.Println("Erreur lors du vidage de la corbeille :", err)
+		return err
+	}
+	return c.Redirect(http.StatusSeeOther, "/trash")
+}
+
+// Fonction pour supprimer définitivement des éléments de la corbeille et leurs contenus
+func (s *Server) purgeTrashItems(ctx context.Context, items []TrashItem) error {
+	for _, item := range items {
+		keys, err := s.trash.Purge(ctx, item.UserID, item.Kind, int64(item.ID))
+		if errors.Is(err, errTrashItemNotFound) {
+			continue // Déjà restauré ou purgé entre-temps
+		}
+		if err != nil {
+			return err
+		}
+		removeBlobs(ctx, s.blobRefs, s.blobs, keys)
+	}
+	return nil
+}
+
+// Fonction pour supprimer définitivement les éléments restés dans la corbeille au-delà de la durée de conservation
+func (s *Server) purgeExpiredTrash(ctx context.Context) error {
+	items, err := s.trash.ListExpired(ctx, time.Now().Add(-s.trashRetention))
+	if err != nil {
+		return err
+	}
+	return s.purgeTrashItems(ctx, items)
+}
+
+// Fonction pour purger régulièrement la corbeille
+func (s *Server) runTrashJanitor(ctx context.Context) {
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+	for {
+		if err := s.purgeExpiredTrash(ctx); err != nil {
+			log.Println("Erreur lors de la purge de la corbeille :", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
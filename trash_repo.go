@@ -0,0 +1,378 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"sort"
+	"time"
+)
+
+// Erreur renvoyée quand l'élément demandé n'est pas (ou plus) dans la corbeille
+var errTrashItemNotFound = errors.New("élément introuvable dans la corbeille")
+
+// Définir une structure pour représenter un élément de la corbeille (note, fichier ou dossier)
+type TrashItem struct {
+	Kind      string    // resourceNote, resourceFile ou resourceFolder
+	ID        int       // ID de l'élément dans sa table
+	UserID    int       // ID du propriétaire
+	Name      string    // Nom du fichier ou du dossier (vide pour une note)
+	DeletedAt time.Time // Date de mise à la corbeille
+	Note      Note      // Note à déchiffrer pour l'affichage (Kind == resourceNote)
+}
+
+// TrashRepo regroupe les requêtes de la corbeille sur les tables notes, files et folders.
+// Un dossier mis à la corbeille emporte son contenu : chaque descendant reçoit l'ID du dossier dans
+// deleted_with et ne figure pas seul dans la corbeille.
+type TrashRepo struct {
+	db      *sql.DB
+	folders *FolderRepo
+}
+
+// Fonction pour vérifier qu'un type d'élément de la corbeille est connu
+func validTrashKind(kind string) bool {
+	return kind == resourceNote || kind == resourceFile || kind == resourceFolder
+}
+
+// Fonction pour mettre une note, un fichier ou un dossier (avec son contenu) à la corbeille
+func (r *TrashRepo) Put(ctx context.Context, userID int, kind string, id int64, now time.Time) error {
+	switch kind {
+	case resourceNote:
+		return r.putRow(ctx, "UPDATE notes SET deleted_at = ? WHERE id = ? AND user_id = ? AND deleted_at IS NULL", now, id, userID)
+	case resourceFile:
+		return r.putRow(ctx, "UPDATE files SET deleted_at = ? WHERE id = ? AND user_id = ? AND deleted_at IS NULL", now, id, userID)
+	case resourceFolder:
+		return r.putFolder(ctx, userID, id, now)
+	}
+	return errTrashItemNotFound
+}
+
+// Fonction pour marquer une seule ligne comme supprimée
+func (r *TrashRepo) putRow(ctx context.Context, query string, args ...interface{}) error {
+	result, err := r.db.ExecContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err == nil && n == 0 {
+		err = errNotVisible
+	}
+	return err
+}
+
+// Fonction pour mettre un dossier à la corbeille avec tout ce qu'il contient encore
+func (r *TrashRepo) putFolder(ctx context.Context, userID int, folderID int64, now time.Time) error {
+	tree, err := r.folders.Tree(ctx, userID, folderID)
+	if err != nil {
+		return err
+	}
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, "UPDATE folders SET deleted_at = ? WHERE id = ? AND user_id = ? AND deleted_at IS NULL", now, folderID, userID)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err != nil || n == 0 {
+		if err == nil {
+			err = errNotVisible
+		}
+		return err
+	}
+
+	// Les éléments déjà à la corbeille gardent leur propre entrée
+	for _, id := range tree {
+		if _, err := tx.ExecContext(ctx, "UPDATE notes SET deleted_at = ?, deleted_with = ? WHERE user_id = ? AND folder_id = ? AND deleted_at IS NULL", now, folderID, userID, id); err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, "UPDATE files SET deleted_at = ?, deleted_with = ? WHERE user_id = ? AND folder_id = ? AND deleted_at IS NULL", now, folderID, userID, id); err != nil {
+			return err
+		}
+		if id == folderID {
+			continue
+		}
+		if _, err := tx.ExecContext(ctx, "UPDATE folders SET deleted_at = ?, deleted_with = ? WHERE id = ? AND user_id = ? AND deleted_at IS NULL", now, folderID, id, userID); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// Fonction pour lister la corbeille d'un utilisateur, de la suppression la plus récente à la plus ancienne
+func (r *TrashRepo) List(ctx context.Context, userID int) ([]TrashItem, error) {
+	return r.list(ctx, "user_id = ?", userID)
+}
+
+// Fonction pour lister les éléments de tous les utilisateurs mis à la corbeille avant une date
+func (r *TrashRepo) ListExpired(ctx context.Context, before time.Time) ([]TrashItem, error) {
+	return r.list(ctx, "deleted_at < ?", before)
+}
+
+// Fonction pour lister les entrées de la corbeille (hors contenu des dossiers) qui vérifient une condition
+func (r *TrashRepo) list(ctx context.Context, where string, arg interface{}) ([]TrashItem, error) {
+	var items []TrashItem
+
+	rows, err := r.db.QueryContext(ctx, "SELECT id, user_id, folder_name, deleted_at FROM folders WHERE deleted_at IS NOT NULL AND deleted_with IS NULL AND "+where, arg)
+	if err != nil {
+		return nil, err
+	}
+	items, err = scanTrashItems(rows, resourceFolder, items)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err = r.db.QueryContext(ctx, "SELECT id, user_id, filename, deleted_at FROM files WHERE deleted_at IS NOT NULL AND deleted_with IS NULL AND "+where, arg)
+	if err != nil {
+		return nil, err
+	}
+	items, err = scanTrashItems(rows, resourceFile, items)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err = r.db.QueryContext(ctx, "SELECT id, user_id, title, content, wrapped_key, sealed_title, sealed_content, deleted_at FROM notes WHERE deleted_at IS NOT NULL AND deleted_with IS NULL AND "+where, arg)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		item := TrashItem{Kind: resourceNote}
+		var userID sql.NullInt64
+		var title, content sql.NullString
+		var deletedAt []byte
+		if err := rows.Scan(&item.ID, &userID, &title, &content, &item.Note.WrappedKey, &item.Note.SealedTitle, &item.Note.SealedContent, &deletedAt); err != nil {
+			return nil, err
+		}
+		item.UserID, item.DeletedAt = int(userID.Int64), parseDBTime(deletedAt)
+		item.Note.ID, item.Note.UserID, item.Note.Title, item.Note.Content = item.ID, item.UserID, title.String, content.String
+		items = append(items, item)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(items, func(i, j int) bool { return items[i].DeletedAt.After(items[j].DeletedAt) })
+	return items, nil
+}
+
+// Fonction pour lire des dossiers ou des fichiers de la corbeille
+func scanTrashItems(rows *sql.Rows, kind string, items []TrashItem) ([]TrashItem, error) {
+	defer rows.Close()
+
+	for rows.Next() {
+		item := TrashItem{Kind: kind}
+		var userID sql.NullInt64
+		var name sql.NullString
+		var deletedAt []byte
+		if err := rows.Scan(&item.ID, &userID, &name, &deletedAt); err != nil {
+			return nil, err
+		}
+		item.UserID, item.Name, item.DeletedAt = int(userID.Int64), name.String, parseDBTime(deletedAt)
+		items = append(items, item)
+	}
+	return items, rows.Err()
+}
+
+// Fonction pour sortir un élément de la corbeille. S'il était rangé dans un dossier qui est lui-même
+// à la corbeille, il est restauré à la racine.
+func (r *TrashRepo) Restore(ctx context.Context, userID int, kind string, id int64) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var table, parentColumn string
+	switch kind {
+	case resourceNote:
+		table, parentColumn = "notes", "folder_id"
+	case resourceFile:
+		table, parentColumn = "files", "folder_id"
+	case resourceFolder:
+		table, parentColumn = "folders", "parent_folder_id"
+	default:
+		return errTrashItemNotFound
+	}
+
+	var parentID sql.NullInt64
+	err = tx.QueryRowContext(ctx, "SELECT "+parentColumn+" FROM "+table+" WHERE id = ? AND user_id = ? AND deleted_at IS NOT NULL AND deleted_with IS NULL FOR UPDATE", id, userID).
+		Scan(&parentID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return errTrashItemNotFound
+	}
+	if err != nil {
+		return err
+	}
+	if parentID.Valid {
+		var parentDeleted []byte
+		if err := tx.QueryRowContext(ctx, "SELECT deleted_at FROM folders WHERE id = ?", parentID.Int64).Scan(&parentDeleted); err != nil {
+			return err
+		}
+		if parentDeleted != nil {
+			parentID = sql.NullInt64{}
+		}
+	}
+
+	if _, err := tx.ExecContext(ctx, "UPDATE "+table+" SET deleted_at = NULL, "+parentColumn+" = ? WHERE id = ?", parentID, id); err != nil {
+		return err
+	}
+	if kind == resourceFolder {
+		for _, table := range []string{"folders", "notes", "files"} {
+			if _, err := tx.ExecContext(ctx, "UPDATE "+table+" SET deleted_at = NULL, deleted_with = NULL WHERE user_id = ? AND deleted_with = ?", userID, id); err != nil {
+				return err
+			}
+		}
+	}
+	return tx.Commit()
+}
+
+// Fonction pour supprimer définitivement un élément de la corbeille (avec le contenu d'un dossier).
+// L'espace libéré est décompté de l'occupation de l'utilisateur.
+// Renvoie les clés de stockage des fichiers et de leurs anciennes versions qui ne sont plus référencées,
+// à effacer du stockage par l'appelant.
+func (r *TrashRepo) Purge(ctx context.Context, userID int, kind string, id int64) ([]string, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var keys []string
+	switch kind {
+	case resourceNote:
+		result, err := tx.ExecContext(ctx, "DELETE FROM notes WHERE id = ? AND user_id = ? AND deleted_at IS NOT NULL AND deleted_with IS NULL", id, userID)
+		if err != nil {
+			return nil, err
+		}
+		if n, err := result.RowsAffected(); err != nil || n == 0 {
+			if err == nil {
+				err = errTrashItemNotFound
+			}
+			return nil, err
+		}
+	case resourceFile:
+		var key sql.NullString
+		var size, versionsSize int64
+		err := tx.QueryRowContext(ctx, "SELECT storage_key, COALESCE(size, 0) FROM files WHERE id = ? AND user_id = ? AND deleted_at IS NOT NULL AND deleted_with IS NULL FOR UPDATE", id, userID).
+			Scan(&key, &size)
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, errTrashItemNotFound
+		}
+		if err != nil {
+			return nil, err
+		}
+		if keys, err = queryKeys(ctx, tx, "SELECT storage_key FROM file_versions WHERE file_id = ?", id); err != nil {
+			return nil, err
+		}
+		if key.Valid {
+			keys = append(keys, key.String)
+		}
+		if err := tx.QueryRowContext(ctx, "SELECT COALESCE(SUM(size), 0) FROM file_versions WHERE file_id = ?", id).Scan(&versionsSize); err != nil {
+			return nil, err
+		}
+		if _, err := tx.ExecContext(ctx, "DELETE FROM files WHERE id = ?", id); err != nil {
+			return nil, err
+		}
+		if err := releaseStorage(ctx, tx, userID, size+versionsSize); err != nil {
+			return nil, err
+		}
+	case resourceFolder:
+		if keys, err = r.purgeFolder(ctx, tx, userID, id); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, errTrashItemNotFound
+	}
+	if keys, err = releaseBlobs(ctx, tx, keys); err != nil {
+		return nil, err
+	}
+	return keys, tx.Commit()
+}
+
+// Fonction pour supprimer définitivement un dossier de la corbeille et le contenu qu'il a emporté.
+// Ce qui était déjà à la corbeille avant lui y reste, déplacé à la racine.
+func (r *TrashRepo) purgeFolder(ctx context.Context, tx *sql.Tx, userID int, folderID int64) ([]string, error) {
+	var parentID sql.NullInt64
+	err := tx.QueryRowContext(ctx, "SELECT parent_folder_id FROM folders WHERE id = ? AND user_id = ? AND deleted_at IS NOT NULL AND deleted_with IS NULL FOR UPDATE", folderID, userID).
+		Scan(&parentID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, errTrashItemNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Sous-dossiers emportés par le dossier, du plus haut au plus profond (parcours en largeur)
+	tree := []int64{folderID}
+	for i := 0; i < len(tree); i++ {
+		rows, err := tx.QueryContext(ctx, "SELECT id FROM folders WHERE parent_folder_id = ? AND deleted_with = ?", tree[i], folderID)
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			var id int64
+			if err := rows.Scan(&id); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			tree = append(tree, id)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, id := range tree {
+		if _, err := tx.ExecContext(ctx, "UPDATE notes SET folder_id = NULL WHERE folder_id = ? AND NOT (deleted_with <=> ?)", id, folderID); err != nil {
+			return nil, err
+		}
+		if _, err := tx.ExecContext(ctx, "UPDATE files SET folder_id = NULL WHERE folder_id = ? AND NOT (deleted_with <=> ?)", id, folderID); err != nil {
+			return nil, err
+		}
+		if _, err := tx.ExecContext(ctx, "UPDATE folders SET parent_folder_id = NULL WHERE parent_folder_id = ? AND NOT (deleted_with <=> ?)", id, folderID); err != nil {
+			return nil, err
+		}
+		// Un envoi en cours vers le dossier aboutira à la racine (sa taille reste réservée jusque-là)
+		if _, err := tx.ExecContext(ctx, "UPDATE uploads SET folder_id = NULL WHERE folder_id = ?", id); err != nil {
+			return nil, err
+		}
+	}
+
+	fileKeys, err := queryKeys(ctx, tx, "SELECT storage_key FROM files WHERE user_id = ? AND deleted_with = ?", userID, folderID)
+	if err != nil {
+		return nil, err
+	}
+	versionKeys, err := queryKeys(ctx, tx, "SELECT v.storage_key FROM file_versions v JOIN files f ON f.id = v.file_id WHERE f.user_id = ? AND f.deleted_with = ?", userID, folderID)
+	if err != nil {
+		return nil, err
+	}
+	var size int64
+	err = tx.QueryRowContext(ctx, "SELECT (SELECT COALESCE(SUM(size), 0) FROM files WHERE user_id = ? AND deleted_with = ?) + (SELECT COALESCE(SUM(v.size), 0) FROM file_versions v JOIN files f ON f.id = v.file_id WHERE f.user_id = ? AND f.deleted_with = ?)",
+		userID, folderID, userID, folderID).Scan(&size)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := tx.ExecContext(ctx, "DELETE FROM files WHERE user_id = ? AND deleted_with = ?", userID, folderID); err != nil {
+		return nil, err
+	}
+	if _, err := tx.ExecContext(ctx, "DELETE FROM notes WHERE user_id = ? AND deleted_with = ?", userID, folderID); err != nil {
+		return nil, err
+	}
+
+	if err := releaseStorage(ctx, tx, userID, size); err != nil {
+		return nil, err
+	}
+
+	// Supprimer les dossiers du plus profond au plus haut pour respecter la clé étrangère parent
+	for i := len(tree) - 1; i >= 0; i-- {
+		if _, err := tx.ExecContext(ctx, "DELETE FROM folders WHERE id = ?", tree[i]); err != nil {
+			return nil, err
+		}
+	}
+	return append(fileKeys, versionKeys...), nil
+}
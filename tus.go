@@ -0,0 +1,663 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// Envois reprenables selon le protocole tus 1.0 (https://tus.io/protocols/resumable-upload),
+// avec les extensions creation, expiration, checksum et termination.
+// Les morceaux reçus sont chiffrés avec la clé de données du futur fichier dès leur arrivée,
+// et le fichier n'est enregistré dans `files` qu'une fois l'envoi complet.
+const (
+	tusVersion            = "1.0.0"
+	tusExtensions         = "creation,expiration,checksum,termination"
+	tusChecksumAlgorithms = "sha1,sha256,md5"
+	tusContentType        = "application/offset+octet-stream"
+)
+
+// Statut renvoyé quand la somme de contrôle d'un morceau ne correspond pas (extension checksum)
+const statusChecksumMismatch = 460
+
+// Erreurs propres aux envois reprenables
+var (
+	errUploadBusy     = errors.New("un autre envoi est en cours sur ce fichier")
+	errUploadExpired  = errors.New("envoi expiré")
+	errUploadTooLarge = errors.New("le morceau dépasse la taille annoncée")
+	errUploadPart     = errors.New("morceau d'envoi manquant")
+)
+
+// UploadStaging garde sur le disque local les morceaux chiffrés des envois en cours : <dossier>/<id>/<position>
+type UploadStaging struct {
+	dir    string
+	expiry time.Duration
+
+	mu   sync.Mutex
+	busy map[string]bool // Envois en train de recevoir un morceau
+}
+
+// Fonction pour créer la zone de préparation des envois
+func NewUploadStaging(dir string, expiry time.Duration) *UploadStaging {
+	return &UploadStaging{dir: dir, expiry: expiry, busy: make(map[string]bool)}
+}
+
+// Fonction pour réserver un envoi le temps de recevoir un morceau
+func (s *UploadStaging) lock(uploadID string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.busy[uploadID] {
+		return false
+	}
+	s.busy[uploadID] = true
+	return true
+}
+
+func (s *UploadStaging) unlock(uploadID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.busy, uploadID)
+}
+
+// Fonction pour calculer le chemin d'un morceau ; l'identifiant est validé comme une clé de stockage
+func (s *UploadStaging) partPath(uploadID string, offset int64) (string, error) {
+	if !storageKeyPattern.MatchString(uploadID) {
+		return "", errInvalidStorageKey
+	}
+	return filepath.Join(s.dir, uploadID, fmt.Sprintf("%020d", offset)), nil
+}
+
+// Fonction pour enregistrer un morceau reçu à une position donnée ; renvoie le nombre d'octets en clair enregistrés.
+// Sans somme de contrôle, une connexion coupée garde les octets déjà reçus (le client reprendra à la suite) ;
+// avec une somme de contrôle, le morceau doit être complet pour pouvoir être vérifié.
+func (s *UploadStaging) writePart(uploadID string, offset int64, dataKey []byte, src io.Reader, keepPartial bool) (int64, error) {
+	path, err := s.partPath(uploadID, offset)
+	if err != nil {
+		return 0, err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return 0, err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".part-*")
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	counter := &countingReader{r: src, keepPartial: keepPartial}
+	encrypted, err := encryptStream(dataKey, counter)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := io.Copy(tmp, encrypted); err != nil {
+		return 0, err
+	}
+	if counter.n == 0 {
+		return 0, nil
+	}
+	if err := tmp.Sync(); err != nil {
+		return 0, err
+	}
+	if err := tmp.Close(); err != nil {
+		return 0, err
+	}
+	return counter.n, os.Rename(tmp.Name(), path)
+}
+
+// Fonction pour supprimer un morceau (somme de contrôle fausse, échec de l'enregistrement)
+func (s *UploadStaging) removePart(uploadID string, offset int64) {
+	if path, err := s.partPath(uploadID, offset); err == nil {
+		os.Remove(path)
+	}
+}
+
+// Fonction pour supprimer tous les morceaux d'un envoi
+func (s *UploadStaging) remove(uploadID string) {
+	if !storageKeyPattern.MatchString(uploadID) {
+		return
+	}
+	if err := os.RemoveAll(filepath.Join(s.dir, uploadID)); err != nil {
+		log.Println("Erreur lors de la suppression des morceaux de l'envoi :", err)
+	}
+}
+
+// Fonction pour relire en clair, dans l'ordre, les morceaux d'un envoi complet
+func (s *UploadStaging) open(uploadID string, length int64, dataKey []byte) io.ReadCloser {
+	return &partsReader{staging: s, uploadID: uploadID, length: length, dataKey: dataKey}
+}
+
+// countingReader compte les octets reçus ; en mode tolérant, une erreur de lecture termine le morceau
+type countingReader struct {
+	r           io.Reader
+	n           int64
+	keepPartial bool
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.n += int64(n)
+	if err != nil && err != io.EOF && r.keepPartial && !errors.Is(err, errUploadTooLarge) {
+		return n, io.EOF
+	}
+	return n, err
+}
+
+// partsReader enchaîne les morceaux d'un envoi : chaque morceau commence à la position où s'arrête le précédent
+type partsReader struct {
+	staging  *UploadStaging
+	uploadID string
+	length   int64
+	dataKey  []byte
+	offset   int64
+	current  io.ReadCloser
+}
+
+func (r *partsReader) Read(p []byte) (int, error) {
+	for {
+		if r.current == nil {
+			if r.offset >= r.length {
+				return 0, io.EOF
+			}
+			path, err := r.staging.partPath(r.uploadID, r.offset)
+			if err != nil {
+				return 0, err
+			}
+			f, err := os.Open(path)
+			if errors.Is(err, fs.ErrNotExist) {
+				return 0, errUploadPart
+			}
+			if err != nil {
+				return 0, err
+			}
+			info, err := f.Stat()
+			if err != nil {
+				f.Close()
+				return 0, err
+			}
+			part, err := decryptSeeker(r.dataKey, f, info.Size())
+			if err != nil {
+				f.Close()
+				return 0, err
+			}
+			r.current = part
+		}
+
+		n, err := r.current.Read(p)
+		r.offset += int64(n)
+		if err == io.EOF {
+			r.current.Close()
+			r.current = nil
+			if n == 0 {
+				continue
+			}
+			err = nil
+		}
+		return n, err
+	}
+}
+
+func (r *partsReader) Close() error {
+	if r.current != nil {
+		return r.current.Close()
+	}
+	return nil
+}
+
+// limitedBody refuse les octets au-delà de la taille restante de l'envoi
+type limitedBody struct {
+	r         io.Reader
+	remaining int64
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+	if b.remaining <= 0 {
+		var extra [1]byte
+		if n, _ := b.r.Read(extra[:]); n > 0 {
+			return 0, errUploadTooLarge
+		}
+		return 0, io.EOF
+	}
+	if int64(len(p)) > b.remaining {
+		p = p[:b.remaining]
+	}
+	n, err := b.r.Read(p)
+	b.remaining -= int64(n)
+	return n, err
+}
+
+// Fonction pour supprimer les envois expirés et les morceaux qui n'appartiennent plus à aucun envoi
+func (s *Server) purgeUploads(ctx context.Context) error {
+	expired, err := s.uploads.DeleteExpired(ctx, time.Now())
+	for _, upload := range expired {
+		s.staging.remove(upload.ID)
+		s.releaseStorage(ctx, upload.UserID, upload.Length)
+	}
+	if err != nil {
+		return err
+	}
+
+	// Dossiers laissés par un envoi supprimé avec son compte, son dossier de destination ou un arrêt brutal
+	active, err := s.uploads.IDs(ctx)
+	if err != nil {
+		return err
+	}
+	entries, err := os.ReadDir(s.staging.dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil || active[entry.Name()] || time.Since(info.ModTime()) < s.staging.expiry {
+			continue
+		}
+		s.staging.remove(entry.Name())
+	}
+	return nil
+}
+
+// Fonction pour purger régulièrement les envois abandonnés
+func (s *Server) runUploadJanitor(ctx context.Context) {
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+	for {
+		if err := s.purgeUploads(ctx); err != nil {
+			log.Println("Erreur lors de la purge des envois expirés :", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Middleware pour vérifier la version du protocole et l'annoncer dans chaque réponse
+func tusProtocol(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		c.Response().Header().Set("Tus-Resumable", tusVersion)
+		if c.Request().Method != http.MethodOptions && c.Request().Header.Get("Tus-Resumable") != tusVersion {
+			c.Response().Header().Set("Tus-Version", tusVersion)
+			return c.NoContent(http.StatusPreconditionFailed)
+		}
+		return next(c)
+	}
+}
+
+// Gestionnaire de route pour annoncer les capacités du serveur (OPTIONS /uploads)
+func (s *Server) tusOptionsHandler(c echo.Context) error {
+	header := c.Response().Header()
+	header.Set("Tus-Version", tusVersion)
+	header.Set("Tus-Extension", tusExtensions)
+	header.Set("Tus-Checksum-Algorithm", tusChecksumAlgorithms)
+	if s.uploadPolicy.MaxSize > 0 {
+		header.Set("Tus-Max-Size", strconv.FormatInt(s.uploadPolicy.MaxSize, 10))
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+// Fonction pour lire l'en-tête Upload-Metadata : paires "clé valeur-en-base64" séparées par des virgules
+func parseUploadMetadata(header string) (map[string]string, error) {
+	metadata := make(map[string]string)
+	for _, pair := range strings.Split(header, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		key, encoded, _ := strings.Cut(pair, " ")
+		value, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("métadonnée %q invalide", key)
+		}
+		metadata[key] = string(value)
+	}
+	return metadata, nil
+}
+
+// Fonction pour lire l'en-tête Upload-Checksum : "algorithme somme-en-base64"
+func parseUploadChecksum(header string) (hash.Hash, []byte, error) {
+	algorithm, encoded, _ := strings.Cut(header, " ")
+	expected, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, nil, errors.New("somme de contrôle invalide")
+	}
+	switch algorithm {
+	case "sha1":
+		return sha1.New(), expected, nil
+	case "sha256":
+		return sha256.New(), expected, nil
+	case "md5":
+		return md5.New(), expected, nil
+	default:
+		return nil, nil, fmt.Errorf("algorithme de somme de contrôle non pris en charge : %q", algorithm)
+	}
+}
+
+// Fonction pour ajouter les en-têtes décrivant l'état d'un envoi
+func setUploadHeaders(c echo.Context, upload PendingUpload) {
+	header := c.Response().Header()
+	header.Set("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+	header.Set("Upload-Expires", upload.ExpiresAt.UTC().Format(http.TimeFormat))
+	header.Set("Cache-Control", "no-store")
+}
+
+// Fonction pour répondre sur une erreur d'envoi
+func uploadErrorResponse(c echo.Context, status int, message string) error {
+	return c.JSON(status, map[string]string{"message": message})
+}
+
+// Fonction pour répondre quand l'envoi demandé n'est pas utilisable
+func uploadLookupResponse(c echo.Context, err error) error {
+	switch {
+	case errors.Is(err, errUploadNotFound):
+		return uploadErrorResponse(c, http.StatusNotFound, "Envoi introuvable")
+	case errors.Is(err, errUploadExpired):
+		return uploadErrorResponse(c, http.StatusGone, "Envoi expiré")
+	default:
+		log.Println("Erreur lors de la récupération de l'envoi :", err)
+		return err
+	}
+}
+
+// Fonction pour récupérer un envoi non expiré de l'utilisateur connecté
+func (s *Server) pendingUpload(c echo.Context) (PendingUpload, error) {
+	upload, err := s.uploads.Get(c.Request().Context(), currentUserID(c), c.Param("id"))
+	if err == nil && time.Now().After(upload.ExpiresAt) {
+		err = errUploadExpired
+	}
+	return upload, err
+}
+
+// Gestionnaire de route pour créer un envoi reprenable (POST /uploads, extension creation)
+func (s *Server) tusCreateHandler(c echo.Context) error {
+	ctx := c.Request().Context()
+	userID := currentUserID(c)
+
+	length, err := strconv.ParseInt(c.Request().Header.Get("Upload-Length"), 10, 64)
+	if err != nil || length < 0 {
+		return uploadErrorResponse(c, http.StatusBadRequest, "En-tête Upload-Length manquant ou invalide")
+	}
+	if s.uploadPolicy.MaxSize > 0 && length > s.uploadPolicy.MaxSize {
+		return uploadErrorResponse(c, http.StatusRequestEntityTooLarge, s.uploadPolicy.tooLarge().Error())
+	}
+	metadata, err := parseUploadMetadata(c.Request().Header.Get("Upload-Metadata"))
+	if err != nil {
+		return uploadErrorResponse(c, http.StatusBadRequest, err.Error())
+	}
+	fileName := metadata["filename"]
+	if fileName == "" {
+		fileName = metadata["name"]
+	}
+	fileType := metadata["filetype"]
+	if fileType == "" {
+		fileType = metadata["type"]
+	}
+
+	// Dossier de destination (racine si absent), qui doit appartenir à l'utilisateur
+	folderID, err := parseFolderID(metadata["folder_id"])
+	if err == nil {
+		err = s.authz.Destination(ctx, userID, userID, folderID)
+	}
+	if err != nil {
+		return folderErrorResponse(c, err)
+	}
+
+	// La clé de données du futur fichier est créée dès maintenant pour chiffrer chaque morceau reçu
+	userKey, err := s.keys.ForRequest(c, userID)
+	if err != nil {
+		return keyringLockedResponse(c)
+	}
+	_, wrappedKey, err := newFileKey(userKey, userID)
+	if err != nil {
+		log.Println("Erreur lors de la génération de la clé du fichier :", err)
+		return err
+	}
+	id, err := randomBytes(16)
+	if err != nil {
+		return err
+	}
+
+	// La taille annoncée est réservée sur le quota avant de recevoir le moindre octet
+	if err := s.reserveStorage(ctx, userID, length); err != nil {
+		if errors.Is(err, errQuotaExceeded) {
+			return uploadErrorResponse(c, http.StatusRequestEntityTooLarge, err.Error())
+		}
+		log.Println("Erreur lors de la réservation de l'espace de stockage :", err)
+		return err
+	}
+
+	upload := PendingUpload{
+		ID:         hex.EncodeToString(id),
+		UserID:     userID,
+		FileName:   cleanDisplayName(fileName),
+		FileType:   cleanDeclaredType(fileType),
+		FolderID:   folderID,
+		Length:     length,
+		WrappedKey: wrappedKey,
+		ExpiresAt:  time.Now().Add(s.staging.expiry).Truncate(time.Second),
+	}
+	if err := s.uploads.Create(ctx, upload); err != nil {
+		s.releaseStorage(ctx, userID, length)
+		log.Println("Erreur lors de l'enregistrement de l'envoi :", err)
+		return err
+	}
+
+	// Un fichier vide est complet dès sa création
+	if length == 0 {
+		if err := s.completeUpload(ctx, upload, userKey); err != nil {
+			s.abandonUpload(ctx, upload)
+			if isUploadPolicyError(err) {
+				return uploadErrorResponse(c, http.StatusUnsupportedMediaType, err.Error())
+			}
+			if errors.Is(err, errScanUnavailable) {
+				return uploadErrorResponse(c, http.StatusServiceUnavailable, err.Error())
+			}
+			log.Println("Erreur lors de l'enregistrement du fichier :", err)
+			return err
+		}
+	}
+
+	c.Response().Header().Set(echo.HeaderLocation, "/uploads/"+upload.ID)
+	c.Response().Header().Set("Upload-Expires", upload.ExpiresAt.UTC().Format(http.TimeFormat))
+	return c.NoContent(http.StatusCreated)
+}
+
+// Gestionnaire de route pour connaître la position d'un envoi (HEAD /uploads/:id)
+func (s *Server) tusHeadHandler(c echo.Context) error {
+	upload, err := s.pendingUpload(c)
+	if err != nil {
+		return uploadLookupResponse(c, err)
+	}
+	setUploadHeaders(c, upload)
+	c.Response().Header().Set("Upload-Length", strconv.FormatInt(upload.Length, 10))
+	return c.NoContent(http.StatusOK)
+}
+
+// Gestionnaire de route pour recevoir un morceau (PATCH /uploads/:id)
+func (s *Server) tusPatchHandler(c echo.Context) error {
+	ctx := c.Request().Context()
+	req := c.Request()
+
+	if req.Header.Get(echo.HeaderContentType) != tusContentType {
+		return uploadErrorResponse(c, http.StatusUnsupportedMediaType, "Content-Type "+tusContentType+" attendu")
+	}
+	offset, err := strconv.ParseInt(req.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil || offset < 0 {
+		return uploadErrorResponse(c, http.StatusBadRequest, "En-tête Upload-Offset manquant ou invalide")
+	}
+	var checksum hash.Hash
+	var expected []byte
+	if header := req.Header.Get("Upload-Checksum"); header != "" {
+		if checksum, expected, err = parseUploadChecksum(header); err != nil {
+			return uploadErrorResponse(c, http.StatusBadRequest, err.Error())
+		}
+	}
+
+	upload, err := s.pendingUpload(c)
+	if err != nil {
+		return uploadLookupResponse(c, err)
+	}
+	if offset != upload.Offset {
+		setUploadHeaders(c, upload)
+		return uploadErrorResponse(c, http.StatusConflict, "Upload-Offset ne correspond pas à la position de l'envoi")
+	}
+	if req.ContentLength > upload.Length-upload.Offset {
+		return uploadErrorResponse(c, http.StatusRequestEntityTooLarge, errUploadTooLarge.Error())
+	}
+	if !s.staging.lock(upload.ID) {
+		return uploadErrorResponse(c, http.StatusLocked, errUploadBusy.Error())
+	}
+	defer s.staging.unlock(upload.ID)
+
+	userKey, err := s.keys.ForRequest(c, upload.UserID)
+	if err != nil {
+		return keyringLockedResponse(c)
+	}
+	dataKey, err := openFileKey(userKey, upload.WrappedKey, upload.UserID)
+	if err != nil {
+		log.Println("Erreur lors de l'ouverture de la clé de l'envoi :", err)
+		return err
+	}
+
+	// Enregistrer le morceau chiffré, en calculant la somme de contrôle au passage
+	var body io.Reader = &limitedBody{r: req.Body, remaining: upload.Length - upload.Offset}
+	if checksum != nil {
+		body = io.TeeReader(body, checksum)
+	}
+	n, err := s.staging.writePart(upload.ID, offset, dataKey, body, checksum == nil)
+	if errors.Is(err, errUploadTooLarge) {
+		return uploadErrorResponse(c, http.StatusRequestEntityTooLarge, err.Error())
+	}
+	if err != nil {
+		log.Println("Erreur lors de l'enregistrement du morceau :", err)
+		return err
+	}
+	if checksum != nil && !bytes.Equal(checksum.Sum(nil), expected) {
+		s.staging.removePart(upload.ID, offset)
+		return uploadErrorResponse(c, statusChecksumMismatch, "Somme de contrôle incorrecte")
+	}
+
+	upload.Offset += n
+	upload.ExpiresAt = time.Now().Add(s.staging.expiry).Truncate(time.Second)
+	if n > 0 && upload.Offset == upload.Length {
+		// Dernier morceau : le fichier entre dans `files` et l'envoi disparaît
+		if err := s.completeUpload(ctx, upload, userKey); err != nil {
+			// Un type refusé ne deviendra pas acceptable en renvoyant le morceau : l'envoi est abandonné
+			if isUploadPolicyError(err) {
+				s.abandonUpload(ctx, upload)
+				return uploadErrorResponse(c, http.StatusUnsupportedMediaType, err.Error())
+			}
+			s.staging.removePart(upload.ID, offset)
+			// Antivirus indisponible : le client pourra renvoyer le dernier morceau plus tard
+			if errors.Is(err, errScanUnavailable) {
+				return uploadErrorResponse(c, http.StatusServiceUnavailable, err.Error())
+			}
+			log.Println("Erreur lors de l'enregistrement du fichier :", err)
+			return err
+		}
+	} else if n > 0 {
+		advanced, err := s.uploads.Advance(ctx, upload.ID, offset, upload.Offset, upload.ExpiresAt)
+		if err != nil || !advanced {
+			s.staging.removePart(upload.ID, offset)
+			if err != nil {
+				log.Println("Erreur lors de l'enregistrement de la position de l'envoi :", err)
+				return err
+			}
+			return uploadErrorResponse(c, http.StatusConflict, "L'envoi a changé pendant la réception du morceau")
+		}
+	}
+
+	setUploadHeaders(c, upload)
+	return c.NoContent(http.StatusNoContent)
+}
+
+// Fonction pour assembler un envoi complet en un fichier chiffré et l'enregistrer dans `files`
+func (s *Server) completeUpload(ctx context.Context, upload PendingUpload, userKey []byte) error {
+	dataKey, err := openFileKey(userKey, upload.WrappedKey, upload.UserID)
+	if err != nil {
+		return err
+	}
+
+	// Même enregistrement qu'un dépôt par formulaire ; le type n'est connu qu'une fois le début du contenu reçu,
+	// la politique d'envoi s'applique donc ici. La taille a été réservée à la création de l'envoi.
+	err = s.importFile(ctx, upload.UserID, userKey, incomingFile{
+		Name:         upload.FileName,
+		Size:         upload.Length,
+		DeclaredType: upload.FileType,
+		FolderID:     upload.FolderID,
+		Open: func() (io.ReadCloser, error) {
+			return s.staging.open(upload.ID, upload.Length, dataKey), nil
+		},
+		WrappedKey: upload.WrappedKey,
+		Reserved:   true,
+	})
+	if err != nil {
+		return err
+	}
+
+	// L'espace réservé à la création de l'envoi est désormais occupé par le fichier
+	if _, err := s.uploads.Delete(ctx, upload.UserID, upload.ID); err != nil {
+		log.Println("Erreur lors de la suppression de l'envoi terminé :", err)
+	}
+	s.staging.remove(upload.ID)
+	go s.refreshAccess(context.Background(), upload.UserID, userKey)
+	return nil
+}
+
+// Fonction pour supprimer un envoi refusé ou en échec et libérer l'espace qui lui était réservé
+func (s *Server) abandonUpload(ctx context.Context, upload PendingUpload) {
+	deleted, err := s.uploads.Delete(ctx, upload.UserID, upload.ID)
+	if err != nil {
+		log.Println("Erreur lors de la suppression de l'envoi :", err)
+		return
+	}
+	if deleted {
+		s.releaseStorage(ctx, upload.UserID, upload.Length)
+	}
+	s.staging.remove(upload.ID)
+}
+
+// Gestionnaire de route pour abandonner un envoi (DELETE /uploads/:id, extension termination)
+func (s *Server) tusDeleteHandler(c echo.Context) error {
+	upload, err := s.uploads.Get(c.Request().Context(), currentUserID(c), c.Param("id"))
+	if err != nil {
+		return uploadLookupResponse(c, err)
+	}
+	if !s.staging.lock(upload.ID) {
+		return uploadErrorResponse(c, http.StatusLocked, errUploadBusy.Error())
+	}
+	defer s.staging.unlock(upload.ID)
+
+	deleted, err := s.uploads.Delete(c.Request().Context(), upload.UserID, upload.ID)
+	if err != nil {
+		log.Println("Erreur lors de la suppression de l'envoi :", err)
+		return err
+	}
+	if deleted {
+		s.releaseStorage(c.Request().Context(), upload.UserID, upload.Length)
+	}
+	s.staging.remove(upload.ID)
+	return c.NoContent(http.StatusNoContent)
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// Erreurs renvoyées par la politique d'envoi
+var (
+	errFileTooLarge = errors.New("fichier trop volumineux")
+	errTypeDenied   = errors.New("type de fichier refusé")
+	errQuarantined  = errors.New("fichier en quarantaine")
+)
+
+// Nombre d'octets lus pour reconnaître le type d'un contenu avant de l'accepter
+const sniffSize = 1024
+
+// UploadPolicy décide si un contenu envoyé est accepté, refusé ou mis en quarantaine
+type UploadPolicy struct {
+	MaxSize     int64 // Taille maximale d'un fichier, en octets (0 : pas de limite propre)
+	Allowed     []string
+	Denied      []string
+	Quarantine  []string
+	SafeServing bool // Tous les contenus servis en téléchargement
+}
+
+// Fonction pour construire la politique d'envoi à partir de la configuration
+func newUploadPolicy(cfg UploadConfig) UploadPolicy {
+	return UploadPolicy{
+		MaxSize:     int64(cfg.MaxSizeMB) << 20,
+		Allowed:     cfg.AllowedTypes,
+		Denied:      cfg.DeniedTypes,
+		Quarantine:  cfg.QuarantineTypes,
+		SafeServing: cfg.SafeServing,
+	}
+}
+
+// Fonction pour valider un motif de type de contenu : "type/sous-type" ou "type/*"
+func validTypePattern(pattern string) bool {
+	mainType, subType, ok := strings.Cut(pattern, "/")
+	return ok && mainType != "" && mainType != "*" && subType != "" && !strings.ContainsAny(pattern, " ;,")
+}
+
+// Fonction pour savoir si un type (sans paramètres) correspond à l'un des motifs
+func matchType(patterns []string, mediaType string) bool {
+	for _, pattern := range patterns {
+		pattern = strings.ToLower(pattern)
+		if pattern == mediaType || (strings.HasSuffix(pattern, "/*") && strings.HasPrefix(mediaType, strings.TrimSuffix(pattern, "*"))) {
+			return true
+		}
+	}
+	return false
+}
+
+// Fonction pour construire l'erreur d'un fichier qui dépasse la taille maximale
+func (p UploadPolicy) tooLarge() error {
+	return fmt.Errorf("%w (%s au plus)", errFileTooLarge, formatBytes(p.MaxSize))
+}
+
+// Fonction pour appliquer la politique à un contenu de taille et de type reconnu connus.
+// Renvoie la raison de la quarantaine (vide si le contenu est servi normalement).
+func (p UploadPolicy) Check(size int64, mimeType string) (string, error) {
+	if p.MaxSize > 0 && size > p.MaxSize {
+		return "", p.tooLarge()
+	}
+	mediaType, _, err := mime.ParseMediaType(mimeType)
+	if err != nil {
+		mediaType = "application/octet-stream"
+	}
+	if matchType(p.Denied, mediaType) || (len(p.Allowed) > 0 && !matchType(p.Allowed, mediaType)) {
+		return "", fmt.Errorf("%w : %s", errTypeDenied, mediaType)
+	}
+	if matchType(p.Quarantine, mediaType) {
+		return "type de contenu suspect : " + mediaType, nil
+	}
+	return "", nil
+}
+
+// Fonction pour appliquer la politique d'envoi à un contenu avant de l'enregistrer : seuls ses premiers octets sont lus
+func (s *Server) checkUpload(size int64, open func() (io.ReadCloser, error)) (string, error) {
+	src, err := open()
+	if err != nil {
+		return "", err
+	}
+	head := make([]byte, sniffSize)
+	n, err := io.ReadFull(src, head)
+	src.Close()
+	if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return "", err
+	}
+	return s.uploadPolicy.Check(size, sniffContentType(head[:n]))
+}
+
+// Fonction pour traduire un refus de la politique d'envoi en réponse JSON
+func uploadPolicyResponse(c echo.Context, err error) error {
+	status := http.StatusUnsupportedMediaType
+	if errors.Is(err, errFileTooLarge) {
+		status = http.StatusRequestEntityTooLarge
+	}
+	return c.JSON(status, map[string]string{"message": err.Error()})
+}
+
+// Fonction pour savoir si une erreur vient de la politique d'envoi
+func isUploadPolicyError(err error) bool {
+	return errors.Is(err, errFileTooLarge) || errors.Is(err, errTypeDenied)
+}
+
+// Définir une structure pour représenter un fichier en quarantaine, pour la page d'administration
+type quarantinedFile struct {
+	ID         int
+	Username   string
+	FileName   string
+	MIMEType   string
+	Reason     string
+	UploadedAt time.Time
+}
+
+// Fonction pour lister les fichiers en quarantaine de tous les utilisateurs (hors corbeille)
+func (r *FileRepo) ListQuarantined(ctx context.Context) ([]quarantinedFile, error) {
+	rows, err := r.db.QueryContext(ctx, "SELECT f.id, u.username, f.filename, f.mime_type, f.quarantine, f.uploaded_at FROM files f LEFT JOIN users u ON u.ID = f.user_id WHERE f.quarantine IS NOT NULL AND f.deleted_at IS NULL ORDER BY f.uploaded_at DESC, f.id DESC")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var files []quarantinedFile
+	for rows.Next() {
+		var file quarantinedFile
+		var username, name, mimeType sql.NullString
+		var uploadedAt []byte
+		if err := rows.Scan(&file.ID, &username, &name, &mimeType, &file.Reason, &uploadedAt); err != nil {
+			return nil, err
+		}
+		file.Username, file.FileName, file.MIMEType, file.UploadedAt = username.String, name.String, mimeType.String, parseDBTime(uploadedAt)
+		files = append(files, file)
+	}
+	return files, rows.Err()
+}
+
+// Fonction pour lever la quarantaine du contenu courant d'un fichier
+func (r *FileRepo) ReleaseQuarantine(ctx context.Context, fileID int64) error {
+	result, err := r.db.ExecContext(ctx, "UPDATE files SET quarantine = NULL WHERE id = ? AND quarantine IS NOT NULL AND deleted_at IS NULL", fileID)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err != nil || n == 0 {
+		if err == nil {
+			err = errFileNotFound
+		}
+		return err
+	}
+	return nil
+}
+
+// Page d'administration des fichiers en quarantaine. Leur contenu est chiffré avec la clé de leur propriétaire :
+// l'administrateur décide d'après le nom, le type reconnu et la raison, sans lire le fichier.
+func (s *Server) quarantineHandler(c echo.Context) error {
+	files, err := s.files.ListQuarantined(c.Request().Context())
+	if err != nil {
+		log.Println("Erreur lors de la récupération des fichiers en quarantaine :", err)
+		return err
+	}
+
+	htmlContent := `
+        <h1>Fichiers en quarantaine</h1>
+        <p>Un fichier en quarantaine n'est plus servi ; son propriétaire peut le supprimer, un administrateur peut lever la quarantaine.</p>`
+	if len(files) == 0 {
+		htmlContent += `
+        <p>Aucun fichier en quarantaine.</p>`
+	} else {
+		htmlContent += `
+        <ul>`
+		for _, file := range files {
+			htmlContent += `
+            <li>` + template.HTMLEscapeString(file.FileName) + ` (` + template.HTMLEscapeString(file.Username) + `, ` + file.UploadedAt.Format("02/01/2006 15:04") + `) : ` + template.HTMLEscapeString(file.Reason) + `
+                <form action="/admin/quarantine/` + strconv.Itoa(file.ID) + `/release" method="post">
+                    <button type="submit">Lever la quarantaine</button>
+                </form>
+            </li>`
+		}
+		htmlContent += `
+        </ul>`
+	}
+	htmlContent += `
+        <a href="/welcome">Retour à la page </a>
+    `
+	return c.HTML(http.StatusOK, htmlContent)
+}
+
+// Traitement du formulaire de levée de quarantaine d'un fichier
+func (s *Server) quarantineReleasePostHandler(c echo.Context) error {
+	fileID, err := parseObjectID(c.Param("id"))
+	if err == nil {
+		err = s.files.ReleaseQuarantine(c.Request().Context(), fileID)
+	}
+	if errors.Is(err, errFileNotFound) || errors.Is(err, errNotVisible) {
+		return c.JSON(http.StatusNotFound, map[string]string{"message": errFileNotFound.Error()})
+	}
+	if err != nil {
+		log.Println("Erreur lors de la levée de la quarantaine :", err)
+		return err
+	}
+	log.Printf("Quarantaine du fichier %d levée par l'utilisateur %d", fileID, currentUserID(c))
+	return c.Redirect(http.StatusSeeOther, "/admin/quarantine")
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// Erreur renvoyée quand l'envoi demandé n'existe pas (ou plus)
+var errUploadNotFound = errors.New("envoi introuvable")
+
+// Définir une structure pour représenter un envoi reprenable en cours
+type PendingUpload struct {
+	ID         string        // Identifiant aléatoire, utilisé dans l'URL de l'envoi
+	UserID     int           // ID du propriétaire
+	FileName   string        // Nom d'origine, pour l'affichage une fois l'envoi terminé
+	FileType   string        // Type annoncé par le client (vide s'il n'en a pas donné)
+	FolderID   sql.NullInt64 // Dossier de destination (NULL pour la racine)
+	Length     int64         // Taille totale annoncée (Upload-Length)
+	Offset     int64         // Octets déjà reçus et enregistrés (Upload-Offset)
+	WrappedKey []byte        // Clé de données du futur fichier, protégée par la clé du propriétaire
+	ExpiresAt  time.Time     // Date après laquelle l'envoi est abandonné
+}
+
+// UploadRepo regroupe les requêtes sur la table uploads
+type UploadRepo struct {
+	db *sql.DB
+}
+
+// Fonction pour enregistrer un nouvel envoi
+func (r *UploadRepo) Create(ctx context.Context, upload PendingUpload) error {
+	_, err := r.db.ExecContext(ctx, "INSERT INTO uploads (id, user_id, filename, declared_type, folder_id, upload_length, upload_offset, wrapped_key, expires_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		upload.ID, upload.UserID, upload.FileName, nullString(upload.FileType), upload.FolderID, upload.Length, upload.Offset, upload.WrappedKey, upload.ExpiresAt)
+	return err
+}
+
+// Fonction pour récupérer un envoi de son propriétaire
+func (r *UploadRepo) Get(ctx context.Context, userID int, uploadID string) (PendingUpload, error) {
+	upload := PendingUpload{ID: uploadID, UserID: userID}
+	var expiresAt []byte
+	var fileType sql.NullString
+	err := r.db.QueryRowContext(ctx, "SELECT filename, declared_type, folder_id, upload_length, upload_offset, wrapped_key, expires_at FROM uploads WHERE id = ? AND user_id = ?", uploadID, userID).
+		Scan(&upload.FileName, &fileType, &upload.FolderID, &upload.Length, &upload.Offset, &upload.WrappedKey, &expiresAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return upload, errUploadNotFound
+	}
+	upload.FileType, upload.ExpiresAt = fileType.String, parseDBTime(expiresAt)
+	return upload, err
+}
+
+// Fonction pour avancer la position d'un envoi ; renvoie false si un autre envoi l'a déplacée entre-temps
+func (r *UploadRepo) Advance(ctx context.Context, uploadID string, from, to int64, expiresAt time.Time) (bool, error) {
+	result, err := r.db.ExecContext(ctx, "UPDATE uploads SET upload_offset = ?, expires_at = ? WHERE id = ? AND upload_offset = ?", to, expiresAt, uploadID, from)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
+
+// Fonction pour supprimer un envoi de son propriétaire ; renvoie false s'il n'existait plus
+func (r *UploadRepo) Delete(ctx context.Context, userID int, uploadID string) (bool, error) {
+	result, err := r.db.ExecContext(ctx, "DELETE FROM uploads WHERE id = ? AND user_id = ?", uploadID, userID)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
+
+// Fonction pour supprimer les envois expirés ; renvoie ceux effectivement supprimés pour nettoyer leurs morceaux
+// et rendre l'espace réservé
+func (r *UploadRepo) DeleteExpired(ctx context.Context, now time.Time) ([]PendingUpload, error) {
+	rows, err := r.db.QueryContext(ctx, "SELECT id, user_id, upload_length FROM uploads WHERE expires_at < ?", now)
+	if err != nil {
+		return nil, err
+	}
+	var expired []PendingUpload
+	for rows.Next() {
+		var upload PendingUpload
+		if err := rows.Scan(&upload.ID, &upload.UserID, &upload.Length); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		expired = append(expired, upload)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var deleted []PendingUpload
+	for _, upload := range expired {
+		result, err := r.db.ExecContext(ctx, "DELETE FROM uploads WHERE id = ? AND expires_at < ?", upload.ID, now)
+		if err != nil {
+			return deleted, err
+		}
+		if n, err := result.RowsAffected(); err == nil && n > 0 {
+			deleted = append(deleted, upload)
+		}
+	}
+	return deleted, nil
+}
+
+// Fonction pour lister les identifiants de tous les envois en cours
+func (r *UploadRepo) IDs(ctx context.Context) (map[string]bool, error) {
+	rows, err := r.db.QueryContext(ctx, "SELECT id FROM uploads")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := make(map[string]bool)
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids[id] = true
+	}
+	return ids, rows.Err()
+}
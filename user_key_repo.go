@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// Erreurs renvoyées quand l'utilisateur n'a pas encore de clé de chiffrement, ou pas encore de paire de clés de partage
+var (
+	errUserKeyNotFound = errors.New("clé de l'utilisateur introuvable")
+	errKeyPairNotFound = errors.New("cet utilisateur doit se connecter une fois avant de recevoir un partage")
+)
+
+// Définir une structure pour représenter la clé de chiffrement protégée d'un utilisateur
+type UserKeyRecord struct {
+	UserID     int
+	Salt       []byte // Sel de dérivation du mot de passe
+	KDFTime    uint32 // Paramètres Argon2id utilisés lors de la protection
+	KDFMemory  uint32 // En Kio
+	KDFThreads uint8
+	WrappedKey []byte // Clé utilisateur chiffrée par le mot de passe puis par la clé maîtresse
+}
+
+// UserKeyRepo regroupe les requêtes sur la table user_keys
+type UserKeyRepo struct {
+	db *sql.DB
+}
+
+// Fonction pour récupérer la clé protégée d'un utilisateur
+func (r *UserKeyRepo) Get(ctx context.Context, userID int) (UserKeyRecord, error) {
+	rec := UserKeyRecord{UserID: userID}
+	err := r.db.QueryRowContext(ctx, "SELECT kdf_salt, kdf_time, kdf_memory, kdf_threads, wrapped_key FROM user_keys WHERE user_id = ?", userID).
+		Scan(&rec.Salt, &rec.KDFTime, &rec.KDFMemory, &rec.KDFThreads, &rec.WrappedKey)
+	if errors.Is(err, sql.ErrNoRows) {
+		return rec, errUserKeyNotFound
+	}
+	return rec, err
+}
+
+// Fonction pour enregistrer la clé protégée d'un nouvel utilisateur (sans écraser une clé existante)
+func (r *UserKeyRepo) Create(ctx context.Context, rec UserKeyRecord) error {
+	_, err := r.db.ExecContext(ctx, "INSERT INTO user_keys (user_id, kdf_salt, kdf_time, kdf_memory, kdf_threads, wrapped_key) VALUES (?, ?, ?, ?, ?, ?)",
+		rec.UserID, rec.Salt, rec.KDFTime, rec.KDFMemory, rec.KDFThreads, rec.WrappedKey)
+	return err
+}
+
+// Fonction pour changer le mot de passe et la protection de la clé dans une même transaction
+func (r *UserKeyRepo) ChangePassword(ctx context.Context, userID int, hashedPassword []byte, rec UserKeyRecord) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, "UPDATE users SET password = ? WHERE id = ?", hashedPassword, userID); err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, "UPDATE user_keys SET kdf_salt = ?, kdf_time = ?, kdf_memory = ?, kdf_threads = ?, wrapped_key = ? WHERE user_id = ?",
+		rec.Salt, rec.KDFTime, rec.KDFMemory, rec.KDFThreads, rec.WrappedKey, userID)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Fonction pour récupérer la paire de clés de partage d'un utilisateur (clé privée chiffrée par sa clé utilisateur)
+func (r *UserKeyRepo) KeyPair(ctx context.Context, userID int) (publicKey, sealedPrivate []byte, err error) {
+	err = r.db.QueryRowContext(ctx, "SELECT public_key, private_key FROM user_keys WHERE user_id = ?", userID).Scan(&publicKey, &sealedPrivate)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && (publicKey == nil || sealedPrivate == nil)) {
+		return nil, nil, errKeyPairNotFound
+	}
+	return publicKey, sealedPrivate, err
+}
+
+// Fonction pour enregistrer la paire de clés de partage d'un utilisateur (sans écraser une paire existante)
+func (r *UserKeyRepo) SetKeyPair(ctx context.Context, userID int, publicKey, sealedPrivate []byte) error {
+	_, err := r.db.ExecContext(ctx, "UPDATE user_keys SET public_key = ?, private_key = ? WHERE user_id = ? AND public_key IS NULL", publicKey, sealedPrivate, userID)
+	return err
+}
+
+// Erreur renvoyée quand une session n'a pas (ou plus) de copie de la clé utilisateur
+var errSessionKeyNotFound = errors.New("clé de session introuvable ou expirée")
+
+// SessionKeyRepo regroupe les requêtes sur la table session_keys (copies de la clé utilisateur des sessions ouvertes)
+type SessionKeyRepo struct {
+	db *sql.DB
+}
+
+// Fonction pour enregistrer la copie de la clé utilisateur d'une nouvelle session
+func (r *SessionKeyRepo) Create(ctx context.Context, id []byte, userID int, wrappedKey []byte, expiresAt time.Time) error {
+	_, err := r.db.ExecContext(ctx, "INSERT INTO session_keys (id, user_id, wrapped_key, expires_at) VALUES (?, ?, ?, ?)", id, userID, wrappedKey, expiresAt)
+	return err
+}
+
+// Fonction pour récupérer la copie de la clé utilisateur d'une session encore valide
+func (r *SessionKeyRepo) Get(ctx context.Context, id []byte, userID int, now time.Time) ([]byte, error) {
+	var wrapped []byte
+	err := r.db.QueryRowContext(ctx, "SELECT wrapped_key FROM session_keys WHERE id = ? AND user_id = ? AND expires_at > ?", id, userID, now).Scan(&wrapped)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, errSessionKeyNotFound
+	}
+	return wrapped, err
+}
+
+// Fonction pour supprimer la copie de la clé d'une session (déconnexion)
+func (r *SessionKeyRepo) Delete(ctx context.Context, id []byte) error {
+	_, err := r.db.ExecContext(ctx, "DELETE FROM session_keys WHERE id = ?", id)
+	return err
+}
+
+// Fonction pour supprimer les copies de la clé de toutes les sessions d'un utilisateur (changement de mot de passe)
+func (r *SessionKeyRepo) DeleteByUser(ctx context.Context, userID int) error {
+	_, err := r.db.ExecContext(ctx, "DELETE FROM session_keys WHERE user_id = ?", userID)
+	return err
+}
+
+// Fonction pour supprimer les copies des sessions expirées
+func (r *SessionKeyRepo) DeleteExpired(ctx context.Context, now time.Time) error {
+	_, err := r.db.ExecContext(ctx, "DELETE FROM session_keys WHERE expires_at <= ?", now)
+	return err
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+)
+
+// Erreur renvoyée quand l'utilisateur demandé n'existe pas
+var errUserNotFound = errors.New("utilisateur introuvable")
+
+// Définir une structure pour représenter un utilisateur
+type User struct {
+	ID        int    // ID de l'utilisateur
+	Username  string // Nom d'utilisateur
+	Password  string // Mot de passe haché avec bcrypt
+	Role      string // Rôle ("utilisateur" ou "admin")
+	CreatedAt string // Date de création telle que renvoyée par MySQL
+}
+
+// UserRepo regroupe les requêtes sur la table users
+type UserRepo struct {
+	db *sql.DB
+}
+
+// Fonction pour récupérer un utilisateur par son nom
+func (r *UserRepo) FindByUsername(ctx context.Context, username string) (User, error) {
+	var user User
+	var role, createdAt sql.NullString
+	err := r.db.QueryRowContext(ctx, "SELECT id, username, password, role, created_at FROM users WHERE username = ?", username).
+		Scan(&user.ID, &user.Username, &user.Password, &role, &createdAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return user, errUserNotFound
+	}
+	user.Role, user.CreatedAt = role.String, createdAt.String
+	return user, err
+}
+
+// Fonction pour récupérer un utilisateur par son ID
+func (r *UserRepo) FindByID(ctx context.Context, userID int) (User, error) {
+	var user User
+	var role, createdAt sql.NullString
+	err := r.db.QueryRowContext(ctx, "SELECT id, username, password, role, created_at FROM users WHERE id = ?", userID).
+		Scan(&user.ID, &user.Username, &user.Password, &role, &createdAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return user, errUserNotFound
+	}
+	user.Role, user.CreatedAt = role.String, createdAt.String
+	return user, err
+}
+
+// Fonction pour récupérer la politique de conservation des versions choisie par un utilisateur (NULL : par défaut)
+func (r *UserRepo) VersionPolicy(ctx context.Context, userID int) (maxCount, maxDays sql.NullInt64, err error) {
+	err = r.db.QueryRowContext(ctx, "SELECT version_limit, version_days FROM users WHERE id = ?", userID).Scan(&maxCount, &maxDays)
+	if errors.Is(err, sql.ErrNoRows) {
+		err = errUserNotFound
+	}
+	return maxCount, maxDays, err
+}
+
+// Fonction pour enregistrer la politique de conservation des versions d'un utilisateur
+func (r *UserRepo) SetVersionPolicy(ctx context.Context, userID int, maxCount, maxDays sql.NullInt64) error {
+	_, err := r.db.ExecContext(ctx, "UPDATE users SET version_limit = ?, version_days = ? WHERE id = ?", maxCount, maxDays, userID)
+	return err
+}
+
+// Définir une structure pour représenter l'occupation du stockage d'un utilisateur
+type StorageUsage struct {
+	UserID   int
+	Username string
+	Role     string
+	Used     int64         // Octets occupés (fichiers, anciennes versions, corbeille et envois en cours)
+	Quota    sql.NullInt64 // Quota propre à l'utilisateur, en octets (NULL : quota de son rôle)
+}
+
+// Fonction pour lire l'occupation du stockage d'un utilisateur
+func (r *UserRepo) Storage(ctx context.Context, userID int) (StorageUsage, error) {
+	usage := StorageUsage{UserID: userID}
+	var role sql.NullString
+	err := r.db.QueryRowContext(ctx, "SELECT username, role, storage_used, storage_quota FROM users WHERE id = ?", userID).
+		Scan(&usage.Username, &role, &usage.Used, &usage.Quota)
+	if errors.Is(err, sql.ErrNoRows) {
+		return usage, errUserNotFound
+	}
+	usage.Role = role.String
+	return usage, err
+}
+
+// Fonction pour lister l'occupation du stockage de tous les utilisateurs
+func (r *UserRepo) ListStorage(ctx context.Context) ([]StorageUsage, error) {
+	rows, err := r.db.QueryContext(ctx, "SELECT id, username, role, storage_used, storage_quota FROM users ORDER BY username")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var usages []StorageUsage
+	for rows.Next() {
+		var usage StorageUsage
+		var role sql.NullString
+		if err := rows.Scan(&usage.UserID, &usage.Username, &role, &usage.Used, &usage.Quota); err != nil {
+			return nil, err
+		}
+		usage.Role = role.String
+		usages = append(usages, usage)
+	}
+	return usages, rows.Err()
+}
+
+// Fonction pour réserver de l'espace avant d'écrire un contenu ; renvoie false si le quota serait dépassé (limit 0 : illimité)
+func (r *UserRepo) ReserveStorage(ctx context.Context, userID int, size, limit int64) (bool, error) {
+	// Un contenu vide tient toujours ; MySQL compterait d'ailleurs 0 ligne modifiée pour un ajout de 0
+	if size == 0 {
+		return true, nil
+	}
+	result, err := r.db.ExecContext(ctx, "UPDATE users SET storage_used = storage_used + ? WHERE id = ? AND (? = 0 OR storage_used + ? <= ?)", size, userID, limit, size, limit)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
+
+// Fonction pour rendre de l'espace réservé ou libéré
+func (r *UserRepo) ReleaseStorage(ctx context.Context, userID int, size int64) error {
+	_, err := r.db.ExecContext(ctx, "UPDATE users SET storage_used = GREATEST(storage_used - ?, 0) WHERE id = ?", size, userID)
+	return err
+}
+
+// Fonction pour rendre de l'espace libéré dans une transaction
+func releaseStorage(ctx context.Context, tx *sql.Tx, userID int, size int64) error {
+	if size == 0 {
+		return nil
+	}
+	_, err := tx.ExecContext(ctx, "UPDATE users SET storage_used = GREATEST(storage_used - ?, 0) WHERE id = ?", size, userID)
+	return err
+}
+
+// Fonction pour fixer le quota propre à un utilisateur (NULL : revenir au quota de son rôle)
+func (r *UserRepo) SetStorageQuota(ctx context.Context, userID int, quota sql.NullInt64) error {
+	result, err := r.db.ExecContext(ctx, "UPDATE users SET storage_quota = ? WHERE id = ?", quota, userID)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		// MySQL ne compte pas une ligne inchangée : vérifier que l'utilisateur existe
+		if _, err := r.Storage(ctx, userID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Fonction pour recalculer l'occupation de tous les utilisateurs à partir des tailles enregistrées
+func (r *UserRepo) RecountStorage(ctx context.Context) error {
+	_, err := r.db.ExecContext(ctx, `UPDATE users u SET storage_used =
+		(SELECT COALESCE(SUM(f.size), 0) FROM files f WHERE f.user_id = u.ID) +
+		(SELECT COALESCE(SUM(v.size), 0) FROM file_versions v JOIN files f ON f.id = v.file_id WHERE f.user_id = u.ID) +
+		(SELECT COALESCE(SUM(p.upload_length), 0) FROM uploads p WHERE p.user_id = u.ID)`)
+	return err
+}
+
+// Fonction pour savoir si un nom d'utilisateur est déjà pris
+func (r *UserRepo) Exists(ctx context.Context, username string) (bool, error) {
+	var count int
+	err := r.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM users WHERE username = ?", username).Scan(&count)
+	return count > 0, err
+}
+
+// Fonction pour créer un utilisateur avec un mot de passe déjà haché
+func (r *UserRepo) Create(ctx context.Context, username string, hashedPassword []byte, role string) (int64, error) {
+	result, err := r.db.ExecContext(ctx, "INSERT INTO users (username, password, role) VALUES (?, ?, ?)", username, hashedPassword, role)
+	if err != nil {
+		return 0, err
+	}
+	return result.LastInsertId()
+}
+
+// Fonction pour lister tous les utilisateurs
+func (r *UserRepo) List(ctx context.Context) ([]User, error) {
+	rows, err := r.db.QueryContext(ctx, "SELECT id, username, role, created_at FROM users")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var users []User
+	for rows.Next() {
+		var user User
+		var role, createdAt sql.NullString
+		if err := rows.Scan(&user.ID, &user.Username, &role, &createdAt); err != nil {
+			return nil, err
+		}
+		user.Role, user.CreatedAt = role.String, createdAt.String
+		users = append(users, user)
+	}
+	return users, rows.Err()
+}
+
+// Fonction pour supprimer un utilisateur avec toutes ses données, corbeille comprise : fichiers et leurs versions,
+// notes, dossiers et envois en cours. Renvoie les clés de stockage qui ne sont plus référencées, à effacer après
+// la validation (leurs vignettes partent avec elles), et les envois dont les morceaux sont à supprimer.
+func (r *UserRepo) Delete(ctx context.Context, userID int) ([]string, []string, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer tx.Rollback()
+
+	fileKeys, err := queryKeys(ctx, tx, "SELECT storage_key FROM files WHERE user_id = ?", userID)
+	if err != nil {
+		return nil, nil, err
+	}
+	versionKeys, err := queryKeys(ctx, tx, "SELECT v.storage_key FROM file_versions v JOIN files f ON f.id = v.file_id WHERE f.user_id = ?", userID)
+	if err != nil {
+		return nil, nil, err
+	}
+	uploadIDs, err := queryKeys(ctx, tx, "SELECT id FROM uploads WHERE user_id = ?", userID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Les versions, liens de partage, accès accordés et documents de recherche suivent par les clés étrangères
+	for _, query := range []string{
+		"DELETE FROM files WHERE user_id = ?",
+		"DELETE FROM notes WHERE user_id = ?",
+		"DELETE FROM uploads WHERE user_id = ?",
+		// Détacher les dossiers de leur parent pour pouvoir tous les supprimer malgré la clé étrangère parent
+		"UPDATE folders SET parent_folder_id = NULL WHERE user_id = ?",
+		"DELETE FROM folders WHERE user_id = ?",
+		"DELETE FROM users WHERE id = ?",
+	} {
+		if _, err := tx.ExecContext(ctx, query, userID); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	keys, err := releaseBlobs(ctx, tx, append(fileKeys, versionKeys...))
+	if err != nil {
+		return nil, nil, err
+	}
+	return keys, uploadIDs, tx.Commit()
+}
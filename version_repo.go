@@ -0,0 +1,210 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// Erreur renvoyée quand la version demandée n'existe pas
+var errVersionNotFound = errors.New("version introuvable")
+
+// Définir une structure pour représenter une ancienne version d'un fichier
+type FileVersion struct {
+	ID         int
+	FileID     int
+	StorageKey string       // Contenu de la version
+	WrappedKey []byte       // Clé de données de la version (nil pour un ancien contenu en clair)
+	Size       int64        // Taille du contenu en clair, en octets (0 tant qu'elle n'est pas calculée)
+	UploadedAt time.Time    // Date d'envoi de la version
+	ReplacedAt time.Time    // Date à laquelle elle a cessé d'être la version courante
+	Metadata   FileMetadata // Métadonnées du contenu de la version
+}
+
+// Définir une structure pour représenter une politique de conservation des versions (0 : pas de limite)
+type VersionPolicy struct {
+	MaxCount int // Nombre maximal d'anciennes versions gardées par fichier
+	MaxDays  int // Durée maximale de conservation d'une ancienne version, en jours
+}
+
+// VersionRepo regroupe les requêtes sur la table file_versions
+type VersionRepo struct {
+	db *sql.DB
+}
+
+// Fonction pour lister les anciennes versions d'un fichier, de la plus récente à la plus ancienne
+func (r *VersionRepo) List(ctx context.Context, fileID int) ([]FileVersion, error) {
+	rows, err := r.db.QueryContext(ctx, "SELECT id, storage_key, wrapped_key, size, uploaded_at, replaced_at, "+metadataColumns+" FROM file_versions WHERE file_id = ? ORDER BY replaced_at DESC, id DESC", fileID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var versions []FileVersion
+	for rows.Next() {
+		version := FileVersion{FileID: fileID}
+		var size sql.NullInt64
+		var uploadedAt, replacedAt []byte
+		var meta metadataRow
+		if err := rows.Scan(append([]interface{}{&version.ID, &version.StorageKey, &version.WrappedKey, &size, &uploadedAt, &replacedAt}, meta.dest()...)...); err != nil {
+			return nil, err
+		}
+		version.Size, version.UploadedAt, version.ReplacedAt = size.Int64, parseDBTime(uploadedAt), parseDBTime(replacedAt)
+		version.Metadata = meta.metadata()
+		versions = append(versions, version)
+	}
+	return versions, rows.Err()
+}
+
+// Fonction pour récupérer une ancienne version d'un fichier
+func (r *VersionRepo) Get(ctx context.Context, fileID int, versionID int64) (FileVersion, error) {
+	version := FileVersion{FileID: fileID}
+	var size sql.NullInt64
+	var uploadedAt, replacedAt []byte
+	var meta metadataRow
+	err := r.db.QueryRowContext(ctx, "SELECT id, storage_key, wrapped_key, size, uploaded_at, replaced_at, "+metadataColumns+" FROM file_versions WHERE id = ? AND file_id = ?", versionID, fileID).
+		Scan(append([]interface{}{&version.ID, &version.StorageKey, &version.WrappedKey, &size, &uploadedAt, &replacedAt}, meta.dest()...)...)
+	if errors.Is(err, sql.ErrNoRows) {
+		return version, errVersionNotFound
+	}
+	version.Size, version.UploadedAt, version.ReplacedAt = size.Int64, parseDBTime(uploadedAt), parseDBTime(replacedAt)
+	version.Metadata = meta.metadata()
+	return version, err
+}
+
+// Fonction pour restaurer une ancienne version : elle redevient courante et la version courante rejoint l'historique
+func (r *VersionRepo) Restore(ctx context.Context, fileID int, versionID int64) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var version FileVersion
+	var versionSize sql.NullInt64
+	var versionUploadedAt []byte
+	var versionMeta metadataRow
+	err = tx.QueryRowContext(ctx, "SELECT storage_key, wrapped_key, size, uploaded_at, "+metadataColumns+" FROM file_versions WHERE id = ? AND file_id = ? FOR UPDATE", versionID, fileID).
+		Scan(append([]interface{}{&version.StorageKey, &version.WrappedKey, &versionSize, &versionUploadedAt}, versionMeta.dest()...)...)
+	if errors.Is(err, sql.ErrNoRows) {
+		return errVersionNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	var currentKey sql.NullString
+	var currentSize sql.NullInt64
+	var currentWrapped, currentUploadedAt []byte
+	var currentMeta metadataRow
+	err = tx.QueryRowContext(ctx, "SELECT storage_key, wrapped_key, size, uploaded_at, "+metadataColumns+" FROM files WHERE id = ? FOR UPDATE", fileID).
+		Scan(append([]interface{}{&currentKey, &currentWrapped, &currentSize, &currentUploadedAt}, currentMeta.dest()...)...)
+	if errors.Is(err, sql.ErrNoRows) {
+		return errFileNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	if _, err := tx.ExecContext(ctx, "DELETE FROM file_versions WHERE id = ?", versionID); err != nil {
+		return err
+	}
+	if currentKey.Valid {
+		_, err = tx.ExecContext(ctx, "INSERT INTO file_versions (file_id, storage_key, wrapped_key, size, uploaded_at, replaced_at, "+metadataColumns+") VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
+			append([]interface{}{fileID, currentKey.String, currentWrapped, currentSize, nullableTime(currentUploadedAt), time.Now()}, currentMeta.values()...)...)
+		if err != nil {
+			return err
+		}
+	}
+	_, err = tx.ExecContext(ctx, "UPDATE files SET storage_key = ?, wrapped_key = ?, size = ?, uploaded_at = ?, "+metadataAssignments+" WHERE id = ?",
+		append(append([]interface{}{version.StorageKey, version.WrappedKey, versionSize, nullableTime(versionUploadedAt)}, versionMeta.values()...), fileID)...)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Fonction pour supprimer les anciennes versions d'un fichier qui dépassent la politique de conservation.
+// L'espace libéré est décompté de l'occupation du propriétaire.
+// Renvoie les clés de stockage qui ne sont plus référencées, à effacer du stockage par l'appelant.
+func (r *VersionRepo) Prune(ctx context.Context, fileID int, policy VersionPolicy, now time.Time) ([]string, error) {
+	versions, err := r.List(ctx, fileID)
+	if err != nil {
+		return nil, err
+	}
+	var expired []FileVersion
+	for i, version := range versions {
+		tooMany := policy.MaxCount > 0 && i >= policy.MaxCount
+		tooOld := policy.MaxDays > 0 && now.Sub(version.ReplacedAt) > time.Duration(policy.MaxDays)*24*time.Hour
+		if tooMany || tooOld {
+			expired = append(expired, version)
+		}
+	}
+	if len(expired) == 0 {
+		return nil, nil
+	}
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var keys []string
+	var freed int64
+	for _, version := range expired {
+		result, err := tx.ExecContext(ctx, "DELETE FROM file_versions WHERE id = ?", version.ID)
+		if err != nil {
+			return nil, err
+		}
+		if n, err := result.RowsAffected(); err != nil || n == 0 {
+			if err != nil {
+				return nil, err
+			}
+			continue // Restaurée ou supprimée entre-temps
+		}
+		keys = append(keys, version.StorageKey)
+		freed += version.Size
+	}
+	if freed > 0 {
+		if _, err := tx.ExecContext(ctx, "UPDATE users SET storage_used = GREATEST(storage_used - ?, 0) WHERE id = (SELECT user_id FROM files WHERE id = ?)", freed, fileID); err != nil {
+			return nil, err
+		}
+	}
+	if keys, err = releaseBlobs(ctx, tx, keys); err != nil {
+		return nil, err
+	}
+	return keys, tx.Commit()
+}
+
+// Fonction pour appliquer la politique de conservation à tous les fichiers d'un utilisateur
+func (r *VersionRepo) PruneUser(ctx context.Context, userID int, policy VersionPolicy, now time.Time) ([]string, error) {
+	rows, err := r.db.QueryContext(ctx, "SELECT DISTINCT v.file_id FROM file_versions v JOIN files f ON f.id = v.file_id WHERE f.user_id = ?", userID)
+	if err != nil {
+		return nil, err
+	}
+	var fileIDs []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		fileIDs = append(fileIDs, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var keys []string
+	for _, id := range fileIDs {
+		fileKeys, err := r.Prune(ctx, id, policy, now)
+		keys = append(keys, fileKeys...)
+		if err != nil {
+			return keys, err
+		}
+	}
+	return keys, nil
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"html/template"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// Fonction pour obtenir la politique de conservation des versions d'un utilisateur (la sienne, sinon celle par défaut)
+func (s *Server) versionPolicy(ctx context.Context, userID int) (VersionPolicy, error) {
+	policy := s.versionDefaults
+	maxCount, maxDays, err := s.users.VersionPolicy(ctx, userID)
+	if err != nil {
+		return policy, err
+	}
+	if maxCount.Valid {
+		policy.MaxCount = int(maxCount.Int64)
+	}
+	if maxDays.Valid {
+		policy.MaxDays = int(maxDays.Int64)
+	}
+	return policy, nil
+}
+
+// Fonction pour traduire une erreur sur les versions en réponse
+func versionErrorResponse(c echo.Context, err error) error {
+	if errors.Is(err, errVersionNotFound) {
+		return c.JSON(http.StatusNotFound, map[string]string{"message": err.Error()})
+	}
+	return accessErrorResponse(c, err, "Erreur lors de l'opération sur les versions")
+}
+
+// Fonction pour formater une date de version
+func formatVersionTime(t time.Time) string {
+	if t.IsZero() {
+		return "date inconnue"
+	}
+	return t.Format("02/01/2006 15:04")
+}
+
+// Gestionnaire de route pour afficher l'historique des versions d'un fichier
+func (s *Server) fileVersionsHandler(c echo.Context) error {
+	ctx := c.Request().Context()
+	fileID, err := parseObjectID(c.Param("id"))
+	if err != nil {
+		return versionErrorResponse(c, err)
+	}
+	file, err := s.authz.File(ctx, currentUserID(c), fileID, AccessRead)
+	if err != nil {
+		return versionErrorResponse(c, err)
+	}
+	versions, err := s.versions.List(ctx, file.ID)
+	if err != nil {
+		log.Println("Erreur lors de la récupération des versions :", err)
+		return err
+	}
+
+	// Retour au dossier du fichier : dans le coffre de l'utilisateur, ou parmi les dossiers partagés avec lui
+	back := welcomeURL(file.FolderID)
+	if file.UserID != currentUserID(c) {
+		back = sharedFolderURL(file.FolderID)
+	}
+	id := strconv.Itoa(file.ID)
+	htmlContent := `
+        <h1>Versions de ` + template.HTMLEscapeString(file.FileName) + `</h1>
+        <ul>
+            <li>Version courante, envoyée le ` + formatVersionTime(file.UploadedAt) + ` — <a href="/view-file/` + id + `" target="_blank">Ouvrir</a></li>`
+	for _, version := range versions {
+		versionID := strconv.Itoa(version.ID)
+		htmlContent += `
+            <li>Envoyée le ` + formatVersionTime(version.UploadedAt) + `, remplacée le ` + formatVersionTime(version.ReplacedAt) + `
+                — <a href="/files/` + id + `/versions/` + versionID + `" target="_blank">Ouvrir</a>
+                <form action="/files/` + id + `/versions/` + versionID + `/restore" method="post" style="display:inline">
+                    <button type="submit">Restaurer</button>
+                </form>
+            </li>`
+	}
+	htmlContent += `
+        </ul>
+        <a href="` + back + `">Retour</a>
+    `
+	return c.HTML(http.StatusOK, htmlContent)
+}
+
+// Gestionnaire de route pour visualiser une ancienne version d'un fichier
+func (s *Server) viewFileVersionHandler(c echo.Context) error {
+	ctx := c.Request().Context()
+	fileID, err := parseObjectID(c.Param("id"))
+	if err != nil {
+		return versionErrorResponse(c, err)
+	}
+	versionID, err := parseObjectID(c.Param("version"))
+	if err != nil {
+		return versionErrorResponse(c, err)
+	}
+	file, err := s.authz.File(ctx, currentUserID(c), fileID, AccessRead)
+	if err != nil {
+		return versionErrorResponse(c, err)
+	}
+	version, err := s.versions.Get(ctx, file.ID, versionID)
+	if err != nil {
+		return versionErrorResponse(c, err)
+	}
+
+	// Une version se sert comme le fichier lui-même, avec son propre contenu et sa propre clé
+	file.StorageKey, file.WrappedKey, file.UploadedAt = version.StorageKey, version.WrappedKey, version.UploadedAt
+	file.Size, file.Metadata = version.Size, version.Metadata
+	return s.serveFile(c, file)
+}
+
+// Gestionnaire de route pour restaurer une ancienne version comme version courante
+func (s *Server) restoreFileVersionHandler(c echo.Context) error {
+	ctx := c.Request().Context()
+	fileID, err := parseObjectID(c.Param("id"))
+	if err != nil {
+		return versionErrorResponse(c, err)
+	}
+	versionID, err := parseObjectID(c.Param("version"))
+	if err != nil {
+		return versionErrorResponse(c, err)
+	}
+	file, err := s.authz.File(ctx, currentUserID(c), fileID, AccessWrite)
+	if err != nil {
+		return versionErrorResponse(c, err)
+	}
+	if err := s.versions.Restore(ctx, file.ID, versionID); err != nil {
+		return versionErrorResponse(c, err)
+	}
+
+	// Réindexer le contenu restauré et l'ouvrir aux liens de partage ; sans la clé du propriétaire,
+	// cela se fera à sa prochaine connexion
+	if restored, err := s.files.Get(ctx, int64(file.ID)); err == nil && restored.UserID == currentUserID(c) {
+		if userKey, err := s.keys.ForRequest(c, restored.UserID); err == nil {
+			go s.indexFile(context.Background(), restored.UserID, userKey, searchSource{FileID: restored.ID, FileName: restored.FileName,
+				StorageKey: restored.StorageKey, WrappedKey: restored.WrappedKey, MIMEType: restored.Metadata.MIMEType})
+			go s.refreshAccess(context.Background(), restored.UserID, userKey)
+		}
+	}
+
+	return c.Redirect(http.StatusSeeOther, "/files/"+strconv.Itoa(file.ID)+"/versions")
+}
+
+// Fonction pour afficher une limite de conservation dans le formulaire (vide : valeur par défaut)
+func policyValue(value sql.NullInt64) string {
+	if !value.Valid {
+		return ""
+	}
+	return strconv.FormatInt(value.Int64, 10)
+}
+
+// Fonction pour lire une limite de conservation du formulaire (vide : valeur par défaut, 0 : pas de limite)
+func parsePolicyValue(value string) (sql.NullInt64, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return sql.NullInt64{}, nil
+	}
+	n, err := strconv.ParseInt(value, 10, 32)
+	if err != nil || n < 0 {
+		return sql.NullInt64{}, errors.New("limite de conservation invalide")
+	}
+	return sql.NullInt64{Int64: n, Valid: true}, nil
+}
+
+// Page pour afficher la politique de conservation des versions de l'utilisateur
+func (s *Server) versionPolicyHandler(c echo.Context) error {
+	maxCount, maxDays, err := s.users.VersionPolicy(c.Request().Context(), currentUserID(c))
+	if err != nil {
+		log.Println("Erreur lors de la lecture de la politique de conservation :", err)
+		return err
+	}
+
+	htmlContent := `
+        <h1>Conservation des anciennes versions</h1>
+        <p>Laisser vide pour utiliser la valeur par défaut (` + strconv.Itoa(s.versionDefaults.MaxCount) + ` versions, ` + strconv.Itoa(s.versionDefaults.MaxDays) + ` jours) ; 0 pour ne fixer aucune limite.</p>
+        <form action="/version-policy" method="post">
+            <input type="number" min="0" name="max_count" placeholder="Versions par fichier" value="` + policyValue(maxCount) + `"><br>
+            <input type="number" min="0" name="max_days" placeholder="Jours de conservation" value="` + policyValue(maxDays) + `"><br>
+            <button type="submit">Enregistrer</button>
+        </form>
+        <a href="/welcome">Retour à la page </a>
+    `
+	return c.HTML(http.StatusOK, htmlContent)
+}
+
+// Traitement du formulaire de politique de conservation ; les versions en trop sont supprimées aussitôt
+func (s *Server) versionPolicyPostHandler(c echo.Context) error {
+	ctx := c.Request().Context()
+	userID := currentUserID(c)
+
+	maxCount, err := parsePolicyValue(c.FormValue("max_count"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"message": err.Error()})
+	}
+	maxDays, err := parsePolicyValue(c.FormValue("max_days"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"message": err.Error()})
+	}
+	if err := s.users.SetVersionPolicy(ctx, userID, maxCount, maxDays); err != nil {
+		log.Println("Erreur lors de l'enregistrement de la politique de conservation :", err)
+		return err
+	}
+
+	policy, err := s.versionPolicy(ctx, userID)
+	if err != nil {
+		return err
+	}
+	keys, err := s.versions.PruneUser(ctx, userID, policy, time.Now())
+	removeBlobs(ctx, s.blobRefs, s.blobs, keys)
+	if err != nil {
+		log.Println("Erreur lors de la suppression des anciennes versions :", err)
+		return err
+	}
+
+	return c.Redirect(http.StatusSeeOther, "/version-policy")
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"archive/zip"
+	"compress/flate"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/binary"
+	"hash"
+	"io"
+
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// Chiffrement AES des archives zip au format WinZip (AE-1, AES-256), lu par 7-Zip, WinZip et la plupart des outils récents
+const (
+	zipMethodAES      = 99     // Méthode annoncée pour une entrée chiffrée ; la vraie méthode est dans le champ extra
+	zipExtraAES       = 0x9901 // Identifiant du champ extra AES
+	zipAESSaltSize    = 16     // Sel de 16 octets pour AES-256
+	zipAESKeySize     = 32
+	zipAESIterations  = 1000 // Itérations PBKDF2-HMAC-SHA1 imposées par le format
+	zipAESMACSize     = 10   // Code d'authentification tronqué ajouté après les données
+	zipAESVerifierLen = 2    // Octets de vérification du mot de passe placés après le sel
+)
+
+// Fonction pour construire le champ extra AES d'une entrée : version AE-1, fournisseur "AE", AES-256 et vraie méthode
+func zipAESExtra(method uint16) []byte {
+	extra := make([]byte, 11)
+	binary.LittleEndian.PutUint16(extra[0:], zipExtraAES)
+	binary.LittleEndian.PutUint16(extra[2:], 7)
+	binary.LittleEndian.PutUint16(extra[4:], 1)
+	copy(extra[6:], "AE")
+	extra[8] = 3
+	binary.LittleEndian.PutUint16(extra[9:], method)
+	return extra
+}
+
+// zipAESWriter compresse (méthode Deflate) puis chiffre une entrée : sel, vérificateur, données chiffrées en AES-CTR
+// (compteur petit-boutiste qui commence à 1), puis HMAC-SHA1 tronqué des données chiffrées
+type zipAESWriter struct {
+	out     io.Writer
+	block   cipher.Block
+	counter [aes.BlockSize]byte
+	stream  [aes.BlockSize]byte
+	used    int // Octets déjà consommés du bloc de flux courant
+	mac     hash.Hash
+	deflate *flate.Writer // nil pour une entrée non compressée
+	header  []byte        // Sel et vérificateur, écrits avec les premières données
+}
+
+// Fonction pour commencer une entrée chiffrée avec le mot de passe de l'archive
+func newZipAESWriter(out io.Writer, password string, method uint16) (io.WriteCloser, error) {
+	salt, err := randomBytes(zipAESSaltSize)
+	if err != nil {
+		return nil, err
+	}
+	keys := pbkdf2.Key([]byte(password), salt, zipAESIterations, 2*zipAESKeySize+zipAESVerifierLen, sha1.New)
+	block, err := aes.NewCipher(keys[:zipAESKeySize])
+	if err != nil {
+		return nil, err
+	}
+	// archive/zip crée le compresseur avant d'écrire l'en-tête local : rien ne doit être écrit ici
+	w := &zipAESWriter{out: out, block: block, used: aes.BlockSize, mac: hmac.New(sha1.New, keys[zipAESKeySize:2*zipAESKeySize]),
+		header: append(salt, keys[2*zipAESKeySize:]...)}
+	if method == zip.Deflate {
+		if w.deflate, err = flate.NewWriter(encryptingWriter{w}, flate.DefaultCompression); err != nil {
+			return nil, err
+		}
+	}
+	return w, nil
+}
+
+func (w *zipAESWriter) Write(p []byte) (int, error) {
+	if w.deflate != nil {
+		return w.deflate.Write(p)
+	}
+	return w.encrypt(p)
+}
+
+// Fonction pour terminer l'entrée : vider le compresseur puis écrire le code d'authentification
+func (w *zipAESWriter) Close() error {
+	if w.deflate != nil {
+		if err := w.deflate.Close(); err != nil {
+			return err
+		}
+	}
+	if err := w.writeHeader(); err != nil {
+		return err
+	}
+	_, err := w.out.Write(w.mac.Sum(nil)[:zipAESMACSize])
+	return err
+}
+
+// Fonction pour écrire le sel et le vérificateur avant les premières données de l'entrée
+func (w *zipAESWriter) writeHeader() error {
+	if w.header == nil {
+		return nil
+	}
+	_, err := w.out.Write(w.header)
+	w.header = nil
+	return err
+}
+
+// Fonction pour chiffrer et écrire des données (compressées ou non)
+func (w *zipAESWriter) encrypt(p []byte) (int, error) {
+	if err := w.writeHeader(); err != nil {
+		return 0, err
+	}
+	buf := make([]byte, len(p))
+	for i := range p {
+		if w.used == aes.BlockSize {
+			for j := range w.counter {
+				w.counter[j]++
+				if w.counter[j] != 0 {
+					break
+				}
+			}
+			w.block.Encrypt(w.stream[:], w.counter[:])
+			w.used = 0
+		}
+		buf[i] = p[i] ^ w.stream[w.used]
+		w.used++
+	}
+	w.mac.Write(buf)
+	if _, err := w.out.Write(buf); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// encryptingWriter est la sortie du compresseur d'une entrée chiffrée
+type encryptingWriter struct {
+	w *zipAESWriter
+}
+
+func (e encryptingWriter) Write(p []byte) (int, error) {
+	return e.w.encrypt(p)
+}